@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/freewilll/splitter/errkind"
+	"github.com/freewilll/splitter/ledger"
+)
+
+type updateMeRequest struct {
+	Name *string `json:"name"`
+}
+
+// maxNameLength is the maximum length of a display name in characters
+const maxNameLength = 100
+
+// validateName validates a display name, returning it without surrounding
+// whitespace. Names are free-form and need not be unique.
+func validateName(name string, errs *validationErrors) string {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxNameLength {
+		errs.add("name", fmt.Sprintf("name must be at most %d characters", maxNameLength))
+	}
+	return name
+}
+
+type changeEmailRequest struct {
+	Email string `json:"email"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// deleteMe deletes the authenticated user's account. The deletion is refused if
+// the user still owes or is owed money. The user's identity is anonymized, while
+// their expenses are kept so that other users' balances remain intact.
+func (api *API) deleteMe(w http.ResponseWriter, r *http.Request, userID int) {
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	expenses := dbh.GetExpenses(userID)
+	settlements := dbh.GetSettlements(userID)
+	balance := ledger.CalculateBalance(expenses, settlements, userID)
+	for _, debts := range [][]ledger.Debt{balance.Debit, balance.Credit} {
+		for _, d := range debts {
+			if d.Amount > ledger.Epsilon {
+				log.Printf("Refusing to delete user %d with outstanding balance %+v", userID, balance)
+				writeError(w, http.StatusConflict, "all debts must be settled before deleting the account")
+				return
+			}
+		}
+	}
+
+	log.Printf("Deleting user %d", userID)
+	dbh.DeleteUser(userID)
+	api.cache.DeleteBalance(userID)
+	api.forgetUser(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchMe updates the authenticated user's profile. Only the display name can
+// be changed, an empty name removes it.
+func (api *API) patchMe(w http.ResponseWriter, r *http.Request, userID int) {
+	var u updateMeRequest
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	var errs validationErrors
+	if u.Name == nil {
+		errs.add("name", "name is required")
+	} else {
+		*u.Name = validateName(*u.Name, &errs)
+	}
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	if err := dbh.SetName(userID, *u.Name); err != nil {
+		switch errkind.Of(err) {
+		case errkind.NotFound:
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	// Write through the cached balances of the users the name is shown to
+	balance := ledger.CalculateBalance(dbh.GetExpenses(userID), dbh.GetSettlements(userID), userID)
+	for _, debts := range [][]ledger.Debt{balance.Debit, balance.Credit} {
+		for _, d := range debts {
+			api.updateBalance(dbh, d.UserID)
+		}
+	}
+
+	log.Printf("User %d changed their name", userID)
+	writeResponse(w, r, newUserResponse(dbh.GetUsersByID([]int{userID})[0]))
+}
+
+// me handles the me endpoint for the PATCH and DELETE methods
+func (api *API) me(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method == "PATCH" {
+		api.patchMe(w, r, userID)
+	} else if r.Method == "DELETE" {
+		api.deleteMe(w, r, userID)
+	} else {
+		methodNotAllowed(w, "PATCH", "DELETE")
+	}
+}
+
+// patchEmail changes the authenticated user's email, which must be valid and
+// not used by another user. The user signs in with the new email from then on.
+func (api *API) patchEmail(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "PATCH" {
+		methodNotAllowed(w, "PATCH")
+		return
+	}
+
+	var e changeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	var errs validationErrors
+	e.Email = normalizeEmail(e.Email)
+	validateEmail(e.Email, mustLoadEmailBlocklist(), &errs)
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	if err := dbh.SetEmail(userID, e.Email); err != nil {
+		switch errkind.Of(err) {
+		case errkind.NotFound:
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		case errkind.Duplicate:
+			log.Printf("User uniqueness failed for email '%s'", e.Email)
+			writeError(w, http.StatusConflict, "a user with that email already exists")
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	log.Printf("User %d changed their email to '%s'", userID, e.Email)
+	writeResponse(w, r, newUserResponse(dbh.GetUsersByID([]int{userID})[0]))
+}
+
+// postPassword changes the authenticated user's password. The current password
+// must be provided and the new password must satisfy the password policy.
+func (api *API) postPassword(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	var p changePasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&p)
+	if err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	var errs validationErrors
+	validatePassword(p.NewPassword, "new_password", &errs)
+	if errs.write(w) {
+		return
+	}
+
+	err = dbh.ChangePassword(userID, p.CurrentPassword, p.NewPassword)
+	if err != nil {
+		switch errkind.Of(err) {
+		case errkind.NotFound, errkind.PasswordMismatch:
+			log.Printf("Password change failed for user %d", userID)
+			writeError(w, http.StatusUnauthorized, "authorization failed")
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	log.Printf("Changed password for user %d", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+	"github.com/freewilll/splitter/testutil"
+)
+
+// deleteMe calls the DELETE me API on behalf of userID
+func deleteMe(api *API, userID int) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodDelete, "/me", nil)
+	response := httptest.NewRecorder()
+	api.me(response, request, userID)
+	return response
+}
+
+// patchMe calls the PATCH me API on behalf of userID
+func patchMe(api *API, userID int, u updateMeRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(u)
+	request, _ := http.NewRequest(http.MethodPatch, "/me", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.me(response, request, userID)
+	return response
+}
+
+func TestUserNames(t *testing.T) {
+	// A name given at registration round-trips through an update, shows up in
+	// the users listing and balances, and needn't be unique
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	response := postUser(api, userID1, createUserRequest{Email: "test2@getstream.io", Password: "secret", Name: "  Alice "})
+	var created userResponse
+	if err := json.NewDecoder(response.Body).Decode(&created); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	if created.Name != "Alice" {
+		t.Errorf("wanted name Alice, got %q", created.Name)
+	}
+	userID2 := created.ID
+
+	response = patchMe(api, userID1, updateMeRequest{Name: &created.Name})
+	var updated userResponse
+	if err := json.NewDecoder(response.Body).Decode(&updated); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wanted := userResponse{ID: userID1, Email: "test1@getstream.io", Name: "Alice"}
+	if updated != wanted {
+		t.Errorf("wanted %+v, got %+v", wanted, updated)
+	}
+
+	response = getUsersWithQuery(api, userID1, "")
+	var users usersResponse
+	if err := json.NewDecoder(response.Body).Decode(&users); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	for _, u := range users.Users {
+		if u.Name != "Alice" {
+			t.Errorf("wanted user %d to be named Alice, got %q", u.ID, u.Name)
+		}
+	}
+
+	makeFriends(dbh, userID1, userID2)
+	postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if balance := getBalance(t, api, userID2); len(balance.Debit) != 1 || balance.Debit[0].Name != "Alice" {
+		t.Errorf("wanted a debt to Alice, got %+v", balance)
+	}
+
+	// Names that are too long are refused, an empty name removes it
+	long := strings.Repeat("x", maxNameLength+1)
+	if response = patchMe(api, userID1, updateMeRequest{Name: &long}); response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+	empty := ""
+	if response = patchMe(api, userID1, updateMeRequest{Name: &empty}); response.Code != http.StatusOK {
+		t.Errorf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+	if users := dbh.GetUsersByID([]int{userID1}); users[0].Name != "" {
+		t.Errorf("wanted no name, got %q", users[0].Name)
+	}
+}
+
+func TestBalanceNamesCached(t *testing.T) {
+	// The names in a cached balance are returned without using the database, and
+	// are refreshed when a user changes their name
+
+	db := testutil.NewMockDatabase(database.NewInMemoryDatabase())
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	dbh.SetName(userID1, "Alice")
+	makeFriends(dbh, userID1, userID2)
+	postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+
+	debtName := func() string {
+		request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+		response := serveWithRecovery(api.getBalance, request, userID2)
+		if response.Code != http.StatusOK {
+			t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+		}
+		var balance ledger.Balance
+		if err := json.NewDecoder(response.Body).Decode(&balance); err != nil || len(balance.Debit) != 1 {
+			t.Fatalf("wanted a debt, got %+v, %v", balance, err)
+		}
+		return balance.Debit[0].Name
+	}
+
+	db.Fail("Connect", errInjected)
+	if name := debtName(); name != "Alice" {
+		t.Errorf("wanted a debt to Alice, got %q", name)
+	}
+	db.Reset("Connect")
+
+	name := "Bob"
+	if response := patchMe(api, userID1, updateMeRequest{Name: &name}); response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+	if name := debtName(); name != "Bob" {
+		t.Errorf("wanted a debt to Bob, got %q", name)
+	}
+}
+
+func TestDeleteMe(t *testing.T) {
+	// Deleting an account is refused while there are debts, and succeeds by
+	// anonymizing the user once they're settled up
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	// Both the debtor and the creditor have an outstanding balance
+	for _, userID := range []int{userID1, userID2} {
+		response = deleteMe(api, userID)
+		if response.Code != http.StatusConflict {
+			t.Errorf("wanted %d, got %d", http.StatusConflict, response.Code)
+		}
+	}
+
+	response = postSettlement(api, userID2, createSettlementRequest{
+		UserID:    userID1,
+		Amount:    21,
+		CreatedAt: "2021-01-02T15:04:05Z",
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create settlement")
+	}
+
+	response = deleteMe(api, userID2)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("wanted %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	users := dbh.GetUsers(database.UsersQuery{})
+	if len(users) != 1 || users[0].ID != userID1 {
+		t.Errorf("wanted only user %d, got %+v", userID1, users)
+	}
+
+	// The expense remains intact for the other user
+	if len(dbh.GetExpenses(userID1)) != 1 {
+		t.Errorf("expense was deleted")
+	}
+
+	// The email can be registered again
+	if _, err := dbh.CreateUser("test2@getstream.io", "secret"); err != nil {
+		t.Errorf("unable to reuse email: %v", err)
+	}
+}
+
+// patchEmail calls the PATCH me/email API on behalf of userID
+func patchEmail(api *API, userID int, email string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(changeEmailRequest{Email: email})
+	request, _ := http.NewRequest(http.MethodPatch, "/me/email", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	api.patchEmail(response, request, userID)
+	return response
+}
+
+func TestChangeEmail(t *testing.T) {
+	// A user changes their email and signs in with the new one. Emails of other
+	// users and invalid emails are rejected.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	dbh.CreateUser("test2@getstream.io", "secret")
+
+	response := patchEmail(api, userID1, " new1@GetStream.io ")
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	var updated userResponse
+	if err := json.NewDecoder(response.Body).Decode(&updated); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	if wanted := (userResponse{ID: userID1, Email: "new1@getstream.io"}); updated != wanted {
+		t.Errorf("wanted %+v, got %+v", wanted, updated)
+	}
+
+	if id, err := dbh.AuthenticateUser("new1@getstream.io", "secret"); err != nil || id != userID1 {
+		t.Errorf("wanted to sign in as %d with the new email, got %d, %v", userID1, id, err)
+	}
+	if _, err := dbh.AuthenticateUser("test1@getstream.io", "secret"); err == nil {
+		t.Errorf("wanted the old email to no longer sign in")
+	}
+
+	tests := []struct {
+		Email  string
+		Wanted int
+	}{
+		{"test2@getstream.io", http.StatusConflict},
+		{"test2@GETSTREAM.IO", http.StatusConflict},
+		{"not an email", http.StatusBadRequest},
+		{"new1@getstream.io", http.StatusOK},
+	}
+	for _, test := range tests {
+		if response := patchEmail(api, userID1, test.Email); response.Code != test.Wanted {
+			t.Errorf("%q: wanted %d, got %d", test.Email, test.Wanted, response.Code)
+		}
+	}
+
+	if users := dbh.GetUsersByEmail([]string{"test2@getstream.io"}); len(users) != 1 || users[0].ID == userID1 {
+		t.Errorf("wanted the other user to keep their email, got %+v", users)
+	}
+}
+
+func TestEmailNormalization(t *testing.T) {
+	// Emails are normalized when registering, signing in and resolving users, so
+	// a differently cased domain finds the same user
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	response := postUser(api, userID1, createUserRequest{Email: " Test2@GetStream.IO ", Password: "secret"})
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	var created userResponse
+	if err := json.NewDecoder(response.Body).Decode(&created); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	if created.Email != "Test2@getstream.io" {
+		t.Errorf("wanted the normalized email, got %q", created.Email)
+	}
+
+	if response := postUser(api, userID1, createUserRequest{Email: "Test2@GETSTREAM.IO", Password: "secret"}); response.Code != http.StatusConflict {
+		t.Errorf("wanted %d, got %d", http.StatusConflict, response.Code)
+	}
+	if response := signin(api, "Test2@GETSTREAM.io", "secret"); response.Code != http.StatusOK {
+		t.Errorf("wanted to sign in, got %d", response.Code)
+	}
+
+	var errs validationErrors
+	users := resolveUserEmails(dbh, []userID{{Email: "Test2@GetStream.io"}}, &errs)
+	if len(errs) != 0 || len(users) != 1 || users[0].ID != created.ID {
+		t.Errorf("wanted to resolve user %d, got %+v, %+v", created.ID, users, errs)
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/errkind"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// requireAdmin only passes requests of administrators on, others get a 403
+func (api *API) requireAdmin(pass authenticatedHandler) authenticatedHandler {
+	return func(w http.ResponseWriter, r *http.Request, userID int) {
+		dbh := api.db.Connect()
+		isAdmin := dbh.IsAdmin(userID)
+		dbh.Close()
+
+		if !isAdmin {
+			log.Printf("User %d is not an administrator", userID)
+			writeError(w, http.StatusForbidden, "administrator access required")
+			return
+		}
+
+		pass(w, r, userID)
+	}
+}
+
+// parseMergePath parses a /users/{id}/merge-into/{targetId} path
+func parseMergePath(path string) (sourceID int, targetID int, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "users" || parts[2] != "merge-into" {
+		return 0, 0, false
+	}
+
+	sourceID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	targetID, err = strconv.Atoi(parts[3])
+	if err != nil {
+		return 0, 0, false
+	}
+	return sourceID, targetID, true
+}
+
+// counterparties returns the ids of the users userID has debts or credits with
+func counterparties(dbh database.Handle, userID int) []int {
+	balance := ledger.CalculateBalance(dbh.GetExpenses(userID), dbh.GetSettlements(userID), userID)
+	ids := make([]int, 0, len(balance.Debit)+len(balance.Credit))
+	for _, debts := range [][]ledger.Debt{balance.Debit, balance.Credit} {
+		for _, d := range debts {
+			ids = append(ids, d.UserID)
+		}
+	}
+	return ids
+}
+
+// postMergeUsers moves all data of a user to another account, e.g. when someone
+// registered twice, and deletes the user. The target inherits the debts and
+// credits, those between the two users cancel out. Only for administrators.
+func (api *API) postMergeUsers(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	sourceID, targetID, ok := parseMergePath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if sourceID == targetID {
+		var errs validationErrors
+		errs.add("target_id", "a user can't be merged into themselves")
+		errs.write(w)
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	// Everyone with a debt or credit with either user needs a new balance
+	affected := append(counterparties(dbh, sourceID), counterparties(dbh, targetID)...)
+
+	if err := dbh.MergeUsers(sourceID, targetID); err != nil {
+		switch errkind.Of(err) {
+		case errkind.NotFound:
+			log.Printf("Unable to merge unknown user %d into %d", sourceID, targetID)
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	log.Printf("User %d merged user %d into user %d", userID, sourceID, targetID)
+	api.cache.DeleteBalance(sourceID)
+	api.cache.RevokeSessions(sourceID, "")
+	api.forgetUser(sourceID)
+	api.updateBalance(dbh, targetID)
+	for _, id := range affected {
+		if id != sourceID && id != targetID {
+			api.updateBalance(dbh, id)
+		}
+	}
+
+	users := dbh.GetUsersByID([]int{targetID})
+	writeResponse(w, r, newUserResponse(users[0]))
+}
+
+type adminExpensesResponse struct {
+	Expenses []expenseResponse `json:"expenses"`
+}
+
+// getAdminExpenses returns the expenses of all users, whether or not the
+// requester takes part in them, in order of id. They are filtered by the
+// owner_id, participant_id, from, to, min_amount and max_amount query
+// parameters and paged with limit and offset. Only for administrators.
+func (api *API) getAdminExpenses(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	var errs validationErrors
+	q := database.ExpensesQuery{Limit: pageSize()}
+
+	for _, param := range []struct {
+		Name  string
+		Value *int
+	}{{"owner_id", &q.OwnerID}, {"participant_id", &q.ParticipantID}} {
+		if value := r.URL.Query().Get(param.Name); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil || id < 1 {
+				errs.add(param.Name, fmt.Sprintf("%s must be a positive integer", param.Name))
+			}
+			*param.Value = id
+		}
+	}
+
+	for _, param := range []struct {
+		Name  string
+		Value *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if value := r.URL.Query().Get(param.Name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errs.add(param.Name, fmt.Sprintf("unable to parse %s", param.Name))
+			}
+			*param.Value = t
+		}
+	}
+
+	for _, param := range []struct {
+		Name  string
+		Value *float64
+	}{{"min_amount", &q.MinAmount}, {"max_amount", &q.MaxAmount}} {
+		if value := r.URL.Query().Get(param.Name); value != "" {
+			amount, err := strconv.ParseFloat(value, 64)
+			if err != nil || amount <= 0 || math.IsInf(amount, 0) {
+				errs.add(param.Name, fmt.Sprintf("%s must be a positive number", param.Name))
+			}
+			*param.Value = amount
+		}
+	}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > *maxUsers {
+			errs.add("limit", fmt.Sprintf("limit must be between 1 and %d", *maxUsers))
+		} else {
+			q.Limit = limit
+		}
+	}
+
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			errs.add("offset", "offset must be a non-negative integer")
+		}
+		q.Offset = offset
+	}
+
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expenses := dbh.FindExpenses(q)
+	response := adminExpensesResponse{Expenses: make([]expenseResponse, len(expenses))}
+	for i, e := range expenses {
+		response.Expenses[i] = newExpenseResponse(e)
+	}
+
+	writeResponse(w, r, response)
+}
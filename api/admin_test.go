@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func postMergeUsers(api *API, userID int, sourceID int, targetID int) *httptest.ResponseRecorder {
+	path := fmt.Sprintf("/users/%d/merge-into/%d", sourceID, targetID)
+	request, _ := http.NewRequest(http.MethodPost, path, nil)
+	response := httptest.NewRecorder()
+	api.requireAdmin(api.postMergeUsers)(response, request, userID)
+	return response
+}
+
+func TestMergeUsers(t *testing.T) {
+	// Merge user 1 into user 2 and ensure user 2 inherits the debts and credits
+	// of user 1, while those between the two of them cancel out
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	expenses := []struct {
+		UserID      int
+		OtherUsers  []userID
+		Amount      float64
+		Description string
+	}{
+		{userID3, []userID{{ID: userID1}}, 30, "User 1 owes user 3 €15"},
+		{userID1, []userID{{ID: userID2}}, 20, "User 2 owes user 1 €10"},
+		{userID3, []userID{{ID: userID2}}, 40, "User 2 owes user 3 €20"},
+		{userID3, []userID{{ID: userID1}, {ID: userID2}}, 30, "Users 1 and 2 owe user 3 €10 each"},
+	}
+	for _, e := range expenses {
+		response := postExpense(api, e.UserID, createExpenseRequest{
+			Description: e.Description,
+			Amount:      e.Amount,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       e.OtherUsers,
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+	}
+
+	response := postSettlement(api, userID1, createSettlementRequest{UserID: userID3, Amount: 5, CreatedAt: "2021-01-02T15:04:05Z"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create settlement: %s", response.Body.String())
+	}
+
+	// User 1 owes user 3 €20 and is owed €10 by user 2, who owes user 3 €30
+	if got := getBalance(t, api, userID2); got.Balance != -40 {
+		t.Fatalf("wanted balance -40 before merging, got %+v", got)
+	}
+
+	response = postMergeUsers(api, adminID, userID1, userID2)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+
+	got := getBalance(t, api, userID2)
+	if got.Balance != -50 || len(got.Debit) != 1 || got.DebtTo(userID3) != 50 || len(got.Credit) != 0 {
+		t.Errorf("wanted user 2 to only owe user 3 €50, got %+v", got)
+	}
+
+	got = getBalance(t, api, userID3)
+	if got.Balance != 50 || len(got.Credit) != 1 || got.Credit[0].UserID != userID2 || got.Credit[0].Amount != 50 {
+		t.Errorf("wanted user 3 to only be owed €50 by user 2, got %+v", got)
+	}
+
+	// User 1 is gone
+	if _, err := dbh.AuthenticateUser("test1@getstream.io", "secret"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("wanted %v, got %v", database.ErrNotFound, err)
+	}
+
+	// User 1 can't be merged again
+	response = postMergeUsers(api, adminID, userID1, userID2)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("wanted %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
+
+func TestMergeUsersInvalid(t *testing.T) {
+	// Only administrators can merge users and only two different existing ones
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+
+	tests := []struct {
+		UserID   int
+		SourceID int
+		TargetID int
+		Wanted   int
+	}{
+		{userID1, userID1, userID2, http.StatusForbidden},
+		{adminID, userID1, userID1, http.StatusBadRequest},
+		{adminID, userID1, 42, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		response := postMergeUsers(api, test.UserID, test.SourceID, test.TargetID)
+		if response.Code != test.Wanted {
+			t.Errorf("merging %d into %d: wanted %d, got %d", test.SourceID, test.TargetID, test.Wanted, response.Code)
+		}
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, "/users/1/merge-with/2", nil)
+	response := httptest.NewRecorder()
+	api.requireAdmin(api.postMergeUsers)(response, request, adminID)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("wanted %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
+
+func getAdminExpenses(t *testing.T, api *API, userID int, query string) ([]expenseResponse, *httptest.ResponseRecorder) {
+	request, _ := http.NewRequest(http.MethodGet, "/admin/expenses?"+query, nil)
+	response := httptest.NewRecorder()
+	api.requireAdmin(api.getAdminExpenses)(response, request, userID)
+	if response.Code != http.StatusOK {
+		return nil, response
+	}
+
+	var got adminExpensesResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	return got.Expenses, response
+}
+
+func TestAdminExpenses(t *testing.T) {
+	// Administrators can list and filter the expenses of all users, others can't
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	expenses := []struct {
+		UserID      int
+		OtherUsers  []userID
+		Amount      float64
+		Description string
+		CreatedAt   string
+	}{
+		{userID1, []userID{{ID: userID2}}, 10, "Lunch", "2021-01-01T12:00:00Z"},
+		{userID2, []userID{{ID: userID3}}, 50, "Dinner", "2021-01-02T12:00:00Z"},
+		{userID3, []userID{{ID: userID1}}, 100, "Hotel", "2021-01-03T12:00:00Z"},
+		{userID1, []userID{{ID: userID3}}, 20, "Taxi", "2021-01-04T12:00:00Z"},
+	}
+	for _, e := range expenses {
+		response := postExpense(api, e.UserID, createExpenseRequest{
+			Description: e.Description,
+			Amount:      e.Amount,
+			CreatedAt:   e.CreatedAt,
+			Users:       e.OtherUsers,
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+	}
+
+	_, response := getAdminExpenses(t, api, userID1, "")
+	if response.Code != http.StatusForbidden {
+		t.Errorf("wanted %d for a non-admin, got %d", http.StatusForbidden, response.Code)
+	}
+
+	tests := []struct {
+		Query  string
+		Wanted []string
+	}{
+		{"", []string{"Lunch", "Dinner", "Hotel", "Taxi"}},
+		{fmt.Sprintf("owner_id=%d", userID1), []string{"Lunch", "Taxi"}},
+		{fmt.Sprintf("participant_id=%d", userID3), []string{"Dinner", "Hotel", "Taxi"}},
+		{"from=2021-01-02T00:00:00Z&to=2021-01-03T12:00:00Z", []string{"Dinner", "Hotel"}},
+		{"min_amount=20&max_amount=50", []string{"Dinner", "Taxi"}},
+		{fmt.Sprintf("participant_id=%d&min_amount=50", userID1), []string{"Hotel"}},
+		{"limit=2", []string{"Lunch", "Dinner"}},
+		{"limit=2&offset=2", []string{"Hotel", "Taxi"}},
+		{"offset=4", []string{}},
+	}
+	for _, test := range tests {
+		got, response := getAdminExpenses(t, api, adminID, test.Query)
+		if response.Code != http.StatusOK {
+			t.Errorf("%q: wanted %d, got %d: %s", test.Query, http.StatusOK, response.Code, response.Body.String())
+			continue
+		}
+		descriptions := make([]string, len(got))
+		for i, e := range got {
+			descriptions[i] = e.Description
+		}
+		if !reflect.DeepEqual(descriptions, test.Wanted) {
+			t.Errorf("%q: wanted %v, got %v", test.Query, test.Wanted, descriptions)
+		}
+	}
+
+	for _, query := range []string{"owner_id=x", "participant_id=0", "from=yesterday", "min_amount=-1", "limit=0", "offset=-1"} {
+		if _, response := getAdminExpenses(t, api, adminID, query); response.Code != http.StatusBadRequest {
+			t.Errorf("%q: wanted %d, got %d", query, http.StatusBadRequest, response.Code)
+		}
+	}
+}
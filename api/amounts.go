@@ -0,0 +1,115 @@
+package api
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+// amountFormat is how amounts are represented in responses
+type amountFormat string
+
+// Amounts are JSON numbers by default. Clients that don't want to parse floats
+// can get decimal strings, e.g. "14.00", or integers in minor units, e.g. 1400.
+const (
+	amountsAsNumbers  amountFormat = ""
+	amountsAsDecimals amountFormat = "decimal"
+	amountsAsCents    amountFormat = "cents"
+)
+
+// parseAmountFormat parses the amounts query parameter
+func parseAmountFormat(value string) (amountFormat, bool) {
+	switch format := amountFormat(value); format {
+	case amountsAsNumbers, amountsAsDecimals, amountsAsCents:
+		return format, true
+	default:
+		return "", false
+	}
+}
+
+// formatAmount returns an amount in the minor unit of currency as format
+func formatAmount(amount float64, currency string, format amountFormat) interface{} {
+	decimals := ledger.Decimals(currency)
+	switch format {
+	case amountsAsDecimals:
+		return strconv.FormatFloat(ledger.RoundAmount(amount, currency), 'f', decimals, 64)
+	case amountsAsCents:
+		return int64(math.Round(amount * math.Pow10(decimals)))
+	default:
+		return amount
+	}
+}
+
+type debtItemView struct {
+	ExpenseID    int         `json:"expense_id,omitempty"`
+	SettlementID int         `json:"settlement_id,omitempty"`
+	Amount       interface{} `json:"amount"`
+	Display      string      `json:"display,omitempty"`
+}
+
+type debtView struct {
+	UserID    int            `json:"user_id"`
+	Name      string         `json:"name,omitempty"`
+	Amount    interface{}    `json:"amount"`
+	Display   string         `json:"display,omitempty"`
+	Breakdown []debtItemView `json:"breakdown,omitempty"`
+}
+
+type balanceView struct {
+	Balance interface{} `json:"balance"`
+	Display string      `json:"display,omitempty"`
+	Debit   []debtView  `json:"debit"`
+	Credit  []debtView  `json:"credit"`
+
+	FromCache  bool      `json:"from_cache"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// formatBalance returns a balance with its amounts in format and, if display is
+// set, also formatted for presentation. Balances aren't kept per currency, so
+// the default currency is used.
+func formatBalance(balance ledger.Balance, format amountFormat, display *localeFormat) interface{} {
+	if format == amountsAsNumbers && display == nil {
+		return balance
+	}
+
+	present := func(amount float64) string {
+		if display == nil {
+			return ""
+		}
+		return display.formatMoney(amount, *defaultCurrency)
+	}
+
+	debts := func(debts []ledger.Debt) []debtView {
+		views := make([]debtView, len(debts))
+		for i, d := range debts {
+			views[i] = debtView{
+				UserID:  d.UserID,
+				Name:    d.Name,
+				Amount:  formatAmount(d.Amount, *defaultCurrency, format),
+				Display: present(d.Amount),
+			}
+			for _, item := range d.Breakdown {
+				views[i].Breakdown = append(views[i].Breakdown, debtItemView{
+					ExpenseID:    item.ExpenseID,
+					SettlementID: item.SettlementID,
+					Amount:       formatAmount(item.Amount, *defaultCurrency, format),
+					Display:      present(item.Amount),
+				})
+			}
+		}
+		return views
+	}
+
+	return balanceView{
+		Balance: formatAmount(balance.Balance, *defaultCurrency, format),
+		Display: present(balance.Balance),
+		Debit:   debts(balance.Debit),
+		Credit:  debts(balance.Credit),
+
+		FromCache:  balance.FromCache,
+		ComputedAt: balance.ComputedAt,
+	}
+}
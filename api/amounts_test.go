@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+func TestFormatBalance(t *testing.T) {
+	// The same balance is serialized with numbers, decimal strings and cents
+
+	balance := ledger.Balance{
+		Balance: 14.1,
+		Credit: []ledger.Debt{{
+			UserID:    2,
+			Amount:    14.1,
+			Breakdown: []ledger.DebtItem{{ExpenseID: 1, Amount: -14.1}},
+		}},
+		Debit:      []ledger.Debt{},
+		FromCache:  true,
+		ComputedAt: time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC),
+	}
+
+	tests := []struct {
+		Format amountFormat
+		Wanted string
+	}{
+		{amountsAsNumbers, `{"balance":14.1,"debit":[],"credit":[{"user_id":2,"amount":14.1,"breakdown":[{"expense_id":1,"amount":-14.1}]}],"from_cache":true,"computed_at":"2021-01-02T15:04:05Z"}`},
+		{amountsAsDecimals, `{"balance":"14.10","debit":[],"credit":[{"user_id":2,"amount":"14.10","breakdown":[{"expense_id":1,"amount":"-14.10"}]}],"from_cache":true,"computed_at":"2021-01-02T15:04:05Z"}`},
+		{amountsAsCents, `{"balance":1410,"debit":[],"credit":[{"user_id":2,"amount":1410,"breakdown":[{"expense_id":1,"amount":-1410}]}],"from_cache":true,"computed_at":"2021-01-02T15:04:05Z"}`},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(formatBalance(balance, test.Format, nil))
+		if err != nil {
+			t.Fatalf("Unable to marshal balance: %v", err)
+		}
+		if string(data) != test.Wanted {
+			t.Errorf("%q: wanted %s, got %s", test.Format, test.Wanted, data)
+		}
+	}
+}
+
+func TestGetBalanceAmounts(t *testing.T) {
+	// The balance endpoint formats amounts as requested, with a different ETag per
+	// format
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      28,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+
+	etags := make(map[string]bool)
+	for _, test := range []struct {
+		Query  string
+		Code   int
+		Wanted interface{}
+	}{
+		{"", http.StatusOK, 14.0},
+		{"amounts=decimal", http.StatusOK, "14.00"},
+		{"amounts=cents", http.StatusOK, 1400.0},
+		{"amounts=pennies", http.StatusBadRequest, nil},
+	} {
+		request, _ := http.NewRequest(http.MethodGet, "/balance?"+test.Query, nil)
+		response := httptest.NewRecorder()
+		api.getBalance(response, request, userID1)
+		if response.Code != test.Code {
+			t.Errorf("%q: wanted %d, got %d", test.Query, test.Code, response.Code)
+		}
+		if test.Code != http.StatusOK {
+			continue
+		}
+
+		var got map[string]interface{}
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if got["balance"] != test.Wanted {
+			t.Errorf("%q: wanted balance %v, got %v", test.Query, test.Wanted, got["balance"])
+		}
+		etags[response.Header().Get("ETag")] = true
+	}
+
+	if len(etags) != 3 {
+		t.Errorf("wanted 3 different ETags, got %v", etags)
+	}
+}
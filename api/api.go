@@ -1,23 +1,35 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/freewilll/splitter/cache"
 	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/errkind"
 	"github.com/freewilll/splitter/jwt"
 	"github.com/freewilll/splitter/ledger"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
-const jwtCookieName = "jwt-token"
-
 type handler func(w http.ResponseWriter, r *http.Request)
 type authenticatedHandler func(w http.ResponseWriter, r *http.Request, userID int)
 
@@ -28,15 +40,30 @@ type errorResponse struct {
 type userResponse struct {
 	ID    int    `json:"id"`
 	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// newUserResponse returns the response for a user in the database
+func newUserResponse(u database.User) userResponse {
+	return userResponse{ID: u.ID, Email: u.Email, Name: u.Name}
 }
 
 type usersResponse struct {
 	Users []userResponse `json:"users"`
 }
 
+type resolveUsersRequest struct {
+	IDs []int `json:"ids"`
+}
+
+type resolveUsersResponse struct {
+	Users map[int]string `json:"users"`
+}
+
 type createUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Name     string `json:"name"` // Optional display name
 }
 
 type authRequest struct {
@@ -44,34 +71,252 @@ type authRequest struct {
 	Password string `json:"password"`
 }
 
+// userID identifies a user by their id or email
 type userID struct {
-	ID int `json:"id"`
+	ID    int    `json:"id"`
+	Email string `json:"email"`
 }
 
 type createExpenseRequest struct {
 	Description string   `json:"description"`
 	Amount      float64  `json:"amount"`
+	Currency    string   `json:"currency"` // Optional ISO 4217 currency code, defaults to the owner's default currency
 	CreatedAt   string   `json:"created_at"`
 	Users       []userID `json:"users"`
+	PayerID     int      `json:"payer_id"` // Optional, defaults to self
+
+	PercentageSplit map[int]float64  `json:"percentage_split"` // Optional, keyed by user id including self
+	ShareSplit      map[int]int      `json:"share_split"`      // Optional number of shares, keyed by user id including self
+	BasePerPerson   float64          `json:"base_per_person"`  // Optional fixed amount per user, the rest is split
+	Tags            []string         `json:"tags"`             // Optional free-form tags
+	IncludeOwner    *bool            `json:"include_owner"`    // Optional, false if the owner doesn't share the expense
+	SplitAmongAll   bool             `json:"split_among_all"`  // Optional, share with all friends instead of users
+	Location        *expenseLocation `json:"location"`         // Optional place where the expense was incurred
+	Notes           string           `json:"notes"`            // Optional longer note, only shown on the expense itself
+	Type            string           `json:"type"`             // Optional expense, the default, or reimbursement
+}
+
+type expenseResponse struct {
+	ID              int              `json:"id"`
+	OwnerID         int              `json:"owner_id"`
+	PayerID         int              `json:"payer_id"`
+	Users           []int            `json:"users"`
+	Description     string           `json:"description"`
+	Amount          float64          `json:"amount"`
+	Currency        string           `json:"currency"`
+	CreatedAt       time.Time        `json:"created_at"`
+	PercentageSplit map[int]float64  `json:"percentage_split,omitempty"`
+	ShareSplit      map[int]int      `json:"share_split,omitempty"`
+	BasePerPerson   float64          `json:"base_per_person,omitempty"`
+	Location        *expenseLocation `json:"location,omitempty"`
+	Display         *expenseDisplay  `json:"display,omitempty"` // Only if requested
+	Notes           string           `json:"notes,omitempty"`   // Only on a single expense
+	Type            string           `json:"type"`
+	Tags            []string         `json:"tags"`
+}
+
+type settlementResponse struct {
+	ID         int       `json:"id"`
+	FromUserID int       `json:"from_user_id"`
+	ToUserID   int       `json:"to_user_id"`
+	Amount     float64   `json:"amount"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // API holds the config and functionality for HTTP REST/JSON API for the application
 type API struct {
-	db    database.Database // The authoritative data store
-	cache cache.Cache       // Cache for balances
+	db      database.Database // The authoritative data store
+	cache   cache.Cache       // Cache for balances
+	metrics metrics           // Counters for the metrics endpoint
+
+	existence existenceCache // Users recently seen to exist
+
+	descriptionValidator DescriptionValidator // Checks descriptions of new expenses
+
+	balanceLocks keyedMutex // Serializes balance updates per user
+
+	idempotency idempotencyKeys // Results of registrations by Idempotency-Key
+
+	imports importLimiter // Last user import of each administrator
+
+	now func() time.Time // Returns the current time, replaced in tests
 }
 
 // serverPort is the TCP port the API listens on
 var serverPort = flag.Int("server-port", 8080, "web server port")
 
+// cookieName is the name of the cookie with the JWT token and cookieDomain its
+// domain, e.g. example.com to share it with all subdomains. Without a domain, the
+// cookie is only sent to the host that set it.
+var cookieName = flag.String("cookie-name", "jwt-token", "name of the cookie with the jwt token")
+var cookieDomain = flag.String("cookie-domain", "", "domain of the cookie with the jwt token, the host that set it if empty")
+
+// allowedEmailDomains is a comma separated list of the email domains users can
+// register with. All domains are allowed if it's empty.
+var allowedEmailDomains = flag.String("allowed-email-domains", "", "comma separated list of email domains allowed to register, all if empty")
+
+// maxFailedLogins is the number of consecutive failed sign ins after which an
+// account is locked for lockoutCooldown
+var maxFailedLogins = flag.Int("max-failed-logins", 5, "number of failed sign ins before an account is locked")
+var lockoutCooldown = flag.Duration("lockout-cooldown", 15*time.Minute, "time an account is locked after too many failed sign ins")
+
+// readOnly rejects all requests that would change data, e.g. during maintenance
+var readOnly = flag.Bool("read-only", false, "reject all mutating requests with a 503")
+
+// minAmount and maxAmount are the inclusive bounds for the amount of an expense
+var minAmount = flag.Float64("min-amount", 0.01, "minimum expense amount")
+var maxAmount = flag.Float64("max-amount", 1000000, "maximum expense amount")
+
+// validateMinorUnits rejects expense amounts with more decimals than the minor
+// unit of their currency has, e.g. JPY 10.5
+var validateMinorUnits = flag.Bool("validate-minor-units", true, "reject expense amounts that aren't a whole number of minor units of the currency")
+
+// defaultCurrency is the currency of expenses created without one, unless the
+// owner has set their own default currency
+var defaultCurrency = flag.String("default-currency", "EUR", "ISO 4217 currency code of expenses without a currency")
+
+// minOtherUsers is the minimum number of users besides the owner sharing an
+// expense. Zero allows personal expenses that don't create any debts.
+var minOtherUsers = flag.Int("min-other-users", 1, "minimum number of other users in an expense")
+
+// maxDescriptionLength is the maximum number of characters in an expense description
+var maxDescriptionLength = flag.Int("max-description-length", 500, "maximum expense description length")
+
+// maxNotesLength is the maximum number of characters in the notes of an expense
+var maxNotesLength = flag.Int("max-notes-length", 5000, "maximum expense notes length")
+
+// duplicateWindow is how close in time two otherwise identical expenses must be
+// to be considered duplicates
+var duplicateWindow = flag.Duration("duplicate-window", time.Minute, "time window for duplicate expense detection")
+
+// maxExpensesPerDay is the maximum number of expenses a user can create per day,
+// starting at midnight UTC. Zero allows any number.
+var maxExpensesPerDay = flag.Int("max-expenses-per-day", 0, "maximum number of expenses a user can create per day, 0 for no limit")
+
+// editWindow is how long after an expense was recorded it can still be changed
+// or deleted. Zero allows changing expenses of any age.
+var editWindow = flag.Duration("edit-window", 0, "time after recording expenses can be changed or deleted, 0 for no limit")
+
+// isTooOldToEdit returns true if an expense is past the edit window at now. The
+// window starts when the expense was recorded, not at its date chosen by the
+// client, so that back-dated expenses can still be corrected.
+func isTooOldToEdit(e ledger.Expense, now time.Time) bool {
+	return *editWindow > 0 && now.Sub(e.RecordedAt) > *editWindow
+}
+
+// maxUsers is the maximum number of users returned when listing users, i.e. the
+// maximum page size, and defaultPageSize the number returned without a limit
+var maxUsers = flag.Int("max-users", 100, "maximum number of users returned")
+var defaultPageSize = flag.Int("default-page-size", 100, "number of items returned by listings without a limit")
+
+// defaultExpenseOrder is the order of listed expenses when a request doesn't ask
+// for one
+var defaultExpenseOrder = flag.String("expense-order", "oldest", "order of listed expenses without an order parameter: oldest or newest")
+
+// pageSize returns the number of items listed without a limit
+func pageSize() int {
+	if *defaultPageSize > *maxUsers {
+		return *maxUsers
+	}
+	return *defaultPageSize
+}
+
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
 // NewAPI Creates a new instance of the HTTP REST/JSON API for the application
 func NewAPI(db database.Database, cache cache.Cache) *API {
-	return &API{db: db, cache: cache}
+	return &API{
+		db:                   db,
+		cache:                cache,
+		existence:            existenceCache{checked: make(map[int]time.Time)},
+		descriptionValidator: noopDescriptionValidator{},
+		now:                  time.Now,
+	}
 }
 
-// writeJSON marshalls data into a response with content-type application/json
+// expenseDisplay is an expense's amount and date formatted for presentation
+type expenseDisplay struct {
+	Amount    string `json:"amount"`
+	CreatedAt string `json:"created_at"`
+}
+
+// newExpenseResponse converts a ledger expense into its JSON representation
+func newExpenseResponse(e ledger.Expense) expenseResponse {
+	tags := e.Tags
+	if tags == nil {
+		tags = make([]string, 0)
+	}
+
+	expenseType := e.Type
+	if expenseType == "" {
+		expenseType = ledger.TypeExpense
+	}
+
+	return expenseResponse{
+		ID:              e.ExpenseID,
+		OwnerID:         e.OwnerID,
+		PayerID:         e.Payer(),
+		Users:           e.Users,
+		Description:     e.Description,
+		Amount:          e.Amount,
+		Currency:        e.Currency,
+		CreatedAt:       e.CreatedAt,
+		PercentageSplit: e.PercentageSplit,
+		ShareSplit:      e.ShareSplit,
+		BasePerPerson:   e.BasePerPerson,
+		Location:        newExpenseLocation(e.Location),
+		Type:            string(expenseType),
+		Tags:            tags,
+	}
+}
+
+// newSettlementResponse converts a ledger settlement into its JSON representation
+func newSettlementResponse(s ledger.Settlement) settlementResponse {
+	return settlementResponse{
+		ID:         s.SettlementID,
+		FromUserID: s.FromUserID,
+		ToUserID:   s.ToUserID,
+		Amount:     s.Amount,
+		CreatedAt:  s.CreatedAt,
+	}
+}
+
+// msgpackContentType is the content type of MessagePack encoded responses
+const msgpackContentType = "application/msgpack"
+
+// acceptsMsgpack returns true if the client accepts MessagePack encoded responses
+func acceptsMsgpack(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		if strings.EqualFold(mediaType, msgpackContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResponse marshalls data into a response. The response is encoded with
+// MessagePack if the client accepts it, JSON otherwise. The JSON field names are
+// used for both.
+func writeResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
+	if !acceptsMsgpack(r) {
+		writeJSON(w, data)
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(data); err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", msgpackContentType)
+	w.Write(buf.Bytes())
+}
+
+// writeJSON marshalls data into a response with content-type application/json.
+// Errors are always written as JSON.
 func writeJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	result, err := json.Marshal(data)
@@ -81,17 +326,52 @@ func writeJSON(w http.ResponseWriter, data interface{}) {
 	io.WriteString(w, string(result))
 }
 
-// writeError writes a status code and error message
+// writeError writes a status code and error message. The content type is set
+// first, headers set after the status code are ignored.
 func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	writeJSON(w, errorResponse{message})
 }
 
+// notFound writes a JSON 404, for requests to unknown paths
+func notFound(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Unknown path %s", r.URL.Path)
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+// methodNotAllowed writes a JSON 405 with an Allow header listing the allowed
+// methods of the endpoint
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// recoverPanics turns a panic in next, e.g. due to a failing database query, into
+// a 500 response
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("Internal error handling %s %s: %v", r.Method, r.URL.Path, err)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // signin handles user authentication with POST requests to the signin endpoint
-// If the user authenticates successfully, a JWT token is set in a cookie
+// If the user authenticates successfully, a JWT token is set in a cookie and the
+// user is returned
 func (api *API) signin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		methodNotAllowed(w, "POST")
+		return
 	}
 
 	dbh := api.db.Connect()
@@ -104,12 +384,21 @@ func (api *API) signin(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
 		return
 	}
+	a.Email = normalizeEmail(a.Email)
+
+	// Refuse to even check the password of a locked account
+	if api.cache.GetFailedLogins(a.Email) >= *maxFailedLogins {
+		log.Printf("Account '%s' is locked", a.Email)
+		writeError(w, http.StatusTooManyRequests, "too many failed sign in attempts, try again later")
+		return
+	}
 
 	id, err := dbh.AuthenticateUser(a.Email, a.Password)
 	if err != nil {
-		switch err {
-		case database.ErrNotFound, database.ErrPasswordMismatch:
+		switch errkind.Of(err) {
+		case errkind.NotFound, errkind.PasswordMismatch:
 			log.Printf("Authentication failed for '%s'", a.Email)
+			api.cache.RecordFailedLogin(a.Email, *lockoutCooldown)
 			writeError(w, http.StatusUnauthorized, "authorization failed")
 			return
 		default:
@@ -117,48 +406,119 @@ func (api *API) signin(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	cookie := jwt.CreateCookie(id, jwtCookieName)
+	api.cache.ResetFailedLogins(a.Email)
+
+	session := jwt.Session{UserID: id, TokenID: jwt.NewTokenID()}
+	cookie := jwt.CreateCookie(session, *cookieName, *cookieDomain)
+	api.cache.AddSession(id, session.TokenID, cookie.Expires.Sub(api.now()))
 	http.SetCookie(w, &cookie)
+	writeResponse(w, r, newUserResponse(dbh.GetUsersByID([]int{id})[0]))
 }
 
 // requireAuth is a handler wrapper to ensures a user is authenticated. The userID
 // is passed on to the next handler in the chain.
 func (api *API) requireAuth(pass authenticatedHandler) handler {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie(jwtCookieName)
+		c, err := r.Cookie(*cookieName)
 		if err != nil {
-			if err == http.ErrNoCookie {
+			if errors.Is(err, http.ErrNoCookie) {
 				log.Printf("Missing jwt cookie")
 				writeError(w, http.StatusUnauthorized, "authorization failed")
 				return
 			}
 			panic(err)
-			return
 		}
 
-		userID, ok := jwt.VerifyToken(c.Value)
+		session, ok := jwt.VerifyToken(c.Value, api.isValidSession)
 		if !ok {
 			writeError(w, http.StatusUnauthorized, "authorization failed")
 			return
 		}
 
+		if *checkUserExists && !api.userExists(session.UserID) {
+			log.Printf("Rejecting token of deleted user %d", session.UserID)
+			writeError(w, http.StatusUnauthorized, "authorization failed")
+			return
+		}
+
 		// Greetings, Professor Falken.
-		pass(w, r, userID)
+		pass(w, r, session.UserID)
 	}
 }
 
-// getUsers returns all users in the database
+// readOnlyPaths are the paths that accept POST requests in read-only mode, since
+// they only read data
+var readOnlyPaths = map[string]bool{
+	"/signin":           true,
+	"/users/resolve":    true,
+	"/balances":         true,
+	"/simulate/balance": true,
+}
+
+// rejectWritesIfReadOnly is a handler wrapper that responds with a 503 to POST,
+// PUT, PATCH and DELETE requests while the API is in read-only mode, unless the
+// path is in readOnlyPaths
+func rejectWritesIfReadOnly(pass handler) handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *readOnly && !readOnlyPaths[r.URL.Path] {
+			switch r.Method {
+			case "POST", "PUT", "PATCH", "DELETE":
+				log.Printf("Rejecting %s %s in read-only mode", r.Method, r.URL.Path)
+				writeError(w, http.StatusServiceUnavailable, "the service is in read-only mode")
+				return
+			}
+		}
+
+		pass(w, r)
+	}
+}
+
+// getUsers returns the users in the database. They are ordered by the optional
+// order_by (email or id) and order (asc or desc) query parameters and capped at
+// the optional limit, which can't exceed maxUsers.
 func (api *API) getUsers(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	q := database.UsersQuery{OrderBy: database.OrderByEmail, Limit: pageSize()}
+
+	switch orderBy := database.UserOrder(r.URL.Query().Get("order_by")); orderBy {
+	case "":
+	case database.OrderByEmail, database.OrderByID:
+		q.OrderBy = orderBy
+	default:
+		errs.add("order_by", "order_by must be email or id")
+	}
+
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		errs.add("order", "order must be asc or desc")
+	}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > *maxUsers {
+			errs.add("limit", fmt.Sprintf("limit must be between 1 and %d", *maxUsers))
+		} else {
+			q.Limit = limit
+		}
+	}
+
+	if errs.write(w) {
+		return
+	}
+
 	dbh := api.db.Connect()
 	defer dbh.Close()
 
-	dbUsers := dbh.GetUsers()
+	dbUsers := dbh.GetUsers(q)
 	users := usersResponse{Users: make([]userResponse, len(dbUsers))}
 	for i, u := range dbUsers {
-		users.Users[i] = userResponse{ID: u.ID, Email: u.Email}
+		users.Users[i] = newUserResponse(u)
 	}
 
-	writeJSON(w, users)
+	writeResponse(w, r, users)
 }
 
 // isEmailValid checks if the email provided passes the required structure and length.
@@ -169,11 +529,46 @@ func isEmailValid(e string) bool {
 	return emailRegex.MatchString(e)
 }
 
+// isEmailDomainAllowed checks if the domain of an email is in allowedEmailDomains
+func isEmailDomainAllowed(e string) bool {
+	if *allowedEmailDomains == "" {
+		return true
+	}
+
+	domain := strings.ToLower(e[strings.LastIndex(e, "@")+1:])
+	for _, allowed := range strings.Split(*allowedEmailDomains, ",") {
+		if domain == strings.ToLower(strings.TrimSpace(allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEmail removes surrounding whitespace and lowercases the domain of an
+// email. The local part is kept as is, since it may be case sensitive. Every
+// email is normalized before it is stored or looked up.
+func normalizeEmail(e string) string {
+	e = strings.TrimSpace(e)
+	at := strings.LastIndex(e, "@")
+	return e[:at+1] + strings.ToLower(e[at+1:])
+}
+
+// validateEmail checks an email is valid, allowed and not in the blocklist
+func validateEmail(e string, blocklist emailBlocklist, errs *validationErrors) {
+	if !isEmailValid(e) {
+		errs.add("email", "invalid email address")
+	} else if !isEmailDomainAllowed(e) {
+		errs.add("email", "email domain is not allowed")
+	} else if blocklist.blocks(e) {
+		errs.add("email", "email address is blocked")
+	}
+}
+
 // postUsers is the user registration endpoint. Some validation is done, then
 // the user is added to the database. A 409 (conflict) is returned if the user already
 // exists.
-func (api *API) postUsers(w http.ResponseWriter, r *http.Request) {
-	dbh := api.db.Connect()
+func (api *API) postUsers(w http.ResponseWriter, r *http.Request, userID int) {
+	dbh := api.connect(userID)
 	defer dbh.Close()
 
 	// Decode request
@@ -186,34 +581,93 @@ func (api *API) postUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate email and password
-	if len(u.Password) < 6 {
-		log.Printf("Invalid password")
-		writeError(w, http.StatusBadRequest, "invalid password: it must be at least 6 characters")
-		return
+	var errs validationErrors
+	u.Email = normalizeEmail(u.Email)
+	validateEmail(u.Email, mustLoadEmailBlocklist(), &errs)
+	validatePassword(u.Password, "password", &errs)
+	u.Name = validateName(u.Name, &errs)
 
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLength {
+		errs.add("Idempotency-Key", fmt.Sprintf("idempotency key must be at most %d characters", maxIdempotencyKeyLength))
 	}
 
-	if !isEmailValid(u.Email) {
-		log.Printf("Invalid email '%s'", u.Email)
-		writeError(w, http.StatusBadRequest, "invalid email address")
+	if errs.write(w) {
 		return
 	}
 
+	// A retry with the same idempotency key gets the original result. Reusing
+	// the key for a different request is an error.
+	fingerprint := requestFingerprint(u)
+	if key != "" {
+		api.idempotency.registering.Lock()
+		defer api.idempotency.registering.Unlock()
+
+		if result, ok := api.idempotency.lookup(key, api.now()); ok {
+			if result.fingerprint != fingerprint {
+				log.Printf("Idempotency key '%s' reused for a different registration", key)
+				writeError(w, http.StatusUnprocessableEntity, "idempotency key was used for a different request")
+				return
+			}
+			log.Printf("Replaying registration of user %d for idempotency key '%s'", result.response.ID, key)
+			writeResponse(w, r, result.response)
+			return
+		}
+	}
+
 	// Add the user to the database
 	log.Printf("Adding user email='%s'", u.Email)
 
 	id, err := dbh.CreateUser(u.Email, u.Password)
 	if err != nil {
-		switch err {
-		case database.ErrDuplicate:
+		switch errkind.Of(err) {
+		case errkind.Duplicate:
 			log.Printf("User uniqueness failed for email '%s'", u.Email)
 			writeError(w, http.StatusConflict, "a user with that email already exists")
+			return
 		default:
 			panic(err)
 		}
 	}
 
-	writeJSON(w, userResponse{ID: id, Email: u.Email})
+	if u.Name != "" {
+		if err := dbh.SetName(id, u.Name); err != nil {
+			panic(err)
+		}
+	}
+
+	response := userResponse{ID: id, Email: u.Email, Name: u.Name}
+	if key != "" {
+		api.idempotency.remember(key, idempotentResult{fingerprint: fingerprint, response: response}, api.now())
+	}
+	writeResponse(w, r, response)
+}
+
+// expenses handles the expenses endpoint for the GET and POST methods
+func (api *API) expenses(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method == "GET" {
+		api.getExpenses(w, r, userID)
+	} else if r.Method == "POST" {
+		api.postExpenses(w, r, userID)
+	} else {
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// expense handles the endpoints of a single expense, /expenses/{id} for the GET,
+// PUT and DELETE methods, /expenses/{id}/tags and /expenses/{id}/breakdown
+func (api *API) expense(w http.ResponseWriter, r *http.Request, userID int) {
+	if strings.HasSuffix(r.URL.Path, "/breakdown") {
+		api.getExpenseBreakdown(w, r, userID)
+	} else if strings.HasSuffix(r.URL.Path, "/tags") {
+		api.postExpenseTags(w, r, userID)
+	} else if r.Method == "PUT" {
+		api.putExpense(w, r, userID)
+	} else if r.Method == "DELETE" {
+		api.deleteExpense(w, r, userID)
+	} else {
+		api.getExpense(w, r, userID)
+	}
 }
 
 // users handles the users endpoint for the GET and POST methods
@@ -221,21 +675,76 @@ func (api *API) users(w http.ResponseWriter, r *http.Request, userID int) {
 	if r.Method == "GET" {
 		api.getUsers(w, r)
 	} else if r.Method == "POST" {
-		api.postUsers(w, r)
+		api.postUsers(w, r, userID)
 	} else {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// isDuplicateExpense returns true if two expenses have the same owner, amount and
+// description and were incurred within duplicateWindow of each other
+func isDuplicateExpense(a ledger.Expense, b ledger.Expense) bool {
+	if a.OwnerID != b.OwnerID || a.Amount != b.Amount || a.Description != b.Description {
+		return false
+	}
+
+	delta := a.CreatedAt.Sub(b.CreatedAt)
+	if delta < 0 {
+		delta = -delta
 	}
+	return delta <= *duplicateWindow
+}
+
+// resolveUsers returns the emails of a list of user ids. Unknown ids are left out
+// of the response.
+func (api *API) resolveUsers(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	var req resolveUsersRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	resolved := resolveUsersResponse{Users: make(map[int]string)}
+	for _, u := range dbh.GetUsersByID(req.IDs) {
+		resolved.Users[u.ID] = u.Email
+	}
+
+	writeResponse(w, r, resolved)
 }
 
 // postExpenses adds an expense
 func (api *API) postExpenses(w http.ResponseWriter, r *http.Request, userID int) {
 	if r.Method != "POST" {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		methodNotAllowed(w, "POST")
+		return
 	}
 
-	dbh := api.db.Connect()
+	dbh := api.connect(userID)
 	defer dbh.Close()
 
+	// Enforce the daily quota of the user
+	if *maxExpensesPerDay > 0 {
+		now := api.now().UTC()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if dbh.CountExpensesSince(userID, today) >= *maxExpensesPerDay {
+			log.Printf("User %d has reached the daily quota of %d expenses", userID, *maxExpensesPerDay)
+			retryAfter := today.AddDate(0, 0, 1).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, fmt.Sprintf("at most %d expenses can be created per day", *maxExpensesPerDay))
+			return
+		}
+	}
+
 	// Decode request
 	var e createExpenseRequest
 	err := json.NewDecoder(r.Body).Decode(&e)
@@ -245,46 +754,133 @@ func (api *API) postExpenses(w http.ResponseWriter, r *http.Request, userID int)
 		return
 	}
 
-	// Validate description, amount and created_at
-	if e.Description == "" {
-		log.Printf("Invalid description '%s'", e.Description)
-		writeError(w, http.StatusBadRequest, "description must not be empty")
+	var errs validationErrors
+	expense := api.expenseFromRequest(dbh, e, userID, &errs)
+	if errs.write(w) {
 		return
 	}
 
+	// Check for an accidental re-submit of the same expense, unless forced
+	if r.URL.Query().Get("force") != "true" {
+		for _, existing := range dbh.GetExpenses(userID) {
+			if isDuplicateExpense(existing, expense) {
+				log.Printf("Duplicate of expense %d", existing.ExpenseID)
+				writeError(w, http.StatusConflict, "a similar expense already exists, use force=true to create it anyway")
+				return
+			}
+		}
+	}
+
+	// Create the entries in the database
+	log.Printf(
+		"Adding expense user_id=%d, payer_id=%d, description='%s', amount=%0.2f, created_at=%s users=%+v",
+		userID, expense.PayerID, expense.Description, expense.Amount, expense.CreatedAt, expense.Users)
+
+	if err := dbh.CreateExpense(expense); err != nil {
+		switch errkind.Of(err) {
+		case errkind.LimitExceeded:
+			errs.add("users", fmt.Sprintf("at most %d users can share an expense", database.MaxParticipants))
+			errs.write(w)
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	// Write through the balances of everyone sharing the expense to the cache,
+	// since warmed balances may be cached for long
+	api.updateBalance(dbh, userID)
+	for _, u := range expense.Users {
+		if u != userID {
+			api.updateBalance(dbh, u)
+		}
+	}
+
+	// Return the result to the client
+	w.WriteHeader(http.StatusCreated)
+}
+
+// expenseFromRequest validates a request of userID to create or change an
+// expense and returns the expense it describes. Problems are added to errs.
+func (api *API) expenseFromRequest(dbh database.Handle, e createExpenseRequest, userID int, errs *validationErrors) ledger.Expense {
+	// Normalize whitespace in the description
+	e.Description = strings.Join(strings.Fields(e.Description), " ")
+
+	// Validate description, currency, amount and created_at
+	if e.Description == "" {
+		errs.add("description", "description must not be empty")
+	} else if utf8.RuneCountInString(e.Description) > *maxDescriptionLength {
+		errs.add("description", fmt.Sprintf("description must be at most %d characters", *maxDescriptionLength))
+	} else if description, err := api.descriptionValidator.ValidateDescription(e.Description); err != nil {
+		errs.add("description", err.Error())
+	} else if description == "" {
+		errs.add("description", "description must not be empty")
+	} else {
+		e.Description = description
+	}
+
+	expenseType := ledger.ExpenseType(e.Type)
+	if expenseType == "" {
+		expenseType = ledger.TypeExpense
+	} else if expenseType != ledger.TypeExpense && expenseType != ledger.TypeReimbursement {
+		errs.add("type", "type must be expense or reimbursement")
+	}
+
+	e.Notes = strings.TrimSpace(e.Notes)
+	if utf8.RuneCountInString(e.Notes) > *maxNotesLength {
+		errs.add("notes", fmt.Sprintf("notes must be at most %d characters", *maxNotesLength))
+	}
+
+	if e.Currency == "" {
+		e.Currency = withDefaults(dbh.GetSettings(userID)).DefaultCurrency
+	} else if !ledger.IsValidCurrency(e.Currency) {
+		errs.add("currency", "unknown currency")
+	}
+
 	if e.Amount <= 0 {
-		log.Printf("Invalid amount '%0.2f'", e.Amount)
-		writeError(w, http.StatusBadRequest, "amount must be positive")
-		return
+		errs.add("amount", "amount must be positive")
+	} else if e.Amount < *minAmount {
+		errs.add("amount", fmt.Sprintf("amount must be at least %0.2f", *minAmount))
+	} else if e.Amount > *maxAmount {
+		errs.add("amount", fmt.Sprintf("amount must be at most %0.2f", *maxAmount))
+	} else if *validateMinorUnits && ledger.IsValidCurrency(e.Currency) && !ledger.IsInMinorUnits(e.Amount, e.Currency) {
+		errs.add("amount", fmt.Sprintf("amount must have at most %d decimals in %s", ledger.Decimals(e.Currency), e.Currency))
 	}
 
 	createdAt, err := time.Parse(time.RFC3339, e.CreatedAt)
 	if err != nil {
-		log.Printf("Unable to parse timestamp '%s'", e.CreatedAt)
-		writeError(w, http.StatusBadRequest, "unable to parse created_at")
-		return
+		errs.add("created_at", "unable to parse created_at")
+	}
+
+	// Expand the users to everyone the owner can share expenses with, as they are
+	// now. Users joining later aren't added to the expense.
+	if e.SplitAmongAll {
+		if len(e.Users) > 0 {
+			errs.add("users", "users must be empty when splitting among all")
+		} else {
+			e.Users = splitAmongAllUsers(dbh, userID)
+		}
 	}
 
 	// Validate users
-	if len(e.Users) < 1 {
-		log.Printf("Users list too small '%+v'", e.Users)
-		writeError(w, http.StatusBadRequest, "at least one other user must be included in an expense")
-		return
+	if len(e.Users) < *minOtherUsers {
+		if *minOtherUsers == 1 {
+			errs.add("users", "at least one other user must be included in an expense")
+		} else {
+			errs.add("users", fmt.Sprintf("at least %d other users must be included in an expense", *minOtherUsers))
+		}
 	}
 
+	// Resolve users identified by email to their ids
+	e.Users = resolveUserEmails(dbh, e.Users, errs)
+
 	// Ensure user_ids don't include self and are unique
 	uniqueUsers := make(map[int]bool, 0)
 	for _, u := range e.Users {
 		if u.ID == userID {
-			log.Print("User list includes self")
-			writeError(w, http.StatusBadRequest, "user list must not include self")
-			return
-		}
-
-		if _, exists := uniqueUsers[u.ID]; exists {
-			log.Printf("Duplicate user %d", u.ID)
-			writeError(w, http.StatusBadRequest, "duplicate user in user list")
-			return
+			errs.add("users", "user list must not include self")
+		} else if _, exists := uniqueUsers[u.ID]; exists {
+			errs.add("users", "duplicate user in user list")
 		}
 
 		uniqueUsers[u.ID] = true
@@ -295,48 +891,387 @@ func (api *API) postExpenses(w http.ResponseWriter, r *http.Request, userID int)
 		users[i] = u.ID
 	}
 
-	// Create the entries in the database
-	log.Printf(
-		"Adding expense user_id=%d, description='%s', amount=%0.2f, created_at=%s users=%+v",
-		userID, e.Description, e.Amount, createdAt, users)
+	// Ensure all users are friends of the owner
+	if *requireFriends {
+		friends := make(map[int]bool)
+		for _, f := range dbh.GetFriends(userID) {
+			friends[f.ID] = true
+		}
+		for _, u := range users {
+			if !friends[u] && u != userID {
+				errs.add("users", fmt.Sprintf("user %d is not a friend", u))
+			}
+		}
+	}
+
+	// Ensure the payer shares the expense
+	payerID := userID
+	if e.PayerID != 0 {
+		if !uniqueUsers[e.PayerID] && e.PayerID != userID {
+			errs.add("payer_id", "payer must be self or in the user list")
+		}
+		payerID = e.PayerID
+	}
+
+	// The owner shares the expense, unless it's e.g. a gift to the other users
+	excludeOwner := e.IncludeOwner != nil && !*e.IncludeOwner
+	if excludeOwner && len(users) == 0 {
+		errs.add("users", "at least one other user must be included in an expense that excludes the owner")
+	}
+
+	// Ensure a percentage split only refers to users sharing the expense
+	for u := range e.PercentageSplit {
+		if !uniqueUsers[u] && (u != userID || excludeOwner) {
+			errs.add("percentage_split", "percentage split must only include users sharing the expense")
+			break
+		}
+	}
+
+	// Likewise for a share split, which can't be combined with a percentage split
+	for u := range e.ShareSplit {
+		if !uniqueUsers[u] && (u != userID || excludeOwner) {
+			errs.add("share_split", "share split must only include users sharing the expense")
+			break
+		}
+	}
+	if e.PercentageSplit != nil && e.ShareSplit != nil {
+		errs.add("share_split", "an expense can't have both a percentage split and a share split")
+	}
+	if e.BasePerPerson > 0 && *validateMinorUnits && ledger.IsValidCurrency(e.Currency) && !ledger.IsInMinorUnits(e.BasePerPerson, e.Currency) {
+		errs.add("base_per_person", fmt.Sprintf("base per person must have at most %d decimals in %s", ledger.Decimals(e.Currency), e.Currency))
+	}
 
 	expense := ledger.Expense{
 		OwnerID:     userID,
+		PayerID:     payerID,
 		Description: e.Description,
+		Notes:       e.Notes,
+		Type:        expenseType,
 		Amount:      e.Amount,
-		CreatedAt:   createdAt,
+		Currency:    e.Currency,
+		CreatedAt:   createdAt.UTC(),
 		Users:       users,
+
+		PercentageSplit: e.PercentageSplit,
+		ShareSplit:      e.ShareSplit,
+		BasePerPerson:   e.BasePerPerson,
+		ExcludeOwner:    excludeOwner,
+
+		Location: parseLocation(e.Location, errs),
+	}
+
+	switch err := expense.Validate(); {
+	case err == nil:
+	case errors.Is(err, ledger.ErrAmountTooLarge):
+		errs.add("amount", err.Error())
+	case errors.Is(err, ledger.ErrInvalidShares):
+		errs.add("share_split", err.Error())
+	case errors.Is(err, ledger.ErrInvalidBase):
+		errs.add("base_per_person", err.Error())
+	case errors.Is(err, ledger.ErrInvalidReimbursement):
+		errs.add("type", err.Error())
+	default:
+		errs.add("percentage_split", err.Error())
+	}
+
+	expense.Tags = normalizeTags(e.Tags, errs)
+	return expense
+}
+
+// resolveUserEmails looks up the ids of users given by email. Users with an
+// unknown email are left out and listed in a validation error.
+func resolveUserEmails(dbh database.Handle, users []userID, errs *validationErrors) []userID {
+	var emails []string
+	for i, u := range users {
+		if u.Email == "" {
+			continue
+		}
+		u.Email = normalizeEmail(u.Email)
+		users[i].Email = u.Email
+		if u.ID != 0 {
+			errs.add("users", "a user must have either an id or an email")
+			continue
+		}
+		emails = append(emails, u.Email)
+	}
+	if len(emails) == 0 {
+		return users
 	}
 
-	dbh.CreateExpense(expense)
+	ids := make(map[string]int, len(emails))
+	for _, u := range dbh.GetUsersByEmail(emails) {
+		ids[u.Email] = u.ID
+	}
+
+	resolved := make([]userID, 0, len(users))
+	var unknown []string
+	for _, u := range users {
+		if u.Email != "" && u.ID == 0 {
+			if ids[u.Email] == 0 {
+				unknown = append(unknown, u.Email)
+				continue
+			}
+			u.ID = ids[u.Email]
+		}
+		resolved = append(resolved, u)
+	}
+	if len(unknown) > 0 {
+		errs.add("users", fmt.Sprintf("unknown emails: %s", strings.Join(unknown, ", ")))
+	}
+	return resolved
+}
+
+// splitAmongAllUsers returns the users an expense split among all is shared
+// with: the owner's friends, or all users if expenses aren't limited to friends
+func splitAmongAllUsers(dbh database.Handle, ownerID int) []userID {
+	var members []database.User
+	if *requireFriends {
+		members = dbh.GetFriends(ownerID)
+	} else {
+		members = dbh.GetUsers(database.UsersQuery{OrderBy: database.OrderByID})
+	}
+
+	users := make([]userID, 0, len(members))
+	for _, u := range members {
+		if u.ID != ownerID {
+			users = append(users, userID{ID: u.ID})
+		}
+	}
+	return users
+}
+
+// updateBalance recalculates the balance for userID from the database and writes
+// it through to the cache. Updates of the same user are serialized, so that a
+// balance calculated before a concurrent change can't overwrite a newer one.
+func (api *API) updateBalance(dbh database.Handle, userID int) ledger.Balance {
+	unlock := api.balanceLocks.lock(userID)
+	defer unlock()
 
-	// Write through the entries to the cache
 	expenses := dbh.GetExpenses(userID)
-	balance := ledger.CalculateBalance(expenses, userID)
-	api.cache.SetBalance(balance, userID)
+	settlements := dbh.GetSettlements(userID)
+	balance := database.WithNames(dbh, ledger.CalculateBalance(expenses, settlements, userID))
+	balance.ComputedAt = api.now().UTC()
+	api.cache.SetBalance(balance, userID, 0)
 	log.Printf("Balance for user %d is %+v", userID, balance)
-
-	// Return the result to the client
-	w.WriteHeader(http.StatusCreated)
+	return balance
 }
 
-// getBalance returns the balance from the cache
+// getBalance returns the balance from the cache. If the asOf query parameter is
+// given, the balance at that time is calculated from the database instead.
+// Administrators can bypass the cache with an X-Cache-Bypass: true header, which
+// recalculates the balance and refreshes the cache. With amounts=decimal or
+// amounts=cents, the amounts are decimal strings or integer cents. With
+// display=true, the amounts are also formatted for the user's locale.
 func (api *API) getBalance(w http.ResponseWriter, r *http.Request, userID int) {
 	if r.Method != "GET" {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		methodNotAllowed(w, "GET")
+		return
 	}
 
-	balance := api.cache.GetBalance(api.db, userID)
-	log.Printf("Balance for user %d is %+v", userID, balance)
-	writeJSON(w, balance)
+	format, ok := parseAmountFormat(r.URL.Query().Get("amounts"))
+	if !ok {
+		var errs validationErrors
+		errs.add("amounts", "amounts must be decimal or cents")
+		errs.write(w)
+		return
+	}
+
+	var balance ledger.Balance
+	if r.URL.Query().Get("asOf") != "" {
+		asOf, err := time.Parse(time.RFC3339, r.URL.Query().Get("asOf"))
+		if err != nil {
+			log.Printf("Unable to parse timestamp '%s'", r.URL.Query().Get("asOf"))
+			writeError(w, http.StatusBadRequest, "unable to parse asOf")
+			return
+		}
+
+		dbh := api.db.Connect()
+		defer dbh.Close()
+
+		expenses := dbh.GetExpenses(userID)
+		settlements := dbh.GetSettlements(userID)
+		balance = database.WithNames(dbh, ledger.CalculateBalanceAsOf(expenses, settlements, userID, asOf))
+		balance.ComputedAt = api.now().UTC()
+		log.Printf("Balance for user %d as of %s is %+v", userID, asOf, balance)
+	} else if r.Header.Get("X-Cache-Bypass") == "true" {
+		dbh := api.db.Connect()
+		defer dbh.Close()
+
+		if !dbh.IsAdmin(userID) {
+			log.Printf("User %d is not allowed to bypass the cache", userID)
+			writeError(w, http.StatusForbidden, "administrator access required to bypass the cache")
+			return
+		}
+
+		log.Printf("Bypassing the cache for the balance of user %d", userID)
+		balance = api.updateBalance(dbh, userID)
+	} else {
+		balance = api.cache.GetBalance(api.db, userID)
+		log.Printf("Balance for user %d is %+v", userID, balance)
+	}
+
+	// The names are cached with the balance, so the database is only needed for
+	// the display format
+	var presentation *localeFormat
+	if r.URL.Query().Get("display") == "true" {
+		dbh := api.db.Connect()
+		display, _ := displayFormat(r, dbh, userID)
+		dbh.Close()
+		presentation = &display
+	}
+	response := formatBalance(balance, format, presentation)
+
+	// The ETag only depends on the amounts, not on whether they came from the cache
+	unstamped := balance
+	unstamped.FromCache, unstamped.ComputedAt = false, time.Time{}
+	etag := balanceETag(formatBalance(unstamped, format, presentation))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeResponse(w, r, response)
+}
+
+// balanceETag returns a weak ETag for a balance, made from a hash of its JSON
+// serialization. It's weak since the balance can also be encoded with MessagePack.
+func balanceETag(balance interface{}) string {
+	data, err := json.Marshal(balance)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf(`W/"%x"`, sha256.Sum256(data))
+}
+
+// etagMatches returns true if an If-None-Match header matches etag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// getStats returns a summary of the expenses the user takes part in
+func (api *API) getStats(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expenses := dbh.GetExpenses(userID)
+	settlements := dbh.GetSettlements(userID)
+	writeResponse(w, r, ledger.CalculateStats(expenses, settlements, userID))
+}
+
+// routes returns a handler with all endpoints of the API. Requests to unknown
+// paths get a JSON 404. In read-only mode, writes to any endpoint are rejected.
+func (api *API) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/signin", api.signin)
+	mux.HandleFunc("/metrics", api.getMetrics)
+	mux.HandleFunc("/meta", api.getMeta)
+	mux.HandleFunc("/readyz", api.getReadyz)
+	mux.HandleFunc("/users", api.requireAuth(api.users))
+	mux.HandleFunc("/users/resolve", api.requireAuth(api.resolveUsers))
+	mux.HandleFunc("/users/", api.requireAuth(api.requireAdmin(api.postMergeUsers)))
+	mux.HandleFunc("/friends", api.requireAuth(api.friends))
+	mux.HandleFunc("/contacts/recent", api.requireAuth(api.getRecentContacts))
+	mux.HandleFunc("/expenses", api.requireAuth(api.expenses))
+	mux.HandleFunc("/expenses/", api.requireAuth(api.expense))
+	mux.HandleFunc("/expenses/search", api.requireAuth(api.getExpenseSearch))
+	mux.HandleFunc("/expenses/shared-with", api.requireAuth(api.getExpensesSharedWith))
+	mux.HandleFunc("/expenses/paid-by-me", api.requireAuth(api.getExpensesPaidByMe))
+	mux.HandleFunc("/tags", api.requireAuth(api.getTags))
+	mux.HandleFunc("/audit", api.requireAuth(api.requireAdmin(api.getAudit)))
+	mux.HandleFunc("/admin/expenses", api.requireAuth(api.requireAdmin(api.getAdminExpenses)))
+	mux.HandleFunc("/admin/users/import", api.requireAuth(api.requireAdmin(api.postImportUsers)))
+	mux.HandleFunc("/cache/warm", api.requireAuth(api.requireAdmin(api.postCacheWarm)))
+	mux.HandleFunc("/settlements", api.requireAuth(api.postSettlements))
+	mux.HandleFunc("/groups", api.requireAuth(api.postGroups))
+	mux.HandleFunc("/group/", api.requireAuth(api.getGroupSettle))
+	mux.HandleFunc("/sessions", api.requireAuth(api.sessions))
+	mux.HandleFunc("/token/introspect", api.requireAuth(api.getTokenIntrospect))
+	mux.HandleFunc("/undo", api.requireAuth(api.postUndo))
+	mux.HandleFunc("/balance", api.requireAuth(api.getBalance))
+	mux.HandleFunc("/balance/settled", api.requireAuth(api.getSettled))
+	mux.HandleFunc("/balance/net", api.requireAuth(api.getNetBalance))
+	mux.HandleFunc("/balances", api.requireAuth(api.postBalances))
+	mux.HandleFunc("/owed-to-me", api.requireAuth(api.getOwedToMe))
+	mux.HandleFunc("/simulate/balance", api.requireAuth(api.postSimulateBalance))
+	mux.HandleFunc("/stats", api.requireAuth(api.getStats))
+	mux.HandleFunc("/leaderboard", api.requireAuth(api.getLeaderboard))
+	mux.HandleFunc("/me", api.requireAuth(api.me))
+	mux.HandleFunc("/me/export", api.requireAuth(api.getExport))
+	mux.HandleFunc("/me/email", api.requireAuth(api.patchEmail))
+	mux.HandleFunc("/me/password", api.requireAuth(api.postPassword))
+	mux.HandleFunc("/me/settings", api.requireAuth(api.settings))
+	mux.HandleFunc("/", notFound)
+	return http.HandlerFunc(rejectWritesIfReadOnly(mux.ServeHTTP))
 }
 
 // Serve starts up the API on serverPort
 func (api *API) Serve() {
-	http.HandleFunc("/signin", api.signin)
-	http.HandleFunc("/users", api.requireAuth(api.users))
-	http.HandleFunc("/expenses", api.requireAuth(api.postExpenses))
-	http.HandleFunc("/balance", api.requireAuth(api.getBalance))
+	if _, err := database.ParseOrder(*defaultExpenseOrder); err != nil {
+		log.Fatalf("expense-order: %v", err)
+	}
+	if !ledger.IsValidCurrency(*defaultCurrency) {
+		log.Fatalf("default-currency: unknown currency %q", *defaultCurrency)
+	}
+	if _, err := loadEmailBlocklist(); err != nil {
+		log.Fatalf("blocked-emails-file: %v", err)
+	}
+
+	if *balanceRetention < 0 {
+		log.Fatal("balance-retention must not be negative")
+	}
+	if *balanceRetention > 0 && *balancePruneInterval <= 0 {
+		log.Fatal("balance-prune-interval must be positive")
+	}
+
+	if *divergenceSampleInterval > 0 {
+		go api.sampleDivergenceForever()
+	}
+
+	// Background jobs are stopped once the server has shut down
+	stop := make(chan struct{})
+	var jobs sync.WaitGroup
+	if *balanceRetention > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			api.pruneBalancesForever(*balancePruneInterval, stop)
+		}()
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", *serverPort),
+		Handler: recoverPanics(gzipResponses(api.routes())),
+	}
+
+	// Shut down on SIGINT or SIGTERM, letting requests in progress finish
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Print("Shutting down")
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Printf("Unable to shut down cleanly: %v", err)
+		}
+	}()
+
 	log.Printf("Listening on port %d", *serverPort)
-	panic(http.ListenAndServe(fmt.Sprintf(":%d", *serverPort), nil))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		panic(err)
+	}
+
+	close(stop)
+	jobs.Wait()
+	log.Print("Shut down")
 }
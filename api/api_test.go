@@ -3,15 +3,22 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/freewilll/splitter/cache"
 	"github.com/freewilll/splitter/database"
 	"github.com/freewilll/splitter/ledger"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 func TestGetUsers(t *testing.T) {
@@ -58,6 +65,85 @@ func TestGetUsers(t *testing.T) {
 	}
 }
 
+// getUsersWithQuery calls the GET users API with a query string
+func getUsersWithQuery(api *API, userID int, query string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodGet, "/users?"+query, nil)
+	response := httptest.NewRecorder()
+	api.users(response, request, userID)
+	return response
+}
+
+func TestGetUsersOrdering(t *testing.T) {
+	// Retrieve the users in each supported order and with a limit
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("b@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("c@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("a@getstream.io", "secret")
+
+	tests := []struct {
+		Query  string
+		Wanted []int
+	}{
+		{"", []int{userID3, userID1, userID2}},
+		{"order_by=email", []int{userID3, userID1, userID2}},
+		{"order_by=email&order=desc", []int{userID2, userID1, userID3}},
+		{"order_by=id", []int{userID1, userID2, userID3}},
+		{"order_by=id&order=desc", []int{userID3, userID2, userID1}},
+		{"order_by=id&limit=2", []int{userID1, userID2}},
+	}
+
+	for _, test := range tests {
+		response := getUsersWithQuery(api, userID1, test.Query)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%q: wanted %d, got %d", test.Query, http.StatusOK, response.Code)
+		}
+
+		var got usersResponse
+		err := json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+
+		ids := make([]int, len(got.Users))
+		for i, u := range got.Users {
+			ids[i] = u.ID
+		}
+		if !reflect.DeepEqual(ids, test.Wanted) {
+			t.Errorf("%q: wanted %v, got %v", test.Query, test.Wanted, ids)
+		}
+	}
+}
+
+func TestGetUsersInvalidQuery(t *testing.T) {
+	// Invalid orderings and limits are rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	for _, query := range []string{
+		"order_by=password",
+		"order_by=email%3B%20DROP%20TABLE%20users",
+		"order=sideways",
+		"limit=0",
+		"limit=abc",
+		fmt.Sprintf("limit=%d", *maxUsers+1),
+	} {
+		response := getUsersWithQuery(api, userID1, query)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%q: wanted %d, got %d", query, http.StatusBadRequest, response.Code)
+		}
+	}
+}
+
 func TestPostUsers(t *testing.T) {
 	// Create a user using the POST users API
 
@@ -98,13 +184,14 @@ func TestPostExpenses(t *testing.T) {
 	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
 	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
 	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
 
 	// User 1 buys a meal for the other two, for €42
 	body, _ := json.Marshal(createExpenseRequest{
 		Description: "Food",
 		Amount:      42,
 		CreatedAt:   "2021-01-01T15:04:05Z",
-		Users:       []userID{{userID2}, {userID3}},
+		Users:       []userID{{ID: userID2}, {ID: userID3}},
 	})
 
 	request, _ := http.NewRequest(http.MethodPost, "/expenses", bytes.NewReader(body))
@@ -125,7 +212,1556 @@ func TestPostExpenses(t *testing.T) {
 		t.Fatalf("Unable to parse response from server '%v'", err)
 	}
 	wantedBalance := 28.0
-	if math.Abs(got.Balance-wantedBalance) > 1e-9 {
+	if math.Abs(got.Balance-wantedBalance) > ledger.Epsilon {
+		t.Errorf("wanted %v,got %v", wantedBalance, got.Balance)
+	}
+}
+
+// postExpense posts an expense request on behalf of userID and returns the response
+func postExpense(api *API, userID int, e createExpenseRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(e)
+	request, _ := http.NewRequest(http.MethodPost, "/expenses", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.postExpenses(response, request, userID)
+	return response
+}
+
+func TestPostExpensesAmountBounds(t *testing.T) {
+	// Ensure amounts at the configured bounds are accepted and amounts just
+	// outside them are rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldMinAmount, oldMaxAmount := *minAmount, *maxAmount
+	defer func() { *minAmount, *maxAmount = oldMinAmount, oldMaxAmount }()
+	*minAmount = 1
+	*maxAmount = 100
+
+	tests := []struct {
+		Amount float64
+		Code   int
+	}{
+		{0.99, http.StatusBadRequest},
+		{1, http.StatusCreated},
+		{100, http.StatusCreated},
+		{100.01, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: "Food",
+			Amount:      test.Amount,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != test.Code {
+			t.Errorf("amount %v: wanted %d, got %d", test.Amount, test.Code, response.Code)
+		}
+	}
+}
+
+func TestPostExpensesAmountTooLarge(t *testing.T) {
+	// An amount that can't be split without losing precision is rejected, even
+	// if it's within the configured bounds
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldMaxAmount := *maxAmount
+	defer func() { *maxAmount = oldMaxAmount }()
+	*maxAmount = math.MaxFloat64
+
+	tests := []struct {
+		Amount float64
+		Code   int
+	}{
+		{1 << 52, http.StatusCreated},
+		{1<<52 + 1, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: "Yacht",
+			Amount:      test.Amount,
+			Currency:    "JPY",
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != test.Code {
+			t.Errorf("amount %v: wanted %d, got %d", test.Amount, test.Code, response.Code)
+		}
+
+		if test.Code == http.StatusBadRequest {
+			var got validationErrorResponse
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+				t.Fatalf("Unable to parse response from server '%v'", err)
+			}
+			if len(got.Errors) != 1 || got.Errors[0].Field != "amount" {
+				t.Errorf("wanted an amount error, got %+v", got)
+			}
+		}
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	// Post an expense and check the GET stats API summarizes it
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	// No deep inspection is done, since this is already covered by the ledger tests
+	request, _ := http.NewRequest(http.MethodGet, "/stats", nil)
+	response = httptest.NewRecorder()
+	api.getStats(response, request, userID2)
+	var got ledger.Stats
+	err := json.NewDecoder(response.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wanted := ledger.Stats{TotalOwed: 21, ExpenseCount: 1, MostFrequentCounterparty: userID1, TotalExpenses: 42}
+	if !reflect.DeepEqual(wanted, got) {
+		t.Errorf("wanted %v,got %v", wanted, got)
+	}
+}
+
+func TestPostExpensesWithPayer(t *testing.T) {
+	// User 1 records an expense paid for by user 2, ensure user 2 is credited
+	// and a payer outside the user list is rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+		PayerID:     userID3,
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+		PayerID:     userID2,
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	for userID, wantedBalance := range map[int]float64{userID1: -21, userID2: 21} {
+		got := getBalance(t, api, userID)
+		if math.Abs(got.Balance-wantedBalance) > ledger.Epsilon {
+			t.Errorf("wanted %v,got %v", wantedBalance, got.Balance)
+		}
+	}
+}
+
+func TestPostExpensesWithPercentageSplit(t *testing.T) {
+	// Post an expense split by percentages and ensure percentages that don't add
+	// up to 100 are rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description:     "Food",
+		Amount:          100,
+		CreatedAt:       "2021-01-01T15:04:05Z",
+		Users:           []userID{{ID: userID2}},
+		PercentageSplit: map[int]float64{userID1: 50, userID2: 40},
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description:     "Food",
+		Amount:          100,
+		CreatedAt:       "2021-01-01T15:04:05Z",
+		Users:           []userID{{ID: userID2}},
+		PercentageSplit: map[int]float64{userID1: 25, userID2: 75},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	got := getBalance(t, api, userID1)
+	wantedBalance := 75.0
+	if math.Abs(got.Balance-wantedBalance) > ledger.Epsilon {
+		t.Errorf("wanted %v,got %v", wantedBalance, got.Balance)
+	}
+}
+
+func TestPostExpensesWithShareSplit(t *testing.T) {
+	// User 2 pays €10 split in 3 shares, one for user 1 and two for user 2. User
+	// 2 absorbs the cent left over. Invalid share splits are rejected.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	invalid := []createExpenseRequest{
+		{ShareSplit: map[int]int{userID1: 0, userID2: 0}},
+		{ShareSplit: map[int]int{userID1: -1, userID2: 2}},
+		{ShareSplit: map[int]int{userID1: 1, userID3: 2}},
+		{ShareSplit: map[int]int{userID1: 1, userID2: 2}, PercentageSplit: map[int]float64{userID1: 50, userID2: 50}},
+	}
+	for _, e := range invalid {
+		e.Description = "Food"
+		e.Amount = 10
+		e.CreatedAt = "2021-01-01T15:04:05Z"
+		e.Users = []userID{{ID: userID2}}
+		response := postExpense(api, userID1, e)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%+v: wanted %d, got %d", e, http.StatusBadRequest, response.Code)
+		}
+	}
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      10,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+		PayerID:     userID2,
+		ShareSplit:  map[int]int{userID1: 1, userID2: 2},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	got := getBalance(t, api, userID1)
+	wantedBalance := -3.33
+	if math.Abs(got.Balance-wantedBalance) > ledger.Epsilon {
 		t.Errorf("wanted %v,got %v", wantedBalance, got.Balance)
 	}
 }
+
+func TestPostExpensesDuplicate(t *testing.T) {
+	// Re-submit a near-identical expense and ensure it's flagged, unless forced
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	expense := createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	}
+	response := postExpense(api, userID1, expense)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	expense.CreatedAt = "2021-01-01T15:04:35Z"
+	response = postExpense(api, userID1, expense)
+	if response.Code != http.StatusConflict {
+		t.Errorf("wanted %d, got %d", http.StatusConflict, response.Code)
+	}
+
+	body, _ := json.Marshal(expense)
+	request, _ := http.NewRequest(http.MethodPost, "/expenses?force=true", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	api.postExpenses(response, request, userID1)
+	if response.Code != http.StatusCreated {
+		t.Errorf("wanted %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	if len(dbh.GetExpenses(userID1)) != 2 {
+		t.Errorf("wanted 2 expenses, got %d", len(dbh.GetExpenses(userID1)))
+	}
+}
+
+func TestResolveUsers(t *testing.T) {
+	// Resolve a mix of known and unknown user ids and ensure only the known
+	// ones are returned
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+
+	body, _ := json.Marshal(resolveUsersRequest{IDs: []int{userID1, 42, userID2, -1}})
+	request, _ := http.NewRequest(http.MethodPost, "/users/resolve", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.resolveUsers(response, request, userID1)
+	var got resolveUsersResponse
+	err := json.NewDecoder(response.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wanted := resolveUsersResponse{Users: map[int]string{
+		userID1: "test1@getstream.io",
+		userID2: "test2@getstream.io",
+	}}
+	if !reflect.DeepEqual(wanted, got) {
+		t.Errorf("wanted %v,got %v", wanted, got)
+	}
+}
+
+func TestPostExpensesDescriptionWhitespace(t *testing.T) {
+	// A padded description is stored trimmed and collapsed, an all-whitespace
+	// description is rejected as empty
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "   \t ",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	var gotError errorResponse
+	err := json.NewDecoder(response.Body).Decode(&gotError)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wantedError := errorResponse{"description must not be empty"}
+	if response.Code != http.StatusBadRequest || gotError != wantedError {
+		t.Errorf("wanted %d %v, got %d %v", http.StatusBadRequest, wantedError, response.Code, gotError)
+	}
+
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "  Food   and \t drinks ",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	expenses := dbh.GetExpenses(userID1)
+	if len(expenses) != 1 || expenses[0].Description != "Food and drinks" {
+		t.Errorf("wanted description 'Food and drinks', got %+v", expenses)
+	}
+}
+
+func TestPostExpensesDescriptionLength(t *testing.T) {
+	// A description of the maximum length is accepted, one character more is rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldMaxDescriptionLength := *maxDescriptionLength
+	defer func() { *maxDescriptionLength = oldMaxDescriptionLength }()
+	*maxDescriptionLength = 10
+
+	tests := []struct {
+		Description string
+		Code        int
+	}{
+		{"€€€€€€€€€€", http.StatusCreated},
+		{"€€€€€€€€€€€", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: test.Description,
+			Amount:      42,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != test.Code {
+			t.Errorf("description '%s': wanted %d, got %d", test.Description, test.Code, response.Code)
+		}
+	}
+}
+
+func TestValidationErrorsAggregated(t *testing.T) {
+	// Submit requests with several problems and ensure all of them are reported
+	// in one response
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	// A bad email and a short password
+	body, _ := json.Marshal(createUserRequest{Email: "not-an-email", Password: "short"})
+	request, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.users(response, request, userID1)
+	var got validationErrorResponse
+	err := json.NewDecoder(response.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wanted := []fieldError{
+		{Field: "email", Message: "invalid email address"},
+		{Field: "password", Message: "invalid password: it must be at least 6 characters"},
+	}
+	if response.Code != http.StatusBadRequest || !reflect.DeepEqual(wanted, got.Errors) {
+		t.Errorf("wanted %d %v, got %d %v", http.StatusBadRequest, wanted, response.Code, got.Errors)
+	}
+
+	// An empty description, a negative amount, a bad timestamp and no users
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "",
+		Amount:      -1,
+		CreatedAt:   "yesterday",
+	})
+	got = validationErrorResponse{}
+	err = json.NewDecoder(response.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wanted = []fieldError{
+		{Field: "description", Message: "description must not be empty"},
+		{Field: "amount", Message: "amount must be positive"},
+		{Field: "created_at", Message: "unable to parse created_at"},
+		{Field: "users", Message: "at least one other user must be included in an expense"},
+	}
+	if response.Code != http.StatusBadRequest || !reflect.DeepEqual(wanted, got.Errors) {
+		t.Errorf("wanted %d %v, got %d %v", http.StatusBadRequest, wanted, response.Code, got.Errors)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	// In read-only mode, writes to any endpoint are rejected, while reads and the
+	// POST endpoints that only read data still work
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+	routes := api.routes()
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	dbh.SetAdmin(userID1, true)
+
+	oldReadOnly := *readOnly
+	defer func() { *readOnly = oldReadOnly }()
+	*readOnly = true
+
+	cookie := signinCookie(t, api, "test1@getstream.io", "secret")
+	expense, _ := json.Marshal(createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+
+	tests := []struct {
+		Method string
+		Path   string
+		Body   string
+		Wanted int
+	}{
+		{http.MethodPost, "/expenses", string(expense), http.StatusServiceUnavailable},
+		{http.MethodDelete, "/sessions", "", http.StatusServiceUnavailable},
+		{http.MethodPost, "/cache/warm", "", http.StatusServiceUnavailable},
+		{http.MethodPatch, "/me", `{"name":"Alice"}`, http.StatusServiceUnavailable},
+		{http.MethodGet, "/balance", "", http.StatusOK},
+		{http.MethodPost, "/signin", `{"email":"test1@getstream.io","password":"secret"}`, http.StatusOK},
+		{http.MethodPost, "/users/resolve", `{"ids":[2]}`, http.StatusOK},
+		{http.MethodPost, "/balances", `{"user_ids":[1]}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(test.Method, test.Path, strings.NewReader(test.Body))
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		routes.ServeHTTP(response, request)
+		if response.Code != test.Wanted {
+			t.Errorf("%s %s: wanted %d, got %d", test.Method, test.Path, test.Wanted, response.Code)
+		}
+	}
+
+	if len(dbh.GetExpenses(userID1)) != 0 {
+		t.Errorf("expense was created in read-only mode")
+	}
+}
+
+// postUser posts a create user request on behalf of userID and returns the response
+func postUser(api *API, userID int, u createUserRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(u)
+	request, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.users(response, request, userID)
+	return response
+}
+
+func TestPostUsersAllowedEmailDomains(t *testing.T) {
+	// With an allowlist of domains, only emails in those domains can register
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	oldAllowedEmailDomains := *allowedEmailDomains
+	defer func() { *allowedEmailDomains = oldAllowedEmailDomains }()
+	*allowedEmailDomains = "example.com, getstream.io"
+
+	tests := []struct {
+		Email string
+		Code  int
+	}{
+		{"test2@getstream.io", http.StatusOK},
+		{"test3@GetStream.IO", http.StatusOK},
+		{"test4@example.org", http.StatusBadRequest},
+		{"test5@getstream.io.evil.com", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		response := postUser(api, userID, createUserRequest{Email: test.Email, Password: "secret"})
+		if response.Code != test.Code {
+			t.Errorf("%s: wanted %d, got %d", test.Email, test.Code, response.Code)
+		}
+	}
+}
+
+func TestPostUsersBlockedEmails(t *testing.T) {
+	// Blocked addresses and domains, from the flag or the file, can't register
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	file := filepath.Join(t.TempDir(), "blocked-emails.txt")
+	if err := ioutil.WriteFile(file, []byte("# Disposable\n\n@tempmail.dev\nspam@getstream.io\n"), 0644); err != nil {
+		t.Fatalf("Unable to write blocklist: %v", err)
+	}
+
+	oldBlockedEmails, oldBlockedEmailsFile := *blockedEmails, *blockedEmailsFile
+	defer func() { *blockedEmails, *blockedEmailsFile = oldBlockedEmails, oldBlockedEmailsFile }()
+	*blockedEmails, *blockedEmailsFile = "mailinator.com, Troll@Example.com", file
+
+	tests := []struct {
+		Email string
+		Code  int
+	}{
+		{"test2@getstream.io", http.StatusOK},
+		{"test3@mailinator.com", http.StatusBadRequest},
+		{"test4@eu.mailinator.com", http.StatusBadRequest},
+		{"test5@notmailinator.com", http.StatusOK},
+		{"troll@example.com", http.StatusBadRequest},
+		{"friend@example.com", http.StatusOK},
+		{"test6@tempmail.dev", http.StatusBadRequest},
+		{"SPAM@getstream.io", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		response := postUser(api, userID, createUserRequest{Email: test.Email, Password: "secret"})
+		if response.Code != test.Code {
+			t.Errorf("%s: wanted %d, got %d", test.Email, test.Code, response.Code)
+		}
+	}
+}
+
+func TestLoadEmailBlocklist(t *testing.T) {
+	// A missing blocklist file is an error, which the server refuses to start
+	// with, and an import checks every email against the blocklist
+
+	oldBlockedEmails, oldBlockedEmailsFile := *blockedEmails, *blockedEmailsFile
+	defer func() { *blockedEmails, *blockedEmailsFile = oldBlockedEmails, oldBlockedEmailsFile }()
+
+	*blockedEmails, *blockedEmailsFile = "", filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := loadEmailBlocklist(); err == nil {
+		t.Errorf("wanted an error for a missing file")
+	}
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+	dbh := db.Connect()
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+
+	*blockedEmails, *blockedEmailsFile = "mailinator.com", ""
+	response := postImportUsers(api, adminID, `{"users":[{"email":"new1@getstream.io"},{"email":"new2@mailinator.com"}]}`)
+	var got importUsersResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Users) != 2 || got.Users[0].Status != importCreated || got.Users[1].Status != importInvalid {
+		t.Errorf("wanted the blocked email to be invalid, got %+v", got.Users)
+	}
+}
+
+// signin calls the signin API and returns the response
+func signin(api *API, email string, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(authRequest{Email: email, Password: password})
+	request, _ := http.NewRequest(http.MethodPost, "/signin", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.signin(response, request)
+	return response
+}
+
+func TestSigninLockout(t *testing.T) {
+	// A successful sign in resets the failed attempts. After too many consecutive
+	// failures, the account is locked and even the correct password is refused.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	dbh.CreateUser("test1@getstream.io", "secret")
+
+	oldMaxFailedLogins := *maxFailedLogins
+	defer func() { *maxFailedLogins = oldMaxFailedLogins }()
+	*maxFailedLogins = 3
+
+	tests := []struct {
+		Password string
+		Code     int
+	}{
+		{"wrong", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusOK},
+		{"wrong", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusTooManyRequests},
+	}
+
+	for i, test := range tests {
+		response := signin(api, "test1@getstream.io", test.Password)
+		if response.Code != test.Code {
+			t.Errorf("attempt %d: wanted %d, got %d", i+1, test.Code, response.Code)
+		}
+	}
+}
+
+func TestSigninResponse(t *testing.T) {
+	// A successful sign in sets the jwt cookie and returns the user
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+
+	response := signin(api, "test2@getstream.io", "secret")
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	if len(response.Result().Cookies()) != 1 || response.Result().Cookies()[0].Name != *cookieName {
+		t.Errorf("wanted a %s cookie, got %v", *cookieName, response.Result().Cookies())
+	}
+
+	var got userResponse
+	err := json.NewDecoder(response.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wanted := userResponse{ID: userID2, Email: "test2@getstream.io"}
+	if !reflect.DeepEqual(wanted, got) {
+		t.Errorf("wanted %v,got %v", wanted, got)
+	}
+}
+
+func TestPostExpensesMinOtherUsers(t *testing.T) {
+	// Allow personal expenses with no other users, and require two other users
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	oldMinOtherUsers := *minOtherUsers
+	defer func() { *minOtherUsers = oldMinOtherUsers }()
+
+	tests := []struct {
+		MinOtherUsers int
+		Users         []userID
+		Code          int
+	}{
+		{0, []userID{}, http.StatusCreated},
+		{1, []userID{}, http.StatusBadRequest},
+		{2, []userID{{ID: userID2}}, http.StatusBadRequest},
+		{2, []userID{{ID: userID2}, {ID: userID3}}, http.StatusCreated},
+	}
+
+	for i, test := range tests {
+		*minOtherUsers = test.MinOtherUsers
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: "Food",
+			Amount:      float64(10 + i),
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       test.Users,
+		})
+		if response.Code != test.Code {
+			t.Errorf("min %d, users %v: wanted %d, got %d", test.MinOtherUsers, test.Users, test.Code, response.Code)
+		}
+	}
+
+	// The personal expense didn't create any debts
+	got := getBalance(t, api, userID1)
+	if len(got.Debit) != 0 || len(got.Credit) != 2 {
+		t.Errorf("wanted only credit from users 2 and 3, got %+v", got)
+	}
+}
+
+func TestPostExpensesMaxParticipants(t *testing.T) {
+	// The database refuses expenses shared by too many users, which is reported
+	// as a validation error
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	oldMaxParticipants := database.MaxParticipants
+	defer func() { database.MaxParticipants = oldMaxParticipants }()
+	database.MaxParticipants = 2
+
+	for i, test := range []struct {
+		Users []userID
+		Code  int
+	}{
+		{[]userID{{ID: userID2}, {ID: userID3}}, http.StatusBadRequest},
+		{[]userID{{ID: userID2}}, http.StatusCreated},
+	} {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: "Food",
+			Amount:      float64(10 + i),
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       test.Users,
+		})
+		if response.Code != test.Code {
+			t.Errorf("users %v: wanted %d, got %d", test.Users, test.Code, response.Code)
+		}
+	}
+
+	if got := getBalance(t, api, userID3); len(got.Debit) != 0 {
+		t.Errorf("wanted no debts for user 3, got %+v", got)
+	}
+}
+
+func TestPostExpensesCurrency(t *testing.T) {
+	// An omitted currency uses the default, an unknown one is rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldDefaultCurrency := *defaultCurrency
+	defer func() { *defaultCurrency = oldDefaultCurrency }()
+	*defaultCurrency = "GBP"
+
+	tests := []struct {
+		Currency string
+		Code     int
+		Wanted   string
+	}{
+		{"", http.StatusCreated, "GBP"},
+		{"JPY", http.StatusCreated, "JPY"},
+		{"XYZ", http.StatusBadRequest, ""},
+		{"eur", http.StatusBadRequest, ""},
+	}
+
+	for i, test := range tests {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: "Food",
+			Amount:      float64(10 + i),
+			Currency:    test.Currency,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != test.Code {
+			t.Errorf("currency '%s': wanted %d, got %d", test.Currency, test.Code, response.Code)
+			continue
+		}
+
+		if test.Code == http.StatusCreated {
+			expenses := dbh.GetExpenses(userID1)
+			if got := expenses[len(expenses)-1].Currency; got != test.Wanted {
+				t.Errorf("currency '%s': wanted %s, got %s", test.Currency, test.Wanted, got)
+			}
+		}
+	}
+}
+
+func TestPostExpensesMinorUnits(t *testing.T) {
+	// Amounts with more decimals than the minor unit of the currency are
+	// rejected, unless the validation is turned off
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	dbh.SetSettings(userID2, database.Settings{DefaultCurrency: "JPY"})
+
+	oldValidateMinorUnits := *validateMinorUnits
+	defer func() { *validateMinorUnits = oldValidateMinorUnits }()
+
+	tests := []struct {
+		UserID   int
+		Amount   float64
+		Currency string
+		Validate bool
+		Code     int
+	}{
+		{userID1, 10, "JPY", true, http.StatusCreated},
+		{userID1, 10.5, "JPY", true, http.StatusBadRequest},
+		{userID1, 10.05, "USD", true, http.StatusCreated},
+		{userID1, 10.005, "USD", true, http.StatusBadRequest},
+		{userID1, 10.005, "BHD", true, http.StatusCreated},
+		{userID1, 10.0005, "BHD", true, http.StatusBadRequest},
+		{userID2, 10.5, "", true, http.StatusBadRequest}, // The default currency of user 2 is JPY
+		{userID1, 10.5, "JPY", false, http.StatusCreated},
+	}
+
+	for i, test := range tests {
+		otherUserID := userID2
+		if test.UserID == userID2 {
+			otherUserID = userID1
+		}
+
+		*validateMinorUnits = test.Validate
+		response := postExpense(api, test.UserID, createExpenseRequest{
+			Description: fmt.Sprintf("Food %d", i),
+			Amount:      test.Amount,
+			Currency:    test.Currency,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: otherUserID}},
+		})
+		if response.Code != test.Code {
+			t.Errorf("%s %v: wanted %d, got %d: %s", test.Currency, test.Amount, test.Code, response.Code, response.Body.String())
+		}
+	}
+}
+
+func TestPostExpensesDailyQuota(t *testing.T) {
+	// Once a user has created the maximum number of expenses of the day, further
+	// expenses are rejected until the next day. Other users are unaffected.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldMaxExpensesPerDay := *maxExpensesPerDay
+	defer func() { *maxExpensesPerDay = oldMaxExpensesPerDay }()
+	*maxExpensesPerDay = 2
+
+	post := func(ownerID int, otherUserID int, description string) *httptest.ResponseRecorder {
+		return postExpense(api, ownerID, createExpenseRequest{
+			Description: description,
+			Amount:      10,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: otherUserID}},
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		if response := post(userID1, userID2, fmt.Sprintf("Food %d", i)); response.Code != http.StatusCreated {
+			t.Fatalf("expense %d: wanted %d, got %d: %s", i, http.StatusCreated, response.Code, response.Body.String())
+		}
+	}
+
+	response := post(userID1, userID2, "Food 2")
+	if response.Code != http.StatusTooManyRequests {
+		t.Errorf("wanted %d, got %d", http.StatusTooManyRequests, response.Code)
+	}
+	if response.Header().Get("Retry-After") == "" {
+		t.Errorf("wanted a Retry-After header")
+	}
+	if response := post(userID2, userID1, "Food 3"); response.Code != http.StatusCreated {
+		t.Errorf("wanted %d for another user, got %d", http.StatusCreated, response.Code)
+	}
+
+	// The quota resets the next day
+	api.now = func() time.Time { return time.Now().AddDate(0, 0, 1) }
+	if response := post(userID1, userID2, "Food 4"); response.Code != http.StatusCreated {
+		t.Errorf("wanted %d the next day, got %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+
+	// Without a limit, any number of expenses can be created
+	api.now = time.Now
+	*maxExpensesPerDay = 0
+	if response := post(userID1, userID2, "Food 5"); response.Code != http.StatusCreated {
+		t.Errorf("wanted %d without a limit, got %d", http.StatusCreated, response.Code)
+	}
+}
+
+func TestPostExpensesBasePerPerson(t *testing.T) {
+	// Each user pays a base before the rest is split evenly. The bases of all
+	// participants must fit in the amount.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	tests := []struct {
+		Amount        float64
+		BasePerPerson float64
+		Code          int
+	}{
+		{14.99, 5, http.StatusBadRequest},
+		{30, -1, http.StatusBadRequest},
+		{30, 5.001, http.StatusBadRequest},
+		{30, 5, http.StatusCreated},
+	}
+	for _, test := range tests {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description:   "Cover charge and drinks",
+			Amount:        test.Amount,
+			CreatedAt:     "2021-01-01T15:04:05Z",
+			Users:         []userID{{ID: userID2}, {ID: userID3}},
+			BasePerPerson: test.BasePerPerson,
+		})
+		if response.Code != test.Code {
+			t.Errorf("%v with base %v: wanted %d, got %d: %s", test.Amount, test.BasePerPerson, test.Code, response.Code, response.Body.String())
+		}
+	}
+
+	// Users 2 and 3 each owe their €5 base plus a third of the €15 rest
+	balance := getBalance(t, api, userID1)
+	if balance.Balance != 20 || len(balance.Credit) != 2 || balance.Credit[0].Amount != 10 || balance.Credit[1].Amount != 10 {
+		t.Errorf("wanted users 2 and 3 to owe 10 each, got %+v", balance)
+	}
+}
+
+func TestGetBalanceAsOf(t *testing.T) {
+	// The balance as of a past time excludes later expenses, the balance as of
+	// the future matches the current balance
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	for _, e := range []struct {
+		Amount    float64
+		CreatedAt string
+	}{{10, "2021-01-01T12:00:00Z"}, {30, "2021-02-01T12:00:00Z"}} {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: "Food",
+			Amount:      e.Amount,
+			CreatedAt:   e.CreatedAt,
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+	}
+
+	tests := []struct {
+		URL    string
+		Wanted float64
+	}{
+		{"/balance?asOf=2020-12-31T00:00:00Z", 0},
+		{"/balance?asOf=2021-01-01T12:00:00Z", 5},
+		{"/balance?asOf=2021-01-15T00:00:00Z", 5},
+		{"/balance?asOf=2099-01-01T00:00:00Z", 20},
+		{"/balance", 20},
+	}
+
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodGet, test.URL, nil)
+		response := httptest.NewRecorder()
+		api.getBalance(response, request, userID1)
+		var got ledger.Balance
+		err := json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if math.Abs(got.Balance-test.Wanted) > ledger.Epsilon {
+			t.Errorf("%s: wanted %v,got %v", test.URL, test.Wanted, got.Balance)
+		}
+	}
+}
+
+func TestGetBalanceEmpty(t *testing.T) {
+	// A user without any expenses gets empty debit and credit arrays, not nulls
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+	response := httptest.NewRecorder()
+	api.getBalance(response, request, userID1)
+	wanted := `{"balance":0,"debit":[],"credit":[],"from_cache":false,"computed_at":`
+	if got := response.Body.String(); !strings.HasPrefix(got, wanted) {
+		t.Errorf("wanted %s..., got %s", wanted, got)
+	}
+}
+
+func TestGetBalanceFromCache(t *testing.T) {
+	// A cold read calculates the balance, the next one is served from the cache
+	// with the same computed_at and ETag
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	var responses []ledger.Balance
+	var etags []string
+	for i := 0; i < 2; i++ {
+		request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+		response := httptest.NewRecorder()
+		api.getBalance(response, request, userID1)
+
+		var got ledger.Balance
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		responses = append(responses, got)
+		etags = append(etags, response.Result().Header.Get("ETag"))
+	}
+
+	if responses[0].FromCache || !responses[1].FromCache {
+		t.Errorf("wanted from_cache false and then true, got %v and %v", responses[0].FromCache, responses[1].FromCache)
+	}
+	if responses[0].ComputedAt.IsZero() || !responses[0].ComputedAt.Equal(responses[1].ComputedAt) {
+		t.Errorf("wanted the same computed_at twice, got %v and %v", responses[0].ComputedAt, responses[1].ComputedAt)
+	}
+	if etags[0] != etags[1] {
+		t.Errorf("wanted the same ETag twice, got %s and %s", etags[0], etags[1])
+	}
+}
+
+func TestGetBalanceEncoding(t *testing.T) {
+	// The balance is encoded with MessagePack if the client accepts it and with
+	// JSON otherwise
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	tests := []struct {
+		Accept      string
+		ContentType string
+	}{
+		{"", "application/json"},
+		{"application/json", "application/json"},
+		{"application/msgpack", "application/msgpack"},
+		{"application/json;q=0.5, application/msgpack", "application/msgpack"},
+	}
+
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+		request.Header.Set("Accept", test.Accept)
+		response := httptest.NewRecorder()
+		api.getBalance(response, request, userID2)
+
+		if got := response.Header().Get("Content-Type"); got != test.ContentType {
+			t.Fatalf("Accept %q: wanted content type %s, got %s", test.Accept, test.ContentType, got)
+		}
+
+		var got ledger.Balance
+		var err error
+		if test.ContentType == "application/msgpack" {
+			dec := msgpack.NewDecoder(response.Body)
+			dec.SetCustomStructTag("json")
+			err = dec.Decode(&got)
+		} else {
+			err = json.NewDecoder(response.Body).Decode(&got)
+		}
+		if err != nil {
+			t.Fatalf("Accept %q: unable to decode response '%v'", test.Accept, err)
+		}
+
+		if got.Balance != -21 || got.DebtTo(userID1) != 21 {
+			t.Errorf("Accept %q: unexpected balance %+v", test.Accept, got)
+		}
+	}
+}
+
+// getBalanceIfNoneMatch calls the GET balance API with an If-None-Match header
+func getBalanceIfNoneMatch(api *API, userID int, etag string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	response := httptest.NewRecorder()
+	api.getBalance(response, request, userID)
+	return response
+}
+
+func TestGetBalanceETag(t *testing.T) {
+	// A balance that hasn't changed since the client last retrieved it is not
+	// sent again. Once it changes, it is sent with a new ETag.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	expense := createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	}
+	if response := postExpense(api, userID1, expense); response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	response := getBalanceIfNoneMatch(api, userID1, "")
+	etag := response.Header().Get("ETag")
+	if response.Code != http.StatusOK || etag == "" {
+		t.Fatalf("wanted %d with an ETag, got %d and %q", http.StatusOK, response.Code, etag)
+	}
+
+	// The balance hasn't changed
+	response = getBalanceIfNoneMatch(api, userID1, etag)
+	if response.Code != http.StatusNotModified {
+		t.Errorf("wanted %d, got %d", http.StatusNotModified, response.Code)
+	}
+	if response.Body.Len() != 0 {
+		t.Errorf("wanted an empty body, got %s", response.Body.String())
+	}
+
+	// Any of a list of ETags can match
+	response = getBalanceIfNoneMatch(api, userID1, `W/"stale", `+etag)
+	if response.Code != http.StatusNotModified {
+		t.Errorf("wanted %d, got %d", http.StatusNotModified, response.Code)
+	}
+
+	// The balance changes
+	expense.Description = "Lunch"
+	if response := postExpense(api, userID1, expense); response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	response = getBalanceIfNoneMatch(api, userID1, etag)
+	if response.Code != http.StatusOK {
+		t.Errorf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+	if newETag := response.Header().Get("ETag"); newETag == "" || newETag == etag {
+		t.Errorf("wanted a new ETag, got %q", newETag)
+	}
+}
+
+func TestPostExpensesIncludeOwner(t *testing.T) {
+	// An expense that excludes the owner is split among the other users only and
+	// the owner is credited the full amount
+
+	tests := []struct {
+		IncludeOwner  *bool
+		OwnerBalance  float64
+		OthersBalance float64
+	}{
+		{nil, 20, -10},
+		{func() *bool { b := true; return &b }(), 20, -10},
+		{func() *bool { b := false; return &b }(), 30, -15},
+	}
+
+	for _, test := range tests {
+		db := database.NewInMemoryDatabase()
+		cache := cache.NewInMemoryCache()
+		api := NewAPI(db, cache)
+
+		dbh := db.Connect()
+		userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+		userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+		userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+		makeFriends(dbh, userID1, userID2, userID3)
+
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description:  "Gift",
+			Amount:       30,
+			CreatedAt:    "2021-01-01T15:04:05Z",
+			Users:        []userID{{ID: userID2}, {ID: userID3}},
+			IncludeOwner: test.IncludeOwner,
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+
+		if got := getBalance(t, api, userID1); got.Balance != test.OwnerBalance {
+			t.Errorf("wanted owner balance %f, got %+v", test.OwnerBalance, got)
+		}
+		for _, u := range []int{userID2, userID3} {
+			if got := getBalance(t, api, u); got.Balance != test.OthersBalance || got.DebtTo(userID1) != -test.OthersBalance {
+				t.Errorf("wanted balance %f for user %d, got %+v", test.OthersBalance, u, got)
+			}
+		}
+	}
+}
+
+func TestUndoExpenseExcludingOwner(t *testing.T) {
+	// Undoing an expense that excludes the owner also recalculates the owner's
+	// cached balance
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	includeOwner := false
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description:  "Gift",
+		Amount:       10,
+		CreatedAt:    "2021-01-01T15:04:05Z",
+		Users:        []userID{{ID: userID2}},
+		IncludeOwner: &includeOwner,
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense: %s", response.Body.String())
+	}
+	if got := getBalance(t, api, userID1); got.Balance != 10 {
+		t.Fatalf("wanted owner balance 10, got %+v", got)
+	}
+
+	if response := postUndo(api, userID1); response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+	for _, u := range []int{userID1, userID2} {
+		if got := getBalance(t, api, u); got.Balance != 0 {
+			t.Errorf("wanted balance 0 for user %d, got %+v", u, got)
+		}
+	}
+}
+
+func TestPostExpensesExcludeOwnerInvalid(t *testing.T) {
+	// An expense that excludes the owner needs other users and can't give the
+	// owner a percentage
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldMinOtherUsers := *minOtherUsers
+	defer func() { *minOtherUsers = oldMinOtherUsers }()
+	*minOtherUsers = 0
+
+	includeOwner := false
+	requests := []createExpenseRequest{
+		{Description: "Gift", Amount: 30, CreatedAt: "2021-01-01T15:04:05Z", IncludeOwner: &includeOwner},
+		{
+			Description:     "Gift",
+			Amount:          30,
+			CreatedAt:       "2021-01-01T15:04:05Z",
+			Users:           []userID{{ID: userID2}},
+			PercentageSplit: map[int]float64{userID1: 50, userID2: 50},
+			IncludeOwner:    &includeOwner,
+		},
+	}
+
+	for _, request := range requests {
+		response := postExpense(api, userID1, request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	}
+}
+
+func TestPostExpensesCreatedAtUTC(t *testing.T) {
+	// Post an expense with a +09:00 offset and ensure it's returned as the same
+	// instant in UTC
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Sushi",
+		Amount:      42,
+		CreatedAt:   "2021-01-02T09:04:05+09:00",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	got := getExpensesWithTag(t, api, userID1, "")
+	if len(got) != 1 {
+		t.Fatalf("wanted 1 expense, got %d", len(got))
+	}
+
+	wanted := time.Date(2021, 1, 2, 0, 4, 5, 0, time.UTC)
+	if !got[0].CreatedAt.Equal(wanted) || got[0].CreatedAt.Location() != time.UTC {
+		t.Errorf("wanted %v, got %v", wanted, got[0].CreatedAt)
+	}
+
+	stored := dbh.GetExpenses(userID1)[0].CreatedAt
+	if stored.Location() != time.UTC {
+		t.Errorf("wanted created_at stored in UTC, got %v", stored)
+	}
+}
+
+func TestGetBalanceCacheBypass(t *testing.T) {
+	// An administrator bypassing the cache gets the balance recalculated from the
+	// database, which also refreshes the stale cache entry. Other users can't
+	// bypass the cache.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	dbh.SetAdmin(userID1, true)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	stale := ledger.Balance{Balance: 1, Debit: []ledger.Debt{}, Credit: []ledger.Debt{}}
+	cache.SetBalance(stale, userID1, 0)
+	cache.SetBalance(stale, userID2, 0)
+
+	getBalanceBypassingCache := func(userID int) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+		request.Header.Set("X-Cache-Bypass", "true")
+		response := httptest.NewRecorder()
+		api.getBalance(response, request, userID)
+		return response
+	}
+
+	response = getBalanceBypassingCache(userID1)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+	var got ledger.Balance
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	if got.Balance != 21 {
+		t.Errorf("wanted balance 21, got %v", got.Balance)
+	}
+	if got := getBalance(t, api, userID1); got.Balance != 21 {
+		t.Errorf("wanted the cache refreshed with balance 21, got %v", got.Balance)
+	}
+
+	response = getBalanceBypassingCache(userID2)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("wanted %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if got := getBalance(t, api, userID2); got.Balance != 1 {
+		t.Errorf("wanted the stale balance 1, got %v", got.Balance)
+	}
+}
+
+func TestPostExpensesSplitAmongAll(t *testing.T) {
+	// Splitting among all shares the expense with the owner's friends at the
+	// time it's created, not with friends added later
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	dbh.CreateUser("test4@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description:   "Party",
+		Amount:        30,
+		CreatedAt:     "2021-01-01T15:04:05Z",
+		SplitAmongAll: true,
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("wanted %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	userID5, _ := dbh.CreateUser("test5@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID5)
+
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description:   "Second party",
+		Amount:        40,
+		CreatedAt:     "2021-01-02T15:04:05Z",
+		SplitAmongAll: true,
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("wanted %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	expenses := dbh.GetExpenses(userID1)
+	wanted := [][]int{{userID1, userID2, userID3}, {userID1, userID2, userID3, userID5}}
+	for i, e := range expenses {
+		users := append([]int{}, e.Users...)
+		sort.Ints(users)
+		if !reflect.DeepEqual(users, wanted[i]) {
+			t.Errorf("%s: wanted users %v, got %v", e.Description, wanted[i], users)
+		}
+	}
+
+	if balance := getBalance(t, api, userID5); balance.Balance != -10 {
+		t.Errorf("wanted user 5 to owe 10, got %+v", balance)
+	}
+
+	// The users can't be given as well
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description:   "Third party",
+		Amount:        40,
+		CreatedAt:     "2021-01-03T15:04:05Z",
+		Users:         []userID{{ID: userID2}},
+		SplitAmongAll: true,
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+}
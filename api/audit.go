@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/freewilll/splitter/database"
+)
+
+// auditLog makes mutations get recorded in the audit log
+var auditLog = flag.Bool("audit-log", true, "record all mutations in the audit log")
+
+// maxAuditEntries is the maximum number of audit log entries returned at once
+const maxAuditEntries = 1000
+
+type auditEntryResponse struct {
+	ID        int             `json:"id"`
+	UserID    int             `json:"user_id"`
+	Action    string          `json:"action"`
+	EntityID  int             `json:"entity_id"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type auditResponse struct {
+	Entries []auditEntryResponse `json:"entries"`
+}
+
+// connect returns a database handle for mutations made by userID. The mutations
+// are recorded in the audit log, if enabled.
+func (api *API) connect(userID int) database.Handle {
+	dbh := api.db.Connect()
+	if *auditLog {
+		return database.NewAuditedHandle(dbh, userID)
+	}
+	return dbh
+}
+
+// getAudit returns the newest entries of the audit log, optionally filtered by
+// the user_id, action, from and to query parameters. Only for administrators.
+func (api *API) getAudit(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	var errs validationErrors
+	q := database.AuditQuery{Action: database.AuditAction(r.URL.Query().Get("action")), Limit: maxAuditEntries}
+
+	if value := r.URL.Query().Get("user_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 1 {
+			errs.add("user_id", "user_id must be a positive integer")
+		}
+		q.UserID = id
+	}
+
+	for _, param := range []struct {
+		Name  string
+		Value *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if value := r.URL.Query().Get(param.Name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errs.add(param.Name, fmt.Sprintf("unable to parse %s", param.Name))
+			}
+			*param.Value = t
+		}
+	}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditEntries {
+			errs.add("limit", fmt.Sprintf("limit must be between 1 and %d", maxAuditEntries))
+		} else {
+			q.Limit = limit
+		}
+	}
+
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	entries := dbh.GetAuditEntries(q)
+	response := auditResponse{Entries: make([]auditEntryResponse, len(entries))}
+	for i, e := range entries {
+		response.Entries[i] = auditEntryResponse{
+			ID:        e.ID,
+			UserID:    e.UserID,
+			Action:    string(e.Action),
+			EntityID:  e.EntityID,
+			CreatedAt: e.CreatedAt,
+		}
+		if e.Before != "" {
+			response.Entries[i].Before = json.RawMessage(e.Before)
+		}
+		if e.After != "" {
+			response.Entries[i].After = json.RawMessage(e.After)
+		}
+	}
+
+	writeResponse(w, r, response)
+}
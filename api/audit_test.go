@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/testutil"
+)
+
+func TestAuditLog(t *testing.T) {
+	// Creating an expense writes exactly one audit entry, which an administrator
+	// can find in the audit log
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	dbh.SetAdmin(userID1, true)
+
+	response := postExpense(api, userID2, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID1}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	entries := dbh.GetAuditEntries(database.AuditQuery{})
+	if len(entries) != 1 {
+		t.Fatalf("wanted 1 audit entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.UserID != userID2 || entry.Action != database.AuditCreateExpense || entry.EntityID != 1 || entry.Before != "" {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if !strings.Contains(entry.After, `"Description":"Dinner"`) {
+		t.Errorf("wanted a snapshot of the expense, got %s", entry.After)
+	}
+
+	tests := []struct {
+		Query  string
+		Wanted int
+	}{
+		{"", 1},
+		{"?action=create_expense&user_id=2", 1},
+		{"?action=delete_expense", 0},
+		{"?user_id=1", 0},
+		{"?from=2100-01-01T00:00:00Z", 0},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodGet, "/audit"+test.Query, nil)
+		response := httptest.NewRecorder()
+		api.requireAdmin(api.getAudit)(response, request, userID1)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: wanted %d, got %d", test.Query, http.StatusOK, response.Code)
+		}
+
+		var got auditResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if len(got.Entries) != test.Wanted {
+			t.Errorf("%s: wanted %d entries, got %d", test.Query, test.Wanted, len(got.Entries))
+		}
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/audit?limit=0", nil)
+	response = httptest.NewRecorder()
+	api.requireAdmin(api.getAudit)(response, request, userID1)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	request, _ = http.NewRequest(http.MethodGet, "/audit", nil)
+	response = httptest.NewRecorder()
+	api.requireAdmin(api.getAudit)(response, request, userID2)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("wanted %d, got %d", http.StatusForbidden, response.Code)
+	}
+}
+
+func TestAuditLogRollback(t *testing.T) {
+	// A mutation that fails, either before or after the expense has been created,
+	// is rolled back and leaves no audit entry
+
+	db := testutil.NewMockDatabase(database.NewInMemoryDatabase())
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	body, _ := json.Marshal(createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+
+	for _, method := range []string{"CreateExpense", "GetLastAction"} {
+		db.Fail(method, errInjected)
+		request, _ := http.NewRequest(http.MethodPost, "/expenses", bytes.NewReader(body))
+		response := serveWithRecovery(api.postExpenses, request, userID1)
+		if response.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: wanted %d, got %d", method, http.StatusInternalServerError, response.Code)
+		}
+		db.Reset(method)
+
+		if entries := dbh.GetAuditEntries(database.AuditQuery{}); len(entries) != 0 {
+			t.Errorf("%s: wanted no audit entries, got %+v", method, entries)
+		}
+		if expenses := dbh.GetExpenses(userID1); len(expenses) != 0 {
+			t.Errorf("%s: wanted the expense rolled back, got %+v", method, expenses)
+		}
+	}
+}
+
+// onlyAuditEntry returns the only entry in the audit log, failing the test if
+// there isn't exactly one
+func onlyAuditEntry(t *testing.T, dbh database.Handle) database.AuditEntry {
+	t.Helper()
+	entries := dbh.GetAuditEntries(database.AuditQuery{})
+	if len(entries) != 1 {
+		t.Fatalf("wanted 1 audit entry, got %+v", entries)
+	}
+	return entries[0]
+}
+
+func TestAuditCreateUser(t *testing.T) {
+	// Creating a user writes one audit entry on behalf of the user creating them
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	response := postUser(api, userID1, createUserRequest{Email: "test2@getstream.io", Password: "secret"})
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	entry := onlyAuditEntry(t, dbh)
+	if entry.UserID != userID1 || entry.Action != database.AuditCreateUser || entry.EntityID != 2 {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if !strings.Contains(entry.After, `"Email":"test2@getstream.io"`) {
+		t.Errorf("wanted a snapshot of the user, got %s", entry.After)
+	}
+}
+
+func TestAuditChangePassword(t *testing.T) {
+	// Changing a password writes one audit entry without snapshots, while a
+	// failed attempt writes none
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	for _, test := range []struct {
+		Request changePasswordRequest
+		Code    int
+	}{
+		{changePasswordRequest{"wrong", "Correct4Horse"}, http.StatusUnauthorized},
+		{changePasswordRequest{"secret", "Correct4Horse"}, http.StatusNoContent},
+	} {
+		body, _ := json.Marshal(test.Request)
+		request, _ := http.NewRequest(http.MethodPost, "/me/password", bytes.NewReader(body))
+		response := httptest.NewRecorder()
+		api.postPassword(response, request, userID1)
+		if response.Code != test.Code {
+			t.Fatalf("%+v: wanted %d, got %d", test.Request, test.Code, response.Code)
+		}
+	}
+
+	entry := onlyAuditEntry(t, dbh)
+	if entry.UserID != userID1 || entry.Action != database.AuditChangePassword || entry.EntityID != userID1 ||
+		entry.Before != "" || entry.After != "" {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+}
+
+func TestAuditRequestFriend(t *testing.T) {
+	// Requesting a friendship writes one audit entry with its status
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+
+	response := postFriend(api, userID1, userID2)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	entry := onlyAuditEntry(t, dbh)
+	if entry.UserID != userID1 || entry.Action != database.AuditRequestFriend || entry.EntityID != userID2 {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if !strings.Contains(entry.After, `"Status":"pending"`) {
+		t.Errorf("wanted a snapshot of the friendship, got %s", entry.After)
+	}
+}
+
+func TestAuditSetEmail(t *testing.T) {
+	// Changing an email writes one audit entry with both emails
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	if response := patchEmail(api, userID1, "new1@getstream.io"); response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	entry := onlyAuditEntry(t, dbh)
+	if entry.UserID != userID1 || entry.Action != database.AuditSetEmail || entry.EntityID != userID1 {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if !strings.Contains(entry.Before, `"Email":"test1@getstream.io"`) || !strings.Contains(entry.After, `"Email":"new1@getstream.io"`) {
+		t.Errorf("wanted snapshots of both emails, got %s and %s", entry.Before, entry.After)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+type balancesRequest struct {
+	UserIDs []int `json:"user_ids"`
+}
+
+type balancesResponse struct {
+	Balances map[int]ledger.Balance `json:"balances"` // Keyed by user id
+}
+
+// postBalances returns the balances of several users at once, read from the
+// cache in a single batch. Only administrators can get the balances of other
+// users. Unknown users are left out of the response.
+func (api *API) postBalances(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var req balancesRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	if len(req.UserIDs) == 0 {
+		var errs validationErrors
+		errs.add("user_ids", "user_ids must not be empty")
+		errs.write(w)
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	if !dbh.IsAdmin(userID) {
+		for _, id := range req.UserIDs {
+			if id != userID {
+				log.Printf("User %d is not allowed to get the balance of user %d", userID, id)
+				writeError(w, http.StatusForbidden, "administrator access required to get the balances of other users")
+				return
+			}
+		}
+	}
+
+	var ids []int
+	for _, u := range dbh.GetUsersByID(req.UserIDs) {
+		ids = append(ids, u.ID)
+	}
+
+	writeResponse(w, r, balancesResponse{Balances: api.cache.GetBalances(api.db, ids)})
+}
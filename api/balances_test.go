@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func postBalances(api *API, userID int, ids []int) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(balancesRequest{UserIDs: ids})
+	request, _ := http.NewRequest(http.MethodPost, "/balances", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	api.postBalances(response, request, userID)
+	return response
+}
+
+func TestPostBalances(t *testing.T) {
+	// Administrators get the balances of several users in one call, other users
+	// only their own
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      30,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}, {ID: userID3}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense: %s", response.Body.String())
+	}
+
+	// Unknown users are left out
+	response = postBalances(api, adminID, []int{userID1, userID2, userID3, 99})
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	var got balancesResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]float64{userID1: 20, userID2: -10, userID3: -10}
+	if len(got.Balances) != len(want) {
+		t.Errorf("wanted %d balances, got %+v", len(want), got.Balances)
+	}
+	for id, amount := range want {
+		if balance, exists := got.Balances[id]; !exists || balance.Balance != amount {
+			t.Errorf("user %d: wanted balance %0.2f, got %+v", id, amount, balance)
+		}
+	}
+
+	if response := postBalances(api, userID2, []int{userID2}); response.Code != http.StatusOK {
+		t.Errorf("wanted %d for the own balance, got %d", http.StatusOK, response.Code)
+	}
+	if response := postBalances(api, userID2, []int{userID2, userID1}); response.Code != http.StatusForbidden {
+		t.Errorf("wanted %d for the balance of another user, got %d", http.StatusForbidden, response.Code)
+	}
+	if response := postBalances(api, adminID, nil); response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d without users, got %d", http.StatusBadRequest, response.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bufio"
+	"flag"
+	"os"
+	"strings"
+)
+
+// blockedEmails and blockedEmailsFile list email addresses and domains that
+// can't register, e.g. disposable email providers. An entry with an @ and a
+// local part, like spam@example.com, blocks that address. Any other entry, like
+// mailinator.com or @mailinator.com, blocks the domain and its subdomains. The
+// file has an entry per line, blank lines and lines starting with # are ignored.
+var blockedEmails = flag.String("blocked-emails", "", "comma separated list of email addresses and domains that can't register")
+var blockedEmailsFile = flag.String("blocked-emails-file", "", "file with an email address or domain per line that can't register")
+
+// emailBlocklist is the lowercased entries of blockedEmails and blockedEmailsFile
+type emailBlocklist []string
+
+// loadEmailBlocklist returns the entries of blockedEmails and blockedEmailsFile.
+// It's loaded once per request, so that the file can be changed without a
+// restart, and returns an error if the file can't be read.
+func loadEmailBlocklist() (emailBlocklist, error) {
+	var entries emailBlocklist
+	for _, entry := range strings.Split(*blockedEmails, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	if *blockedEmailsFile == "" {
+		return entries, nil
+	}
+
+	f, err := os.Open(*blockedEmailsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if entry != "" && !strings.HasPrefix(entry, "#") {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// mustLoadEmailBlocklist returns the blocklist like loadEmailBlocklist and
+// panics if the file can't be read, since it's checked when the server starts
+func mustLoadEmailBlocklist() emailBlocklist {
+	blocklist, err := loadEmailBlocklist()
+	if err != nil {
+		panic(err)
+	}
+	return blocklist
+}
+
+// blocks checks if an email address or its domain is in the blocklist
+func (b emailBlocklist) blocks(e string) bool {
+	e = strings.ToLower(e)
+	domain := e[strings.LastIndex(e, "@")+1:]
+
+	for _, entry := range b {
+		if i := strings.LastIndex(entry, "@"); i > 0 {
+			if e == entry {
+				return true
+			}
+			continue
+		}
+
+		blocked := strings.TrimPrefix(entry, "@")
+		if domain == blocked || strings.HasSuffix(domain, "."+blocked) {
+			return true
+		}
+	}
+	return false
+}
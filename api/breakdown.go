@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/freewilll/splitter/errkind"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// shareResponse is the part of an expense a user is responsible for
+type shareResponse struct {
+	UserID int     `json:"user_id"`
+	Name   string  `json:"name,omitempty"`
+	Amount float64 `json:"amount"`
+	Paid   bool    `json:"paid"` // The user paid for the expense
+}
+
+type breakdownResponse struct {
+	ExpenseID int             `json:"expense_id"`
+	PayerID   int             `json:"payer_id"`
+	Amount    float64         `json:"amount"`
+	Currency  string          `json:"currency"`
+	Split     string          `json:"split"` // equal, percentage or shares
+	Base      float64         `json:"base_per_person,omitempty"`
+	Shares    []shareResponse `json:"shares"`
+}
+
+// splitMethod returns the name of the method an expense is split by
+func splitMethod(e ledger.Expense) string {
+	if e.ShareSplit != nil {
+		return "shares"
+	} else if e.PercentageSplit != nil {
+		return "percentage"
+	}
+	return "equal"
+}
+
+// getExpenseBreakdown returns the share of each user of an expense shared by
+// the authenticated user, as calculated for the balances, ordered by user id.
+// The shares add up to the amount.
+func (api *API) getExpenseBreakdown(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	expenseID, ok := parseExpensePath(r.URL.Path, "breakdown")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expense, err := dbh.GetExpense(expenseID)
+	if errkind.Of(err) == errkind.NotFound || (err == nil && !expense.Involves(userID)) {
+		writeError(w, http.StatusNotFound, "expense not found")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	names := make(map[int]string, len(expense.Users))
+	for _, u := range dbh.GetUsersByID(expense.Users) {
+		names[u.ID] = u.Name
+	}
+
+	shares := make([]shareResponse, 0, len(expense.Users))
+	for u, amount := range expense.Shares() {
+		shares = append(shares, shareResponse{UserID: u, Name: names[u], Amount: amount, Paid: u == expense.Payer()})
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].UserID < shares[j].UserID })
+
+	writeResponse(w, r, breakdownResponse{
+		ExpenseID: expense.ExpenseID,
+		PayerID:   expense.Payer(),
+		Amount:    expense.Amount,
+		Currency:  expense.Currency,
+		Split:     splitMethod(expense),
+		Base:      expense.BasePerPerson,
+		Shares:    shares,
+	})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// getExpenseBreakdown calls the GET expense breakdown API on behalf of userID
+func getExpenseBreakdown(api *API, userID int, expenseID int) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/expenses/%d/breakdown", expenseID), nil)
+	response := httptest.NewRecorder()
+	api.expense(response, request, userID)
+	return response
+}
+
+func TestGetExpenseBreakdown(t *testing.T) {
+	// The breakdown has the share of every user, following the split of the
+	// expense, and adds up to the amount. Only users sharing the expense can see
+	// it.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	userID4, _ := dbh.CreateUser("test4@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+	dbh.SetName(userID2, "Bob")
+
+	tests := []struct {
+		Expense createExpenseRequest
+		Split   string
+		Wanted  []shareResponse
+	}{
+		{
+			createExpenseRequest{Description: "Dinner", Amount: 42, Users: []userID{{ID: userID2}, {ID: userID3}}},
+			"equal",
+			[]shareResponse{{userID1, "", 14, true}, {userID2, "Bob", 14, false}, {userID3, "", 14, false}},
+		},
+		{
+			createExpenseRequest{Description: "Taxi", Amount: 10, Users: []userID{{ID: userID2}}, PayerID: userID2, ShareSplit: map[int]int{userID1: 1, userID2: 2}},
+			"shares",
+			[]shareResponse{{userID1, "", 3.33, false}, {userID2, "Bob", 6.67, true}},
+		},
+		{
+			createExpenseRequest{Description: "Hotel", Amount: 100, Users: []userID{{ID: userID2}, {ID: userID3}}, PercentageSplit: map[int]float64{userID1: 50, userID2: 30, userID3: 20}},
+			"percentage",
+			[]shareResponse{{userID1, "", 50, true}, {userID2, "Bob", 30, false}, {userID3, "", 20, false}},
+		},
+	}
+
+	for _, test := range tests {
+		test.Expense.CreatedAt = "2021-01-01T15:04:05Z"
+		if response := postExpense(api, userID1, test.Expense); response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+		action, _ := dbh.GetLastAction(userID1)
+
+		response := getExpenseBreakdown(api, userID2, action.ID)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: wanted %d, got %d", test.Expense.Description, http.StatusOK, response.Code)
+		}
+		var got breakdownResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+
+		if got.Split != test.Split || !reflect.DeepEqual(got.Shares, test.Wanted) {
+			t.Errorf("%s: wanted %s split %+v, got %s split %+v", test.Expense.Description, test.Split, test.Wanted, got.Split, got.Shares)
+		}
+		var total float64
+		for _, share := range got.Shares {
+			total += share.Amount
+		}
+		if math.Abs(total-test.Expense.Amount) > ledger.Epsilon {
+			t.Errorf("%s: wanted shares adding up to %v, got %v", test.Expense.Description, test.Expense.Amount, total)
+		}
+	}
+
+	// Users not sharing the expense and unknown expenses aren't found
+	if response := getExpenseBreakdown(api, userID4, 1); response.Code != http.StatusNotFound {
+		t.Errorf("wanted %d for another user, got %d", http.StatusNotFound, response.Code)
+	}
+	if response := getExpenseBreakdown(api, userID1, 42); response.Code != http.StatusNotFound {
+		t.Errorf("wanted %d for an unknown expense, got %d", http.StatusNotFound, response.Code)
+	}
+}
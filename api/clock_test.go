@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/jwt"
+)
+
+func TestClockExpiry(t *testing.T) {
+	// Advancing the clocks of the api, jwt and cache expires tokens and cache
+	// entries without waiting
+
+	db := database.NewInMemoryDatabase()
+	memoryCache := cache.NewInMemoryCache().(*cache.InMemoryCache)
+	api := NewAPI(db, memoryCache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	api.now = clock
+	memoryCache.Now = clock
+	oldNow := jwt.Now
+	defer func() { jwt.Now = oldNow }()
+	jwt.Now = clock
+
+	cookie := signinCookie(t, api, "test1@getstream.io", "secret")
+	session, err := jwt.ParseToken(cookie.Value, api.isValidSession)
+	if err != nil || session.UserID != userID1 || !session.ExpiresAt.Equal(now.Add(30*time.Minute)) {
+		t.Fatalf("wanted a token of user %d expiring in 30 minutes, got %+v, %v", userID1, session, err)
+	}
+	memoryCache.RecordFailedLogin("test2@getstream.io", time.Minute)
+
+	pass := func(w http.ResponseWriter, r *http.Request, userID int) {}
+	tests := []struct {
+		Elapsed      time.Duration
+		Code         int
+		Session      bool
+		FailedLogins int
+	}{
+		{59 * time.Second, http.StatusOK, true, 1},
+		{time.Minute + time.Second, http.StatusOK, true, 0},
+		{30*time.Minute - time.Second, http.StatusOK, true, 0},
+		{30*time.Minute + time.Second, http.StatusUnauthorized, false, 0},
+	}
+	signedInAt := now
+	for _, test := range tests {
+		now = signedInAt.Add(test.Elapsed)
+		if response := callWithCookie(api, http.MethodGet, pass, cookie); response.Code != test.Code {
+			t.Errorf("after %v: wanted %d, got %d", test.Elapsed, test.Code, response.Code)
+		}
+		if got := memoryCache.IsValidSession(userID1, session.TokenID); got != test.Session {
+			t.Errorf("after %v: wanted the session valid %v, got %v", test.Elapsed, test.Session, got)
+		}
+		if got := memoryCache.GetFailedLogins("test2@getstream.io"); got != test.FailedLogins {
+			t.Errorf("after %v: wanted %d failed logins, got %d", test.Elapsed, test.FailedLogins, got)
+		}
+	}
+
+	if _, err := jwt.ParseToken(cookie.Value, nil); err != jwt.ErrExpired {
+		t.Errorf("wanted %v, got %v", jwt.ErrExpired, err)
+	}
+}
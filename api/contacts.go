@@ -0,0 +1,43 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// defaultRecentContacts is the number of recent contacts returned without a limit
+const defaultRecentContacts = 10
+
+// getRecentContacts returns the users the authenticated user most recently
+// shared expenses with, to pre-populate new expenses. The number of users is
+// given by the limit query parameter.
+func (api *API) getRecentContacts(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	limit := defaultRecentContacts
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > *maxUsers {
+			var errs validationErrors
+			errs.add("limit", fmt.Sprintf("limit must be between 1 and %d", *maxUsers))
+			errs.write(w)
+			return
+		}
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	dbUsers := dbh.GetRecentContacts(userID, limit)
+	users := usersResponse{Users: make([]userResponse, len(dbUsers))}
+	for i, u := range dbUsers {
+		users.Users[i] = newUserResponse(u)
+	}
+
+	writeResponse(w, r, users)
+}
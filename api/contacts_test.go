@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func getRecentContacts(t *testing.T, api *API, userID int, query string) ([]int, *httptest.ResponseRecorder) {
+	request, _ := http.NewRequest(http.MethodGet, "/contacts/recent?"+query, nil)
+	response := httptest.NewRecorder()
+	api.getRecentContacts(response, request, userID)
+	if response.Code != http.StatusOK {
+		return nil, response
+	}
+
+	var got usersResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	ids := make([]int, len(got.Users))
+	for i, u := range got.Users {
+		ids[i] = u.ID
+	}
+	return ids, response
+}
+
+func TestRecentContacts(t *testing.T) {
+	// The users shared with most recently come first, the user themselves and
+	// users they haven't shared with are left out
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	userID4, _ := dbh.CreateUser("test4@getstream.io", "secret")
+	userID5, _ := dbh.CreateUser("test5@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3, userID4, userID5)
+
+	expenses := []struct {
+		UserID     int
+		OtherUsers []userID
+		CreatedAt  string
+	}{
+		{userID1, []userID{{ID: userID2}}, "2021-01-01T12:00:00Z"},
+		{userID1, []userID{{ID: userID3}, {ID: userID4}}, "2021-01-02T12:00:00Z"},
+		{userID1, []userID{{ID: userID3}}, "2021-01-03T12:00:00Z"},
+		{userID2, []userID{{ID: userID1}}, "2021-01-05T12:00:00Z"},
+		{userID4, []userID{{ID: userID5}}, "2021-01-10T12:00:00Z"},
+	}
+	for i, e := range expenses {
+		response := postExpense(api, e.UserID, createExpenseRequest{
+			Description: fmt.Sprintf("Expense %d", i),
+			Amount:      10,
+			CreatedAt:   e.CreatedAt,
+			Users:       e.OtherUsers,
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+	}
+
+	tests := []struct {
+		UserID int
+		Query  string
+		Wanted []int
+	}{
+		{userID1, "", []int{userID2, userID3, userID4}},
+		{userID1, "limit=2", []int{userID2, userID3}},
+		{userID4, "", []int{userID5, userID1, userID3}},
+		{userID5, "", []int{userID4}},
+	}
+	for _, test := range tests {
+		got, response := getRecentContacts(t, api, test.UserID, test.Query)
+		if response.Code != http.StatusOK {
+			t.Errorf("user %d %q: wanted %d, got %d", test.UserID, test.Query, http.StatusOK, response.Code)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.Wanted) {
+			t.Errorf("user %d %q: wanted %v, got %v", test.UserID, test.Query, test.Wanted, got)
+		}
+	}
+
+	// Deleted users are left out
+	dbh.DeleteUser(userID2)
+	if got, _ := getRecentContacts(t, api, userID1, ""); !reflect.DeepEqual(got, []int{userID3, userID4}) {
+		t.Errorf("wanted users %d and %d, got %v", userID3, userID4, got)
+	}
+
+	if _, response := getRecentContacts(t, api, userID1, "limit=0"); response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+}
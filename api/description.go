@@ -0,0 +1,22 @@
+package api
+
+// DescriptionValidator lets deployments check expense descriptions beyond the
+// built-in length limit, e.g. with a profanity filter. ValidateDescription
+// returns the description to store, which may be sanitized, or an error whose
+// message is returned to the client.
+type DescriptionValidator interface {
+	ValidateDescription(description string) (string, error)
+}
+
+// noopDescriptionValidator accepts all descriptions unchanged
+type noopDescriptionValidator struct{}
+
+// ValidateDescription returns the description unchanged
+func (noopDescriptionValidator) ValidateDescription(description string) (string, error) {
+	return description, nil
+}
+
+// SetDescriptionValidator sets the validator for the descriptions of new expenses
+func (api *API) SetDescriptionValidator(v DescriptionValidator) {
+	api.descriptionValidator = v
+}
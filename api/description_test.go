@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// bannedWordValidator rejects descriptions containing a banned word and
+// uppercases all others
+type bannedWordValidator struct {
+	word string
+}
+
+func (v bannedWordValidator) ValidateDescription(description string) (string, error) {
+	if strings.Contains(strings.ToLower(description), v.word) {
+		return "", errors.New("description contains a banned word")
+	}
+	return strings.ToUpper(description), nil
+}
+
+func TestDescriptionValidator(t *testing.T) {
+	// A custom validator rejects descriptions with a banned word and sanitizes
+	// the others
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+	api.SetDescriptionValidator(bannedWordValidator{word: "rude"})
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Something RUDE",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+	var got validationErrorResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wanted := []fieldError{{Field: "description", Message: "description contains a banned word"}}
+	if !reflect.DeepEqual(got.Errors, wanted) {
+		t.Errorf("wanted %+v, got %+v", wanted, got.Errors)
+	}
+
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("wanted %d, got %d", http.StatusCreated, response.Code)
+	}
+	if expenses := dbh.GetExpenses(userID1); len(expenses) != 1 || expenses[0].Description != "DINNER" {
+		t.Errorf("wanted only the sanitized dinner, got %+v", expenses)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+// checkUserExists makes requireAuth reject tokens of users that have since been
+// deleted. Users that exist are remembered for userExistsTTL to keep it cheap.
+var checkUserExists = flag.Bool("check-user-exists", true, "reject tokens of deleted users")
+var userExistsTTL = flag.Duration("user-exists-ttl", time.Minute, "time a user is remembered to exist")
+
+// existenceCache remembers when users were last seen to exist
+type existenceCache struct {
+	mutex   sync.Mutex
+	checked map[int]time.Time
+}
+
+// userExists returns true if userID exists and hasn't been deleted, looking it
+// up in the database unless it was seen within userExistsTTL
+func (api *API) userExists(userID int) bool {
+	api.existence.mutex.Lock()
+	checked, ok := api.existence.checked[userID]
+	api.existence.mutex.Unlock()
+	if ok && api.now().Sub(checked) < *userExistsTTL {
+		return true
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+	if !dbh.UserExists(userID) {
+		return false
+	}
+
+	api.existence.mutex.Lock()
+	api.existence.checked[userID] = api.now()
+	api.existence.mutex.Unlock()
+	return true
+}
+
+// forgetUser makes the next userExists call for userID look it up in the
+// database, e.g. after the user has been deleted
+func (api *API) forgetUser(userID int) {
+	api.existence.mutex.Lock()
+	delete(api.existence.checked, userID)
+	api.existence.mutex.Unlock()
+}
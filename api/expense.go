@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/errkind"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// getExpense returns an expense shared by the authenticated user, including its
+// notes, which aren't in lists of expenses
+func (api *API) getExpense(w http.ResponseWriter, r *http.Request, userID int) {
+	expenseID, ok := parseExpensePath(r.URL.Path, "")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET", "PUT", "DELETE")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expense, err := dbh.GetExpense(expenseID)
+	if errkind.Of(err) == errkind.NotFound || (err == nil && !expense.Involves(userID)) {
+		writeError(w, http.StatusNotFound, "expense not found")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	response := newExpenseResponse(expense)
+	response.Notes = expense.Notes
+	writeResponse(w, r, response)
+}
+
+// findEditableExpense returns the expense of a /expenses/{id} path if the
+// authenticated user owns it and it's within the edit window. Otherwise an error
+// is written and false is returned.
+func (api *API) findEditableExpense(w http.ResponseWriter, r *http.Request, dbh database.Handle, userID int) (ledger.Expense, bool) {
+	expenseID, ok := parseExpensePath(r.URL.Path, "")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return ledger.Expense{}, false
+	}
+
+	expense, err := dbh.GetExpense(expenseID)
+	if errkind.Of(err) == errkind.NotFound || (err == nil && !expense.Involves(userID)) {
+		writeError(w, http.StatusNotFound, "expense not found")
+		return ledger.Expense{}, false
+	} else if err != nil {
+		panic(err)
+	}
+
+	if expense.OwnerID != userID {
+		log.Printf("User %d doesn't own expense %d", userID, expenseID)
+		writeError(w, http.StatusForbidden, "only the owner can change the expense")
+		return ledger.Expense{}, false
+	}
+
+	if isTooOldToEdit(expense, api.now()) {
+		log.Printf("Expense %d is too old to change", expenseID)
+		writeError(w, http.StatusForbidden, "the expense is too old to change")
+		return ledger.Expense{}, false
+	}
+
+	return expense, true
+}
+
+// updateExpenseBalances writes through the balances of everyone sharing any of
+// the versions of an expense to the cache
+func (api *API) updateExpenseBalances(dbh database.Handle, expenses ...ledger.Expense) {
+	updated := make(map[int]bool)
+	for _, e := range expenses {
+		for _, u := range append([]int{e.OwnerID, e.Payer()}, e.Users...) {
+			if !updated[u] {
+				api.updateBalance(dbh, u)
+				updated[u] = true
+			}
+		}
+	}
+}
+
+// putExpense replaces an expense of the authenticated user with the expense in
+// the request, which is validated like a new one. Its tags are kept.
+func (api *API) putExpense(w http.ResponseWriter, r *http.Request, userID int) {
+	var e createExpenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	before, ok := api.findEditableExpense(w, r, dbh, userID)
+	if !ok {
+		return
+	}
+
+	var errs validationErrors
+	expense := api.expenseFromRequest(dbh, e, userID, &errs)
+	if errs.write(w) {
+		return
+	}
+	expense.ExpenseID = before.ExpenseID
+
+	log.Printf("Changing expense %d of user %d", expense.ExpenseID, userID)
+	if err := dbh.UpdateExpense(expense); err != nil {
+		switch errkind.Of(err) {
+		case errkind.LimitExceeded:
+			errs.add("users", fmt.Sprintf("at most %d users can share an expense", database.MaxParticipants))
+			errs.write(w)
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	after, err := dbh.GetExpense(expense.ExpenseID)
+	if err != nil {
+		panic(err)
+	}
+	api.updateExpenseBalances(dbh, before, after)
+
+	response := newExpenseResponse(after)
+	response.Notes = after.Notes
+	writeResponse(w, r, response)
+}
+
+// deleteExpense deletes an expense of the authenticated user
+func (api *API) deleteExpense(w http.ResponseWriter, r *http.Request, userID int) {
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	expense, ok := api.findEditableExpense(w, r, dbh, userID)
+	if !ok {
+		return
+	}
+
+	log.Printf("Deleting expense %d of user %d", expense.ExpenseID, userID)
+	dbh.DeleteExpense(expense.ExpenseID)
+	api.updateExpenseBalances(dbh, expense)
+
+	w.WriteHeader(http.StatusNoContent)
+}
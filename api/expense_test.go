@@ -0,0 +1,242 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func getExpense(api *API, userID int, expenseID int) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/expenses/%d", expenseID), nil)
+	response := httptest.NewRecorder()
+	api.expense(response, request, userID)
+	return response
+}
+
+// callExpense calls the /expenses/{id} endpoint with a method on behalf of userID
+func callExpense(api *API, method string, userID int, expenseID int, e interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(e)
+	request, _ := http.NewRequest(method, fmt.Sprintf("/expenses/%d", expenseID), bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	api.expense(response, request, userID)
+	return response
+}
+
+func TestExpenseNotes(t *testing.T) {
+	// Notes are returned with a single expense to the users sharing it, but not
+	// in lists of expenses or to other users
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	notes := "Booked by Bob, the deposit is refunded on Monday"
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Boat",
+		Notes:       "  " + notes + "\n",
+		Amount:      100,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense: %s", response.Body.String())
+	}
+	expenseID := dbh.GetExpenses(userID1)[0].ExpenseID
+
+	for _, u := range []int{userID1, userID2} {
+		response := getExpense(api, u, expenseID)
+		if response.Code != http.StatusOK {
+			t.Fatalf("user %d: wanted %d, got %d", u, http.StatusOK, response.Code)
+		}
+		var got expenseResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != expenseID || got.Description != "Boat" || got.Notes != notes {
+			t.Errorf("user %d: wanted the expense with notes %q, got %+v", u, notes, got)
+		}
+	}
+
+	if response := getExpense(api, userID3, expenseID); response.Code != http.StatusNotFound || strings.Contains(response.Body.String(), notes) {
+		t.Errorf("wanted %d without notes for another user, got %d: %s", http.StatusNotFound, response.Code, response.Body.String())
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/expenses", nil)
+	response = httptest.NewRecorder()
+	api.expenses(response, request, userID1)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "notes") {
+		t.Errorf("wanted a list of expenses without notes, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// Notes have a maximum length
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "Boat",
+		Notes:       strings.Repeat("x", *maxNotesLength+1),
+		Amount:      100,
+		CreatedAt:   "2021-01-02T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d for long notes, got %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestPutAndDeleteExpense(t *testing.T) {
+	// The owner changes an expense and then deletes it. The balances of users
+	// who shared either version are recalculated. Other users can't change it.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      20,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense: %s", response.Body.String())
+	}
+	expenseID := dbh.GetExpenses(userID1)[0].ExpenseID
+	dbh.AddExpenseTags(expenseID, []string{"food"})
+
+	// User 3 replaces user 2
+	changed := createExpenseRequest{
+		Description: "Lunch",
+		Amount:      30,
+		CreatedAt:   "2021-01-02T15:04:05Z",
+		Users:       []userID{{ID: userID3}},
+	}
+	response = callExpense(api, http.MethodPut, userID1, expenseID, changed)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	var got expenseResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != expenseID || got.Description != "Lunch" || got.Amount != 30 || len(got.Tags) != 1 {
+		t.Errorf("wanted the changed expense with its tags, got %+v", got)
+	}
+	for u, wanted := range map[int]float64{userID1: 15, userID2: 0, userID3: -15} {
+		if balance := getBalance(t, api, u).Balance; balance != wanted {
+			t.Errorf("user %d: wanted balance %0.2f, got %0.2f", u, wanted, balance)
+		}
+	}
+
+	// Only the owner can change the expense, other users don't see it at all
+	for _, test := range []struct {
+		Method string
+		UserID int
+		Wanted int
+	}{
+		{http.MethodPut, userID3, http.StatusForbidden},
+		{http.MethodDelete, userID3, http.StatusForbidden},
+		{http.MethodPut, userID2, http.StatusNotFound},
+		{http.MethodDelete, userID2, http.StatusNotFound},
+	} {
+		if response := callExpense(api, test.Method, test.UserID, expenseID, changed); response.Code != test.Wanted {
+			t.Errorf("%s by user %d: wanted %d, got %d", test.Method, test.UserID, test.Wanted, response.Code)
+		}
+	}
+
+	// The changed expense is validated like a new one
+	invalid := changed
+	invalid.Amount = -1
+	if response := callExpense(api, http.MethodPut, userID1, expenseID, invalid); response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d for an invalid expense, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	response = callExpense(api, http.MethodDelete, userID1, expenseID, nil)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusNoContent, response.Code, response.Body.String())
+	}
+	if expenses := dbh.GetExpenses(userID1); len(expenses) != 0 {
+		t.Errorf("wanted no expenses, got %+v", expenses)
+	}
+	for _, u := range []int{userID1, userID3} {
+		if balance := getBalance(t, api, u).Balance; balance != 0 {
+			t.Errorf("user %d: wanted balance 0, got %0.2f", u, balance)
+		}
+	}
+}
+
+func TestExpenseEditWindow(t *testing.T) {
+	// An expense can be tagged, changed and deleted within the edit window after
+	// it was recorded, even if it's dated long before, and is rejected afterwards
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldEditWindow := *editWindow
+	defer func() { *editWindow = oldEditWindow }()
+	*editWindow = 30 * 24 * time.Hour
+
+	tests := []struct {
+		Name          string
+		Elapsed       time.Duration
+		Wanted        int
+		WantedDeleted int
+	}{
+		{"just recorded", 0, http.StatusOK, http.StatusNoContent},
+		{"recent", 24 * time.Hour, http.StatusOK, http.StatusNoContent},
+		{"old", 31 * 24 * time.Hour, http.StatusForbidden, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		e := createExpenseRequest{
+			Description: test.Name,
+			Amount:      10,
+			CreatedAt:   time.Now().Add(-365 * 24 * time.Hour).Format(time.RFC3339),
+			Users:       []userID{{ID: userID2}},
+		}
+		api.now = time.Now
+		if response := postExpense(api, userID1, e); response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+		created, _ := dbh.GetLastAction(userID1)
+		api.now = func() time.Time { return time.Now().Add(test.Elapsed) }
+
+		if response := postExpenseTags(api, userID1, created.ID, []string{"food"}); response.Code != test.Wanted {
+			t.Errorf("%s: wanted %d when tagging, got %d", test.Name, test.Wanted, response.Code)
+		}
+		e.Amount = 20
+		if response := callExpense(api, http.MethodPut, userID1, created.ID, e); response.Code != test.Wanted {
+			t.Errorf("%s: wanted %d when changing, got %d", test.Name, test.Wanted, response.Code)
+		}
+		if response := callExpense(api, http.MethodDelete, userID1, created.ID, nil); response.Code != test.WantedDeleted {
+			t.Errorf("%s: wanted %d when deleting, got %d", test.Name, test.WantedDeleted, response.Code)
+		}
+	}
+
+	expenses := dbh.GetExpenses(userID1)
+	if len(expenses) != 1 || expenses[0].Amount != 10 || len(expenses[0].Tags) != 0 {
+		t.Errorf("wanted only the unchanged old expense, got %+v", expenses)
+	}
+}
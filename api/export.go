@@ -0,0 +1,60 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+type exportResponse struct {
+	User        userResponse         `json:"user"`
+	Expenses    []expenseResponse    `json:"expenses"`
+	Settlements []settlementResponse `json:"settlements"`
+	Balance     ledger.Balance       `json:"balance"`
+}
+
+// getExport returns all data of the authenticated user: their profile, the
+// expenses they share, their settlements and their balance. Other users only
+// appear by id.
+func (api *API) getExport(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	users := dbh.GetUsersByID([]int{userID})
+	if len(users) != 1 {
+		log.Printf("Unknown user %d", userID)
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	expenses := make([]ledger.Expense, 0)
+	for _, e := range dbh.GetExpenses(userID) {
+		if e.Involves(userID) {
+			expenses = append(expenses, e)
+		}
+	}
+	settlements := dbh.GetSettlements(userID)
+
+	export := exportResponse{
+		User:        newUserResponse(users[0]),
+		Expenses:    make([]expenseResponse, len(expenses)),
+		Settlements: make([]settlementResponse, len(settlements)),
+		Balance:     database.WithNames(dbh, ledger.CalculateBalance(expenses, settlements, userID)),
+	}
+	for i, e := range expenses {
+		export.Expenses[i] = newExpenseResponse(e)
+	}
+	for i, s := range settlements {
+		export.Settlements[i] = newSettlementResponse(s)
+	}
+
+	log.Printf("Exporting data for user %d", userID)
+	writeResponse(w, r, export)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestGetExport(t *testing.T) {
+	// Export the data of a user and ensure it contains their expenses but none
+	// of the expenses between other users
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	expenses := []struct {
+		UserID      int
+		OtherUserID int
+		Description string
+	}{
+		{userID1, userID2, "Dinner"},
+		{userID2, userID3, "Private gift"},
+	}
+	for _, e := range expenses {
+		response := postExpense(api, e.UserID, createExpenseRequest{
+			Description: e.Description,
+			Amount:      42,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: e.OtherUserID}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/me/export", nil)
+	response := httptest.NewRecorder()
+	api.getExport(response, request, userID1)
+	body := response.Body.String()
+	var got exportResponse
+	err := json.NewDecoder(strings.NewReader(body)).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+
+	if got.User.ID != userID1 || got.User.Email != "test1@getstream.io" {
+		t.Errorf("wanted user %d, got %+v", userID1, got.User)
+	}
+
+	if len(got.Expenses) != 1 || got.Expenses[0].Description != "Dinner" {
+		t.Errorf("wanted only the Dinner expense, got %+v", got.Expenses)
+	}
+
+	if got.Balance.Balance != 21 {
+		t.Errorf("wanted balance 21, got %v", got.Balance.Balance)
+	}
+
+	for _, private := range []string{"Private gift", "test2@getstream.io", "test3@getstream.io"} {
+		if strings.Contains(body, private) {
+			t.Errorf("export contains '%s'", private)
+		}
+	}
+}
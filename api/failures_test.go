@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/testutil"
+)
+
+var errInjected = errors.New("injected failure")
+
+// serveWithRecovery calls an authenticated handler the way the server does,
+// turning panics into a 500
+func serveWithRecovery(pass authenticatedHandler, request *http.Request, userID int) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pass(w, r, userID)
+	})).ServeHTTP(response, request)
+	return response
+}
+
+func TestDatabaseFailure(t *testing.T) {
+	// A failing database query results in a 500 and the database keeps working
+	// once the failure is resolved
+
+	db := testutil.NewMockDatabase(database.NewInMemoryDatabase())
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	body, _ := json.Marshal(createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+
+	db.Fail("CreateExpense", errInjected)
+	request, _ := http.NewRequest(http.MethodPost, "/expenses", bytes.NewReader(body))
+	response := serveWithRecovery(api.postExpenses, request, userID1)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("wanted %d, got %d", http.StatusInternalServerError, response.Code)
+	}
+
+	var got errorResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Error != "internal server error" {
+		t.Errorf("wanted a json error, got %v (%v)", got, err)
+	}
+
+	db.Reset("CreateExpense")
+	request, _ = http.NewRequest(http.MethodPost, "/expenses", bytes.NewReader(body))
+	response = serveWithRecovery(api.postExpenses, request, userID1)
+	if response.Code != http.StatusCreated {
+		t.Errorf("wanted %d, got %d", http.StatusCreated, response.Code)
+	}
+}
+
+func TestDatabaseErrorReturned(t *testing.T) {
+	// An unexpected error returned by the database results in a 500
+
+	db := testutil.NewMockDatabase(database.NewInMemoryDatabase())
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	dbh.CreateUser("test1@getstream.io", "secret")
+
+	db.Fail("AuthenticateUser", errInjected)
+	body, _ := json.Marshal(authRequest{Email: "test1@getstream.io", Password: "secret"})
+	request, _ := http.NewRequest(http.MethodPost, "/signin", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	recoverPanics(http.HandlerFunc(api.signin)).ServeHTTP(response, request)
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("wanted %d, got %d", http.StatusInternalServerError, response.Code)
+	}
+}
+
+func TestWrappedDatabaseErrors(t *testing.T) {
+	// Errors returned by the database are classified by kind, however they have
+	// been wrapped with context
+
+	db := testutil.NewMockDatabase(database.NewInMemoryDatabase())
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+
+	wrapped := fmt.Errorf("creating user: %w", fmt.Errorf("user test3@getstream.io: %w", database.ErrDuplicate))
+	db.Fail("CreateUser", wrapped)
+	response := postUser(api, userID1, createUserRequest{Email: "test3@getstream.io", Password: "secret"})
+	if response.Code != http.StatusConflict {
+		t.Errorf("wanted %d for %v, got %d", http.StatusConflict, wrapped, response.Code)
+	}
+
+	wrapped = fmt.Errorf("requesting friendship: %w", database.ErrNotFound)
+	db.Fail("RequestFriend", wrapped)
+	response = postFriend(api, userID1, userID2)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("wanted %d for %v, got %d", http.StatusNotFound, wrapped, response.Code)
+	}
+
+	// Errors of other kinds still result in a 500
+	wrapped = fmt.Errorf("creating user: %w", errInjected)
+	db.Fail("CreateUser", wrapped)
+	request, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewReader([]byte(`{"email":"test3@getstream.io","password":"secret"}`)))
+	response = serveWithRecovery(api.users, request, userID1)
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("wanted %d for %v, got %d", http.StatusInternalServerError, wrapped, response.Code)
+	}
+}
+
+func TestCacheFailure(t *testing.T) {
+	// The balance can't be retrieved while the cache is down
+
+	db := database.NewInMemoryDatabase()
+	cache := testutil.NewMockCache(cache.NewInMemoryCache())
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	cache.Fail("GetBalance", errInjected)
+	request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+	response := serveWithRecovery(api.getBalance, request, userID1)
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("wanted %d, got %d", http.StatusInternalServerError, response.Code)
+	}
+
+	cache.Reset("GetBalance")
+	request, _ = http.NewRequest(http.MethodGet, "/balance", nil)
+	response = serveWithRecovery(api.getBalance, request, userID1)
+	if response.Code != http.StatusOK {
+		t.Errorf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+}
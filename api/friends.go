@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/freewilll/splitter/errkind"
+)
+
+// requireFriends restricts the users sharing an expense to the owner's confirmed friends
+var requireFriends = flag.Bool("require-friends", true, "only allow splitting expenses with confirmed friends")
+
+type friendRequest struct {
+	UserID int `json:"user_id"`
+}
+
+type friendResponse struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status"`
+}
+
+// getFriends returns the authenticated user's confirmed friends
+func (api *API) getFriends(w http.ResponseWriter, r *http.Request, userID int) {
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	dbUsers := dbh.GetFriends(userID)
+	users := usersResponse{Users: make([]userResponse, len(dbUsers))}
+	for i, u := range dbUsers {
+		users.Users[i] = newUserResponse(u)
+	}
+
+	writeResponse(w, r, users)
+}
+
+// postFriends sends a friend request to another user. If that user has already
+// sent a friend request to the authenticated user, the friendship is confirmed.
+func (api *API) postFriends(w http.ResponseWriter, r *http.Request, userID int) {
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	var f friendRequest
+	err := json.NewDecoder(r.Body).Decode(&f)
+	if err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	if f.UserID == userID {
+		log.Print("Friend request to self")
+		writeError(w, http.StatusBadRequest, "user_id must not be self")
+		return
+	}
+
+	status, err := dbh.RequestFriend(userID, f.UserID)
+	if err != nil {
+		switch errkind.Of(err) {
+		case errkind.NotFound:
+			log.Printf("Friend request to unknown user %d", f.UserID)
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		case errkind.Duplicate:
+			log.Printf("Duplicate friend request from %d to %d", userID, f.UserID)
+			writeError(w, http.StatusConflict, "friendship already requested")
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	log.Printf("Friendship between %d and %d is %s", userID, f.UserID, status)
+	writeResponse(w, r, friendResponse{UserID: f.UserID, Status: string(status)})
+}
+
+// friends handles the friends endpoint for the GET and POST methods
+func (api *API) friends(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method == "GET" {
+		api.getFriends(w, r, userID)
+	} else if r.Method == "POST" {
+		api.postFriends(w, r, userID)
+	} else {
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// makeFriends makes all users confirmed friends of each other
+func makeFriends(dbh database.Handle, userIDs ...int) {
+	for i, a := range userIDs {
+		for _, b := range userIDs[i+1:] {
+			dbh.RequestFriend(a, b)
+			dbh.RequestFriend(b, a)
+		}
+	}
+}
+
+// postFriend posts a friend request on behalf of userID and returns the response
+func postFriend(api *API, userID int, friendID int) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(friendRequest{UserID: friendID})
+	request, _ := http.NewRequest(http.MethodPost, "/friends", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	api.friends(response, request, userID)
+	return response
+}
+
+func TestFriends(t *testing.T) {
+	// Splitting an expense with a user is only possible once they've accepted
+	// a friend request
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+
+	expense := createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	}
+
+	response := postExpense(api, userID1, expense)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	// A pending friend request isn't enough
+	tests := []struct {
+		UserID   int
+		FriendID int
+		Code     int
+		Status   string
+	}{
+		{userID1, userID2, http.StatusOK, "pending"},
+		{userID1, userID2, http.StatusConflict, ""},
+		{userID1, 42, http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		response = postFriend(api, test.UserID, test.FriendID)
+		var got friendResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		if response.Code != test.Code || got.Status != test.Status {
+			t.Errorf("%d -> %d: wanted %d %s, got %d %s",
+				test.UserID, test.FriendID, test.Code, test.Status, response.Code, got.Status)
+		}
+	}
+
+	response = postExpense(api, userID1, expense)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	// User 2 accepts the request
+	response = postFriend(api, userID2, userID1)
+	var got friendResponse
+	json.NewDecoder(response.Body).Decode(&got)
+	if response.Code != http.StatusOK || got.Status != "confirmed" {
+		t.Errorf("wanted %d confirmed, got %d %s", http.StatusOK, response.Code, got.Status)
+	}
+
+	response = postExpense(api, userID1, expense)
+	if response.Code != http.StatusCreated {
+		t.Errorf("wanted %d, got %d", http.StatusCreated, response.Code)
+	}
+}
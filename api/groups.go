@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/errkind"
+	"github.com/freewilll/splitter/ledger"
+)
+
+type createGroupRequest struct {
+	Name    string `json:"name"`
+	UserIDs []int  `json:"user_ids"` // The other members, the creator is always a member
+}
+
+type groupResponse struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	OwnerID int    `json:"owner_id"`
+	Members []int  `json:"members"`
+}
+
+type settleGroupResponse struct {
+	GroupID   int               `json:"group_id"`
+	Transfers []ledger.Transfer `json:"transfers"`
+}
+
+func newGroupResponse(g database.Group) groupResponse {
+	return groupResponse{ID: g.ID, Name: g.Name, OwnerID: g.OwnerID, Members: g.Members}
+}
+
+// postGroups creates a group of the authenticated user and other users, who
+// must be their friends unless expenses aren't limited to friends
+func (api *API) postGroups(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var g createGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	var errs validationErrors
+	g.Name = validateName(g.Name, &errs)
+	if g.Name == "" {
+		errs.add("name", "name is required")
+	}
+
+	friends := make(map[int]bool)
+	for _, f := range dbh.GetFriends(userID) {
+		friends[f.ID] = true
+	}
+	members := map[int]bool{userID: true}
+	for _, u := range g.UserIDs {
+		if members[u] {
+			errs.add("user_ids", "duplicate user in user list")
+		} else if *requireFriends && !friends[u] {
+			errs.add("user_ids", fmt.Sprintf("user %d is not a friend", u))
+		} else if !dbh.UserExists(u) {
+			errs.add("user_ids", fmt.Sprintf("user %d doesn't exist", u))
+		}
+		members[u] = true
+	}
+	if database.MaxParticipants > 0 && len(members) > database.MaxParticipants {
+		errs.add("user_ids", fmt.Sprintf("a group can have at most %d members", database.MaxParticipants))
+	}
+
+	if errs.write(w) {
+		return
+	}
+
+	groupID := dbh.CreateGroup(database.Group{Name: g.Name, OwnerID: userID, Members: g.UserIDs})
+	group, err := dbh.GetGroup(groupID)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("User %d created group %d with members %v", userID, groupID, group.Members)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, newGroupResponse(group))
+}
+
+// parseGroupPath parses a /group/{id}/{action} path, e.g. /group/1/settle
+func parseGroupPath(path string, action string) (int, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "group" || parts[2] != action {
+		return 0, false
+	}
+
+	groupID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, false
+	}
+	return groupID, true
+}
+
+// groupNetBalances returns the net balance of each member of a group towards the
+// other members, positive if they are owed money. Debts to users outside the
+// group are left out, so the balances add up to zero.
+func groupNetBalances(balances map[int]ledger.Balance, members []int) map[int]float64 {
+	inGroup := make(map[int]bool, len(members))
+	for _, u := range members {
+		inGroup[u] = true
+	}
+
+	net := make(map[int]float64, len(members))
+	for _, u := range members {
+		for _, d := range balances[u].Credit {
+			if inGroup[d.UserID] {
+				net[u] += d.Amount
+			}
+		}
+		for _, d := range balances[u].Debit {
+			if inGroup[d.UserID] {
+				net[u] -= d.Amount
+			}
+		}
+	}
+	return net
+}
+
+// getGroupSettle returns the fewest transfers that settle all debts between the
+// members of a group the authenticated user is a member of
+func (api *API) getGroupSettle(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	groupID, ok := parseGroupPath(r.URL.Path, "settle")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	group, err := dbh.GetGroup(groupID)
+	if errkind.Of(err) == errkind.NotFound || (err == nil && !isGroupMember(group, userID)) {
+		writeError(w, http.StatusNotFound, "group not found")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	balances := api.cache.GetBalances(api.db, group.Members)
+	transfers := ledger.SimplifyDebts(groupNetBalances(balances, group.Members))
+	writeResponse(w, r, settleGroupResponse{GroupID: groupID, Transfers: transfers})
+}
+
+// isGroupMember returns true if userID is a member of a group
+func isGroupMember(g database.Group, userID int) bool {
+	for _, u := range g.Members {
+		if u == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// callRoute calls an endpoint through the routes of the API with a cookie
+func callRoute(api *API, method string, path string, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, path, strings.NewReader(body))
+	request.AddCookie(cookie)
+	response := httptest.NewRecorder()
+	api.routes().ServeHTTP(response, request)
+	return response
+}
+
+func TestGroupSettle(t *testing.T) {
+	// The debts between four members of a group are settled with the fewest
+	// transfers, after which everyone is at zero. Debts to a user outside the
+	// group are left out.
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	userID4, _ := dbh.CreateUser("test4@getstream.io", "secret")
+	userID5, _ := dbh.CreateUser("test5@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3, userID4, userID5)
+
+	// Users 2, 3 and 4 owe user 1 10 each, users 1, 3 and 4 owe user 2 10 each
+	// and user 4 owes user 3 10: five debts between pairs of members
+	for _, e := range []struct {
+		OwnerID int
+		Amount  float64
+		Users   []userID
+	}{
+		{userID1, 40, []userID{{ID: userID2}, {ID: userID3}, {ID: userID4}}},
+		{userID2, 40, []userID{{ID: userID1}, {ID: userID3}, {ID: userID4}}},
+		{userID3, 20, []userID{{ID: userID4}}},
+		{userID5, 10, []userID{{ID: userID4}}},
+	} {
+		response := postExpense(api, e.OwnerID, createExpenseRequest{
+			Description: "Dinner",
+			Amount:      e.Amount,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       e.Users,
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+	}
+
+	cookie1 := signinCookie(t, api, "test1@getstream.io", "secret")
+	response := callRoute(api, http.MethodPost, "/groups", fmt.Sprintf(`{"name":"Trip","user_ids":[%d,%d,%d]}`, userID2, userID3, userID4), cookie1)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+	var group groupResponse
+	if err := json.NewDecoder(response.Body).Decode(&group); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	if len(group.Members) != 4 || group.OwnerID != userID1 {
+		t.Fatalf("unexpected group %+v", group)
+	}
+
+	response = callRoute(api, http.MethodGet, fmt.Sprintf("/group/%d/settle", group.ID), "", cookie1)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	var settle settleGroupResponse
+	if err := json.NewDecoder(response.Body).Decode(&settle); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+
+	// No two members' balances cancel out, so three transfers are the fewest
+	// possible, where paying every debt takes five
+	if len(settle.Transfers) != 3 {
+		t.Errorf("wanted 3 transfers, got %+v", settle.Transfers)
+	}
+
+	balances := map[int]float64{}
+	for _, u := range []int{userID1, userID2, userID3, userID4} {
+		balances[u] = getBalance(t, api, u).Balance
+	}
+	balances[userID4] += 5 // The debt to user 5, who isn't in the group
+	for _, transfer := range settle.Transfers {
+		balances[transfer.FromUserID] += transfer.Amount
+		balances[transfer.ToUserID] -= transfer.Amount
+	}
+	for u, balance := range balances {
+		if math.Abs(balance) > 1e-9 {
+			t.Errorf("wanted user %d settled, got %f after %+v", u, balance, settle.Transfers)
+		}
+	}
+
+	// The group is only visible to its members
+	cookie5 := signinCookie(t, api, "test5@getstream.io", "secret")
+	for _, test := range []struct {
+		Path   string
+		Cookie *http.Cookie
+		Wanted int
+	}{
+		{fmt.Sprintf("/group/%d/settle", group.ID), cookie5, http.StatusNotFound},
+		{"/group/42/settle", cookie1, http.StatusNotFound},
+		{fmt.Sprintf("/group/%d/unknown", group.ID), cookie1, http.StatusNotFound},
+	} {
+		if response := callRoute(api, http.MethodGet, test.Path, "", test.Cookie); response.Code != test.Wanted {
+			t.Errorf("%s: wanted %d, got %d", test.Path, test.Wanted, response.Code)
+		}
+	}
+}
+
+func TestPostGroupsInvalid(t *testing.T) {
+	// A group needs a name and its members must be friends of the creator
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	cookie := signinCookie(t, api, "test1@getstream.io", "secret")
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"name":"","user_ids":[%d]}`, userID2),
+		fmt.Sprintf(`{"name":"Trip","user_ids":[%d]}`, userID3),
+		fmt.Sprintf(`{"name":"Trip","user_ids":[%d,%d]}`, userID2, userID2),
+	} {
+		if response := callRoute(api, http.MethodPost, "/groups", body, cookie); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: wanted %d, got %d", body, http.StatusBadRequest, response.Code)
+		}
+	}
+}
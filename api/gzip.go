@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"net/http"
+	"strings"
+)
+
+// gzipMinSize is the minimum size of a response body before it's compressed.
+// Compressing small bodies costs more than it saves.
+var gzipMinSize = flag.Int("gzip-min-size", 1024, "minimum response size in bytes to gzip")
+
+// bufferedResponseWriter holds on to the status code and body of a response, so
+// that it can be decided how to send it once it's complete
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code to send later
+func (b *bufferedResponseWriter) WriteHeader(code int) {
+	b.status = code
+}
+
+// Write appends data to the body to send later
+func (b *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+// acceptsGzip returns true if the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(encoding, ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), "gzip") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if q := strings.TrimSpace(param); q == "q=0" || q == "q=0.0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponses compresses the responses of next with gzip if the client accepts
+// it and the body is at least gzipMinSize bytes
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		b := &bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(b, r)
+
+		if b.body.Len() < *gzipMinSize || w.Header().Get("Content-Encoding") != "" {
+			w.WriteHeader(b.status)
+			w.Write(b.body.Bytes())
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(b.status)
+
+		gz := gzip.NewWriter(w)
+		gz.Write(b.body.Bytes())
+		gz.Close()
+	})
+}
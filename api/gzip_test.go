@@ -0,0 +1,74 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipResponses(t *testing.T) {
+	// Large responses are compressed if the client accepts gzip, small ones and
+	// responses to other clients are left alone
+
+	large := strings.Repeat(`{"id":1,"email":"test1@getstream.io"}`, 100)
+	small := `{"id":1}`
+
+	tests := []struct {
+		AcceptEncoding string
+		Body           string
+		Gzipped        bool
+	}{
+		{"gzip", large, true},
+		{"deflate, gzip;q=0.8", large, true},
+		{"", large, false},
+		{"deflate", large, false},
+		{"gzip;q=0", large, false},
+		{"gzip", small, false},
+	}
+
+	for _, test := range tests {
+		handler := gzipResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, test.Body)
+		}))
+
+		request, _ := http.NewRequest(http.MethodGet, "/users", nil)
+		request.Header.Set("Accept-Encoding", test.AcceptEncoding)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if response.Code != http.StatusCreated {
+			t.Errorf("%q: wanted %d, got %d", test.AcceptEncoding, http.StatusCreated, response.Code)
+		}
+
+		gzipped := response.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != test.Gzipped {
+			t.Fatalf("%q: wanted gzipped %v, got %v", test.AcceptEncoding, test.Gzipped, gzipped)
+		}
+
+		body := response.Body.String()
+		if gzipped {
+			if response.Body.Len() >= len(test.Body) {
+				t.Errorf("%q: compressed body isn't smaller", test.AcceptEncoding)
+			}
+
+			gz, err := gzip.NewReader(response.Body)
+			if err != nil {
+				t.Fatalf("%q: unable to read gzipped body: %v", test.AcceptEncoding, err)
+			}
+			data, err := ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatalf("%q: unable to read gzipped body: %v", test.AcceptEncoding, err)
+			}
+			body = string(data)
+		}
+
+		if body != test.Body {
+			t.Errorf("%q: body mismatch", test.AcceptEncoding)
+		}
+	}
+}
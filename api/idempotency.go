@@ -0,0 +1,92 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"sync"
+	"time"
+)
+
+// idempotencyTTL is how long the result of a registration with an
+// Idempotency-Key header is remembered, so that a client retrying it gets the
+// original result instead of a conflict
+var idempotencyTTL = flag.Duration("idempotency-ttl", 24*time.Hour, "time the result of a registration with an Idempotency-Key header is remembered")
+
+// maxIdempotencyKeyLength is the maximum length of an Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// fingerprintKey is the key of the hashes of registrations. Since the hashes
+// include the password and are remembered for idempotencyTTL, a plain hash could
+// be brute forced. Like the results, the key only lives as long as the process.
+var fingerprintKey = newFingerprintKey()
+
+// idempotentResult is the result of a registration made with an idempotency key
+type idempotentResult struct {
+	fingerprint [sha256.Size]byte // Keyed hash of the request the key was first used for
+	response    userResponse
+	createdAt   time.Time
+}
+
+// idempotencyKeys remembers the results of registrations by idempotency key in
+// memory, so a retry only gets the original result from the same server.
+type idempotencyKeys struct {
+	registering  sync.Mutex // Serializes registrations with a key, so that concurrent retries can't both create the user
+	resultsMutex sync.Mutex // Protects results, held only while reading or writing them
+	results      map[string]idempotentResult
+}
+
+// newFingerprintKey returns a random key for hashing registrations
+func newFingerprintKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// requestFingerprint returns a keyed hash of a registration, to tell a retry
+// apart from a different request reusing the key
+func requestFingerprint(u createUserRequest) [sha256.Size]byte {
+	data, err := json.Marshal(u)
+	if err != nil {
+		panic(err)
+	}
+
+	var fingerprint [sha256.Size]byte
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write(data)
+	copy(fingerprint[:], mac.Sum(nil))
+	return fingerprint
+}
+
+// lookup returns the remembered result for key, if it hasn't expired by now
+func (k *idempotencyKeys) lookup(key string, now time.Time) (idempotentResult, bool) {
+	k.resultsMutex.Lock()
+	defer k.resultsMutex.Unlock()
+
+	result, ok := k.results[key]
+	if !ok || now.Sub(result.createdAt) > *idempotencyTTL {
+		return idempotentResult{}, false
+	}
+	return result, true
+}
+
+// remember stores the result for key at now and forgets expired results
+func (k *idempotencyKeys) remember(key string, result idempotentResult, now time.Time) {
+	k.resultsMutex.Lock()
+	defer k.resultsMutex.Unlock()
+
+	if k.results == nil {
+		k.results = make(map[string]idempotentResult)
+	}
+	for other, r := range k.results {
+		if now.Sub(r.createdAt) > *idempotencyTTL {
+			delete(k.results, other)
+		}
+	}
+	result.createdAt = now
+	k.results[key] = result
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// postUserWithKey calls the POST users API with an Idempotency-Key header
+func postUserWithKey(api *API, userID int, key string, u createUserRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(u)
+	request, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", key)
+	response := httptest.NewRecorder()
+	api.users(response, request, userID)
+	return response
+}
+
+func TestPostUsersIdempotencyKey(t *testing.T) {
+	// A retried registration with the same idempotency key gets the original
+	// result, while a genuine duplicate email is still a conflict
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	alice := createUserRequest{Email: "alice@getstream.io", Password: "secret", Name: "Alice"}
+	var original userResponse
+	for i := 0; i < 2; i++ {
+		response := postUserWithKey(api, userID1, "key-1", alice)
+		if response.Code != http.StatusOK {
+			t.Fatalf("attempt %d: wanted %d, got %d: %s", i, http.StatusOK, response.Code, response.Body.String())
+		}
+
+		var got userResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if i == 0 {
+			original = got
+		} else if got != original {
+			t.Errorf("wanted the original result %+v, got %+v", original, got)
+		}
+	}
+	if users := dbh.GetUsers(database.UsersQuery{}); len(users) != 2 {
+		t.Errorf("wanted 2 users, got %+v", users)
+	}
+
+	tests := []struct {
+		Key     string
+		Request createUserRequest
+		Wanted  int
+	}{
+		{"", alice, http.StatusConflict},      // Genuine duplicate without a key
+		{"key-2", alice, http.StatusConflict}, // Genuine duplicate from another request
+		{"key-1", createUserRequest{Email: "bob@getstream.io", Password: "secret"}, http.StatusUnprocessableEntity},
+		{string(make([]byte, maxIdempotencyKeyLength+1)), createUserRequest{Email: "bob@getstream.io", Password: "secret"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		response := postUserWithKey(api, userID1, test.Key, test.Request)
+		if response.Code != test.Wanted {
+			t.Errorf("%q %s: wanted %d, got %d: %s", test.Key, test.Request.Email, test.Wanted, response.Code, response.Body.String())
+		}
+	}
+
+	// An expired key no longer replays the result
+	oldIdempotencyTTL := *idempotencyTTL
+	defer func() { *idempotencyTTL = oldIdempotencyTTL }()
+	*idempotencyTTL = 0
+	if response := postUserWithKey(api, userID1, "key-1", alice); response.Code != http.StatusConflict {
+		t.Errorf("wanted %d after expiry, got %d", http.StatusConflict, response.Code)
+	}
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	// The same registration has the same fingerprint, which isn't a plain hash
+	// of the request and its password
+
+	u := createUserRequest{Email: "test1@getstream.io", Password: "secret"}
+	data, _ := json.Marshal(u)
+	if requestFingerprint(u) != requestFingerprint(u) {
+		t.Errorf("wanted the same fingerprint for the same registration")
+	}
+	if requestFingerprint(u) == sha256.Sum256(data) {
+		t.Errorf("wanted a keyed hash")
+	}
+
+	other := u
+	other.Password = "other"
+	if requestFingerprint(u) == requestFingerprint(other) {
+		t.Errorf("wanted a different fingerprint for another password")
+	}
+}
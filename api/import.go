@@ -0,0 +1,150 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/freewilll/splitter/errkind"
+)
+
+// maxImportUsers is the maximum number of users in a single import and
+// importInterval the minimum time between two imports by the same administrator
+var maxImportUsers = flag.Int("import-max-users", 100, "maximum number of users in a single import")
+var importInterval = flag.Duration("import-interval", time.Minute, "minimum time between two user imports by the same administrator")
+
+// temporaryPasswordBytes is the number of random bytes in a temporary password
+const temporaryPasswordBytes = 12
+
+// Statuses of the users in an import
+const (
+	importCreated = "created"
+	importExists  = "exists"
+	importInvalid = "invalid"
+)
+
+type importUsersRequest struct {
+	Users []struct {
+		Email string `json:"email"`
+	} `json:"users"`
+}
+
+// importedUserResponse is the result of importing one user. The temporary
+// password is only set if the user was created.
+type importedUserResponse struct {
+	Email             string `json:"email"`
+	Status            string `json:"status"` // created, exists or invalid
+	ID                int    `json:"id,omitempty"`
+	TemporaryPassword string `json:"temporary_password,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+type importUsersResponse struct {
+	Users []importedUserResponse `json:"users"`
+}
+
+// importLimiter remembers when administrators last imported users
+type importLimiter struct {
+	mutex sync.Mutex
+	last  map[int]time.Time
+}
+
+// wait returns how long userID has to wait before importing again at now. If
+// they don't have to wait, the import is recorded.
+func (l *importLimiter) wait(userID int, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.last == nil {
+		l.last = make(map[int]time.Time)
+	}
+	if wait := l.last[userID].Add(*importInterval).Sub(now); wait > 0 {
+		return wait
+	}
+	l.last[userID] = now
+	return 0
+}
+
+// temporaryPassword returns a random password for an imported user
+func temporaryPassword() string {
+	b := make([]byte, temporaryPasswordBytes)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// postImportUsers creates users with temporary passwords for a list of emails.
+// Each user is created on its own, so that an email that is invalid or already
+// taken doesn't stop the others from being imported. The result of every email
+// is returned, in the order of the request.
+func (api *API) postImportUsers(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var req importUsersRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	var errs validationErrors
+	if len(req.Users) == 0 {
+		errs.add("users", "users must not be empty")
+	} else if len(req.Users) > *maxImportUsers {
+		errs.add("users", fmt.Sprintf("at most %d users can be imported at once", *maxImportUsers))
+	}
+	if errs.write(w) {
+		return
+	}
+
+	if wait := api.imports.wait(userID, api.now()); wait > 0 {
+		log.Printf("User %d imported users less than %s ago", userID, *importInterval)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("users can be imported once every %s", *importInterval))
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	blocklist := mustLoadEmailBlocklist()
+	response := importUsersResponse{Users: make([]importedUserResponse, len(req.Users))}
+	for i, u := range req.Users {
+		result := importedUserResponse{Email: normalizeEmail(u.Email)}
+		var errs validationErrors
+		validateEmail(result.Email, blocklist, &errs)
+		if len(errs) > 0 {
+			result.Status, result.Error = importInvalid, errs[0].Message
+			response.Users[i] = result
+			continue
+		}
+
+		password := temporaryPassword()
+		id, err := dbh.CreateUser(result.Email, password)
+		switch {
+		case err == nil:
+			result.Status, result.ID, result.TemporaryPassword = importCreated, id, password
+		case errkind.Of(err) == errkind.Duplicate:
+			result.Status = importExists
+		default:
+			panic(err)
+		}
+		response.Users[i] = result
+	}
+
+	log.Printf("User %d imported %d users", userID, len(req.Users))
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func postImportUsers(api *API, userID int, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodPost, "/admin/users/import", bytes.NewReader([]byte(body)))
+	response := httptest.NewRecorder()
+	api.requireAdmin(api.postImportUsers)(response, request, userID)
+	return response
+}
+
+func TestImportUsers(t *testing.T) {
+	// New users are created with a temporary password, existing and invalid
+	// emails are reported without stopping the import
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	api.now = func() time.Time { return now }
+
+	dbh := db.Connect()
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	response := postImportUsers(api, adminID, `{"users":[{"email":"new1@getstream.io"},{"email":"test1@getstream.io"},{"email":"not an email"},{"email":"new2@getstream.io"},{"email":"new1@getstream.io"},{"email":" test1@GETSTREAM.IO"}]}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+
+	var got importUsersResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	wanted := []string{importCreated, importExists, importInvalid, importCreated, importExists, importExists}
+	if len(got.Users) != len(wanted) {
+		t.Fatalf("wanted %d results, got %+v", len(wanted), got.Users)
+	}
+	for i, status := range wanted {
+		if got.Users[i].Status != status {
+			t.Errorf("%d: wanted %s, got %+v", i, status, got.Users[i])
+		}
+		if (status == importCreated) != (got.Users[i].TemporaryPassword != "") {
+			t.Errorf("%d: wanted a temporary password only for a created user, got %+v", i, got.Users[i])
+		}
+	}
+
+	// The temporary passwords work and the existing user keeps theirs
+	for _, i := range []int{0, 3} {
+		if id, err := dbh.AuthenticateUser(got.Users[i].Email, got.Users[i].TemporaryPassword); err != nil || id != got.Users[i].ID {
+			t.Errorf("%s: wanted to sign in as %d, got %d, %v", got.Users[i].Email, got.Users[i].ID, id, err)
+		}
+	}
+	if got.Users[0].TemporaryPassword == got.Users[3].TemporaryPassword {
+		t.Errorf("wanted different temporary passwords")
+	}
+	if id, err := dbh.AuthenticateUser("test1@getstream.io", "secret"); err != nil || id != userID1 {
+		t.Errorf("wanted the existing user to keep their password, got %d, %v", id, err)
+	}
+
+	// Imports are rate limited per administrator
+	response = postImportUsers(api, adminID, `{"users":[{"email":"new3@getstream.io"}]}`)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") != "60" {
+		t.Errorf("wanted %d with Retry-After 60, got %d, %q", http.StatusTooManyRequests, response.Code, response.Header().Get("Retry-After"))
+	}
+	now = now.Add(*importInterval)
+	if response := postImportUsers(api, adminID, `{"users":[{"email":"new3@getstream.io"}]}`); response.Code != http.StatusOK {
+		t.Errorf("wanted %d after the interval, got %d", http.StatusOK, response.Code)
+	}
+
+	// Imports are limited in size and only for administrators
+	now = now.Add(*importInterval)
+	if response := postImportUsers(api, adminID, `{"users":[]}`); response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d without users, got %d", http.StatusBadRequest, response.Code)
+	}
+	if response := postImportUsers(api, userID1, `{"users":[{"email":"new4@getstream.io"}]}`); response.Code != http.StatusForbidden {
+		t.Errorf("wanted %d for a user who isn't an administrator, got %d", http.StatusForbidden, response.Code)
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+type leaderboardEntry struct {
+	UserID int     `json:"user_id"`
+	Email  string  `json:"email"`
+	Total  float64 `json:"total"`
+	Count  int     `json:"count"`
+}
+
+type leaderboardResponse struct {
+	Leaderboard []leaderboardEntry `json:"leaderboard"`
+}
+
+// parseTimeParam parses an optional RFC3339 query parameter, returning def if
+// it's absent
+func parseTimeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// getLeaderboard returns the users ranked by the total amount they paid for expenses
+// incurred between the optional from and to query parameters. The ranking is
+// highest first, unless order=asc is given.
+func (api *API) getLeaderboard(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	var errs validationErrors
+	from, err := parseTimeParam(r, "from", time.Time{})
+	if err != nil {
+		errs.add("from", "unable to parse from")
+	}
+
+	to, err := parseTimeParam(r, "to", time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		errs.add("to", "unable to parse to")
+	}
+
+	order := r.URL.Query().Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		errs.add("order", "order must be asc or desc")
+	}
+
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	totals := dbh.GetPayerTotals(from, to)
+
+	// Resolve the emails of the payers
+	ids := make([]int, len(totals))
+	for i, t := range totals {
+		ids[i] = t.UserID
+	}
+	emails := make(map[int]string)
+	for _, u := range dbh.GetUsersByID(ids) {
+		emails[u.ID] = u.Email
+	}
+
+	leaderboard := leaderboardResponse{Leaderboard: make([]leaderboardEntry, len(totals))}
+	for i, t := range totals {
+		entry := leaderboardEntry{UserID: t.UserID, Email: emails[t.UserID], Total: t.Total, Count: t.Count}
+		if order == "asc" {
+			leaderboard.Leaderboard[len(totals)-i-1] = entry
+		} else {
+			leaderboard.Leaderboard[i] = entry
+		}
+	}
+
+	log.Printf("Leaderboard from %s to %s has %d entries", from, to, len(totals))
+	writeResponse(w, r, leaderboard)
+}
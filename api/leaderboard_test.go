@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestGetLeaderboard(t *testing.T) {
+	// Create expenses paid by three users and check the ranking and totals,
+	// with and without a date range
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	expenses := []struct {
+		UserID    int
+		Amount    float64
+		CreatedAt string
+	}{
+		{userID1, 10, "2021-01-01T12:00:00Z"},
+		{userID2, 25, "2021-01-02T12:00:00Z"},
+		{userID3, 5, "2021-01-03T12:00:00Z"},
+		{userID1, 20, "2021-02-01T12:00:00Z"},
+	}
+	for _, e := range expenses {
+		other := userID1
+		if e.UserID == userID1 {
+			other = userID2
+		}
+		response := postExpense(api, e.UserID, createExpenseRequest{
+			Description: "Food",
+			Amount:      e.Amount,
+			CreatedAt:   e.CreatedAt,
+			Users:       []userID{{ID: other}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+	}
+
+	tests := []struct {
+		URL    string
+		Wanted []leaderboardEntry
+	}{
+		{
+			"/leaderboard",
+			[]leaderboardEntry{
+				{userID1, "test1@getstream.io", 30, 2},
+				{userID2, "test2@getstream.io", 25, 1},
+				{userID3, "test3@getstream.io", 5, 1},
+			},
+		},
+		{
+			"/leaderboard?to=2021-01-31T00:00:00Z",
+			[]leaderboardEntry{
+				{userID2, "test2@getstream.io", 25, 1},
+				{userID1, "test1@getstream.io", 10, 1},
+				{userID3, "test3@getstream.io", 5, 1},
+			},
+		},
+		{
+			"/leaderboard?from=2021-01-02T00:00:00Z&order=asc",
+			[]leaderboardEntry{
+				{userID3, "test3@getstream.io", 5, 1},
+				{userID1, "test1@getstream.io", 20, 1},
+				{userID2, "test2@getstream.io", 25, 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodGet, test.URL, nil)
+		response := httptest.NewRecorder()
+		api.getLeaderboard(response, request, userID1)
+		var got leaderboardResponse
+		err := json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if !reflect.DeepEqual(test.Wanted, got.Leaderboard) {
+			t.Errorf("%s: wanted %v,got %v", test.URL, test.Wanted, got.Leaderboard)
+		}
+	}
+}
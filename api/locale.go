@@ -0,0 +1,117 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// localeFormat describes how amounts and dates are presented in a locale
+type localeFormat struct {
+	decimal     string // Decimal separator
+	group       string // Thousands separator
+	symbolAfter bool   // The currency symbol follows the amount, separated by a space
+	dateLayout  string // Layout of dates for time.Format
+}
+
+// localeFormats are the supported locales. A locale that isn't listed is
+// matched on its language, e.g. de-AT is formatted like de.
+var localeFormats = map[string]localeFormat{
+	"en":    {decimal: ".", group: ",", dateLayout: "2006-01-02"},
+	"en-US": {decimal: ".", group: ",", dateLayout: "01/02/2006"},
+	"en-GB": {decimal: ".", group: ",", dateLayout: "02/01/2006"},
+	"de":    {decimal: ",", group: ".", symbolAfter: true, dateLayout: "02.01.2006"},
+	"fr":    {decimal: ",", group: " ", symbolAfter: true, dateLayout: "02/01/2006"},
+	"nl":    {decimal: ",", group: ".", dateLayout: "02-01-2006"},
+	"es":    {decimal: ",", group: ".", symbolAfter: true, dateLayout: "02/01/2006"},
+	"it":    {decimal: ",", group: ".", symbolAfter: true, dateLayout: "02/01/2006"},
+	"ja":    {decimal: ".", group: ",", dateLayout: "2006/01/02"},
+}
+
+// currencySymbols are the symbols of common currencies, others are shown by
+// their code
+var currencySymbols = map[string]string{
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"USD": "$",
+}
+
+// findLocaleFormat returns the format of a locale or its language
+func findLocaleFormat(locale string) (localeFormat, bool) {
+	if f, exists := localeFormats[locale]; exists {
+		return f, true
+	}
+	language := strings.SplitN(locale, "-", 2)[0]
+	f, exists := localeFormats[strings.ToLower(language)]
+	return f, exists
+}
+
+// displayFormat returns the format for presenting amounts and dates to a user if
+// the display query parameter is true. The locale is the first supported one in
+// the Accept-Language header, or else the user's preferred locale.
+func displayFormat(r *http.Request, dbh database.Handle, userID int) (localeFormat, bool) {
+	if r.URL.Query().Get("display") != "true" {
+		return localeFormat{}, false
+	}
+
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		if f, exists := findLocaleFormat(tag); exists {
+			return f, true
+		}
+	}
+
+	if f, exists := findLocaleFormat(withDefaults(dbh.GetSettings(userID)).Locale); exists {
+		return f, true
+	}
+	return localeFormats["en"], true
+}
+
+// formatMoney formats an amount in a currency, e.g. €1,234.50 or 1.234,50 €
+func (f localeFormat) formatMoney(amount float64, currency string) string {
+	decimals := ledger.Decimals(currency)
+	digits := strconv.FormatFloat(math.Abs(ledger.RoundAmount(amount, currency)), 'f', decimals, 64)
+
+	whole, fraction := digits, ""
+	if decimals > 0 {
+		whole, fraction = digits[:len(digits)-decimals-1], digits[len(digits)-decimals:]
+	}
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(f.group)
+		}
+		grouped.WriteRune(digit)
+	}
+	number := grouped.String()
+	if fraction != "" {
+		number += f.decimal + fraction
+	}
+
+	symbol, exists := currencySymbols[currency]
+	if !exists {
+		symbol = currency
+	}
+
+	sign := ""
+	if ledger.RoundAmount(amount, currency) < 0 {
+		sign = "-"
+	}
+
+	if f.symbolAfter || !exists {
+		return sign + number + " " + symbol
+	}
+	return sign + symbol + number
+}
+
+// formatDate formats the date of a time
+func (f localeFormat) formatDate(t time.Time) string {
+	return t.Format(f.dateLayout)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestFormatMoney(t *testing.T) {
+	// Amounts are formatted with the separators and symbol placement of a locale
+
+	tests := []struct {
+		Locale   string
+		Amount   float64
+		Currency string
+		Wanted   string
+	}{
+		{"en-US", 14, "EUR", "€14.00"},
+		{"de-DE", 14, "EUR", "14,00\u00a0€"},
+		{"de-AT", 1234.5, "EUR", "1.234,50\u00a0€"},
+		{"en-GB", -1234567.891, "GBP", "-£1,234,567.89"},
+		{"fr-FR", 1234.5, "USD", "1\u202f234,50\u00a0$"},
+		{"ja", 1500, "JPY", "¥1,500"},
+		{"nl-NL", 12.5, "CHF", "12,50\u00a0CHF"},
+	}
+	for _, test := range tests {
+		f, _ := findLocaleFormat(test.Locale)
+		if got := f.formatMoney(test.Amount, test.Currency); got != test.Wanted {
+			t.Errorf("%s %f %s: wanted %q, got %q", test.Locale, test.Amount, test.Currency, test.Wanted, got)
+		}
+	}
+}
+
+func TestDisplayFormatting(t *testing.T) {
+	// With display=true, amounts and dates are formatted for the Accept-Language
+	// header or else the preferred locale, next to the raw fields
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	dbh.SetSettings(userID1, database.Settings{Locale: "en-GB"})
+
+	postExpense(api, userID1, createExpenseRequest{
+		Description: "Hotel",
+		Amount:      2469,
+		Currency:    "EUR",
+		CreatedAt:   "2021-02-03T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+
+	tests := []struct {
+		AcceptLanguage string
+		Amount         string
+		CreatedAt      string
+		Balance        string
+	}{
+		{"de-DE,de;q=0.9,en;q=0.8", "2.469,00\u00a0€", "03.02.2021", "1.234,50\u00a0€"},
+		{"xx, de", "2.469,00\u00a0€", "03.02.2021", "1.234,50\u00a0€"},
+		{"", "€2,469.00", "03/02/2021", "€1,234.50"},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodGet, "/expenses?display=true", nil)
+		request.Header.Set("Accept-Language", test.AcceptLanguage)
+		response := httptest.NewRecorder()
+		api.expenses(response, request, userID1)
+
+		var expenses []expenseResponse
+		if err := json.NewDecoder(response.Body).Decode(&expenses); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if len(expenses) != 1 || expenses[0].Amount != 2469 || expenses[0].Display == nil {
+			t.Fatalf("wanted the expense with its raw amount and display, got %+v", expenses)
+		}
+		if d := expenses[0].Display; d.Amount != test.Amount || d.CreatedAt != test.CreatedAt {
+			t.Errorf("%q: wanted %s on %s, got %+v", test.AcceptLanguage, test.Amount, test.CreatedAt, d)
+		}
+
+		request, _ = http.NewRequest(http.MethodGet, "/balance?display=true", nil)
+		request.Header.Set("Accept-Language", test.AcceptLanguage)
+		response = httptest.NewRecorder()
+		api.getBalance(response, request, userID1)
+
+		var balance balanceView
+		if err := json.NewDecoder(response.Body).Decode(&balance); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if balance.Balance != 1234.5 || balance.Display != test.Balance {
+			t.Errorf("%q: wanted 1234.5 displayed as %s, got %+v", test.AcceptLanguage, test.Balance, balance)
+		}
+	}
+
+	// Without display=true, nothing is formatted
+	for _, e := range getExpensesWithTag(t, api, userID1, "") {
+		if e.Display != nil {
+			t.Errorf("wanted no display, got %+v", e.Display)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+// maxPlaceLength is the maximum length of the name of a place in characters
+const maxPlaceLength = 200
+
+// expenseLocation is where an expense was incurred, given by coordinates, the
+// name of a place or both
+type expenseLocation struct {
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Place     string   `json:"place,omitempty"`
+}
+
+// parseLocation validates the location of a new expense and returns it, or nil
+// if there is none. Failures are added to errs.
+func parseLocation(l *expenseLocation, errs *validationErrors) *ledger.Location {
+	if l == nil {
+		return nil
+	}
+
+	place := strings.Join(strings.Fields(l.Place), " ")
+	if utf8.RuneCountInString(place) > maxPlaceLength {
+		errs.add("location", fmt.Sprintf("place must be at most %d characters", maxPlaceLength))
+	}
+
+	if (l.Latitude == nil) != (l.Longitude == nil) {
+		errs.add("location", "latitude and longitude must be given together")
+	} else if l.Latitude == nil && place == "" {
+		errs.add("location", "location must have coordinates or a place")
+	}
+	if l.Latitude != nil && (*l.Latitude < -90 || *l.Latitude > 90) {
+		errs.add("location", "latitude must be between -90 and 90")
+	}
+	if l.Longitude != nil && (*l.Longitude < -180 || *l.Longitude > 180) {
+		errs.add("location", "longitude must be between -180 and 180")
+	}
+
+	return &ledger.Location{Latitude: l.Latitude, Longitude: l.Longitude, Place: place}
+}
+
+// newExpenseLocation converts the location of an expense into its JSON
+// representation, nil if it has none
+func newExpenseLocation(l *ledger.Location) *expenseLocation {
+	if l == nil {
+		return nil
+	}
+	return &expenseLocation{Latitude: l.Latitude, Longitude: l.Longitude, Place: l.Place}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestPostExpensesLocation(t *testing.T) {
+	// Valid locations are stored and returned with the expense, out of range
+	// coordinates are rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	tests := []struct {
+		Location *expenseLocation
+		Want     *expenseLocation
+		Code     int
+	}{
+		{nil, nil, http.StatusCreated},
+		{&expenseLocation{Latitude: float(52.37), Longitude: float(4.89)}, &expenseLocation{Latitude: float(52.37), Longitude: float(4.89)}, http.StatusCreated},
+		{&expenseLocation{Latitude: float(-90), Longitude: float(180), Place: "  South   Pole "}, &expenseLocation{Latitude: float(-90), Longitude: float(180), Place: "South Pole"}, http.StatusCreated},
+		{&expenseLocation{Place: "Café de Paris"}, &expenseLocation{Place: "Café de Paris"}, http.StatusCreated},
+		{&expenseLocation{Latitude: float(90.5), Longitude: float(0)}, nil, http.StatusBadRequest},
+		{&expenseLocation{Latitude: float(0), Longitude: float(-180.5)}, nil, http.StatusBadRequest},
+		{&expenseLocation{Latitude: float(10)}, nil, http.StatusBadRequest},
+		{&expenseLocation{Place: " "}, nil, http.StatusBadRequest},
+	}
+
+	for i, test := range tests {
+		description := fmt.Sprintf("Food %d", i)
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: description,
+			Amount:      10,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: userID2}},
+			Location:    test.Location,
+		})
+		if response.Code != test.Code {
+			t.Errorf("%d: wanted %d, got %d: %s", i, test.Code, response.Code, response.Body.String())
+			continue
+		}
+		if test.Code != http.StatusCreated {
+			continue
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/expenses", nil)
+		response = httptest.NewRecorder()
+		api.expenses(response, request, userID1)
+
+		var expenses []expenseResponse
+		if err := json.NewDecoder(response.Body).Decode(&expenses); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		found := false
+		for _, e := range expenses {
+			if e.Description != description {
+				continue
+			}
+			found = true
+			if !reflect.DeepEqual(e.Location, test.Want) {
+				t.Errorf("%d: wanted location %+v, got %+v", i, test.Want, e.Location)
+			}
+		}
+		if !found {
+			t.Errorf("%d: expense %q not found", i, description)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import "sync"
+
+// keyedMutex is a set of mutexes, one per key, e.g. a user id. Mutexes are
+// created on demand and removed once nobody holds or waits for them.
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[int]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	users int // Number of goroutines holding or waiting for the lock
+}
+
+// lock locks the mutex of key and returns a function unlocking it
+func (k *keyedMutex) lock(key int) func() {
+	k.mutex.Lock()
+	if k.locks == nil {
+		k.locks = make(map[int]*keyedLock)
+	}
+	l, exists := k.locks[key]
+	if !exists {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.users++
+	k.mutex.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.mutex.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(k.locks, key)
+		}
+		k.mutex.Unlock()
+	}
+}
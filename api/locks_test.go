@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// slowCache delays the first balance written to it, signalling on started when
+// the write begins
+type slowCache struct {
+	cache.Cache
+	writes  int32
+	started chan bool
+}
+
+func (c *slowCache) SetBalance(balance ledger.Balance, userID int, ttl time.Duration) {
+	if atomic.AddInt32(&c.writes, 1) == 1 {
+		c.started <- true
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Cache.SetBalance(balance, userID, ttl)
+}
+
+func TestConcurrentBalanceUpdates(t *testing.T) {
+	// While the balance after the first expense is slowly being written to the
+	// cache, a second expense is created. The cached balance includes both.
+
+	db := database.NewInMemoryDatabase()
+	cache := &slowCache{Cache: cache.NewInMemoryCache(), started: make(chan bool)}
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	postExpenseAsync := func(description string, amount float64) <-chan int {
+		done := make(chan int, 1)
+		go func() {
+			response := postExpense(api, userID1, createExpenseRequest{
+				Description: description,
+				Amount:      amount,
+				CreatedAt:   "2021-01-01T15:04:05Z",
+				Users:       []userID{{ID: userID2}},
+			})
+			done <- response.Code
+		}()
+		return done
+	}
+
+	first := postExpenseAsync("Lunch", 10)
+	<-cache.started
+	second := postExpenseAsync("Dinner", 20)
+
+	for _, done := range []<-chan int{first, second} {
+		if code := <-done; code != http.StatusCreated {
+			t.Fatalf("wanted %d, got %d", http.StatusCreated, code)
+		}
+	}
+
+	if balance := cache.GetBalance(db, userID1); balance.Balance != 15 {
+		t.Errorf("wanted a cached balance of 15, got %+v", balance)
+	}
+}
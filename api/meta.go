@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+// splitMethods are the ways an expense can be split
+var splitMethods = []string{"equal", "percentage", "shares"}
+
+type paginationResponse struct {
+	DefaultPageSize int `json:"default_page_size"`
+	MaxPageSize     int `json:"max_page_size"`
+}
+
+type currencyResponse struct {
+	Code     string `json:"code"`
+	Decimals int    `json:"decimals"`
+}
+
+type metaResponse struct {
+	Pagination      paginationResponse `json:"pagination"`
+	DefaultCurrency string             `json:"default_currency"`
+	Currencies      []currencyResponse `json:"currencies"`
+	SplitMethods    []string           `json:"split_methods"`
+}
+
+// getMeta returns the server's limits and supported features, so that clients
+// can adapt to them. No authentication is required.
+func (api *API) getMeta(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	response := metaResponse{
+		Pagination:      paginationResponse{DefaultPageSize: pageSize(), MaxPageSize: *maxUsers},
+		DefaultCurrency: *defaultCurrency,
+		SplitMethods:    splitMethods,
+	}
+	for _, currency := range ledger.Currencies() {
+		response.Currencies = append(response.Currencies, currencyResponse{Code: currency, Decimals: ledger.Decimals(currency)})
+	}
+
+	writeResponse(w, r, response)
+}
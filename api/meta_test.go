@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestGetMeta(t *testing.T) {
+	// The meta endpoint reports the configured limits, which the user listing
+	// adheres to
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	oldDefaultPageSize, oldMaxUsers := *defaultPageSize, *maxUsers
+	defer func() { *defaultPageSize, *maxUsers = oldDefaultPageSize, oldMaxUsers }()
+	*defaultPageSize, *maxUsers = 2, 3
+
+	request, _ := http.NewRequest(http.MethodGet, "/meta", nil)
+	response := httptest.NewRecorder()
+	api.getMeta(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	var got metaResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+
+	wanted := paginationResponse{DefaultPageSize: 2, MaxPageSize: 3}
+	if got.Pagination != wanted {
+		t.Errorf("wanted %+v, got %+v", wanted, got.Pagination)
+	}
+	if got.DefaultCurrency != *defaultCurrency {
+		t.Errorf("wanted default currency %s, got %s", *defaultCurrency, got.DefaultCurrency)
+	}
+	if !reflect.DeepEqual(got.SplitMethods, []string{"equal", "percentage", "shares"}) {
+		t.Errorf("unexpected split methods %v", got.SplitMethods)
+	}
+	currencies := make(map[string]int)
+	for _, c := range got.Currencies {
+		currencies[c.Code] = c.Decimals
+	}
+	if currencies["EUR"] != 2 || currencies["JPY"] != 0 || currencies["KWD"] != 3 {
+		t.Errorf("unexpected currencies %+v", got.Currencies)
+	}
+
+	// Users are listed in pages of the default size
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	dbh.CreateUser("test2@getstream.io", "secret")
+	dbh.CreateUser("test3@getstream.io", "secret")
+
+	var users usersResponse
+	response = getUsersWithQuery(api, userID1, "")
+	if err := json.NewDecoder(response.Body).Decode(&users); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	if len(users.Users) != 2 {
+		t.Errorf("wanted 2 users, got %d", len(users.Users))
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// divergenceSampleInterval is how often cached balances are compared with the
+// database and divergenceSampleSize how many random users are compared each time
+var divergenceSampleInterval = flag.Duration("divergence-sample-interval", time.Minute, "interval between comparing cached balances with the database, 0 to disable")
+var divergenceSampleSize = flag.Int("divergence-sample-size", 10, "number of random users to compare cached balances for")
+
+// metrics holds the counters exposed on the metrics endpoint. They are updated
+// atomically.
+type metrics struct {
+	divergenceChecks    int64 // Number of cached balances compared with the database
+	divergences         int64 // Number of cached balances that differed from the database
+	lastDivergenceCount int64 // Number of divergences found in the last sample
+}
+
+// balancesEqual returns true if two balances have the same amounts, ignoring
+// floating point noise and the order of the debts
+func balancesEqual(a ledger.Balance, b ledger.Balance) bool {
+	if math.Abs(a.Balance-b.Balance) > ledger.Epsilon {
+		return false
+	}
+
+	amounts := func(balance ledger.Balance) map[int]float64 {
+		m := make(map[int]float64)
+		for _, d := range balance.Debit {
+			m[d.UserID] += d.Amount
+		}
+		for _, d := range balance.Credit {
+			m[d.UserID] -= d.Amount
+		}
+		return m
+	}
+
+	am, bm := amounts(a), amounts(b)
+	for userID, amount := range am {
+		if math.Abs(amount-bm[userID]) > ledger.Epsilon {
+			return false
+		}
+	}
+	for userID, amount := range bm {
+		if math.Abs(amount-am[userID]) > ledger.Epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleDivergence compares the cached balances of up to sampleSize random users
+// with their balances calculated from the database and returns how many differ
+func (api *API) sampleDivergence(r *rand.Rand, sampleSize int) int {
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	users := dbh.GetUsers(database.UsersQuery{})
+	r.Shuffle(len(users), func(i, j int) { users[i], users[j] = users[j], users[i] })
+	if len(users) > sampleSize {
+		users = users[:sampleSize]
+	}
+
+	divergences := 0
+	for _, u := range users {
+		cached := api.cache.GetBalance(api.db, u.ID)
+		actual := ledger.CalculateBalance(dbh.GetExpenses(u.ID), dbh.GetSettlements(u.ID), u.ID)
+		if !balancesEqual(cached, actual) {
+			log.Printf("Cached balance of user %d %+v differs from %+v", u.ID, cached, actual)
+			divergences++
+		}
+	}
+
+	atomic.AddInt64(&api.metrics.divergenceChecks, int64(len(users)))
+	atomic.AddInt64(&api.metrics.divergences, int64(divergences))
+	atomic.StoreInt64(&api.metrics.lastDivergenceCount, int64(divergences))
+	return divergences
+}
+
+// trySampleDivergence samples the divergence of cached balances like
+// sampleDivergence. A panic, e.g. due to a failing cache or database, is logged
+// instead of crashing the server, and false is returned.
+func (api *API) trySampleDivergence(r *rand.Rand, sampleSize int) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Unable to sample the divergence of cached balances: %v", err)
+			ok = false
+		}
+	}()
+
+	api.sampleDivergence(r, sampleSize)
+	return true
+}
+
+// sampleDivergenceForever samples the divergence of cached balances every
+// divergenceSampleInterval
+func (api *API) sampleDivergenceForever() {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for range time.Tick(*divergenceSampleInterval) {
+		api.trySampleDivergence(r, *divergenceSampleSize)
+	}
+}
+
+// getMetrics writes the metrics in the prometheus text format
+func (api *API) getMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP splitter_balance_divergence_checks_total Number of cached balances compared with the database.\n")
+	fmt.Fprintf(w, "# TYPE splitter_balance_divergence_checks_total counter\n")
+	fmt.Fprintf(w, "splitter_balance_divergence_checks_total %d\n", atomic.LoadInt64(&api.metrics.divergenceChecks))
+	fmt.Fprintf(w, "# HELP splitter_balance_divergences_total Number of cached balances that differed from the database.\n")
+	fmt.Fprintf(w, "# TYPE splitter_balance_divergences_total counter\n")
+	fmt.Fprintf(w, "splitter_balance_divergences_total %d\n", atomic.LoadInt64(&api.metrics.divergences))
+	fmt.Fprintf(w, "# HELP splitter_balance_divergences Number of cached balances that differed from the database in the last sample.\n")
+	fmt.Fprintf(w, "# TYPE splitter_balance_divergences gauge\n")
+	fmt.Fprintf(w, "splitter_balance_divergences %d\n", atomic.LoadInt64(&api.metrics.lastDivergenceCount))
+}
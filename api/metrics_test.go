@@ -0,0 +1,93 @@
+package api
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+	"github.com/freewilll/splitter/testutil"
+)
+
+func TestSampleDivergence(t *testing.T) {
+	// Make the cached balance of a user stale and ensure the divergence is counted
+	// and exposed on the metrics endpoint
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	if got := api.sampleDivergence(r, 10); got != 0 {
+		t.Fatalf("wanted no divergences, got %d", got)
+	}
+
+	cache.SetBalance(ledger.Balance{Balance: 1, Debit: []ledger.Debt{}, Credit: []ledger.Debt{}}, userID2, 0)
+	if got := api.sampleDivergence(r, 10); got != 1 {
+		t.Fatalf("wanted 1 divergence, got %d", got)
+	}
+	if api.metrics.divergences != 1 {
+		t.Fatalf("wanted divergence counter 1, got %d", api.metrics.divergences)
+	}
+	if api.metrics.divergenceChecks != 4 {
+		t.Fatalf("wanted 4 checks, got %d", api.metrics.divergenceChecks)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	recorder := httptest.NewRecorder()
+	api.getMetrics(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), "splitter_balance_divergences_total 1\n") {
+		t.Fatalf("divergence counter missing from metrics: %s", recorder.Body.String())
+	}
+}
+
+func TestSampleDivergenceCacheFailure(t *testing.T) {
+	// A failing cache doesn't crash the sampler, which carries on once the cache
+	// has recovered
+
+	db := database.NewInMemoryDatabase()
+	cache := testutil.NewMockCache(cache.NewInMemoryCache())
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	dbh.CreateUser("test1@getstream.io", "secret")
+
+	r := rand.New(rand.NewSource(1))
+	cache.Fail("GetBalance", errInjected)
+	if api.trySampleDivergence(r, 10) {
+		t.Fatalf("wanted the sample to fail")
+	}
+	if api.metrics.divergenceChecks != 0 {
+		t.Fatalf("wanted no checks, got %d", api.metrics.divergenceChecks)
+	}
+
+	cache.Reset("GetBalance")
+	if !api.trySampleDivergence(r, 10) {
+		t.Fatalf("wanted the sample to succeed")
+	}
+	if api.metrics.divergenceChecks != 1 {
+		t.Fatalf("wanted 1 check, got %d", api.metrics.divergenceChecks)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+)
+
+type netBalanceResponse struct {
+	Balance interface{} `json:"balance"`
+}
+
+// getNetBalance returns only the authenticated user's net balance, for clients
+// that don't need the debts. It's served from the cache without looking up the
+// names of the other users. The amounts query parameter works as for the full
+// balance.
+func (api *API) getNetBalance(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	format, ok := parseAmountFormat(r.URL.Query().Get("amounts"))
+	if !ok {
+		var errs validationErrors
+		errs.add("amounts", "amounts must be decimal or cents")
+		errs.write(w)
+		return
+	}
+
+	balance := api.cache.GetBalance(api.db, userID)
+	writeResponse(w, r, netBalanceResponse{Balance: formatAmount(balance.Balance, *defaultCurrency, format)})
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestGetNetBalance(t *testing.T) {
+	// The net balance is the same as the full balance's, without the debts
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      36,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}, {ID: userID3}},
+	})
+	postExpense(api, userID2, createExpenseRequest{
+		Description: "Taxi",
+		Amount:      10,
+		CreatedAt:   "2021-01-01T18:04:05Z",
+		Users:       []userID{{ID: userID1}},
+	})
+
+	for _, userID := range []int{userID1, userID2, userID3} {
+		request, _ := http.NewRequest(http.MethodGet, "/balance/net", nil)
+		response := httptest.NewRecorder()
+		api.getNetBalance(response, request, userID)
+		if response.Code != http.StatusOK {
+			t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+		}
+
+		var got map[string]interface{}
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if len(got) != 1 {
+			t.Errorf("wanted only the balance, got %v", got)
+		}
+		if wanted := getBalance(t, api, userID).Balance; got["balance"] != wanted {
+			t.Errorf("user %d: wanted %f, got %v", userID, wanted, got["balance"])
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+)
+
+// owedUserResponse is a user who owes the authenticated user money
+type owedUserResponse struct {
+	UserID int     `json:"user_id"`
+	Email  string  `json:"email"`
+	Name   string  `json:"name,omitempty"`
+	Amount float64 `json:"amount"`
+}
+
+type owedToMeResponse struct {
+	Users []owedUserResponse `json:"users"`
+	Total float64            `json:"total"` // Amount owed by all users together
+}
+
+// getOwedToMe returns the users who owe the authenticated user money, the
+// largest debt first
+func (api *API) getOwedToMe(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	balance := api.cache.GetBalance(api.db, userID)
+
+	amounts := make(map[int]float64, len(balance.Credit))
+	ids := make([]int, 0, len(balance.Credit))
+	for _, d := range balance.Credit {
+		amounts[d.UserID] = d.Amount
+		ids = append(ids, d.UserID)
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	response := owedToMeResponse{Users: make([]owedUserResponse, 0, len(ids))}
+	for _, u := range dbh.GetUsersByID(ids) {
+		response.Users = append(response.Users, owedUserResponse{UserID: u.ID, Email: u.Email, Name: u.Name, Amount: amounts[u.ID]})
+		response.Total += amounts[u.ID]
+	}
+	sort.SliceStable(response.Users, func(i, j int) bool {
+		return response.Users[i].Amount > response.Users[j].Amount
+	})
+
+	writeResponse(w, r, response)
+}
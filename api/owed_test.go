@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestGetOwedToMe(t *testing.T) {
+	// Users 3 and 4 owe user 1, largest debt first, while user 1 owes user 2
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	userID4, _ := dbh.CreateUser("test4@getstream.io", "secret")
+	dbh.SetName(userID4, "Alice")
+	makeFriends(dbh, userID1, userID2, userID3, userID4)
+
+	expenses := []struct {
+		PayerID int
+		UserID  int
+		Amount  float64
+	}{
+		{userID2, userID1, 20},
+		{userID1, userID3, 30},
+		{userID1, userID4, 10},
+		{userID1, userID4, 60},
+	}
+	for i, e := range expenses {
+		response := postExpense(api, e.PayerID, createExpenseRequest{
+			Description: string(rune('A' + i)),
+			Amount:      e.Amount,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: e.UserID}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/owed-to-me", nil)
+	response := httptest.NewRecorder()
+	api.getOwedToMe(response, request, userID1)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	var got owedToMeResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	wanted := owedToMeResponse{
+		Users: []owedUserResponse{
+			{UserID: userID4, Email: "test4@getstream.io", Name: "Alice", Amount: 35},
+			{UserID: userID3, Email: "test3@getstream.io", Amount: 15},
+		},
+		Total: 50,
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("wanted %+v, got %+v", wanted, got)
+	}
+
+	// User 3 only owes money, nobody owes them
+	response = httptest.NewRecorder()
+	api.getOwedToMe(response, request, userID3)
+	got = owedToMeResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Users) != 0 || got.Total != 0 {
+		t.Errorf("wanted nobody owing user 3, got %+v", got)
+	}
+}
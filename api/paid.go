@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+type paidByMeResponse struct {
+	Expenses []expenseResponse  `json:"expenses"`
+	Totals   map[string]float64 `json:"totals"` // Total amount paid per currency
+}
+
+// getExpensesPaidByMe returns the expenses the authenticated user paid for,
+// whoever recorded them, with the totals per currency. Expenses they only share
+// are left out.
+func (api *API) getExpensesPaidByMe(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expenses := dbh.GetExpensesPaidBy(userID)
+	response := paidByMeResponse{
+		Expenses: make([]expenseResponse, len(expenses)),
+		Totals:   make(map[string]float64),
+	}
+	for i, e := range expenses {
+		response.Expenses[i] = newExpenseResponse(e)
+		response.Totals[e.Currency] = ledger.RoundAmount(response.Totals[e.Currency]+e.Amount, e.Currency)
+	}
+
+	writeResponse(w, r, response)
+}
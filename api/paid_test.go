@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func getExpensesPaidByMe(t *testing.T, api *API, userID int) paidByMeResponse {
+	request, _ := http.NewRequest(http.MethodGet, "/expenses/paid-by-me", nil)
+	response := httptest.NewRecorder()
+	api.getExpensesPaidByMe(response, request, userID)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	var got paidByMeResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	return got
+}
+
+func TestGetExpensesPaidByMe(t *testing.T) {
+	// Only the expenses a user paid for are listed and totalled, not those they
+	// merely share or recorded on behalf of someone else
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	expenses := []struct {
+		UserID  int
+		Request createExpenseRequest
+	}{
+		{userID1, createExpenseRequest{Description: "Dinner", Amount: 42, Users: []userID{{ID: userID2}}}},
+		{userID1, createExpenseRequest{Description: "Lunch", Amount: 10.5, Users: []userID{{ID: userID3}}}},
+		{userID1, createExpenseRequest{Description: "Souvenirs", Amount: 10, Currency: "USD", Users: []userID{{ID: userID3}}}},
+		{userID2, createExpenseRequest{Description: "Taxi", Amount: 20, Users: []userID{{ID: userID1}}}},
+		{userID1, createExpenseRequest{Description: "Tickets", Amount: 30, Users: []userID{{ID: userID2}}, PayerID: userID2}},
+	}
+	for _, e := range expenses {
+		e.Request.CreatedAt = "2021-01-01T15:04:05Z"
+		if response := postExpense(api, e.UserID, e.Request); response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+	}
+
+	tests := []struct {
+		UserID       int
+		Descriptions []string
+		Totals       map[string]float64
+	}{
+		{userID1, []string{"Dinner", "Lunch", "Souvenirs"}, map[string]float64{"EUR": 52.5, "USD": 10}},
+		{userID2, []string{"Taxi", "Tickets"}, map[string]float64{"EUR": 50}},
+		{userID3, []string{}, map[string]float64{}},
+	}
+	for _, test := range tests {
+		got := getExpensesPaidByMe(t, api, test.UserID)
+		if d := descriptions(got.Expenses); !reflect.DeepEqual(d, test.Descriptions) {
+			t.Errorf("user %d: wanted %v, got %v", test.UserID, test.Descriptions, d)
+		}
+		if !reflect.DeepEqual(got.Totals, test.Totals) {
+			t.Errorf("user %d: wanted totals %v, got %v", test.UserID, test.Totals, got.Totals)
+		}
+	}
+}
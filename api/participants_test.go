@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestPostExpensesUsersByEmail(t *testing.T) {
+	// Users can be identified by email instead of, or mixed with, their ids.
+	// Unknown emails are rejected.
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+	makeFriends(dbh, userID1, userID3)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      30,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{Email: "test2@getstream.io"}, {Email: "test3@getstream.io"}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "Drinks",
+		Amount:      30,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}, {Email: "test3@getstream.io"}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+
+	for _, e := range dbh.GetExpenses(userID1) {
+		if want := []int{userID1, userID2, userID3}; !reflect.DeepEqual(e.Users, want) {
+			t.Errorf("%s: wanted users %v, got %v", e.Description, want, e.Users)
+		}
+	}
+
+	// Unknown emails are listed in the error
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "Taxi",
+		Amount:      30,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{Email: "test2@getstream.io"}, {Email: "nobody@getstream.io"}},
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+	if body := response.Body.String(); !strings.Contains(body, "unknown emails: nobody@getstream.io") {
+		t.Errorf("wanted the unknown email in the error, got %s", body)
+	}
+
+	// Duplicates are detected after resolving emails
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "Taxi",
+		Amount:      30,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}, {Email: "test2@getstream.io"}},
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d for a duplicate user, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	if got := len(dbh.GetExpenses(userID1)); got != 2 {
+		t.Errorf("wanted 2 expenses, got %d", got)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Password policy flags
+var passwordMinLength = flag.Int("password-min-length", 6, "minimum password length")
+var passwordRequireDigit = flag.Bool("password-require-digit", false, "require passwords to contain a digit")
+var passwordRequireMixedCase = flag.Bool("password-require-mixed-case", false, "require passwords to contain upper and lower case letters")
+var passwordRejectCommon = flag.Bool("password-reject-common", false, "reject commonly used passwords")
+
+// commonPasswords is a list of frequently used passwords, all lower case
+var commonPasswords = map[string]bool{
+	"000000": true, "111111": true, "112233": true, "121212": true, "123123": true,
+	"123321": true, "1234567": true, "12345678": true, "123456789": true, "1234567890": true,
+	"123456": true, "123qwe": true, "1q2w3e": true, "1q2w3e4r": true, "654321": true,
+	"666666": true, "696969": true, "7777777": true, "987654321": true, "aa123456": true,
+	"abc123": true, "access": true, "admin": true, "admin123": true, "baseball": true,
+	"batman": true, "charlie": true, "dragon": true, "football": true, "freedom": true,
+	"iloveyou": true, "letmein": true, "login": true, "master": true, "michael": true,
+	"monkey": true, "mustang": true, "passw0rd": true, "password": true, "password1": true,
+	"password123": true, "princess": true, "qazwsx": true, "qwerty": true, "qwerty123": true,
+	"qwertyuiop": true, "secret": true, "shadow": true, "starwars": true, "sunshine": true,
+	"superman": true, "trustno1": true, "welcome": true, "whatever": true, "zaq12wsx": true,
+}
+
+// validatePassword checks a password against the password policy and records a
+// validation failure for each rule it breaks
+func validatePassword(password string, field string, errs *validationErrors) {
+	if len(password) < *passwordMinLength {
+		errs.add(field, fmt.Sprintf("invalid password: it must be at least %d characters", *passwordMinLength))
+	}
+
+	if *passwordRequireDigit && strings.IndexFunc(password, unicode.IsDigit) == -1 {
+		errs.add(field, "invalid password: it must contain a digit")
+	}
+
+	if *passwordRequireMixedCase &&
+		(strings.IndexFunc(password, unicode.IsUpper) == -1 || strings.IndexFunc(password, unicode.IsLower) == -1) {
+		errs.add(field, "invalid password: it must contain upper and lower case letters")
+	}
+
+	if *passwordRejectCommon && commonPasswords[strings.ToLower(password)] {
+		errs.add(field, "invalid password: it is too common")
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// setPasswordPolicy enables all password rules and returns a function restoring
+// the previous policy
+func setPasswordPolicy() func() {
+	oldMinLength, oldRequireDigit := *passwordMinLength, *passwordRequireDigit
+	oldRequireMixedCase, oldRejectCommon := *passwordRequireMixedCase, *passwordRejectCommon
+
+	*passwordMinLength = 8
+	*passwordRequireDigit = true
+	*passwordRequireMixedCase = true
+	*passwordRejectCommon = true
+
+	return func() {
+		*passwordMinLength, *passwordRequireDigit = oldMinLength, oldRequireDigit
+		*passwordRequireMixedCase, *passwordRejectCommon = oldRequireMixedCase, oldRejectCommon
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	// Check each rule of the password policy fires on its own and a strong
+	// password passes
+
+	defer setPasswordPolicy()()
+
+	tests := []struct {
+		Password string
+		Wanted   []string
+	}{
+		{"Sh0rt", []string{"invalid password: it must be at least 8 characters"}},
+		{"NoDigitsHere", []string{"invalid password: it must contain a digit"}},
+		{"lowercase123", []string{"invalid password: it must contain upper and lower case letters"}},
+		{"Password123", []string{"invalid password: it is too common"}},
+		{"Correct4Horse", nil},
+	}
+
+	for _, test := range tests {
+		var errs validationErrors
+		validatePassword(test.Password, "password", &errs)
+		var got []string
+		for _, e := range errs {
+			got = append(got, e.Message)
+		}
+		if !reflect.DeepEqual(test.Wanted, got) {
+			t.Errorf("%s: wanted %v, got %v", test.Password, test.Wanted, got)
+		}
+	}
+}
+
+func TestPostPassword(t *testing.T) {
+	// Change a password, applying the password policy and requiring the current password
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	defer setPasswordPolicy()()
+
+	tests := []struct {
+		Request changePasswordRequest
+		Code    int
+	}{
+		{changePasswordRequest{"secret", "weak"}, http.StatusBadRequest},
+		{changePasswordRequest{"wrong", "Correct4Horse"}, http.StatusUnauthorized},
+		{changePasswordRequest{"secret", "Correct4Horse"}, http.StatusNoContent},
+		{changePasswordRequest{"Correct4Horse", "Battery5Staple"}, http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		body, _ := json.Marshal(test.Request)
+		request, _ := http.NewRequest(http.MethodPost, "/me/password", bytes.NewReader(body))
+		response := httptest.NewRecorder()
+		api.postPassword(response, request, userID)
+		if response.Code != test.Code {
+			t.Errorf("%+v: wanted %d, got %d", test.Request, test.Code, response.Code)
+		}
+	}
+}
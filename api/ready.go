@@ -0,0 +1,37 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+// Statuses of the readiness endpoint
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+)
+
+type readyResponse struct {
+	Status string `json:"status"`
+	Cache  string `json:"cache"`
+}
+
+// getReadyz reports whether the server is ready to serve requests. It's
+// degraded with a 503 while too many recent calls to the cache have failed, so
+// that e.g. a load balancer can take it out of rotation.
+func (api *API) getReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	if !api.cache.Healthy() {
+		log.Print("WARNING: the cache is degraded")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeJSON(w, readyResponse{Status: statusDegraded, Cache: statusDegraded})
+		return
+	}
+
+	writeResponse(w, r, readyResponse{Status: statusOK, Cache: statusOK})
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/testutil"
+)
+
+func TestReadyz(t *testing.T) {
+	// The server is ready while the cache is healthy and degraded otherwise
+
+	mockCache := testutil.NewMockCache(cache.NewInMemoryCache())
+	api := NewAPI(database.NewInMemoryDatabase(), mockCache)
+
+	getReadyz := func() (int, readyResponse) {
+		request, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+		response := httptest.NewRecorder()
+		api.routes().ServeHTTP(response, request)
+
+		var got readyResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		return response.Code, got
+	}
+
+	if code, got := getReadyz(); code != http.StatusOK || got != (readyResponse{Status: "ok", Cache: "ok"}) {
+		t.Errorf("wanted a healthy server, got %d %+v", code, got)
+	}
+
+	mockCache.Fail("Healthy", errors.New("redis is down"))
+	if code, got := getReadyz(); code != http.StatusServiceUnavailable || got != (readyResponse{Status: "degraded", Cache: "degraded"}) {
+		t.Errorf("wanted a degraded server, got %d %+v", code, got)
+	}
+
+	mockCache.Reset("Healthy")
+	if code, _ := getReadyz(); code != http.StatusOK {
+		t.Errorf("wanted the server to recover, got %d", code)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+func TestPostExpensesReimbursement(t *testing.T) {
+	// User 2 pays user 1 back part of a dinner, reducing their debt by the full
+	// amount
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      40,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense: %s", response.Body.String())
+	}
+
+	response = postExpense(api, userID2, createExpenseRequest{
+		Description: "Paying back dinner",
+		Amount:      15,
+		CreatedAt:   "2021-01-02T15:04:05Z",
+		Users:       []userID{{ID: userID1}},
+		Type:        "reimbursement",
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create reimbursement: %s", response.Body.String())
+	}
+
+	if got := getBalance(t, api, userID2); math.Abs(got.Balance+5) > ledger.Epsilon {
+		t.Errorf("wanted user 2 to owe €5, got %+v", got)
+	}
+	if got := getExpenseWithType(t, api, userID2, "Paying back dinner"); got != "reimbursement" {
+		t.Errorf("wanted type reimbursement, got %q", got)
+	}
+
+	tests := []struct {
+		Type       string
+		ShareSplit map[int]int
+	}{
+		{"refund", nil},
+		{"reimbursement", map[int]int{userID1: 1, userID2: 1}},
+	}
+	for _, test := range tests {
+		response = postExpense(api, userID2, createExpenseRequest{
+			Description: "Paying back lunch",
+			Amount:      10,
+			CreatedAt:   "2021-01-03T15:04:05Z",
+			Users:       []userID{{ID: userID1}},
+			Type:        test.Type,
+			ShareSplit:  test.ShareSplit,
+		})
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: wanted %d, got %d", test.Type, http.StatusBadRequest, response.Code)
+		}
+	}
+}
+
+// getExpenseWithType returns the type of a user's expense with a description
+func getExpenseWithType(t *testing.T, api *API, userID int, description string) string {
+	for _, e := range getExpensesWithTag(t, api, userID, "") {
+		if e.Description == description {
+			return e.Type
+		}
+	}
+	t.Fatalf("expense %q not found", description)
+	return ""
+}
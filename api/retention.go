@@ -0,0 +1,46 @@
+package api
+
+import (
+	"flag"
+	"log"
+	"time"
+)
+
+// balanceRetention is how long cached balances are kept after they were computed
+// and balancePruneInterval how often older ones are deleted
+var balanceRetention = flag.Duration("balance-retention", 24*time.Hour, "delete cached balances computed longer ago than this, 0 to keep them until they expire")
+var balancePruneInterval = flag.Duration("balance-prune-interval", 10*time.Minute, "interval between deleting cached balances past the retention")
+
+// tryPruneBalances deletes the cached balances computed longer than
+// balanceRetention ago and returns how many were deleted. A panic, e.g. due to a
+// failing cache, is logged instead of crashing the server, and false is returned.
+func (api *API) tryPruneBalances() (pruned int, ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Unable to prune cached balances: %v", err)
+			ok = false
+		}
+	}()
+
+	pruned = api.cache.PruneBalances(api.now().Add(-*balanceRetention))
+	if pruned > 0 {
+		log.Printf("Pruned %d cached balances older than %s", pruned, *balanceRetention)
+	}
+	return pruned, true
+}
+
+// pruneBalancesForever prunes the cached balances every interval, until stop is
+// closed
+func (api *API) pruneBalancesForever(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			api.tryPruneBalances()
+		}
+	}
+}
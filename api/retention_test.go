@@ -0,0 +1,73 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+	"github.com/freewilll/splitter/testutil"
+)
+
+func TestPruneBalancesForever(t *testing.T) {
+	// Cached balances past the retention are pruned in the background, recent ones
+	// are kept, and the pruner stops when asked to
+
+	db := database.NewInMemoryDatabase()
+	cache := testutil.NewMockCache(cache.NewInMemoryCache())
+	api := NewAPI(db, cache)
+
+	oldRetention := *balanceRetention
+	defer func() { *balanceRetention = oldRetention }()
+	*balanceRetention = time.Hour
+
+	now := time.Now().UTC()
+	cache.SetBalance(ledger.Balance{Balance: 1, ComputedAt: now.Add(-2 * time.Hour)}, 1, 0)
+	cache.SetBalance(ledger.Balance{Balance: 2, ComputedAt: now}, 2, 0)
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		api.pruneBalancesForever(time.Millisecond, stop)
+		close(stopped)
+	}()
+
+	// Both balances are calculated again if they're missing, which the empty
+	// database would make 0
+	deadline := time.Now().Add(5 * time.Second)
+	for cache.GetBalance(db, 1).Balance != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("wanted the old balance to be pruned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if balance := cache.GetBalance(db, 2); balance.Balance != 2 {
+		t.Errorf("wanted the recent balance to be kept, got %+v", balance)
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wanted the pruner to stop")
+	}
+}
+
+func TestPruneBalancesCacheFailure(t *testing.T) {
+	// A failing cache doesn't crash the pruner
+
+	db := database.NewInMemoryDatabase()
+	cache := testutil.NewMockCache(cache.NewInMemoryCache())
+	api := NewAPI(db, cache)
+
+	cache.Fail("PruneBalances", errInjected)
+	if _, ok := api.tryPruneBalances(); ok {
+		t.Fatalf("wanted pruning to fail")
+	}
+
+	cache.Reset("PruneBalances")
+	if _, ok := api.tryPruneBalances(); !ok {
+		t.Fatalf("wanted pruning to succeed")
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestUnknownRoutes(t *testing.T) {
+	// Unknown paths get a JSON 404, known ones are routed as usual
+
+	api := NewAPI(database.NewInMemoryDatabase(), cache.NewInMemoryCache())
+	routes := api.routes()
+
+	for _, path := range []string{"/", "/nonexistent", "/balance/unknown", "/me/settings/x"} {
+		request, _ := http.NewRequest(http.MethodGet, path, nil)
+		response := httptest.NewRecorder()
+		routes.ServeHTTP(response, request)
+		if response.Code != http.StatusNotFound {
+			t.Errorf("%s: wanted %d, got %d", path, http.StatusNotFound, response.Code)
+			continue
+		}
+		if contentType := response.Result().Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: wanted a json content type, got %q", path, contentType)
+		}
+
+		var got errorResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Error != "not found" {
+			t.Errorf("%s: wanted a json error, got %v (%v)", path, got, err)
+		}
+	}
+
+	// A known path without a cookie is unauthorized, rather than unknown
+	request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+	response := httptest.NewRecorder()
+	routes.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("wanted %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	// A request with a method the endpoint doesn't support gets a 405 listing
+	// the supported methods in the Allow header
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+	routes := api.routes()
+
+	dbh := db.Connect()
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+	cookie := signinCookie(t, api, "admin@getstream.io", "secret")
+
+	tests := []struct {
+		Path  string
+		Allow string
+	}{
+		{"/signin", "POST"},
+		{"/metrics", "GET"},
+		{"/meta", "GET"},
+		{"/users", "GET, POST"},
+		{"/users/resolve", "POST"},
+		{"/users/1/merge-into/2", "POST"},
+		{"/friends", "GET, POST"},
+		{"/contacts/recent", "GET"},
+		{"/expenses", "GET, POST"},
+		{"/expenses/1/tags", "POST"},
+		{"/expenses/1/breakdown", "GET"},
+		{"/expenses/search", "GET"},
+		{"/expenses/shared-with", "GET"},
+		{"/expenses/paid-by-me", "GET"},
+		{"/tags", "GET"},
+		{"/audit", "GET"},
+		{"/admin/expenses", "GET"},
+		{"/cache/warm", "POST"},
+		{"/settlements", "POST"},
+		{"/sessions", "DELETE"},
+		{"/token/introspect", "GET"},
+		{"/undo", "POST"},
+		{"/balance", "GET"},
+		{"/balance/settled", "GET"},
+		{"/balance/net", "GET"},
+		{"/stats", "GET"},
+		{"/leaderboard", "GET"},
+		{"/me", "PATCH, DELETE"},
+		{"/me/export", "GET"},
+		{"/me/password", "POST"},
+		{"/me/settings", "GET, PUT"},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest("TRACE", test.Path, nil)
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		routes.ServeHTTP(response, request)
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: wanted %d, got %d", test.Path, http.StatusMethodNotAllowed, response.Code)
+			continue
+		}
+		if allow := response.Result().Header.Get("Allow"); allow != test.Allow {
+			t.Errorf("%s: wanted Allow %q, got %q", test.Path, test.Allow, allow)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// getExpenseSearch returns the expenses shared by the authenticated user with a
+// description containing the q parameter, ignoring case
+func (api *API) getExpenseSearch(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		var errs validationErrors
+		errs.add("q", "search query is required")
+		errs.write(w)
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expenses := dbh.SearchExpenses(userID, query)
+	response := make([]expenseResponse, len(expenses))
+	for i, e := range expenses {
+		response[i] = newExpenseResponse(e)
+	}
+
+	log.Printf("Found %d expenses for user %d matching %q", len(expenses), userID, query)
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func searchExpenses(api *API, userID int, query string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodGet, "/expenses/search?q="+url.QueryEscape(query), nil)
+	response := httptest.NewRecorder()
+	api.getExpenseSearch(response, request, userID)
+	return response
+}
+
+func TestSearchExpenses(t *testing.T) {
+	// Search the expenses of a user and ensure only their matching expenses are
+	// returned
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	expenses := []struct {
+		UserID      int
+		OtherUserID int
+		Description string
+	}{
+		{userID1, userID2, "Dinner at Luigi's"},
+		{userID2, userID1, "Late dinner"},
+		{userID1, userID2, "Coffee"},
+		{userID2, userID3, "Dinner without user 1"},
+	}
+	for _, e := range expenses {
+		response := postExpense(api, e.UserID, createExpenseRequest{
+			Description: e.Description,
+			Amount:      42,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: e.OtherUserID}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+	}
+
+	tests := []struct {
+		Query  string
+		Wanted []string
+	}{
+		{"dinner", []string{"Dinner at Luigi's", "Late dinner"}},
+		{"DINNER", []string{"Dinner at Luigi's", "Late dinner"}},
+		{"coffee", []string{"Coffee"}},
+		{"user 1", []string{}},
+		{"tea", []string{}},
+	}
+
+	for _, test := range tests {
+		response := searchExpenses(api, userID1, test.Query)
+		if response.Code != http.StatusOK {
+			t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+		}
+
+		var got []expenseResponse
+		err := json.NewDecoder(strings.NewReader(response.Body.String())).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+
+		descriptions := make([]string, len(got))
+		for i, e := range got {
+			descriptions[i] = e.Description
+		}
+		sort.Strings(descriptions)
+
+		if strings.Join(descriptions, ",") != strings.Join(test.Wanted, ",") {
+			t.Errorf("query %q: wanted %v, got %v", test.Query, test.Wanted, descriptions)
+		}
+	}
+}
+
+func TestSearchExpensesWithoutQuery(t *testing.T) {
+	// A search without a query is rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	response := searchExpenses(api, userID1, " ")
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/freewilll/splitter/jwt"
+)
+
+// isValidSession checks the session of a jwt token hasn't been revoked
+func (api *API) isValidSession(session jwt.Session) bool {
+	return api.cache.IsValidSession(session.UserID, session.TokenID)
+}
+
+// deleteSessions signs the authenticated user out of all other sessions, by
+// revoking all their tokens except the one used for this request
+func (api *API) deleteSessions(w http.ResponseWriter, r *http.Request, userID int) {
+	c, err := r.Cookie(*cookieName)
+	if err != nil {
+		panic(err)
+	}
+
+	session, ok := jwt.VerifyToken(c.Value, nil)
+	if !ok || session.UserID != userID {
+		writeError(w, http.StatusUnauthorized, "authorization failed")
+		return
+	}
+
+	log.Printf("Revoking all sessions of user %d except the current one", userID)
+	api.cache.RevokeSessions(userID, session.TokenID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessions handles the sessions endpoint for the DELETE method
+func (api *API) sessions(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method == "DELETE" {
+		api.deleteSessions(w, r, userID)
+	} else {
+		methodNotAllowed(w, "DELETE")
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// signinCookie signs in and returns the jwt cookie
+func signinCookie(t *testing.T, api *API, email string, password string) *http.Cookie {
+	response := signin(api, email, password)
+	cookies := response.Result().Cookies()
+	if response.Code != http.StatusOK || len(cookies) != 1 {
+		t.Fatalf("Unable to sign in")
+	}
+	return cookies[0]
+}
+
+// callWithCookie calls an authenticated handler through requireAuth using a cookie
+func callWithCookie(api *API, method string, pass authenticatedHandler, cookie *http.Cookie) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, "/", nil)
+	request.AddCookie(cookie)
+	response := httptest.NewRecorder()
+	api.requireAuth(pass)(response, request)
+	return response
+}
+
+func TestDeleteSessions(t *testing.T) {
+	// Sign in on two devices and sign out the other device from the second one
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	dbh.CreateUser("test1@getstream.io", "secret")
+
+	cookie1 := signinCookie(t, api, "test1@getstream.io", "secret")
+	cookie2 := signinCookie(t, api, "test1@getstream.io", "secret")
+
+	for _, cookie := range []*http.Cookie{cookie1, cookie2} {
+		response := callWithCookie(api, http.MethodGet, api.getBalance, cookie)
+		if response.Code != http.StatusOK {
+			t.Errorf("wanted %d, got %d", http.StatusOK, response.Code)
+		}
+	}
+
+	response := callWithCookie(api, http.MethodDelete, api.sessions, cookie2)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("wanted %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	response = callWithCookie(api, http.MethodGet, api.getBalance, cookie1)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("wanted %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	response = callWithCookie(api, http.MethodGet, api.getBalance, cookie2)
+	if response.Code != http.StatusOK {
+		t.Errorf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+}
+
+func TestDeletedUserToken(t *testing.T) {
+	// Delete a signed in user and ensure their token is rejected, unless the
+	// existence check is disabled
+
+	oldCheckUserExists := *checkUserExists
+	defer func() { *checkUserExists = oldCheckUserExists }()
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	dbh.CreateUser("test1@getstream.io", "secret")
+	cookie := signinCookie(t, api, "test1@getstream.io", "secret")
+
+	*checkUserExists = true
+	response := callWithCookie(api, http.MethodGet, api.getBalance, cookie)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	response = callWithCookie(api, http.MethodDelete, api.me, cookie)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("wanted %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	response = callWithCookie(api, http.MethodGet, api.getBalance, cookie)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("wanted %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	*checkUserExists = false
+	response = callWithCookie(api, http.MethodGet, api.getBalance, cookie)
+	if response.Code != http.StatusOK {
+		t.Errorf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+}
+
+func TestCookieNameAndDomain(t *testing.T) {
+	// The configured cookie name and domain are used when signing in, and only a
+	// cookie with that name authenticates
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	dbh.CreateUser("test1@getstream.io", "secret")
+
+	oldCookieName, oldCookieDomain := *cookieName, *cookieDomain
+	defer func() { *cookieName, *cookieDomain = oldCookieName, oldCookieDomain }()
+	*cookieName = "splitter-session"
+	*cookieDomain = "example.com"
+
+	cookie := signinCookie(t, api, "test1@getstream.io", "secret")
+	if cookie.Name != "splitter-session" || cookie.Domain != "example.com" {
+		t.Fatalf("wanted cookie splitter-session for example.com, got %s for %s", cookie.Name, cookie.Domain)
+	}
+
+	if response := callWithCookie(api, http.MethodGet, api.getBalance, cookie); response.Code != http.StatusOK {
+		t.Errorf("wanted %d with the configured cookie, got %d", http.StatusOK, response.Code)
+	}
+
+	renamed := *cookie
+	renamed.Name = "jwt-token"
+	if response := callWithCookie(api, http.MethodGet, api.getBalance, &renamed); response.Code != http.StatusUnauthorized {
+		t.Errorf("wanted %d with the default cookie name, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/errkind"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// defaultLocale is the locale of users who haven't set one
+var defaultLocale = flag.String("default-locale", "en-US", "locale of users without a preferred locale")
+
+// localeRegex matches locales like en and de-DE
+var localeRegex = regexp.MustCompile("^[a-z]{2,3}(-[A-Z]{2})?$")
+
+// settingsRequest holds a user's preferences. Omitted preferences are unset and
+// get their defaults.
+type settingsRequest struct {
+	DefaultCurrency string `json:"default_currency"`
+	Locale          string `json:"locale"`
+	Notifications   bool   `json:"notifications"`
+}
+
+type settingsResponse settingsRequest
+
+// withDefaults returns the settings with defaults for the unset preferences
+func withDefaults(s database.Settings) database.Settings {
+	if s.DefaultCurrency == "" {
+		s.DefaultCurrency = *defaultCurrency
+	}
+	if s.Locale == "" {
+		s.Locale = *defaultLocale
+	}
+	return s
+}
+
+// getSettings returns the authenticated user's preferences
+func (api *API) getSettings(w http.ResponseWriter, r *http.Request, userID int) {
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	s := withDefaults(dbh.GetSettings(userID))
+	writeResponse(w, r, settingsResponse{DefaultCurrency: s.DefaultCurrency, Locale: s.Locale, Notifications: s.Notifications})
+}
+
+// putSettings replaces the authenticated user's preferences. Unknown
+// preferences are rejected.
+func (api *API) putSettings(w http.ResponseWriter, r *http.Request, userID int) {
+	var s settingsRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+			var errs validationErrors
+			errs.add("settings", "unknown setting "+field)
+			errs.write(w)
+			return
+		}
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	var errs validationErrors
+	if s.DefaultCurrency != "" && !ledger.IsValidCurrency(s.DefaultCurrency) {
+		errs.add("default_currency", "unknown currency")
+	}
+	if s.Locale != "" && !localeRegex.MatchString(s.Locale) {
+		errs.add("locale", "locale must be a language code, optionally followed by a country code, e.g. de-DE")
+	}
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	settings := database.Settings{DefaultCurrency: s.DefaultCurrency, Locale: s.Locale, Notifications: s.Notifications}
+	if err := dbh.SetSettings(userID, settings); err != nil {
+		switch errkind.Of(err) {
+		case errkind.NotFound:
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	log.Printf("User %d changed their settings to %+v", userID, settings)
+	settings = withDefaults(settings)
+	writeResponse(w, r, settingsResponse{DefaultCurrency: settings.DefaultCurrency, Locale: settings.Locale, Notifications: settings.Notifications})
+}
+
+// settings handles the settings endpoint for the GET and PUT methods
+func (api *API) settings(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method == "GET" {
+		api.getSettings(w, r, userID)
+	} else if r.Method == "PUT" {
+		api.putSettings(w, r, userID)
+	} else {
+		methodNotAllowed(w, "GET", "PUT")
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// callSettings calls the settings API with method on behalf of userID
+func callSettings(api *API, userID int, method string, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, "/me/settings", bytes.NewReader([]byte(body)))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.settings(response, request, userID)
+	return response
+}
+
+// getSettings calls the GET settings API and returns the decoded settings
+func getSettings(t *testing.T, api *API, userID int) settingsResponse {
+	response := callSettings(api, userID, http.MethodGet, "")
+	var got settingsResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	return got
+}
+
+func TestSettings(t *testing.T) {
+	// Settings have defaults until they're updated, after which they persist and
+	// apply to new expenses
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	wanted := settingsResponse{DefaultCurrency: *defaultCurrency, Locale: *defaultLocale}
+	if got := getSettings(t, api, userID1); got != wanted {
+		t.Errorf("wanted %+v, got %+v", wanted, got)
+	}
+
+	response := callSettings(api, userID1, http.MethodPut, `{"default_currency":"GBP","locale":"de-DE","notifications":true}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	wanted = settingsResponse{DefaultCurrency: "GBP", Locale: "de-DE", Notifications: true}
+	if got := getSettings(t, api, userID1); got != wanted {
+		t.Errorf("wanted %+v, got %+v", wanted, got)
+	}
+	if got := getSettings(t, api, userID2); got.DefaultCurrency != *defaultCurrency {
+		t.Errorf("wanted the default currency for user 2, got %+v", got)
+	}
+
+	postExpense(api, userID1, createExpenseRequest{
+		Description: "Tea",
+		Amount:      10,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if expenses := dbh.GetExpenses(userID1); len(expenses) != 1 || expenses[0].Currency != "GBP" {
+		t.Errorf("wanted an expense in GBP, got %+v", expenses)
+	}
+
+	// Omitted settings are reset to their defaults
+	callSettings(api, userID1, http.MethodPut, `{"locale":"nl"}`)
+	wanted = settingsResponse{DefaultCurrency: *defaultCurrency, Locale: "nl"}
+	if got := getSettings(t, api, userID1); got != wanted {
+		t.Errorf("wanted %+v, got %+v", wanted, got)
+	}
+}
+
+func TestSettingsInvalid(t *testing.T) {
+	// Unknown settings and invalid values are rejected without changing anything
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	for _, body := range []string{
+		`{"theme":"dark"}`,
+		`{"locale":"de-DE","theme":"dark"}`,
+		`{"default_currency":"XYZ"}`,
+		`{"locale":"German"}`,
+		`{"notifications":"yes"}`,
+	} {
+		if response := callSettings(api, userID, http.MethodPut, body); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: wanted %d, got %d", body, http.StatusBadRequest, response.Code)
+		}
+	}
+
+	if got := dbh.GetSettings(userID); got != (database.Settings{}) {
+		t.Errorf("wanted no settings, got %+v", got)
+	}
+}
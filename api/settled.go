@@ -0,0 +1,61 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+type settledResponse struct {
+	UserID  int     `json:"user_id"` // The counterparty
+	Settled bool    `json:"settled"` // True if neither owes the other anything
+	Owes    bool    `json:"owes"`    // True if the authenticated user owes the counterparty
+	Owed    bool    `json:"owed"`    // True if the counterparty owes the authenticated user
+	Amount  float64 `json:"amount"`  // Amount owed to the counterparty, negative if owed by them
+}
+
+// getSettled returns, for every user the authenticated user has shared expenses
+// or settlements with, whether they are settled up
+func (api *API) getSettled(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expenses := dbh.GetExpenses(userID)
+	settlements := dbh.GetSettlements(userID)
+	balance := ledger.CalculateBalance(expenses, settlements, userID)
+
+	amounts := make(map[int]float64)
+	for _, d := range balance.Debit {
+		amounts[d.UserID] += d.Amount
+	}
+	for _, d := range balance.Credit {
+		amounts[d.UserID] -= d.Amount
+	}
+
+	counterparties := ledger.Counterparties(expenses, settlements, userID)
+	response := make([]settledResponse, len(counterparties))
+	for i, counterpartyID := range counterparties {
+		amount := amounts[counterpartyID]
+		settled := math.Abs(amount) <= ledger.Epsilon
+		if settled {
+			amount = 0
+		}
+		response[i] = settledResponse{
+			UserID:  counterpartyID,
+			Settled: settled,
+			Owes:    !settled && amount > 0,
+			Owed:    !settled && amount < 0,
+			Amount:  amount,
+		}
+	}
+
+	log.Printf("Settled state for user %d is %+v", userID, response)
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+func TestGetSettled(t *testing.T) {
+	// User 2 settles what they owe user 1 but still owes user 3
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	for _, payerID := range []int{userID1, userID3} {
+		response := postExpense(api, payerID, createExpenseRequest{
+			Description: "Lunch",
+			Amount:      20,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+	}
+
+	response := postSettlement(api, userID2, createSettlementRequest{
+		UserID:    userID1,
+		Amount:    10,
+		CreatedAt: "2021-01-02T15:04:05Z",
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create settlement")
+	}
+
+	tests := []struct {
+		UserID int
+		Wanted []settledResponse
+	}{
+		{userID1, []settledResponse{{UserID: userID2, Settled: true}}},
+		{userID2, []settledResponse{
+			{UserID: userID1, Settled: true},
+			{UserID: userID3, Owes: true, Amount: 10},
+		}},
+		{userID3, []settledResponse{{UserID: userID2, Owed: true, Amount: -10}}},
+	}
+
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodGet, "/balance/settled", nil)
+		response := httptest.NewRecorder()
+		api.getSettled(response, request, test.UserID)
+		if response.Code != http.StatusOK {
+			t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+		}
+
+		var got []settledResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if !reflect.DeepEqual(got, test.Wanted) {
+			t.Errorf("user %d: wanted %+v, got %+v", test.UserID, test.Wanted, got)
+		}
+	}
+}
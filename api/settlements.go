@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+// allowOverpayment allows settlements larger than the outstanding debt, which
+// flips the debt around
+var allowOverpayment = flag.Bool("allow-overpayment", false, "allow settlements larger than the outstanding debt")
+
+type createSettlementRequest struct {
+	UserID    int     `json:"user_id"`
+	Amount    float64 `json:"amount"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// postSettlements records a (partial) payment of the authenticated user's debt
+// to another user
+func (api *API) postSettlements(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	// Decode request
+	var s createSettlementRequest
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	// Validate user_id, amount and created_at
+	if s.UserID == userID {
+		log.Print("Settlement with self")
+		writeError(w, http.StatusBadRequest, "user_id must not be self")
+		return
+	}
+
+	if s.Amount <= 0 {
+		log.Printf("Invalid amount '%0.2f'", s.Amount)
+		writeError(w, http.StatusBadRequest, "amount must be positive")
+		return
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, s.CreatedAt)
+	if err != nil {
+		log.Printf("Unable to parse timestamp '%s'", s.CreatedAt)
+		writeError(w, http.StatusBadRequest, "unable to parse created_at")
+		return
+	}
+
+	// Ensure the settlement doesn't exceed the outstanding debt
+	expenses := dbh.GetExpenses(userID)
+	settlements := dbh.GetSettlements(userID)
+	debt := ledger.CalculateBalance(expenses, settlements, userID).DebtTo(s.UserID)
+	if !*allowOverpayment && s.Amount > debt+ledger.Epsilon {
+		log.Printf("Settlement amount %0.2f exceeds debt %0.2f", s.Amount, debt)
+		writeError(w, http.StatusBadRequest, "amount must not exceed the outstanding debt")
+		return
+	}
+
+	// Create the entry in the database
+	log.Printf(
+		"Adding settlement from_user_id=%d, to_user_id=%d, amount=%0.2f, created_at=%s",
+		userID, s.UserID, s.Amount, createdAt)
+
+	dbh.CreateSettlement(ledger.Settlement{
+		FromUserID: userID,
+		ToUserID:   s.UserID,
+		Amount:     s.Amount,
+		CreatedAt:  createdAt.UTC(),
+	})
+
+	// Write through the balances of both users to the cache
+	api.updateBalance(dbh, userID)
+	api.updateBalance(dbh, s.UserID)
+
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// postSettlement posts a settlement request on behalf of userID and returns the response
+func postSettlement(api *API, userID int, s createSettlementRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(s)
+	request, _ := http.NewRequest(http.MethodPost, "/settlements", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	api.postSettlements(response, request, userID)
+	return response
+}
+
+func TestPostSettlements(t *testing.T) {
+	// Partially pay back a debt, then ensure paying back more than is owed is rejected
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	// User 1 buys a meal for the other two, for €42
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}, {ID: userID3}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	// User 2 pays back €10 of the €14 they owe
+	response = postSettlement(api, userID2, createSettlementRequest{
+		UserID:    userID1,
+		Amount:    10,
+		CreatedAt: "2021-01-02T15:04:05Z",
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create settlement")
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+	response = httptest.NewRecorder()
+	api.getBalance(response, request, userID2)
+	var got ledger.Balance
+	err := json.NewDecoder(response.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wantedBalance := -4.0
+	if math.Abs(got.Balance-wantedBalance) > ledger.Epsilon {
+		t.Errorf("wanted %v,got %v", wantedBalance, got.Balance)
+	}
+
+	// Paying back €5 is more than the remaining €4
+	response = postSettlement(api, userID2, createSettlementRequest{
+		UserID:    userID1,
+		Amount:    5,
+		CreatedAt: "2021-01-03T15:04:05Z",
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/freewilll/splitter/database"
+)
+
+// getExpensesSharedWith returns the expenses the authenticated user shares with
+// the comma separated user ids in the users parameter, e.g. to reconcile a trip.
+// With match=all, the default, all of the users must be involved in an expense,
+// with match=any at least one of them.
+func (api *API) getExpensesSharedWith(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	var errs validationErrors
+	q := database.SharedWithQuery{All: true}
+
+	seen := make(map[int]bool)
+	for _, value := range strings.Split(r.URL.Query().Get("users"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 1 {
+			errs.add("users", "users must be a comma separated list of positive integers")
+			break
+		}
+		if id != userID && !seen[id] {
+			seen[id] = true
+			q.UserIDs = append(q.UserIDs, id)
+		}
+	}
+	if len(q.UserIDs) == 0 && len(errs) == 0 {
+		errs.add("users", "at least one other user is required")
+	}
+
+	switch r.URL.Query().Get("match") {
+	case "", "all":
+	case "any":
+		q.All = false
+	default:
+		errs.add("match", "match must be all or any")
+	}
+
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expenses := dbh.GetExpensesSharedWith(userID, q)
+	response := make([]expenseResponse, len(expenses))
+	for i, e := range expenses {
+		response[i] = newExpenseResponse(e)
+	}
+
+	log.Printf("Found %d expenses for user %d shared with %v", len(expenses), userID, q.UserIDs)
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+func getExpensesSharedWith(api *API, userID int, query string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodGet, "/expenses/shared-with?"+query, nil)
+	response := httptest.NewRecorder()
+	api.getExpensesSharedWith(response, request, userID)
+	return response
+}
+
+func TestGetExpensesSharedWith(t *testing.T) {
+	// Find the expenses of a trip shared with all, or any, of a group of users
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	userID4, _ := dbh.CreateUser("test4@getstream.io", "secret")
+
+	expenses := []struct {
+		OwnerID     int
+		PayerID     int
+		Users       []int
+		Description string
+	}{
+		{userID1, 0, []int{userID2, userID3}, "Hotel"},
+		{userID1, 0, []int{userID2}, "Train"},
+		{userID3, 0, []int{userID1}, "Museum"},
+		{userID2, 0, []int{userID3}, "Without user 1"},
+		{userID4, userID2, []int{userID1}, "Paid by user 2"},
+	}
+	for _, e := range expenses {
+		dbh.CreateExpense(ledger.Expense{
+			OwnerID:     e.OwnerID,
+			PayerID:     e.PayerID,
+			Users:       e.Users,
+			Amount:      42,
+			Currency:    "EUR",
+			Description: e.Description,
+			CreatedAt:   time.Date(2021, 1, 1, 15, 4, 5, 0, time.UTC),
+		})
+	}
+
+	tests := []struct {
+		Query  string
+		Wanted []string
+	}{
+		{"users=2,3", []string{"Hotel"}},
+		{"users=2,3&match=all", []string{"Hotel"}},
+		{"users=2,3,3,1&match=all", []string{"Hotel"}},
+		{"users=2,3&match=any", []string{"Hotel", "Museum", "Paid by user 2", "Train"}},
+		{"users=2,4", []string{"Paid by user 2"}},
+		{"users=4&match=any", []string{"Paid by user 2"}},
+		{"users=99&match=any", []string{}},
+	}
+	for _, test := range tests {
+		response := getExpensesSharedWith(api, userID1, test.Query)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: wanted %d, got %d", test.Query, http.StatusOK, response.Code)
+		}
+
+		var got []expenseResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		if d := descriptions(got); !reflect.DeepEqual(d, test.Wanted) {
+			t.Errorf("%s: wanted %v, got %v", test.Query, test.Wanted, d)
+		}
+	}
+
+	for _, query := range []string{"", "users=1", "users=two", "users=2&match=some"} {
+		if response := getExpensesSharedWith(api, userID1, query); response.Code != http.StatusBadRequest {
+			t.Errorf("%q: wanted %d, got %d", query, http.StatusBadRequest, response.Code)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+// maxSimulatedExpenses is the maximum number of expenses in a simulation
+var maxSimulatedExpenses = flag.Int("simulate-max-expenses", 1000, "maximum number of expenses in a balance simulation")
+
+// simulatedExpense is a hypothetical expense. Unlike a created expense, any
+// user can be the owner and the users don't need to exist.
+type simulatedExpense struct {
+	OwnerID int     `json:"owner_id"`
+	PayerID int     `json:"payer_id"` // Optional, defaults to the owner
+	Users   []int   `json:"users"`    // The other users sharing the expense
+	Amount  float64 `json:"amount"`
+
+	PercentageSplit map[int]float64 `json:"percentage_split"` // Optional, keyed by user id including the owner
+	ShareSplit      map[int]int     `json:"share_split"`      // Optional number of shares, keyed by user id including the owner
+	BasePerPerson   float64         `json:"base_per_person"`  // Optional fixed amount per user, the rest is split
+	IncludeOwner    *bool           `json:"include_owner"`    // Optional, false if the owner doesn't share the expense
+	Type            string          `json:"type"`             // Optional expense, the default, or reimbursement
+}
+
+type simulateBalanceRequest struct {
+	UserID   int                `json:"user_id"`
+	Expenses []simulatedExpense `json:"expenses"`
+}
+
+// toExpense validates a simulated expense and returns it as the i-th expense,
+// adding any validation failures to errs
+func (s simulatedExpense) toExpense(i int, errs *validationErrors) ledger.Expense {
+	field := func(name string) string {
+		return fmt.Sprintf("expenses[%d].%s", i, name)
+	}
+	invalid := func(name string, message string) {
+		errs.add(field(name), fmt.Sprintf("expense %d: %s", i, message))
+	}
+
+	if s.OwnerID <= 0 {
+		invalid("owner_id", "owner_id must be positive")
+	}
+
+	if s.Amount <= 0 {
+		invalid("amount", "amount must be positive")
+	} else if s.Amount > *maxAmount {
+		invalid("amount", fmt.Sprintf("amount must be at most %0.2f", *maxAmount))
+	}
+
+	expenseType := ledger.ExpenseType(s.Type)
+	if expenseType == "" {
+		expenseType = ledger.TypeExpense
+	} else if expenseType != ledger.TypeExpense && expenseType != ledger.TypeReimbursement {
+		invalid("type", "type must be expense or reimbursement")
+	}
+
+	uniqueUsers := make(map[int]bool, len(s.Users))
+	for _, u := range s.Users {
+		if u <= 0 {
+			invalid("users", "user ids must be positive")
+		} else if u == s.OwnerID {
+			invalid("users", "user list must not include the owner")
+		} else if uniqueUsers[u] {
+			invalid("users", "duplicate user in user list")
+		}
+		uniqueUsers[u] = true
+	}
+
+	payerID := s.OwnerID
+	if s.PayerID != 0 {
+		if !uniqueUsers[s.PayerID] && s.PayerID != s.OwnerID {
+			invalid("payer_id", "payer must be the owner or in the user list")
+		}
+		payerID = s.PayerID
+	}
+
+	excludeOwner := s.IncludeOwner != nil && !*s.IncludeOwner
+	if excludeOwner && len(s.Users) == 0 {
+		invalid("users", "at least one other user must be included in an expense that excludes the owner")
+	}
+
+	for u := range s.PercentageSplit {
+		if !uniqueUsers[u] && (u != s.OwnerID || excludeOwner) {
+			invalid("percentage_split", "percentage split must only include users sharing the expense")
+			break
+		}
+	}
+	for u := range s.ShareSplit {
+		if !uniqueUsers[u] && (u != s.OwnerID || excludeOwner) {
+			invalid("share_split", "share split must only include users sharing the expense")
+			break
+		}
+	}
+	if s.PercentageSplit != nil && s.ShareSplit != nil {
+		invalid("share_split", "an expense can't have both a percentage split and a share split")
+	}
+
+	// The owner is one of the users, as it is for stored expenses
+	users := append([]int{}, s.Users...)
+	if !excludeOwner {
+		users = append(users, s.OwnerID)
+	}
+
+	expense := ledger.Expense{
+		ExpenseID: i + 1,
+		OwnerID:   s.OwnerID,
+		PayerID:   payerID,
+		Type:      expenseType,
+		Amount:    s.Amount,
+		Currency:  *defaultCurrency,
+		Users:     users,
+
+		PercentageSplit: s.PercentageSplit,
+		ShareSplit:      s.ShareSplit,
+		BasePerPerson:   s.BasePerPerson,
+		ExcludeOwner:    excludeOwner,
+	}
+
+	switch err := expense.Validate(); {
+	case err == nil:
+	case errors.Is(err, ledger.ErrAmountTooLarge):
+		invalid("amount", err.Error())
+	case errors.Is(err, ledger.ErrInvalidShares):
+		invalid("share_split", err.Error())
+	case errors.Is(err, ledger.ErrInvalidBase):
+		invalid("base_per_person", err.Error())
+	case errors.Is(err, ledger.ErrInvalidReimbursement):
+		invalid("type", err.Error())
+	default:
+		invalid("percentage_split", err.Error())
+	}
+
+	return expense
+}
+
+// postSimulateBalance returns the balance of a user if the expenses in the
+// request were the only ones. Nothing is stored, so the expenses can involve
+// any users. Expenses are numbered from 1 in the breakdown of the balance.
+func (api *API) postSimulateBalance(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var req simulateBalanceRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	var errs validationErrors
+	if req.UserID <= 0 {
+		errs.add("user_id", "user_id must be positive")
+	}
+	if len(req.Expenses) == 0 {
+		errs.add("expenses", "expenses must not be empty")
+	} else if len(req.Expenses) > *maxSimulatedExpenses {
+		errs.add("expenses", fmt.Sprintf("at most %d expenses can be simulated", *maxSimulatedExpenses))
+	}
+	if errs.write(w) {
+		return
+	}
+
+	expenses := make([]ledger.Expense, len(req.Expenses))
+	for i, s := range req.Expenses {
+		expenses[i] = s.toExpense(i, &errs)
+	}
+	if errs.write(w) {
+		return
+	}
+
+	balance := ledger.CalculateBalance(expenses, nil, req.UserID)
+	balance.ComputedAt = api.now().UTC()
+	writeResponse(w, r, balance)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+func postSimulateBalance(api *API, userID int, req simulateBalanceRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	request, _ := http.NewRequest(http.MethodPost, "/simulate/balance", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	api.postSimulateBalance(response, request, userID)
+	return response
+}
+
+func TestSimulateBalance(t *testing.T) {
+	// The scenarios of the ledger tests give the same balances over HTTP, without
+	// storing any expenses
+
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewInMemoryCache())
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	excluded := false
+	meal := simulatedExpense{OwnerID: 1, Users: []int{2, 3}, Amount: 42}
+	coffee := simulatedExpense{OwnerID: 2, Users: []int{1}, Amount: 8}
+
+	tests := []struct {
+		Name     string
+		Expenses []simulatedExpense
+		Balances map[int]map[int]float64 // Keyed by user id, then by the other user, positive if owed
+	}{
+		{"meal", []simulatedExpense{meal}, map[int]map[int]float64{
+			1: {2: 14, 3: 14},
+			2: {1: -14},
+			3: {1: -14},
+		}},
+		{"meal and coffee", []simulatedExpense{meal, coffee}, map[int]map[int]float64{
+			1: {2: 10, 3: 14},
+			2: {1: -10},
+			3: {1: -14},
+		}},
+		{"payer", []simulatedExpense{{OwnerID: 1, PayerID: 2, Users: []int{2, 3}, Amount: 42}}, map[int]map[int]float64{
+			1: {2: -14},
+			2: {1: 14, 3: 14},
+			3: {2: -14},
+		}},
+		{"percentage split", []simulatedExpense{{OwnerID: 1, Users: []int{2, 3}, Amount: 100, PercentageSplit: map[int]float64{1: 50, 2: 30, 3: 20}}}, map[int]map[int]float64{
+			1: {2: 30, 3: 20},
+			2: {1: -30},
+			3: {1: -20},
+		}},
+		{"share split", []simulatedExpense{{OwnerID: 1, PayerID: 2, Users: []int{2}, Amount: 10, ShareSplit: map[int]int{1: 1, 2: 2}}}, map[int]map[int]float64{
+			1: {2: -3.33},
+			2: {1: 3.33},
+		}},
+		{"excluded owner", []simulatedExpense{{OwnerID: 1, Users: []int{2, 3, 4}, Amount: 10, IncludeOwner: &excluded}}, map[int]map[int]float64{
+			1: {2: 3.34, 3: 3.33, 4: 3.33},
+			4: {1: -3.33},
+		}},
+		{"reimbursement", []simulatedExpense{
+			{OwnerID: 1, Users: []int{2, 3}, Amount: 30},
+			{OwnerID: 2, Users: []int{1}, Amount: 6, Type: "reimbursement"},
+			{OwnerID: 1, PayerID: 3, Users: []int{3}, Amount: 10, Type: "reimbursement"},
+		}, map[int]map[int]float64{
+			1: {2: 4},
+			2: {1: -4},
+			3: {},
+		}},
+	}
+
+	for _, test := range tests {
+		for targetID, wanted := range test.Balances {
+			response := postSimulateBalance(api, userID1, simulateBalanceRequest{UserID: targetID, Expenses: test.Expenses})
+			if response.Code != http.StatusOK {
+				t.Fatalf("%s: wanted %d, got %d: %s", test.Name, http.StatusOK, response.Code, response.Body.String())
+			}
+
+			var got ledger.Balance
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+				t.Fatalf("Unable to parse response from server '%v'", err)
+			}
+
+			debts := make(map[int]float64)
+			total := 0.0
+			for _, d := range got.Credit {
+				debts[d.UserID] += d.Amount
+			}
+			for _, d := range got.Debit {
+				debts[d.UserID] -= d.Amount
+			}
+			for _, amount := range wanted {
+				total += amount
+			}
+			if len(debts) != len(wanted) || math.Abs(got.Balance-total) > ledger.Epsilon {
+				t.Errorf("%s, user %d: wanted %v, got %+v", test.Name, targetID, wanted, got)
+				continue
+			}
+			for u, amount := range wanted {
+				if math.Abs(debts[u]-amount) > ledger.Epsilon {
+					t.Errorf("%s, user %d: wanted %v, got %+v", test.Name, targetID, wanted, got)
+					break
+				}
+			}
+		}
+	}
+
+	if expenses := dbh.GetExpenses(userID1); len(expenses) != 0 {
+		t.Errorf("wanted no stored expenses, got %+v", expenses)
+	}
+}
+
+func TestSimulateBalanceInvalid(t *testing.T) {
+	// Invalid simulations are rejected with the index of the invalid expense
+
+	api := NewAPI(database.NewInMemoryDatabase(), cache.NewInMemoryCache())
+
+	tests := []struct {
+		Name    string
+		Request simulateBalanceRequest
+		Field   string
+	}{
+		{"no user", simulateBalanceRequest{Expenses: []simulatedExpense{{OwnerID: 1, Users: []int{2}, Amount: 10}}}, "user_id"},
+		{"no expenses", simulateBalanceRequest{UserID: 1}, "expenses"},
+		{"no owner", simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{{Users: []int{2}, Amount: 10}}}, "expenses[0].owner_id"},
+		{"negative amount", simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{{OwnerID: 1, Users: []int{2}, Amount: 10}, {OwnerID: 1, Users: []int{2}, Amount: -1}}}, "expenses[1].amount"},
+		{"owner in users", simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{{OwnerID: 1, Users: []int{1, 2}, Amount: 10}}}, "expenses[0].users"},
+		{"payer not sharing", simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{{OwnerID: 1, PayerID: 3, Users: []int{2}, Amount: 10}}}, "expenses[0].payer_id"},
+		{"percentages", simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{{OwnerID: 1, Users: []int{2}, Amount: 10, PercentageSplit: map[int]float64{1: 50, 2: 40}}}}, "expenses[0].percentage_split"},
+		{"unknown type", simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{{OwnerID: 1, Users: []int{2}, Amount: 10, Type: "gift"}}}, "expenses[0].type"},
+		{"reimbursement without recipient", simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{{OwnerID: 1, Amount: 10, Type: "reimbursement"}}}, "expenses[0].type"},
+	}
+
+	for _, test := range tests {
+		response := postSimulateBalance(api, 1, test.Request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: wanted %d, got %d", test.Name, http.StatusBadRequest, response.Code)
+			continue
+		}
+		if body := response.Body.String(); !strings.Contains(body, `"field":"`+test.Field+`"`) {
+			t.Errorf("%s: wanted an error for %s, got %s", test.Name, test.Field, body)
+		}
+	}
+
+	old := *maxSimulatedExpenses
+	defer func() { *maxSimulatedExpenses = old }()
+	*maxSimulatedExpenses = 1
+	expense := simulatedExpense{OwnerID: 1, Users: []int{2}, Amount: 10}
+	if response := postSimulateBalance(api, 1, simulateBalanceRequest{UserID: 1, Expenses: []simulatedExpense{expense, expense}}); response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d for too many expenses, got %d", http.StatusBadRequest, response.Code)
+	}
+}
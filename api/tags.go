@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// maxTags is the maximum number of tags on an expense and maxTagLength the
+// maximum number of characters in a tag
+const (
+	maxTags      = 10
+	maxTagLength = 50
+)
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type tagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+// normalizeTags trims and lowercases tags and removes duplicates, so that e.g.
+// "Work" and "work " are the same tag. Invalid tags are added to errs.
+func normalizeTags(tags []string, errs *validationErrors) []string {
+	unique := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			errs.add("tags", "tags must not be empty")
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			errs.add("tags", fmt.Sprintf("tags must be at most %d characters", maxTagLength))
+			continue
+		}
+		if !unique[tag] {
+			unique[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+
+	if len(normalized) > maxTags {
+		errs.add("tags", fmt.Sprintf("at most %d tags are allowed", maxTags))
+	}
+
+	sort.Strings(normalized)
+	return normalized
+}
+
+// getExpenses returns the expenses shared by the authenticated user, only those
+// with a tag if the tag query parameter is given. They are ordered by the order
+// query parameter, oldest or newest first, or else by -expense-order. With
+// display=true, the amounts and dates are also formatted for the user's locale.
+func (api *API) getExpenses(w http.ResponseWriter, r *http.Request, userID int) {
+	name := r.URL.Query().Get("order")
+	if name == "" {
+		name = *defaultExpenseOrder
+	}
+	order, err := database.ParseOrder(name)
+	if err != nil {
+		var errs validationErrors
+		errs.add("order", "order must be oldest or newest")
+		errs.write(w)
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	expenses := dbh.FindExpenses(database.ExpensesQuery{
+		InvolvedID: userID,
+		Tag:        strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag"))),
+		Order:      order,
+	})
+
+	display, ok := displayFormat(r, dbh, userID)
+	response := make([]expenseResponse, len(expenses))
+	for i, e := range expenses {
+		response[i] = newExpenseResponse(e)
+		if ok {
+			response[i].Display = &expenseDisplay{Amount: display.formatMoney(e.Amount, e.Currency), CreatedAt: display.formatDate(e.CreatedAt)}
+		}
+	}
+	writeResponse(w, r, response)
+}
+
+// parseExpensePath parses a /expenses/{id}/{action} path, e.g. /expenses/1/tags,
+// or a /expenses/{id} path if the action is empty
+func parseExpensePath(path string, action string) (int, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if action != "" {
+		if len(parts) != 3 || parts[2] != action {
+			return 0, false
+		}
+		parts = parts[:2]
+	}
+	if len(parts) != 2 || parts[0] != "expenses" {
+		return 0, false
+	}
+
+	expenseID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, false
+	}
+	return expenseID, true
+}
+
+// postExpenseTags attaches tags to an expense shared by the authenticated user
+// and returns the expense
+func (api *API) postExpenseTags(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	expenseID, ok := parseExpensePath(r.URL.Path, "tags")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var t tagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	var errs validationErrors
+	tags := normalizeTags(t.Tags, &errs)
+	if errs.write(w) {
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	// Find the expense, it must be shared by the user
+	findExpense := func() (ledger.Expense, bool) {
+		for _, e := range dbh.GetExpenses(userID) {
+			if e.ExpenseID == expenseID && e.Involves(userID) {
+				return e, true
+			}
+		}
+		return ledger.Expense{}, false
+	}
+
+	expense, found := findExpense()
+	if !found {
+		writeError(w, http.StatusNotFound, "expense not found")
+		return
+	}
+
+	if isTooOldToEdit(expense, api.now()) {
+		log.Printf("Expense %d is too old to change", expenseID)
+		writeError(w, http.StatusForbidden, "the expense is too old to change")
+		return
+	}
+
+	combined := append(append([]string{}, expense.Tags...), tags...)
+	if len(normalizeTags(combined, &errs)) > maxTags {
+		errs.write(w)
+		return
+	}
+
+	log.Printf("Adding tags %v to expense %d", tags, expenseID)
+	dbh.AddExpenseTags(expenseID, tags)
+
+	expense, _ = findExpense()
+	writeResponse(w, r, newExpenseResponse(expense))
+}
+
+// getTags returns the tags of all expenses shared by the authenticated user
+func (api *API) getTags(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	dbh := api.db.Connect()
+	defer dbh.Close()
+
+	writeResponse(w, r, tagsResponse{Tags: dbh.GetTags(userID)})
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+)
+
+// getExpensesWithTag calls the GET expenses API, filtering by tag if it's not empty
+func getExpensesWithTag(t *testing.T, api *API, userID int, tag string) []expenseResponse {
+	request, _ := http.NewRequest(http.MethodGet, "/expenses?tag="+url.QueryEscape(tag), nil)
+	response := httptest.NewRecorder()
+	api.expenses(response, request, userID)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	var got []expenseResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	return got
+}
+
+func postExpenseTags(api *API, userID int, expenseID int, tags []string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(tagsRequest{Tags: tags})
+	request, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/expenses/%d/tags", expenseID), bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	api.postExpenseTags(response, request, userID)
+	return response
+}
+
+// descriptions returns the sorted descriptions of expenses
+func descriptions(expenses []expenseResponse) []string {
+	result := make([]string, len(expenses))
+	for i, e := range expenses {
+		result[i] = e.Description
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestExpenseTags(t *testing.T) {
+	// Tag expenses when creating them and afterwards, then filter by tag
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2, userID3)
+
+	expenses := []struct {
+		UserID      int
+		OtherUserID int
+		Description string
+		Tags        []string
+	}{
+		{userID1, userID2, "Ski pass", []string{"2024-ski", " Work", "reimbursable"}},
+		{userID1, userID2, "Dinner", []string{"2024-ski"}},
+		{userID1, userID2, "Coffee", nil},
+		{userID2, userID3, "Ski rental", []string{"2024-ski"}},
+	}
+	for _, e := range expenses {
+		response := postExpense(api, e.UserID, createExpenseRequest{
+			Description: e.Description,
+			Amount:      42,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: e.OtherUserID}},
+			Tags:        e.Tags,
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+	}
+
+	// Multiple tags coexist on one expense and are normalized
+	got := getExpensesWithTag(t, api, userID1, "work")
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Tags, []string{"2024-ski", "reimbursable", "work"}) {
+		t.Fatalf("wanted the ski pass with 3 tags, got %+v", got)
+	}
+
+	// Other users' expenses aren't included
+	wanted := []string{"Dinner", "Ski pass"}
+	if got := descriptions(getExpensesWithTag(t, api, userID1, "2024-ski")); !reflect.DeepEqual(got, wanted) {
+		t.Errorf("wanted %v, got %v", wanted, got)
+	}
+
+	// All expenses are returned without a tag
+	wanted = []string{"Coffee", "Dinner", "Ski pass"}
+	if got := descriptions(getExpensesWithTag(t, api, userID1, "")); !reflect.DeepEqual(got, wanted) {
+		t.Errorf("wanted %v, got %v", wanted, got)
+	}
+
+	// Tag the coffee afterwards
+	coffeeID := 3
+	response := postExpenseTags(api, userID2, coffeeID, []string{"work", "Caffeine"})
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+
+	wanted = []string{"Coffee", "Ski pass"}
+	if got := descriptions(getExpensesWithTag(t, api, userID1, "work")); !reflect.DeepEqual(got, wanted) {
+		t.Errorf("wanted %v, got %v", wanted, got)
+	}
+
+	// The tags of all of a user's expenses
+	request, _ := http.NewRequest(http.MethodGet, "/tags", nil)
+	response = httptest.NewRecorder()
+	api.getTags(response, request, userID1)
+	var gotTags tagsResponse
+	if err := json.NewDecoder(response.Body).Decode(&gotTags); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	wantedTags := []string{"2024-ski", "caffeine", "reimbursable", "work"}
+	if !reflect.DeepEqual(gotTags.Tags, wantedTags) {
+		t.Errorf("wanted %v, got %v", wantedTags, gotTags.Tags)
+	}
+}
+
+func TestExpenseTagsInvalid(t *testing.T) {
+	// Invalid tags are rejected and only users sharing an expense can tag it
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+		Tags:        []string{" "},
+	})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	response = postExpense(api, userID1, createExpenseRequest{
+		Description: "Dinner",
+		Amount:      42,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense: %s", response.Body.String())
+	}
+	expenseID := 1
+
+	tooMany := make([]string, maxTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag-%d", i)
+	}
+
+	tests := []struct {
+		UserID    int
+		ExpenseID int
+		Tags      []string
+		Wanted    int
+	}{
+		{userID1, expenseID, tooMany, http.StatusBadRequest},
+		{userID1, expenseID, []string{strings.Repeat("a", maxTagLength+1)}, http.StatusBadRequest},
+		{userID3, expenseID, []string{"work"}, http.StatusNotFound},
+		{userID1, 42, []string{"work"}, http.StatusNotFound},
+		{userID1, expenseID, tooMany[:maxTags], http.StatusOK},
+		{userID1, expenseID, []string{"one-too-many"}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		response := postExpenseTags(api, test.UserID, test.ExpenseID, test.Tags)
+		if response.Code != test.Wanted {
+			t.Errorf("tags %v: wanted %d, got %d", test.Tags, test.Wanted, response.Code)
+		}
+	}
+}
+
+func TestGetExpensesOrder(t *testing.T) {
+	// Expenses are listed oldest or newest first by incurred time, with ties
+	// broken by id, as configured or requested
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldDefaultExpenseOrder := *defaultExpenseOrder
+	defer func() { *defaultExpenseOrder = oldDefaultExpenseOrder }()
+
+	for _, e := range []struct {
+		Description string
+		CreatedAt   string
+	}{
+		{"February", "2021-02-01T12:00:00Z"},
+		{"January", "2021-01-01T12:00:00Z"},
+		{"March", "2021-03-01T12:00:00Z"},
+		{"Also January", "2021-01-01T12:00:00Z"},
+	} {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: e.Description,
+			Amount:      10,
+			CreatedAt:   e.CreatedAt,
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense: %s", response.Body.String())
+		}
+	}
+
+	oldest := []string{"January", "Also January", "February", "March"}
+	newest := []string{"March", "February", "Also January", "January"}
+	tests := []struct {
+		Default string
+		Query   string
+		Code    int
+		Wanted  []string
+	}{
+		{"oldest", "", http.StatusOK, oldest},
+		{"newest", "", http.StatusOK, newest},
+		{"newest", "?order=oldest", http.StatusOK, oldest},
+		{"oldest", "?order=newest", http.StatusOK, newest},
+		{"oldest", "?order=random", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		*defaultExpenseOrder = test.Default
+		request, _ := http.NewRequest(http.MethodGet, "/expenses"+test.Query, nil)
+		response := httptest.NewRecorder()
+		api.expenses(response, request, userID1)
+		if response.Code != test.Code {
+			t.Errorf("%s %q: wanted %d, got %d", test.Default, test.Query, test.Code, response.Code)
+			continue
+		}
+		if test.Code != http.StatusOK {
+			continue
+		}
+
+		var got []expenseResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+		var descriptions []string
+		for _, e := range got {
+			descriptions = append(descriptions, e.Description)
+		}
+		if !reflect.DeepEqual(descriptions, test.Wanted) {
+			t.Errorf("%s %q: wanted %v, got %v", test.Default, test.Query, test.Wanted, descriptions)
+		}
+	}
+}
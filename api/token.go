@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/freewilll/splitter/jwt"
+)
+
+type introspectRequest struct {
+	Token string `json:"token"`
+}
+
+type introspectResponse struct {
+	Valid     bool       `json:"valid"`
+	Reason    string     `json:"reason,omitempty"`     // Why the token isn't valid
+	UserID    int        `json:"user_id,omitempty"`    // Set if the signature is valid
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Set if the signature is valid
+}
+
+// getTokenIntrospect verifies the jwt token in the Authorization header, as a
+// bearer token, or in the token field of the body and returns its validity and
+// claims. The reason is returned for an invalid token. Users can only inspect
+// their own tokens, administrators anyone's.
+func (api *API) getTokenIntrospect(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	var t introspectRequest
+	if header := r.Header.Get("Authorization"); header != "" {
+		t.Token = strings.TrimPrefix(header, "Bearer ")
+	} else if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		log.Print("Unable to decode and parse json")
+		writeError(w, http.StatusBadRequest, "unable to decode and parse json")
+		return
+	}
+
+	if t.Token == "" {
+		var errs validationErrors
+		errs.add("token", "token is required")
+		errs.write(w)
+		return
+	}
+
+	session, err := jwt.ParseToken(t.Token, api.isValidSession)
+	if session.UserID != 0 && session.UserID != userID {
+		dbh := api.db.Connect()
+		isAdmin := dbh.IsAdmin(userID)
+		dbh.Close()
+
+		if !isAdmin {
+			log.Printf("User %d is not allowed to inspect a token of user %d", userID, session.UserID)
+			writeError(w, http.StatusForbidden, "only administrators can inspect tokens of other users")
+			return
+		}
+	}
+
+	response := introspectResponse{Valid: err == nil, UserID: session.UserID}
+	if err != nil {
+		response.Reason = err.Error()
+	}
+	if !session.ExpiresAt.IsZero() {
+		response.ExpiresAt = &session.ExpiresAt
+	}
+
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/jwt"
+)
+
+func introspect(t *testing.T, api *API, userID int, header string, body string) (introspectResponse, *httptest.ResponseRecorder) {
+	request, _ := http.NewRequest(http.MethodGet, "/token/introspect", bytes.NewReader([]byte(body)))
+	if header != "" {
+		request.Header.Set("Authorization", "Bearer "+header)
+	}
+	response := httptest.NewRecorder()
+	api.getTokenIntrospect(response, request, userID)
+
+	var got introspectResponse
+	if response.Code == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server '%v'", err)
+		}
+	}
+	return got, response
+}
+
+func TestTokenIntrospect(t *testing.T) {
+	// Inspect valid and invalid tokens, only administrators can inspect tokens
+	// of other users
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	adminID, _ := dbh.CreateUser("admin@getstream.io", "secret")
+	dbh.SetAdmin(adminID, true)
+
+	token := signinCookie(t, api, "test1@getstream.io", "secret").Value
+	body, _ := json.Marshal(introspectRequest{Token: token})
+
+	// A valid token, in the header or the body
+	for _, request := range []struct{ Header, Body string }{{token, ""}, {"", string(body)}} {
+		got, _ := introspect(t, api, userID1, request.Header, request.Body)
+		if !got.Valid || got.Reason != "" || got.UserID != userID1 || got.ExpiresAt == nil {
+			t.Fatalf("wanted a valid token of user %d, got %+v", userID1, got)
+		}
+		if until := time.Until(*got.ExpiresAt); until < 29*time.Minute || until > 30*time.Minute {
+			t.Errorf("wanted the token to expire in 30 minutes, got %v", got.ExpiresAt)
+		}
+	}
+
+	expiresAt := time.Now().Add(-time.Minute).Truncate(time.Second).UTC()
+	tests := []struct {
+		Description string
+		Token       string
+		Reason      string
+		UserID      int
+	}{
+		{"expired", jwt.CreateToken(jwt.Session{UserID: userID1, TokenID: jwt.NewTokenID()}, expiresAt), "token is expired", userID1},
+		{"revoked", jwt.CreateToken(jwt.Session{UserID: userID1, TokenID: jwt.NewTokenID()}, time.Now().Add(time.Minute)), "token has been revoked", userID1},
+		{"tampered", token[:len(token)-4] + "AAAA", "invalid signature", 0},
+		{"malformed", "not-a-token", "malformed token", 0},
+	}
+	for _, test := range tests {
+		got, response := introspect(t, api, userID1, test.Token, "")
+		if response.Code != http.StatusOK {
+			t.Errorf("%s: wanted %d, got %d", test.Description, http.StatusOK, response.Code)
+			continue
+		}
+		if got.Valid || got.Reason != test.Reason || got.UserID != test.UserID {
+			t.Errorf("%s: wanted an invalid token of user %d because %q, got %+v", test.Description, test.UserID, test.Reason, got)
+		}
+	}
+
+	got, _ := introspect(t, api, userID1, tests[0].Token, "")
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("wanted the expired token to have expired at %v, got %+v", expiresAt, got)
+	}
+
+	// Other users can't inspect the token, administrators can
+	if _, response := introspect(t, api, userID2, token, ""); response.Code != http.StatusForbidden {
+		t.Errorf("wanted %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if got, response := introspect(t, api, adminID, token, ""); response.Code != http.StatusOK || !got.Valid || got.UserID != userID1 {
+		t.Errorf("wanted the admin to see a valid token of user %d, got %d %+v", userID1, response.Code, got)
+	}
+
+	// A token is required
+	if _, response := introspect(t, api, userID1, "", "{}"); response.Code != http.StatusBadRequest {
+		t.Errorf("wanted %d, got %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestTokenIssuerAudience(t *testing.T) {
+	// Tokens are only accepted with the configured issuer and audience
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	oldIssuer, oldAudience := jwt.Issuer, jwt.Audience
+	defer func() { jwt.Issuer, jwt.Audience = oldIssuer, oldAudience }()
+
+	createToken := func(issuer string, audience string) string {
+		jwt.Issuer, jwt.Audience = issuer, audience
+		defer func() { jwt.Issuer, jwt.Audience = "splitter", "expenses" }()
+		return signinCookie(t, api, "test1@getstream.io", "secret").Value
+	}
+
+	tests := []struct {
+		Description string
+		Token       string
+		Reason      string
+	}{
+		{"matching", createToken("splitter", "expenses"), ""},
+		{"wrong issuer", createToken("other", "expenses"), "invalid issuer"},
+		{"wrong audience", createToken("splitter", "other"), "invalid audience"},
+		{"no claims", createToken("", ""), "invalid issuer"},
+	}
+	for _, test := range tests {
+		got, response := introspect(t, api, userID1, test.Token, "")
+		if response.Code != http.StatusOK {
+			t.Errorf("%s: wanted %d, got %d", test.Description, http.StatusOK, response.Code)
+			continue
+		}
+		if got.Valid != (test.Reason == "") || got.Reason != test.Reason || got.UserID != userID1 {
+			t.Errorf("%s: wanted reason %q, got %+v", test.Description, test.Reason, got)
+		}
+
+		if _, ok := jwt.VerifyToken(test.Token, api.isValidSession); ok != (test.Reason == "") {
+			t.Errorf("%s: wanted verified %v, got %v", test.Description, test.Reason == "", ok)
+		}
+	}
+}
+
+func TestTokenLeeway(t *testing.T) {
+	// Tokens that expired less than the leeway ago are still valid
+
+	userID1 := 1
+
+	oldLeeway := jwt.Leeway
+	defer func() { jwt.Leeway = oldLeeway }()
+	jwt.Leeway = 30 * time.Second
+
+	tests := []struct {
+		Description string
+		ExpiresAt   time.Time
+		Valid       bool
+	}{
+		{"not expired", time.Now().Add(time.Minute), true},
+		{"within the leeway", time.Now().Add(-10 * time.Second), true},
+		{"beyond the leeway", time.Now().Add(-time.Minute), false},
+	}
+	for _, test := range tests {
+		token := jwt.CreateToken(jwt.Session{UserID: userID1, TokenID: jwt.NewTokenID()}, test.ExpiresAt)
+		if session, err := jwt.ParseToken(token, nil); (err == nil) != test.Valid || session.UserID != userID1 {
+			t.Errorf("%s: wanted valid %v, got %+v, %v", test.Description, test.Valid, session, err)
+		}
+	}
+
+	jwt.Leeway = 0
+	token := jwt.CreateToken(jwt.Session{UserID: userID1, TokenID: jwt.NewTokenID()}, time.Now().Add(-10*time.Second))
+	if _, err := jwt.ParseToken(token, nil); err != jwt.ErrExpired {
+		t.Errorf("wanted %v without a leeway, got %v", jwt.ErrExpired, err)
+	}
+}
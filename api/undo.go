@@ -0,0 +1,78 @@
+package api
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/errkind"
+)
+
+// undoWindow is how long after creating an expense or settlement it can be undone
+var undoWindow = flag.Duration("undo-window", 5*time.Minute, "time window in which the last action can be undone")
+
+type undoResponse struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+// postUndo deletes the authenticated user's most recently created expense or
+// settlement, provided it was created within the undo window. The balances of
+// all affected users are recalculated.
+func (api *API) postUndo(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	dbh := api.connect(userID)
+	defer dbh.Close()
+
+	action, err := dbh.GetLastAction(userID)
+	if err != nil {
+		switch errkind.Of(err) {
+		case errkind.NotFound:
+			log.Printf("Nothing to undo for user %d", userID)
+			writeError(w, http.StatusNotFound, "nothing to undo")
+			return
+		default:
+			panic(err)
+		}
+	}
+
+	if api.now().Sub(action.RecordedAt) > *undoWindow {
+		log.Printf("Last action for user %d is too old to undo", userID)
+		writeError(w, http.StatusForbidden, "the last action is too old to undo")
+		return
+	}
+
+	if action.Type == database.ActionExpense {
+		expense, err := dbh.GetExpense(action.ID)
+		if err != nil {
+			panic(err)
+		}
+		if isTooOldToEdit(expense, api.now()) {
+			log.Printf("Expense %d is too old to delete", action.ID)
+			writeError(w, http.StatusForbidden, "the expense is too old to delete")
+			return
+		}
+	}
+
+	log.Printf("Undoing %s %d for user %d", action.Type, action.ID, userID)
+
+	switch action.Type {
+	case database.ActionExpense:
+		dbh.DeleteExpense(action.ID)
+	case database.ActionSettlement:
+		dbh.DeleteSettlement(action.ID)
+	}
+
+	// Write through the balances of all affected users to the cache
+	for _, u := range action.Users {
+		api.updateBalance(dbh, u)
+	}
+
+	writeResponse(w, r, undoResponse{Type: string(action.Type), ID: action.ID})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// getBalance calls the GET balance API for userID and returns the decoded balance
+func getBalance(t *testing.T, api *API, userID int) ledger.Balance {
+	request, _ := http.NewRequest(http.MethodGet, "/balance", nil)
+	response := httptest.NewRecorder()
+	api.getBalance(response, request, userID)
+	var got ledger.Balance
+	err := json.NewDecoder(response.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	return got
+}
+
+// postUndo calls the POST undo API on behalf of userID
+func postUndo(api *API, userID int) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodPost, "/undo", nil)
+	response := httptest.NewRecorder()
+	api.postUndo(response, request, userID)
+	return response
+}
+
+func TestPostUndo(t *testing.T) {
+	// Create two expenses, undo the last one and ensure the balances of all
+	// involved users are restored
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	for _, amount := range []float64{10, 30} {
+		response := postExpense(api, userID1, createExpenseRequest{
+			Description: "Food",
+			Amount:      amount,
+			CreatedAt:   "2021-01-01T15:04:05Z",
+			Users:       []userID{{ID: userID2}},
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Unable create expense")
+		}
+	}
+
+	// User 2 can't undo user 1's expense
+	response := postUndo(api, userID2)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("wanted %d, got %d", http.StatusNotFound, response.Code)
+	}
+
+	response = postUndo(api, userID1)
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	for userID, wantedBalance := range map[int]float64{userID1: 5, userID2: -5} {
+		got := getBalance(t, api, userID)
+		if math.Abs(got.Balance-wantedBalance) > ledger.Epsilon {
+			t.Errorf("wanted %v,got %v", wantedBalance, got.Balance)
+		}
+	}
+}
+
+func TestPostUndoOutsideWindow(t *testing.T) {
+	// Ensure an action older than the undo window isn't undone
+
+	db := database.NewInMemoryDatabase()
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	makeFriends(dbh, userID1, userID2)
+
+	oldUndoWindow := *undoWindow
+	defer func() { *undoWindow = oldUndoWindow }()
+	*undoWindow = 0
+
+	response := postExpense(api, userID1, createExpenseRequest{
+		Description: "Food",
+		Amount:      10,
+		CreatedAt:   "2021-01-01T15:04:05Z",
+		Users:       []userID{{ID: userID2}},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unable create expense")
+	}
+
+	response = postUndo(api, userID1)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("wanted %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	if len(dbh.GetExpenses(userID1)) != 1 {
+		t.Errorf("expense was deleted")
+	}
+}
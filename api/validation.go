@@ -0,0 +1,45 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type validationErrorResponse struct {
+	Error  string       `json:"error"`  // All messages joined together
+	Errors []fieldError `json:"errors"` // The individual failures
+}
+
+// validationErrors collects all validation failures of a request, so that they
+// can be reported to the client in one response
+type validationErrors []fieldError
+
+// add records a validation failure for a field
+func (v *validationErrors) add(field string, message string) {
+	log.Printf("Invalid %s: %s", field, message)
+	*v = append(*v, fieldError{Field: field, Message: message})
+}
+
+// write writes a 400 with all validation failures. It returns false if there
+// are none and nothing has been written.
+func (v validationErrors) write(w http.ResponseWriter) bool {
+	if len(v) == 0 {
+		return false
+	}
+
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Message
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	writeJSON(w, validationErrorResponse{Error: strings.Join(messages, "; "), Errors: v})
+	return true
+}
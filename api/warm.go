@@ -0,0 +1,91 @@
+package api
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// cacheWarmWorkers is the number of balances calculated concurrently when
+// warming the cache
+var cacheWarmWorkers = flag.Int("cache-warm-workers", 4, "number of balances calculated concurrently when warming the cache")
+
+// cacheWarmTTL is how long warmed balances are cached. Balances written when
+// expenses change are cached for the cache's default TTL.
+var cacheWarmTTL = flag.Duration("cache-warm-ttl", time.Hour, "time balances written by warming the cache are cached")
+
+type cacheWarmResponse struct {
+	Users int `json:"users"` // Number of users whose balance has been cached
+}
+
+// userBalance is the balance of a user, calculated by a cache warming worker
+type userBalance struct {
+	userID  int
+	balance ledger.Balance
+}
+
+// warmCache calculates the balances of all users with a pool of workers and
+// writes them to the cache. It returns the number of users.
+func (api *API) warmCache(workers int) int {
+	dbh := api.db.Connect()
+	users := dbh.GetUsers(database.UsersQuery{})
+	dbh.Close()
+
+	userIDs := make(chan int)
+	balances := make(chan userBalance)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dbh := api.db.Connect()
+			defer dbh.Close()
+
+			for userID := range userIDs {
+				balance := database.WithNames(dbh, ledger.CalculateBalance(dbh.GetExpenses(userID), dbh.GetSettlements(userID), userID))
+				balance.ComputedAt = api.now().UTC()
+				balances <- userBalance{userID: userID, balance: balance}
+			}
+		}()
+	}
+
+	go func() {
+		for _, u := range users {
+			userIDs <- u.ID
+		}
+		close(userIDs)
+		wg.Wait()
+		close(balances)
+	}()
+
+	// The workers only calculate, the balances are written to the cache from here
+	for b := range balances {
+		api.cache.SetBalance(b.balance, b.userID, *cacheWarmTTL)
+	}
+
+	return len(users)
+}
+
+// postCacheWarm writes the balances of all users to the cache, e.g. after it
+// has been flushed. Only for administrators.
+func (api *API) postCacheWarm(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	workers := *cacheWarmWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	count := api.warmCache(workers)
+	log.Printf("User %d warmed the cache with the balances of %d users", userID, count)
+	writeResponse(w, r, cacheWarmResponse{Users: count})
+}
@@ -0,0 +1,72 @@
+//go:build integration
+// +build integration
+
+package api
+
+// Integration tests against a real redis, started in a docker container. Run
+// them with:
+//
+//	go test -tags integration ./api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// startRedis starts a redis container and returns its address. The container
+// is terminated when the test finishes.
+func startRedis(t *testing.T) string {
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:6",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections").WithStartupTimeout(time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("Unable to start redis: %v", err)
+	}
+	t.Cleanup(func() { container.Terminate(ctx) })
+
+	endpoint, err := container.Endpoint(ctx, "")
+	if err != nil {
+		t.Fatalf("Unable to get redis address: %v", err)
+	}
+	return endpoint
+}
+
+func TestRedisCacheWarmTTL(t *testing.T) {
+	// Warmed balances are cached in redis for the warm TTL, not the short TTL of
+	// balances written when expenses change
+
+	addr := startRedis(t)
+	db := database.NewInMemoryDatabase()
+	api := NewAPI(db, cache.NewRedisCache(cache.Config{Addr: addr, KeyPrefix: "splitter:", HealthWindow: cache.DefaultHealthWindow, ErrorThreshold: cache.DefaultErrorThreshold}))
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+
+	if count := api.warmCache(1); count != 1 {
+		t.Fatalf("wanted 1 user, got %d", count)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+	ttl, err := rdb.TTL(context.Background(), "splitter:balance:1").Result()
+	if err != nil {
+		t.Fatalf("Unable to get the TTL: %v", err)
+	}
+	if ttl <= *cacheWarmTTL-time.Minute || ttl > *cacheWarmTTL {
+		t.Errorf("wanted the balance of user %d cached for %v, got %v", userID1, *cacheWarmTTL, ttl)
+	}
+}
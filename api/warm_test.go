@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+	"github.com/freewilll/splitter/testutil"
+)
+
+func TestPostCacheWarm(t *testing.T) {
+	// Warm an empty cache and ensure every user has a cached balance, by making
+	// the database unavailable before reading the balances
+
+	db := testutil.NewMockDatabase(database.NewInMemoryDatabase())
+	cache := cache.NewInMemoryCache()
+	api := NewAPI(db, cache)
+
+	dbh := db.Connect()
+	userIDs := make([]int, 0)
+	for _, email := range []string{"test1@getstream.io", "test2@getstream.io", "test3@getstream.io", "test4@getstream.io"} {
+		id, _ := dbh.CreateUser(email, "secret")
+		userIDs = append(userIDs, id)
+	}
+	makeFriends(dbh, userIDs...)
+	dbh.SetAdmin(userIDs[0], true)
+	now := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	api.now = func() time.Time { return now }
+
+	// Bypass the API, so that the cache remains empty
+	dbh.CreateExpense(ledger.Expense{
+		OwnerID:     userIDs[0],
+		Users:       []int{userIDs[1], userIDs[2]},
+		Amount:      42,
+		Description: "Dinner",
+		CreatedAt:   time.Now(),
+	})
+
+	request, _ := http.NewRequest(http.MethodPost, "/cache/warm", nil)
+	response := httptest.NewRecorder()
+	api.requireAdmin(api.postCacheWarm)(response, request, userIDs[0])
+	if response.Code != http.StatusOK {
+		t.Fatalf("wanted %d, got %d", http.StatusOK, response.Code)
+	}
+
+	var got cacheWarmResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%v'", err)
+	}
+	if got.Users != len(userIDs) {
+		t.Errorf("wanted %d users, got %d", len(userIDs), got.Users)
+	}
+
+	db.Fail("Connect", errInjected)
+	wanted := []float64{28, -14, -14, 0}
+	for i, id := range userIDs {
+		if balance := cache.GetBalance(db, id); balance.Balance != wanted[i] || !balance.ComputedAt.Equal(now) {
+			t.Errorf("user %d: wanted balance %v computed at %s, got %+v", id, wanted[i], now, balance)
+		}
+	}
+
+	// The time the balance was computed is returned to the client
+	db.Reset("Connect")
+	if balance := getBalance(t, api, userIDs[0]); !balance.ComputedAt.Equal(now) {
+		t.Errorf("wanted computed_at %s, got %s", now, balance.ComputedAt)
+	}
+}
+
+func TestPostCacheWarmTTL(t *testing.T) {
+	// Warmed balances are cached until the warm TTL has passed
+
+	db := database.NewInMemoryDatabase()
+	memoryCache := cache.NewInMemoryCache().(*cache.InMemoryCache)
+	api := NewAPI(db, memoryCache)
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	memoryCache.Now = func() time.Time { return now }
+
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	api.warmCache(1)
+
+	for _, test := range []struct {
+		Elapsed   time.Duration
+		FromCache bool
+	}{
+		{time.Minute, true},
+		{*cacheWarmTTL - time.Second, true},
+		{*cacheWarmTTL, false},
+	} {
+		memoryCache.Now = func() time.Time { return now.Add(test.Elapsed) }
+		if balance := memoryCache.GetBalance(db, userID1); balance.FromCache != test.FromCache {
+			t.Errorf("after %v: wanted from cache %v, got %+v", test.Elapsed, test.FromCache, balance)
+		}
+		if !test.FromCache {
+			break
+		}
+	}
+}
@@ -1,12 +1,39 @@
 package cache
 
 import (
+	"time"
+
 	"github.com/freewilll/splitter/database"
 	"github.com/freewilll/splitter/ledger"
 )
 
-// Cache is an interface used for caching the ledger's balance
+// Cache is an interface used for caching the ledger's balance, counting
+// failed sign in attempts and keeping track of signed in sessions
 type Cache interface {
-	SetBalance(balance ledger.Balance, userID int)
+	SetBalance(balance ledger.Balance, userID int, ttl time.Duration) // Cache a balance for ttl, the cache's default if zero
 	GetBalance(db database.Database, userID int) ledger.Balance
+	GetBalances(db database.Database, userIDs []int) map[int]ledger.Balance // Get several balances at once
+	DeleteBalance(userID int)
+	PruneBalances(before time.Time) int // Delete balances computed before a time and expired ones, returning how many
+
+	GetFailedLogins(email string) int                      // Number of consecutive failed sign ins
+	RecordFailedLogin(email string, ttl time.Duration) int // Increment and expire after ttl
+	ResetFailedLogins(email string)                        // Forget all failed sign ins
+
+	AddSession(userID int, tokenID string, ttl time.Duration) // Add a valid token id, expiring after ttl
+	IsValidSession(userID int, tokenID string) bool           // Check if a token id is valid
+	RevokeSessions(userID int, except string)                 // Revoke all token ids except one
+
+	Healthy() bool // False while the cache is degraded
+}
+
+// calculateBalance calculates the balance of userID from the database at now,
+// for a balance missing from the cache. The names of the other users are cached
+// with it.
+func calculateBalance(dbh database.Handle, userID int, now time.Time) ledger.Balance {
+	expenses := dbh.GetExpenses(userID)
+	settlements := dbh.GetSettlements(userID)
+	balance := database.WithNames(dbh, ledger.CalculateBalance(expenses, settlements, userID))
+	balance.ComputedAt = now.UTC()
+	return balance
 }
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// Defaults of the health configuration
+const (
+	DefaultHealthWindow   = 20
+	DefaultErrorThreshold = 0.5
+)
+
+// health tracks the outcomes of the most recent calls to redis. The cache is
+// degraded while the share of failed calls among them is above the threshold,
+// and recovers as successful calls push the failures out of the window.
+type health struct {
+	mutex     sync.Mutex
+	failed    []bool // Ring buffer of outcomes, true for a failed call
+	next      int    // Index of the oldest outcome once the buffer is full
+	errors    int    // Number of failed calls in the buffer
+	threshold float64
+}
+
+// newHealth returns the health of a cache considering the last window calls, of
+// which there must be at least one
+func newHealth(window int, threshold float64) *health {
+	if window < 1 {
+		panic(fmt.Sprintf("health window must be at least 1, got %d", window))
+	}
+	return &health{failed: make([]bool, 0, window), threshold: threshold}
+}
+
+// record records the outcome of a call. A missing key isn't a failure.
+func (h *health) record(err error) {
+	failed := err != nil && !errors.Is(err, redis.Nil)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if failed {
+		h.errors++
+	}
+	if len(h.failed) < cap(h.failed) {
+		h.failed = append(h.failed, failed)
+		return
+	}
+	if h.failed[h.next] {
+		h.errors--
+	}
+	h.failed[h.next] = failed
+	h.next = (h.next + 1) % len(h.failed)
+}
+
+// healthy returns false if the error rate of the recorded calls is above the
+// threshold
+func (h *health) healthy() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.failed) == 0 {
+		return true
+	}
+	return float64(h.errors)/float64(len(h.failed)) <= h.threshold
+}
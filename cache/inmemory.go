@@ -1,28 +1,203 @@
 package cache
 
 import (
+	"sync"
+	"time"
+
 	"github.com/freewilll/splitter/database"
 	"github.com/freewilll/splitter/ledger"
 )
 
-// InMemoryCache implements the Cache interface for an in memory cache
+// InMemoryCache implements the Cache interface for an in memory cache. It's safe
+// for concurrent use.
 type InMemoryCache struct {
-	entries map[int]ledger.Balance
+	mutex        sync.Mutex // Protects the maps, not held while calculating balances
+	entries      map[int]cachedBalance
+	failedLogins map[string]failedLogins
+	sessions     map[int]map[string]time.Time // Expiry time of token ids per user
+
+	Now func() time.Time // Returns the current time, replaced in tests
+}
+
+// cachedBalance is a balance in the cache, that never expires if expiresAt is
+// zero
+type cachedBalance struct {
+	balance   ledger.Balance
+	expiresAt time.Time
+}
+
+// failedLogins is the number of failed sign ins for an email
+type failedLogins struct {
+	count     int
+	expiresAt time.Time
 }
 
 // NewInMemoryCache creates an instance of InMemoryCache
 func NewInMemoryCache() Cache {
 	cache := new(InMemoryCache)
-	cache.entries = make(map[int]ledger.Balance)
+	cache.entries = make(map[int]cachedBalance)
+	cache.failedLogins = make(map[string]failedLogins)
+	cache.sessions = make(map[int]map[string]time.Time)
+	cache.Now = time.Now
 	return cache
 }
 
-// SetBalance sets the userID/balance key/value
-func (c *InMemoryCache) SetBalance(balance ledger.Balance, userID int) {
-	c.entries[userID] = balance
+// SetBalance sets the userID/balance key/value. It expires after ttl, or never
+// if ttl is zero.
+func (c *InMemoryCache) SetBalance(balance ledger.Balance, userID int, ttl time.Duration) {
+	entry := cachedBalance{balance: balance}
+	if ttl > 0 {
+		entry.expiresAt = c.Now().Add(ttl)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[userID] = entry
+}
+
+// lookup returns the cached balance of userID, if it hasn't expired
+func (c *InMemoryCache) lookup(userID int) (ledger.Balance, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exists := c.entries[userID]
+	if !exists || (!entry.expiresAt.IsZero() && !c.Now().Before(entry.expiresAt)) {
+		return ledger.Balance{}, false
+	}
+	entry.balance.FromCache = true
+	return entry.balance, true
+}
+
+// GetBalance gets the userID/balance key/value. If the key doesn't exist, the
+// expenses are read from the database, calculated and then written to the cache.
+func (c *InMemoryCache) GetBalance(db database.Database, userID int) ledger.Balance {
+	if balance, exists := c.lookup(userID); exists {
+		return balance
+	}
+
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	balance := calculateBalance(dbh, userID, c.Now())
+	c.SetBalance(balance, userID, 0)
+
+	return balance
+}
+
+// GetBalances gets the balances of several users. Missing balances are
+// calculated with a single database connection and written to the cache.
+func (c *InMemoryCache) GetBalances(db database.Database, userIDs []int) map[int]ledger.Balance {
+	balances := make(map[int]ledger.Balance, len(userIDs))
+	var dbh database.Handle
+	for _, userID := range userIDs {
+		if balance, exists := c.lookup(userID); exists {
+			balances[userID] = balance
+			continue
+		}
+
+		if dbh == nil {
+			dbh = db.Connect()
+			defer dbh.Close()
+		}
+		balance := calculateBalance(dbh, userID, c.Now())
+		c.SetBalance(balance, userID, 0)
+		balances[userID] = balance
+	}
+	return balances
+}
+
+// DeleteBalance deletes the userID/balance key/value
+func (c *InMemoryCache) DeleteBalance(userID int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, userID)
+}
+
+// PruneBalances deletes the balances computed before a time, as well as expired
+// ones, which are otherwise only skipped. It returns how many were deleted.
+func (c *InMemoryCache) PruneBalances(before time.Time) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.Now()
+	pruned := 0
+	for userID, entry := range c.entries {
+		if entry.balance.ComputedAt.Before(before) || (!entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)) {
+			delete(c.entries, userID)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// GetFailedLogins returns the number of consecutive failed sign ins for an email
+func (c *InMemoryCache) GetFailedLogins(email string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.failedLoginCount(email)
+}
+
+// failedLoginCount returns the number of failed sign ins for an email. The mutex
+// must be held.
+func (c *InMemoryCache) failedLoginCount(email string) int {
+	f, exists := c.failedLogins[email]
+	if !exists || c.Now().After(f.expiresAt) {
+		return 0
+	}
+	return f.count
+}
+
+// RecordFailedLogin increments the number of failed sign ins for an email. The
+// count expires after ttl.
+func (c *InMemoryCache) RecordFailedLogin(email string, ttl time.Duration) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count := c.failedLoginCount(email) + 1
+	c.failedLogins[email] = failedLogins{count: count, expiresAt: c.Now().Add(ttl)}
+	return count
+}
+
+// ResetFailedLogins forgets the failed sign ins for an email
+func (c *InMemoryCache) ResetFailedLogins(email string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.failedLogins, email)
+}
+
+// AddSession adds a valid token id for a user, that expires after ttl
+func (c *InMemoryCache) AddSession(userID int, tokenID string, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.sessions[userID] == nil {
+		c.sessions[userID] = make(map[string]time.Time)
+	}
+	c.sessions[userID][tokenID] = c.Now().Add(ttl)
+}
+
+// IsValidSession checks if a token id is valid for a user
+func (c *InMemoryCache) IsValidSession(userID int, tokenID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expiresAt, exists := c.sessions[userID][tokenID]
+	return exists && c.Now().Before(expiresAt)
+}
+
+// RevokeSessions revokes all token ids of a user, except one
+func (c *InMemoryCache) RevokeSessions(userID int, except string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for tokenID := range c.sessions[userID] {
+		if tokenID != except {
+			delete(c.sessions[userID], tokenID)
+		}
+	}
 }
 
-// GetBalance gets the userID/balance key/value
-func (c *InMemoryCache) GetBalance(_ database.Database, userID int) ledger.Balance {
-	return c.entries[userID]
+// Healthy returns true, an in memory cache can't be degraded
+func (c *InMemoryCache) Healthy() bool {
+	return true
 }
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+func TestInMemoryPruneBalances(t *testing.T) {
+	// Balances computed before the retention window and expired ones are
+	// deleted, recent ones are kept, also if they never expire
+
+	now := time.Now().UTC()
+	c := NewInMemoryCache().(*InMemoryCache)
+	c.Now = func() time.Time { return now }
+
+	c.SetBalance(ledger.Balance{Balance: 1, ComputedAt: now.Add(-2 * time.Hour)}, 1, 0)
+	c.SetBalance(ledger.Balance{Balance: 2, ComputedAt: now}, 2, 0)
+	c.SetBalance(ledger.Balance{Balance: 3, ComputedAt: now}, 3, time.Minute)
+	c.SetBalance(ledger.Balance{Balance: 4, ComputedAt: now}, 4, time.Hour)
+
+	c.Now = func() time.Time { return now.Add(2 * time.Minute) }
+	if pruned := c.PruneBalances(now.Add(-time.Hour)); pruned != 2 {
+		t.Errorf("wanted 2 pruned balances, got %d", pruned)
+	}
+
+	for userID, wanted := range map[int]bool{1: false, 2: true, 3: false, 4: true} {
+		if _, exists := c.entries[userID]; exists != wanted {
+			t.Errorf("user %d: wanted cached %t, got %t", userID, wanted, exists)
+		}
+	}
+}
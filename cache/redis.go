@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -15,42 +16,94 @@ import (
 
 // Config is the redis configuration
 type Config struct {
-	Addr     string
-	Password string
-	Db       int
+	Addr      string
+	Password  string
+	Db        int
+	KeyPrefix string // Prepended to all keys, to share a redis instance with other applications
+
+	HealthWindow   int     // Number of recent calls the error rate is calculated over
+	ErrorThreshold float64 // Error rate above which the cache is degraded
 }
 
 var ctx = context.Background()
 
 var cacheEntryTTL = 5 * time.Second
 
+// pruneBatchSize is the number of balances read at once when pruning
+const pruneBatchSize = 100
+
+// redisClient is the part of a redis client used by the cache, so that it can
+// be replaced in tests
+type redisClient interface {
+	redis.Cmdable
+	Close() error
+}
+
 // RedisCache implements the Cache interface for redis
 type RedisCache struct {
 	config Config
+	health *health
+	dial   func(options *redis.Options) redisClient
+
+	Now func() time.Time // Returns the current time, replaced in tests
 }
 
 // NewRedisCache creates an instance of RedisCache
 func NewRedisCache(config Config) Cache {
-	return new(RedisCache)
-
+	return RedisCache{
+		config: config,
+		health: newHealth(config.HealthWindow, config.ErrorThreshold),
+		dial:   func(options *redis.Options) redisClient { return redis.NewClient(options) },
+		Now:    time.Now,
+	}
 }
 
 // connect returns a Redis client
-func (r RedisCache) connect() *redis.Client {
-	return redis.NewClient(&redis.Options{
+func (r RedisCache) connect() redisClient {
+	return r.dial(&redis.Options{
 		Addr:     r.config.Addr,
 		Password: r.config.Password,
 		DB:       r.config.Db,
 	})
 }
 
+// check records the outcome of a redis call in the health of the cache and
+// panics if the call failed
+func (r RedisCache) check(err error) {
+	r.health.record(err)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Healthy returns false while the cache is degraded, i.e. too many recent
+// calls to redis have failed
+func (r RedisCache) Healthy() bool {
+	return r.health.healthy()
+}
+
 // makeKey makes a key from a userID
 func (r RedisCache) makeKey(userID int) string {
-	return fmt.Sprintf("key-%d", userID)
+	return fmt.Sprintf("%sbalance:%d", r.config.KeyPrefix, userID)
+}
+
+// makeFailedLoginsKey makes a key for the failed sign ins of an email
+func (r RedisCache) makeFailedLoginsKey(email string) string {
+	return fmt.Sprintf("%sfailed-logins:%s", r.config.KeyPrefix, email)
 }
 
-// setBalanceWithRdb writes the balance to redis for a userID
-func (r RedisCache) setBalanceWithRdb(rdb *redis.Client, balance ledger.Balance, userID int) {
+// makeSessionsKey makes a key for the set of valid token ids of a user
+func (r RedisCache) makeSessionsKey(userID int) string {
+	return fmt.Sprintf("%ssessions:%d", r.config.KeyPrefix, userID)
+}
+
+// setBalanceWithRdb writes the balance to redis for a userID, expiring after
+// ttl or cacheEntryTTL if it's zero
+func (r RedisCache) setBalanceWithRdb(rdb redisClient, balance ledger.Balance, userID int, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = cacheEntryTTL
+	}
+
 	key := r.makeKey(userID)
 
 	value, err := json.Marshal(balance)
@@ -58,47 +111,239 @@ func (r RedisCache) setBalanceWithRdb(rdb *redis.Client, balance ledger.Balance,
 		panic(err)
 	}
 
-	err = rdb.Set(ctx, key, value, cacheEntryTTL).Err()
-	if err != nil {
-		panic(err)
-	}
+	r.check(rdb.Set(ctx, key, value, ttl).Err())
 }
 
-// SetBalance sets the userID/balance key/value in redis
-func (r RedisCache) SetBalance(balance ledger.Balance, userID int) {
+// SetBalance sets the userID/balance key/value in redis, expiring after ttl or
+// cacheEntryTTL if it's zero
+func (r RedisCache) SetBalance(balance ledger.Balance, userID int, ttl time.Duration) {
 	rdb := r.connect()
 	defer rdb.Close()
-	r.setBalanceWithRdb(rdb, balance, userID)
+	r.setBalanceWithRdb(rdb, balance, userID, ttl)
 }
 
 // GetBalance gets the userID/balance key/value in redis. If the key doesn't exist,
 // the expenses are read from the database, calculated and then written to the cache.
 // A TTL ensures data doesn't remain stail in case of race conditions writing the
-// data concurrently.
+// data concurrently. A malformed balance in the cache is deleted and calculated
+// again.
 func (r RedisCache) GetBalance(db database.Database, userID int) ledger.Balance {
 	rdb := r.connect()
 	defer rdb.Close()
 
 	key := r.makeKey(userID)
 	val, err := rdb.Get(ctx, key).Result()
-	if err == redis.Nil {
-		dbh := db.Connect()
-		defer dbh.Close()
-
-		expenses := dbh.GetExpenses(userID)
-		balance := ledger.CalculateBalance(expenses, userID)
-		r.setBalanceWithRdb(rdb, balance, userID)
+	r.health.record(err)
+	if err == nil {
+		var balance ledger.Balance
+		err := json.Unmarshal([]byte(val), &balance)
+		if err == nil {
+			balance.FromCache = true
+			return balance
+		}
 
-		return balance
-	} else if err != nil {
+		log.Printf("WARNING: malformed balance of user %d in the cache, recalculating it: %v", userID, err)
+		r.check(rdb.Del(ctx, key).Err())
+	} else if !errors.Is(err, redis.Nil) {
 		panic(err)
-	} else {
+	}
+
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	balance := calculateBalance(dbh, userID, r.Now())
+	r.setBalanceWithRdb(rdb, balance, userID, 0)
+
+	return balance
+}
+
+// GetBalances gets the balances of several users with a single MGET. Missing and
+// malformed balances are calculated with a single database connection and
+// written to redis in one pipeline.
+func (r RedisCache) GetBalances(db database.Database, userIDs []int) map[int]ledger.Balance {
+	balances := make(map[int]ledger.Balance, len(userIDs))
+	if len(userIDs) == 0 {
+		return balances
+	}
+
+	rdb := r.connect()
+	defer rdb.Close()
+
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = r.makeKey(userID)
+	}
+	vals, err := rdb.MGet(ctx, keys...).Result()
+	r.check(err)
+
+	var missing []int
+	for i, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			missing = append(missing, userIDs[i])
+			continue
+		}
+
 		var balance ledger.Balance
-		err := json.Unmarshal([]byte(val), &balance)
+		if err := json.Unmarshal([]byte(s), &balance); err != nil {
+			log.Printf("WARNING: malformed balance of user %d in the cache, recalculating it: %v", userIDs[i], err)
+			missing = append(missing, userIDs[i])
+			continue
+		}
+		balance.FromCache = true
+		balances[userIDs[i]] = balance
+	}
+	if len(missing) == 0 {
+		return balances
+	}
+
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	pipe := rdb.Pipeline()
+	for _, userID := range missing {
+		balance := calculateBalance(dbh, userID, r.Now())
+		balances[userID] = balance
+
+		value, err := json.Marshal(balance)
 		if err != nil {
-			log.Fatalf("Unable to decode and parse json from cache")
+			panic(err)
+		}
+		pipe.Set(ctx, r.makeKey(userID), value, cacheEntryTTL)
+	}
+	_, err = pipe.Exec(ctx)
+	r.check(err)
+
+	return balances
+}
+
+// DeleteBalance deletes the userID/balance key/value in redis
+func (r RedisCache) DeleteBalance(userID int) {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	r.check(rdb.Del(ctx, r.makeKey(userID)).Err())
+}
+
+// PruneBalances deletes the balances in redis computed before a time, as well as
+// malformed ones. Expired balances are already deleted by redis. It returns how
+// many were deleted.
+func (r RedisCache) PruneBalances(before time.Time) int {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	var keys []string
+	iter := rdb.Scan(ctx, 0, r.config.KeyPrefix+"balance:*", pruneBatchSize).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	r.check(iter.Err())
+
+	pruned := 0
+	for start := 0; start < len(keys); start += pruneBatchSize {
+		end := start + pruneBatchSize
+		if end > len(keys) {
+			end = len(keys)
 		}
+		batch := keys[start:end]
+
+		vals, err := rdb.MGet(ctx, batch...).Result()
+		r.check(err)
+
+		var stale []string
+		for i, val := range vals {
+			s, ok := val.(string)
+			if !ok {
+				continue // Expired since the scan
+			}
+			var balance ledger.Balance
+			if err := json.Unmarshal([]byte(s), &balance); err != nil || balance.ComputedAt.Before(before) {
+				stale = append(stale, batch[i])
+			}
+		}
+		if len(stale) > 0 {
+			r.check(rdb.Del(ctx, stale...).Err())
+			pruned += len(stale)
+		}
+	}
+	return pruned
+}
+
+// GetFailedLogins returns the number of consecutive failed sign ins for an email
+func (r RedisCache) GetFailedLogins(email string) int {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	cmd := rdb.Get(ctx, r.makeFailedLoginsKey(email))
+	r.health.record(cmd.Err())
+	count, err := cmd.Int()
+	if errors.Is(err, redis.Nil) {
+		return 0
+	} else if err != nil {
+		panic(err)
+	}
+	return count
+}
+
+// RecordFailedLogin increments the number of failed sign ins for an email. The
+// count expires after ttl.
+func (r RedisCache) RecordFailedLogin(email string, ttl time.Duration) int {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	key := r.makeFailedLoginsKey(email)
+	pipe := rdb.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	r.check(err)
+	return int(incr.Val())
+}
+
+// ResetFailedLogins forgets the failed sign ins for an email
+func (r RedisCache) ResetFailedLogins(email string) {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	r.check(rdb.Del(ctx, r.makeFailedLoginsKey(email)).Err())
+}
+
+// AddSession adds a valid token id for a user. Since all token ids of a user
+// are stored in one set, the set expires after the ttl of the most recent one.
+func (r RedisCache) AddSession(userID int, tokenID string, ttl time.Duration) {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	key := r.makeSessionsKey(userID)
+	pipe := rdb.TxPipeline()
+	pipe.SAdd(ctx, key, tokenID)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	r.check(err)
+}
 
-		return balance
+// IsValidSession checks if a token id is valid for a user
+func (r RedisCache) IsValidSession(userID int, tokenID string) bool {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	valid, err := rdb.SIsMember(ctx, r.makeSessionsKey(userID), tokenID).Result()
+	r.check(err)
+	return valid
+}
+
+// RevokeSessions revokes all token ids of a user, except one
+func (r RedisCache) RevokeSessions(userID int, except string) {
+	rdb := r.connect()
+	defer rdb.Close()
+
+	key := r.makeSessionsKey(userID)
+	tokenIDs, err := rdb.SMembers(ctx, key).Result()
+	r.check(err)
+
+	for _, tokenID := range tokenIDs {
+		if tokenID != except {
+			r.check(rdb.SRem(ctx, key, tokenID).Err())
+		}
 	}
 }
@@ -0,0 +1,141 @@
+//go:build integration
+// +build integration
+
+package cache
+
+// Integration tests against a real redis, started in a docker container. Run
+// them with:
+//
+//	go test -tags integration ./cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// startRedis starts a redis container and returns a cache using it. The
+// container is terminated when the test finishes.
+func startRedis(t *testing.T) RedisCache {
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:6",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections").WithStartupTimeout(time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("Unable to start redis: %v", err)
+	}
+	t.Cleanup(func() { container.Terminate(ctx) })
+
+	endpoint, err := container.Endpoint(ctx, "")
+	if err != nil {
+		t.Fatalf("Unable to get redis address: %v", err)
+	}
+	return NewRedisCache(Config{Addr: endpoint, KeyPrefix: "splitter:", HealthWindow: DefaultHealthWindow, ErrorThreshold: DefaultErrorThreshold}).(RedisCache)
+}
+
+func TestRedisMalformedBalance(t *testing.T) {
+	// A malformed balance in the cache is replaced by one calculated from the
+	// database, instead of bringing the server down
+
+	r := startRedis(t)
+
+	db := database.NewInMemoryDatabase()
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	dbh.CreateExpense(ledger.Expense{OwnerID: userID1, Users: []int{userID2}, Amount: 42, CreatedAt: time.Now()})
+
+	rdb := r.connect()
+	defer rdb.Close()
+	if err := rdb.Set(ctx, r.makeKey(userID1), "{not json", 0).Err(); err != nil {
+		t.Fatalf("Unable to write to redis: %v", err)
+	}
+
+	if balance := r.GetBalance(db, userID1); balance.Balance != 21 || balance.FromCache {
+		t.Errorf("wanted a calculated balance 21, got %+v", balance)
+	}
+
+	// The recalculated balance is cached again
+	dbh.CreateExpense(ledger.Expense{OwnerID: userID1, Users: []int{userID2}, Amount: 10, CreatedAt: time.Now()})
+	if balance := r.GetBalance(db, userID1); balance.Balance != 21 || !balance.FromCache {
+		t.Errorf("wanted the cached balance 21, got %+v", balance)
+	}
+}
+
+func TestRedisGetBalances(t *testing.T) {
+	// Cached, missing and malformed balances are returned in one batch and the
+	// missing ones are written to the cache
+
+	r := startRedis(t)
+
+	db := database.NewInMemoryDatabase()
+	dbh := db.Connect()
+	userID1, _ := dbh.CreateUser("test1@getstream.io", "secret")
+	userID2, _ := dbh.CreateUser("test2@getstream.io", "secret")
+	userID3, _ := dbh.CreateUser("test3@getstream.io", "secret")
+	dbh.CreateExpense(ledger.Expense{OwnerID: userID1, Users: []int{userID2, userID3}, Amount: 30, CreatedAt: time.Now()})
+
+	r.SetBalance(ledger.Balance{Balance: 42}, userID1, 0)
+	rdb := r.connect()
+	defer rdb.Close()
+	if err := rdb.Set(ctx, r.makeKey(userID2), "{not json", 0).Err(); err != nil {
+		t.Fatalf("Unable to write to redis: %v", err)
+	}
+
+	balances := r.GetBalances(db, []int{userID1, userID2, userID3})
+	want := map[int]float64{userID1: 42, userID2: -10, userID3: -10}
+	for userID, amount := range want {
+		if balances[userID].Balance != amount {
+			t.Errorf("user %d: wanted balance %0.2f, got %+v", userID, amount, balances[userID])
+		}
+	}
+
+	if n, err := rdb.Exists(ctx, r.makeKey(userID3)).Result(); err != nil || n != 1 {
+		t.Errorf("wanted the balance of user %d in the cache, got %d, %v", userID3, n, err)
+	}
+}
+
+func TestRedisPruneBalances(t *testing.T) {
+	// Balances computed before the retention window and malformed ones are
+	// deleted, recent ones and other keys are kept
+
+	r := startRedis(t)
+	now := time.Now().UTC()
+	r.SetBalance(ledger.Balance{Balance: 1, ComputedAt: now.Add(-2 * time.Hour)}, 1, time.Hour)
+	r.SetBalance(ledger.Balance{Balance: 2, ComputedAt: now}, 2, time.Hour)
+	r.AddSession(1, "token", time.Hour)
+
+	rdb := r.connect()
+	defer rdb.Close()
+	if err := rdb.Set(ctx, r.makeKey(3), "{not json", time.Hour).Err(); err != nil {
+		t.Fatalf("Unable to write to redis: %v", err)
+	}
+
+	if pruned := r.PruneBalances(now.Add(-time.Hour)); pruned != 2 {
+		t.Errorf("wanted 2 pruned balances, got %d", pruned)
+	}
+	for _, test := range []struct {
+		Key    string
+		Wanted int64
+	}{
+		{r.makeKey(1), 0},
+		{r.makeKey(2), 1},
+		{r.makeKey(3), 0},
+		{r.makeSessionsKey(1), 1},
+	} {
+		if n, err := rdb.Exists(ctx, test.Key).Result(); err != nil || n != test.Wanted {
+			t.Errorf("%s: wanted %d, got %d, %v", test.Key, test.Wanted, n, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestRedisKeyPrefix(t *testing.T) {
+	// The configured prefix is prepended to all keys
+
+	r := NewRedisCache(Config{KeyPrefix: "splitter:", HealthWindow: DefaultHealthWindow}).(RedisCache)
+
+	tests := []struct {
+		Got    string
+		Wanted string
+	}{
+		{r.makeKey(42), "splitter:balance:42"},
+		{r.makeFailedLoginsKey("test1@getstream.io"), "splitter:failed-logins:test1@getstream.io"},
+		{r.makeSessionsKey(42), "splitter:sessions:42"},
+	}
+
+	for _, test := range tests {
+		if test.Got != test.Wanted {
+			t.Errorf("wanted %s, got %s", test.Wanted, test.Got)
+		}
+	}
+}
+
+// stubClient is a redis client whose GET fails with err, or else finds value or
+// no key if it's empty
+type stubClient struct {
+	redis.Cmdable
+	err   *error
+	value string
+}
+
+func (s stubClient) Get(ctx context.Context, key string) *redis.StringCmd {
+	if *s.err == nil && s.value != "" {
+		return redis.NewStringResult(s.value, nil)
+	}
+	cmd := redis.NewStringCmd(ctx, "get", key)
+	if *s.err != nil {
+		cmd.SetErr(*s.err)
+	} else {
+		cmd.SetErr(redis.Nil)
+	}
+	return cmd
+}
+
+func (s stubClient) Close() error {
+	return nil
+}
+
+func TestRedisHealth(t *testing.T) {
+	// The cache is degraded when more than the threshold of the recent calls
+	// fail and recovers after successful calls
+
+	var err error
+	r := NewRedisCache(Config{HealthWindow: 4, ErrorThreshold: 0.5}).(RedisCache)
+	r.dial = func(options *redis.Options) redisClient { return stubClient{err: &err} }
+
+	call := func() {
+		defer func() { recover() }()
+		r.GetFailedLogins("test1@getstream.io")
+	}
+
+	errDown := errors.New("connection refused")
+	tests := []struct {
+		Err     error
+		Healthy bool
+	}{
+		{nil, true},
+		{errDown, true},   // 1 of 2
+		{errDown, false},  // 2 of 3
+		{errDown, false},  // 3 of 4
+		{nil, false},      // 3 of 4, the first success dropped
+		{nil, true},       // 2 of 4
+		{nil, true},       // 1 of 4
+		{errDown, true},   // 1 of 4
+		{redis.Nil, true}, // A missing key isn't a failure
+		{errDown, true},   // 2 of 4
+		{errDown, false},  // 3 of 4
+	}
+
+	if !r.Healthy() {
+		t.Fatalf("wanted a new cache to be healthy")
+	}
+	for i, test := range tests {
+		err = test.Err
+		call()
+		if got := r.Healthy(); got != test.Healthy {
+			t.Errorf("%d: after %v, wanted healthy %v, got %v", i, test.Err, test.Healthy, got)
+		}
+	}
+}
+
+func TestRedisHealthMalformedValue(t *testing.T) {
+	// A malformed value is a bug rather than a failure of redis, so it doesn't
+	// degrade the cache, even when any failure would
+
+	var err error
+	r := NewRedisCache(Config{HealthWindow: 1, ErrorThreshold: 0}).(RedisCache)
+	r.dial = func(options *redis.Options) redisClient { return stubClient{err: &err, value: "many"} }
+
+	func() {
+		defer func() { recover() }()
+		r.GetFailedLogins("test1@getstream.io")
+		t.Errorf("wanted a malformed count to panic")
+	}()
+
+	if !r.Healthy() {
+		t.Errorf("wanted the cache to stay healthy")
+	}
+}
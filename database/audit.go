@@ -0,0 +1,307 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+// AuditAction is the kind of mutation recorded in the audit log
+type AuditAction string
+
+// Mutations recorded in the audit log
+const (
+	AuditCreateExpense    AuditAction = "create_expense"
+	AuditUpdateExpense    AuditAction = "update_expense"
+	AuditTagExpense       AuditAction = "tag_expense"
+	AuditDeleteExpense    AuditAction = "delete_expense"
+	AuditCreateSettlement AuditAction = "create_settlement"
+	AuditDeleteSettlement AuditAction = "delete_settlement"
+	AuditSetName          AuditAction = "set_name"
+	AuditSetSettings      AuditAction = "set_settings"
+	AuditDeleteUser       AuditAction = "delete_user"
+	AuditSetAdmin         AuditAction = "set_admin"
+	AuditMergeUsers       AuditAction = "merge_users"
+	AuditCreateUser       AuditAction = "create_user"
+	AuditChangePassword   AuditAction = "change_password"
+	AuditRequestFriend    AuditAction = "request_friend"
+	AuditSetEmail         AuditAction = "set_email"
+	AuditCreateGroup      AuditAction = "create_group"
+)
+
+// AuditEntry is an entry in the append-only audit log of mutations
+type AuditEntry struct {
+	ID        int         // Id of the entry
+	UserID    int         // User id who made the mutation
+	Action    AuditAction // What was mutated
+	EntityID  int         // Id of the mutated expense, settlement or user
+	Before    string      // JSON snapshot before the mutation, empty if created
+	After     string      // JSON snapshot after the mutation, empty if deleted
+	CreatedAt time.Time   // The time the mutation was made
+}
+
+// AuditQuery filters the audit log. Zero values don't filter.
+type AuditQuery struct {
+	UserID int         // Only entries of mutations made by this user
+	Action AuditAction // Only entries of this action
+	From   time.Time   // Only entries made at or after this time
+	To     time.Time   // Only entries made at or before this time
+	Limit  int         // At most this many entries, newest first
+}
+
+// AuditMutation is run by WithAudit. It makes a mutation using dbh and returns
+// the entry to record for it.
+type AuditMutation func(dbh Handle) (AuditEntry, error)
+
+// AuditedHandle wraps a Handle, recording every mutation made through it in the
+// audit log on behalf of a user. The audit entry is written in the same
+// transaction as the mutation, so a failed mutation leaves no entry behind.
+type AuditedHandle struct {
+	Handle
+	userID int // The user making the mutations
+}
+
+// NewAuditedHandle returns a handle recording the mutations made through dbh on
+// behalf of userID
+func NewAuditedHandle(dbh Handle, userID int) *AuditedHandle {
+	return &AuditedHandle{Handle: dbh, userID: userID}
+}
+
+// snapshot returns the JSON representation of v for the audit log
+func snapshot(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// expenseSnapshot returns the snapshot of an expense, or an empty string if it
+// doesn't exist
+func expenseSnapshot(dbh Handle, expenseID int) string {
+	e, err := dbh.GetExpense(expenseID)
+	if errors.Is(err, ErrNotFound) {
+		return ""
+	} else if err != nil {
+		panic(err)
+	}
+	return snapshot(e)
+}
+
+// settlementSnapshot returns the snapshot of a settlement, or an empty string if
+// it doesn't exist
+func settlementSnapshot(dbh Handle, settlementID int) string {
+	s, err := dbh.GetSettlement(settlementID)
+	if errors.Is(err, ErrNotFound) {
+		return ""
+	} else if err != nil {
+		panic(err)
+	}
+	return snapshot(s)
+}
+
+// userSnapshot returns the snapshot of a user, including whether they are an
+// administrator
+func userSnapshot(dbh Handle, userID int) string {
+	users := dbh.GetUsersByID([]int{userID})
+	if len(users) == 0 {
+		return ""
+	}
+	return snapshot(struct {
+		User
+		Admin bool
+	}{users[0], dbh.IsAdmin(userID)})
+}
+
+// audit runs mutate on the wrapped handle, recording the entry it returns
+func (h *AuditedHandle) audit(mutate AuditMutation) error {
+	return h.Handle.WithAudit(func(dbh Handle) (AuditEntry, error) {
+		entry, err := mutate(dbh)
+		entry.UserID = h.userID
+		return entry, err
+	})
+}
+
+// CreateExpense creates an expense and records it in the audit log
+func (h *AuditedHandle) CreateExpense(e ledger.Expense) error {
+	return h.audit(func(dbh Handle) (AuditEntry, error) {
+		if err := dbh.CreateExpense(e); err != nil {
+			return AuditEntry{}, err
+		}
+		action, err := dbh.GetLastAction(e.OwnerID)
+		if err != nil {
+			panic(err)
+		}
+		return AuditEntry{Action: AuditCreateExpense, EntityID: action.ID, After: expenseSnapshot(dbh, action.ID)}, nil
+	})
+}
+
+// UpdateExpense updates an expense and records it in the audit log
+func (h *AuditedHandle) UpdateExpense(e ledger.Expense) error {
+	return h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := expenseSnapshot(dbh, e.ExpenseID)
+		if err := dbh.UpdateExpense(e); err != nil {
+			return AuditEntry{}, err
+		}
+		return AuditEntry{Action: AuditUpdateExpense, EntityID: e.ExpenseID, Before: before, After: expenseSnapshot(dbh, e.ExpenseID)}, nil
+	})
+}
+
+// AddExpenseTags attaches tags to an expense and records it in the audit log
+func (h *AuditedHandle) AddExpenseTags(expenseID int, tags []string) {
+	h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := expenseSnapshot(dbh, expenseID)
+		dbh.AddExpenseTags(expenseID, tags)
+		return AuditEntry{Action: AuditTagExpense, EntityID: expenseID, Before: before, After: expenseSnapshot(dbh, expenseID)}, nil
+	})
+}
+
+// DeleteExpense deletes an expense and records it in the audit log
+func (h *AuditedHandle) DeleteExpense(expenseID int) {
+	h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := expenseSnapshot(dbh, expenseID)
+		dbh.DeleteExpense(expenseID)
+		return AuditEntry{Action: AuditDeleteExpense, EntityID: expenseID, Before: before}, nil
+	})
+}
+
+// CreateSettlement creates a settlement and records it in the audit log
+func (h *AuditedHandle) CreateSettlement(s ledger.Settlement) int {
+	var settlementID int
+	h.audit(func(dbh Handle) (AuditEntry, error) {
+		settlementID = dbh.CreateSettlement(s)
+		return AuditEntry{Action: AuditCreateSettlement, EntityID: settlementID, After: settlementSnapshot(dbh, settlementID)}, nil
+	})
+	return settlementID
+}
+
+// DeleteSettlement deletes a settlement and records it in the audit log
+func (h *AuditedHandle) DeleteSettlement(settlementID int) {
+	h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := settlementSnapshot(dbh, settlementID)
+		dbh.DeleteSettlement(settlementID)
+		return AuditEntry{Action: AuditDeleteSettlement, EntityID: settlementID, Before: before}, nil
+	})
+}
+
+// SetName changes a user's display name and records it in the audit log
+func (h *AuditedHandle) SetName(userID int, name string) error {
+	return h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := userSnapshot(dbh, userID)
+		if err := dbh.SetName(userID, name); err != nil {
+			return AuditEntry{}, err
+		}
+		return AuditEntry{Action: AuditSetName, EntityID: userID, Before: before, After: userSnapshot(dbh, userID)}, nil
+	})
+}
+
+// SetEmail changes a user's email and records it in the audit log
+func (h *AuditedHandle) SetEmail(userID int, email string) error {
+	return h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := userSnapshot(dbh, userID)
+		if err := dbh.SetEmail(userID, email); err != nil {
+			return AuditEntry{}, err
+		}
+		return AuditEntry{Action: AuditSetEmail, EntityID: userID, Before: before, After: userSnapshot(dbh, userID)}, nil
+	})
+}
+
+// SetSettings replaces a user's preferences and records it in the audit log
+func (h *AuditedHandle) SetSettings(userID int, s Settings) error {
+	return h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := snapshot(dbh.GetSettings(userID))
+		if err := dbh.SetSettings(userID, s); err != nil {
+			return AuditEntry{}, err
+		}
+		return AuditEntry{Action: AuditSetSettings, EntityID: userID, Before: before, After: snapshot(dbh.GetSettings(userID))}, nil
+	})
+}
+
+// DeleteUser anonymizes a user and records it in the audit log
+func (h *AuditedHandle) DeleteUser(userID int) {
+	h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := userSnapshot(dbh, userID)
+		dbh.DeleteUser(userID)
+		return AuditEntry{Action: AuditDeleteUser, EntityID: userID, Before: before, After: userSnapshot(dbh, userID)}, nil
+	})
+}
+
+// SetAdmin makes a user an administrator or not and records it in the audit log
+func (h *AuditedHandle) SetAdmin(userID int, admin bool) {
+	h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := userSnapshot(dbh, userID)
+		dbh.SetAdmin(userID, admin)
+		return AuditEntry{Action: AuditSetAdmin, EntityID: userID, Before: before, After: userSnapshot(dbh, userID)}, nil
+	})
+}
+
+// CreateUser creates a user and records it in the audit log
+func (h *AuditedHandle) CreateUser(email string, password string) (int, error) {
+	var userID int
+	err := h.audit(func(dbh Handle) (AuditEntry, error) {
+		var err error
+		if userID, err = dbh.CreateUser(email, password); err != nil {
+			return AuditEntry{}, err
+		}
+		return AuditEntry{Action: AuditCreateUser, EntityID: userID, After: userSnapshot(dbh, userID)}, nil
+	})
+	return userID, err
+}
+
+// ChangePassword changes a user's password and records it in the audit log.
+// There are no snapshots, so that password hashes don't end up in the log.
+func (h *AuditedHandle) ChangePassword(userID int, current string, new string) error {
+	return h.audit(func(dbh Handle) (AuditEntry, error) {
+		if err := dbh.ChangePassword(userID, current, new); err != nil {
+			return AuditEntry{}, err
+		}
+		return AuditEntry{Action: AuditChangePassword, EntityID: userID}, nil
+	})
+}
+
+// RequestFriend requests or confirms a friendship and records it in the audit
+// log. The snapshot is of the friendship with the requested user.
+func (h *AuditedHandle) RequestFriend(userID int, friendID int) (FriendStatus, error) {
+	var status FriendStatus
+	err := h.audit(func(dbh Handle) (AuditEntry, error) {
+		var err error
+		if status, err = dbh.RequestFriend(userID, friendID); err != nil {
+			return AuditEntry{}, err
+		}
+		after := snapshot(struct {
+			UserID   int
+			FriendID int
+			Status   FriendStatus
+		}{userID, friendID, status})
+		return AuditEntry{Action: AuditRequestFriend, EntityID: friendID, After: after}, nil
+	})
+	return status, err
+}
+
+// CreateGroup creates a group and records it in the audit log
+func (h *AuditedHandle) CreateGroup(g Group) int {
+	var groupID int
+	h.audit(func(dbh Handle) (AuditEntry, error) {
+		groupID = dbh.CreateGroup(g)
+		created, err := dbh.GetGroup(groupID)
+		if err != nil {
+			panic(err)
+		}
+		return AuditEntry{Action: AuditCreateGroup, EntityID: groupID, After: snapshot(created)}, nil
+	})
+	return groupID
+}
+
+// MergeUsers merges a user into another and records it in the audit log. The
+// snapshots are of the merged user.
+func (h *AuditedHandle) MergeUsers(sourceID int, targetID int) error {
+	return h.audit(func(dbh Handle) (AuditEntry, error) {
+		before := userSnapshot(dbh, sourceID)
+		if err := dbh.MergeUsers(sourceID, targetID); err != nil {
+			return AuditEntry{}, err
+		}
+		return AuditEntry{Action: AuditMergeUsers, EntityID: sourceID, Before: before, After: userSnapshot(dbh, sourceID)}, nil
+	})
+}
@@ -1,6 +1,10 @@
 package database
 
 import (
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/freewilll/splitter/ledger"
 )
 
@@ -8,6 +12,133 @@ import (
 type User struct {
 	ID    int
 	Email string
+	Name  string // Optional free-form display name
+}
+
+// Settings are the preferences of a user. Zero values are unset, for which
+// defaults apply.
+type Settings struct {
+	DefaultCurrency string // ISO 4217 code of new expenses without a currency
+	Locale          string // Preferred locale, e.g. de-DE
+	Notifications   bool   // Opted in to notifications
+}
+
+// UserOrder is the field users are ordered by
+type UserOrder string
+
+// The fields users can be ordered by
+const (
+	OrderByEmail UserOrder = "email"
+	OrderByID    UserOrder = "id"
+)
+
+// UsersQuery holds the ordering and limit for retrieving users
+type UsersQuery struct {
+	OrderBy    UserOrder // Field to order by, email if empty
+	Descending bool      // Order descending instead of ascending
+	Limit      int       // Maximum number of users, unlimited if zero
+}
+
+// SharedWithQuery selects the expenses shared with other users
+type SharedWithQuery struct {
+	UserIDs []int // The other users, without duplicates
+	All     bool  // All of the users must be involved instead of any
+}
+
+// Order is the order in which expenses are returned. Expenses incurred at the
+// same time are ordered by id, so that pages are stable.
+type Order int
+
+const (
+	OldestFirst Order = iota // By incurred time, oldest first
+	NewestFirst              // By incurred time, newest first
+)
+
+// orders are the orders that can be requested by name
+var orders = map[string]Order{
+	"oldest": OldestFirst,
+	"newest": NewestFirst,
+}
+
+// ParseOrder returns the order named oldest or newest
+func ParseOrder(name string) (Order, error) {
+	order, ok := orders[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown order %q", name)
+	}
+	return order, nil
+}
+
+// ExpensesQuery filters, orders and pages the expenses of all users. Zero values
+// don't filter.
+type ExpensesQuery struct {
+	OwnerID       int       // Only expenses created by this user
+	ParticipantID int       // Only expenses shared by this user
+	InvolvedID    int       // Only expenses created, paid for or shared by this user
+	Tag           string    // Only expenses with this tag
+	From          time.Time // Only expenses incurred at or after this time
+	To            time.Time // Only expenses incurred at or before this time
+	MinAmount     float64   // Only expenses of at least this amount
+	MaxAmount     float64   // Only expenses of at most this amount
+	Offset        int       // Skip this many expenses
+	Limit         int       // At most this many expenses
+	Order         Order     // Order of the expenses, oldest first by default
+}
+
+// PayerTotal is the total amount a user paid for expenses
+type PayerTotal struct {
+	UserID int     // The payer
+	Total  float64 // Sum of the amounts of the expenses
+	Count  int     // Number of expenses
+}
+
+// FriendStatus is the state of a friendship between two users
+type FriendStatus string
+
+// A friend request is pending until the other user requests the friendship too
+const (
+	FriendPending   FriendStatus = "pending"
+	FriendConfirmed FriendStatus = "confirmed"
+)
+
+// Group is a set of users who settle up together
+type Group struct {
+	ID      int
+	Name    string
+	OwnerID int   // User id who created the group
+	Members []int // User ids of the members including the owner, in order of id
+}
+
+// groupMembers returns the members of a group, the owner included, without
+// duplicates and in order of id
+func groupMembers(g Group) []int {
+	seen := map[int]bool{g.OwnerID: true}
+	members := []int{g.OwnerID}
+	for _, u := range g.Members {
+		if !seen[u] {
+			seen[u] = true
+			members = append(members, u)
+		}
+	}
+	sort.Ints(members)
+	return members
+}
+
+// ActionType is the type of a user's action that changes the ledger
+type ActionType string
+
+// The types of actions that can be undone
+const (
+	ActionExpense    ActionType = "expense"
+	ActionSettlement ActionType = "settlement"
+)
+
+// Action is an expense or settlement created by a user
+type Action struct {
+	Type       ActionType // Expense or settlement
+	ID         int        // Id of the expense or settlement
+	Users      []int      // All users whose balance is affected by the action
+	RecordedAt time.Time  // The time the action was stored in the database
 }
 
 // Database is an interface that does nothing more than return a database handle
@@ -19,11 +150,132 @@ type Database interface {
 // Handle is an interface containng methods to manage a database handle
 // and perform user, ledger and expenses queries on it.
 type Handle interface {
-	Close()                                                      // Close the database handle
-	CreateSchema()                                               // Create the database schema
-	CreateUser(email string, password string) (int, error)       // Create a user
-	AuthenticateUser(email string, password string) (int, error) // Authenticate a user
-	GetUsers() []User                                            // Get a slice of all users
-	CreateExpense(e ledger.Expense)                              // Create an expense entry
-	GetExpenses(userID int) []ledger.Expense                     // Get a slice of all exepnses
+	Close()                                                               // Close the database handle
+	CreateSchema()                                                        // Create the database schema
+	CreateUser(email string, password string) (int, error)                // Create a user
+	AuthenticateUser(email string, password string) (int, error)          // Authenticate a user
+	ChangePassword(userID int, current string, new string) error          // Change a user's password
+	GetUsers(q UsersQuery) []User                                         // Get a slice of all users
+	GetUsersByID(ids []int) []User                                        // Get a slice of the users that exist out of ids
+	GetUsersByEmail(emails []string) []User                               // Get a slice of the users that exist out of emails
+	SetName(userID int, name string) error                                // Change a user's display name
+	SetEmail(userID int, email string) error                              // Change a user's email
+	GetSettings(userID int) Settings                                      // Get a user's preferences
+	SetSettings(userID int, s Settings) error                             // Replace a user's preferences
+	DeleteUser(userID int)                                                // Anonymize a user and prevent them from signing in
+	UserExists(userID int) bool                                           // Check if a user exists and hasn't been deleted
+	IsAdmin(userID int) bool                                              // Check if a user is an administrator
+	SetAdmin(userID int, admin bool)                                      // Make a user an administrator or not
+	MergeUsers(sourceID int, targetID int) error                          // Move all of a user's data to another and delete them
+	RequestFriend(userID int, friendID int) (FriendStatus, error)         // Request or confirm a friendship
+	GetFriends(userID int) []User                                         // Get a slice of a user's confirmed friends
+	GetRecentContacts(userID int, limit int) []User                       // Get the users a user most recently shared expenses with
+	CreateGroup(g Group) int                                              // Create a group of users
+	GetGroup(groupID int) (Group, error)                                  // Get a group
+	CreateExpense(e ledger.Expense) error                                 // Create an expense entry
+	UpdateExpense(e ledger.Expense) error                                 // Replace the details and users of an expense
+	GetExpense(expenseID int) (ledger.Expense, error)                     // Get an expense
+	GetExpenses(userID int) []ledger.Expense                              // Get the expenses involving a user
+	SearchExpenses(userID int, query string) []ledger.Expense             // Get a user's expenses matching a description
+	GetExpensesByTag(userID int, tag string) []ledger.Expense             // Get a user's expenses with a tag
+	GetExpensesSharedWith(userID int, q SharedWithQuery) []ledger.Expense // Get a user's expenses involving other users
+	GetExpensesPaidBy(userID int) []ledger.Expense                        // Get the expenses a user paid for
+	FindExpenses(q ExpensesQuery) []ledger.Expense                        // Get the expenses of all users matching q
+	AddExpenseTags(expenseID int, tags []string)                          // Attach tags to an expense
+	GetTags(userID int) []string                                          // Get the tags of a user's expenses
+	GetPayerTotals(from time.Time, to time.Time) []PayerTotal             // Get totals paid per user, highest first
+	CreateSettlement(s ledger.Settlement) int                             // Create a settlement entry
+	GetSettlement(settlementID int) (ledger.Settlement, error)            // Get a settlement
+	GetSettlements(userID int) []ledger.Settlement                        // Get a slice of a user's settlements
+	GetLastAction(userID int) (Action, error)                             // Get a user's most recent action
+	CountExpensesSince(userID int, since time.Time) int                   // Count the expenses a user recorded since a time
+	DeleteExpense(expenseID int)                                          // Delete an expense
+	DeleteSettlement(settlementID int)                                    // Delete a settlement
+	WithAudit(mutate AuditMutation) error                                 // Make a mutation and record it in the audit log
+	GetAuditEntries(q AuditQuery) []AuditEntry                            // Get entries of the audit log, newest first
+}
+
+// expenseUsers returns the users sharing an expense, without duplicates. The
+// owner is first, unless they don't share the expense, and appears only once,
+// whether or not they are in the expense's Users.
+func expenseUsers(e ledger.Expense) []int {
+	users := make([]int, 0, len(e.Users)+1)
+	seen := map[int]bool{e.OwnerID: true}
+	if !e.ExcludeOwner {
+		users = append(users, e.OwnerID)
+	}
+	for _, u := range e.Users {
+		if !seen[u] {
+			seen[u] = true
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// affectedUsers returns the users whose balances an expense affects: those
+// sharing it, and its owner and payer even if they don't share it
+func affectedUsers(e ledger.Expense) []int {
+	users := make([]int, 0, len(e.Users)+2)
+	seen := make(map[int]bool)
+	for _, u := range append([]int{e.OwnerID, e.Payer()}, e.Users...) {
+		if !seen[u] {
+			seen[u] = true
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// MaxParticipants is the maximum number of users sharing an expense, including
+// the owner. It's enforced by CreateExpense, however the expense is created.
+// Zero allows any number.
+var MaxParticipants = 100
+
+// checkParticipants returns ErrTooManyParticipants if an expense is shared by
+// more than MaxParticipants users
+func checkParticipants(e ledger.Expense) error {
+	if n := len(expenseUsers(e)); MaxParticipants > 0 && n > MaxParticipants {
+		return fmt.Errorf("expense with %d participants, at most %d: %w", n, MaxParticipants, ErrTooManyParticipants)
+	}
+	return nil
+}
+
+// anonymizedEmail returns the unique email of a deleted user
+func anonymizedEmail(userID int) string {
+	return fmt.Sprintf("deleted-%d@deleted.invalid", userID)
+}
+
+// WithNames returns a copy of balance with the display names of the other users
+// filled in. The debts are copied since the balance may be shared with a cache.
+func WithNames(dbh Handle, balance ledger.Balance) ledger.Balance {
+	var ids []int
+	for _, debts := range [][]ledger.Debt{balance.Debit, balance.Credit} {
+		for _, d := range debts {
+			ids = append(ids, d.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return balance
+	}
+
+	names := make(map[int]string, len(ids))
+	for _, u := range dbh.GetUsersByID(ids) {
+		names[u.ID] = u.Name
+	}
+
+	named := func(debts []ledger.Debt) []ledger.Debt {
+		if debts == nil {
+			return nil
+		}
+		result := make([]ledger.Debt, len(debts))
+		for i, d := range debts {
+			d.Name = names[d.UserID]
+			result[i] = d
+		}
+		return result
+	}
+	balance.Debit = named(balance.Debit)
+	balance.Credit = named(balance.Credit)
+	return balance
 }
@@ -1,6 +1,11 @@
 package database
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/freewilll/splitter/ledger"
 )
 
@@ -8,13 +13,38 @@ import (
 type userWithPassword struct {
 	ID       int
 	Email    string
+	Name     string
 	Password string
+	Deleted  bool
+	Admin    bool
+	Settings Settings
 }
 
 // InMemoryDatabase implements the Database interface for an in memory database
 type InMemoryDatabase struct {
-	users    []userWithPassword
-	expenses []ledger.Expense
+	users            []userWithPassword
+	expenses         []ledger.Expense
+	settlements      []ledger.Settlement
+	friendships      []friendship
+	actions          []inMemoryAction // Log of created expenses and settlements, oldest first
+	audit            []AuditEntry     // Audit log of mutations, oldest first
+	groups           []Group
+	nextExpenseID    int
+	nextSettlementID int
+}
+
+// friendship is a friend request from userID to friendID, which is confirmed
+// once friendID requests it too
+type friendship struct {
+	userID    int
+	friendID  int
+	confirmed bool
+}
+
+// inMemoryAction is an entry in the log of actions
+type inMemoryAction struct {
+	Action
+	userID int // The user who created the action
 }
 
 // InMemoryHandle implements the DatabaseHandle interface for an in memory database
@@ -27,6 +57,13 @@ func NewInMemoryDatabase() Database {
 	db := new(InMemoryDatabase)
 	db.users = make([]userWithPassword, 0)
 	db.expenses = make([]ledger.Expense, 0)
+	db.settlements = make([]ledger.Settlement, 0)
+	db.friendships = make([]friendship, 0)
+	db.actions = make([]inMemoryAction, 0)
+	db.audit = make([]AuditEntry, 0)
+	db.groups = make([]Group, 0)
+	db.nextExpenseID = 1
+	db.nextSettlementID = 1
 	return db
 }
 
@@ -47,7 +84,7 @@ func (h *InMemoryHandle) CreateSchema() {}
 func (h *InMemoryHandle) CreateUser(email string, password string) (int, error) {
 	for _, u := range h.db.users {
 		if u.Email == email {
-			return 0, ErrDuplicate
+			return 0, fmt.Errorf("user %s: %w", email, ErrDuplicate)
 		}
 	}
 
@@ -56,28 +93,713 @@ func (h *InMemoryHandle) CreateUser(email string, password string) (int, error)
 	return userID, nil
 }
 
-// AuthenticateUser isn't fully implemented. It always returns 1, nil.
+// AuthenticateUser checks if the user with email/password exists and the password
+// matches. ErrNotFound if the user doesn't exist. ErrPasswordMismatch is returned
+// if the password mismatches.
 func (h *InMemoryHandle) AuthenticateUser(email string, password string) (int, error) {
-	return 1, nil
+	for i, u := range h.db.users {
+		if u.Email == email && !u.Deleted {
+			if u.Password != password {
+				return 0, fmt.Errorf("user %s: %w", email, ErrPasswordMismatch)
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("user %s: %w", email, ErrNotFound)
+}
+
+// ChangePassword replaces a user's password if the current password matches
+func (h *InMemoryHandle) ChangePassword(userID int, current string, new string) error {
+	if userID < 1 || userID > len(h.db.users) || h.db.users[userID-1].Deleted {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	if h.db.users[userID-1].Password != current {
+		return fmt.Errorf("user %d: %w", userID, ErrPasswordMismatch)
+	}
+
+	h.db.users[userID-1].Password = new
+	return nil
+}
+
+// GetUsers returns a list of all users in the order and up to the limit of q
+func (h *InMemoryHandle) GetUsers(q UsersQuery) []User {
+	users := make([]User, 0)
+	for i, u := range h.db.users {
+		if !u.Deleted {
+			users = append(users, User{ID: i + 1, Email: u.Email, Name: u.Name})
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		if q.OrderBy == OrderByID {
+			return a.ID < b.ID
+		}
+		return a.Email < b.Email
+	})
+
+	if q.Limit > 0 && len(users) > q.Limit {
+		users = users[:q.Limit]
+	}
+	return users
+}
+
+// GetUsersByID returns a list of the users with the given ids, skipping unknown ids
+func (h *InMemoryHandle) GetUsersByID(ids []int) []User {
+	users := make([]User, 0)
+	for _, id := range ids {
+		if id >= 1 && id <= len(h.db.users) {
+			users = append(users, User{ID: id, Email: h.db.users[id-1].Email, Name: h.db.users[id-1].Name})
+		}
+	}
+	return users
 }
 
-// GetUsers returns a list of all users
-func (h *InMemoryHandle) GetUsers() []User {
+// GetUsersByEmail returns a list of the users with the given emails, skipping
+// unknown emails and deleted users
+func (h *InMemoryHandle) GetUsersByEmail(emails []string) []User {
 	users := make([]User, 0)
 	for i, u := range h.db.users {
-		users = append(users, User{ID: i + 1, Email: u.Email})
+		if u.Deleted {
+			continue
+		}
+		for _, email := range emails {
+			if u.Email == email {
+				users = append(users, User{ID: i + 1, Email: u.Email, Name: u.Name})
+				break
+			}
+		}
 	}
 	return users
 }
 
-// CreateExpense creates an expense
-func (h *InMemoryHandle) CreateExpense(expense ledger.Expense) {
-	expense.Users = append(expense.Users, expense.OwnerID)
-	expense.ExpenseID = len(h.db.expenses) + 1
+// SetName changes the display name of a user. ErrNotFound is returned if the
+// user doesn't exist.
+func (h *InMemoryHandle) SetName(userID int, name string) error {
+	if !h.UserExists(userID) {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	h.db.users[userID-1].Name = name
+	return nil
+}
+
+// SetEmail changes the email of a user. ErrNotFound is returned if the user
+// doesn't exist and ErrDuplicate if another user has the email.
+func (h *InMemoryHandle) SetEmail(userID int, email string) error {
+	if !h.UserExists(userID) {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	for i, u := range h.db.users {
+		if u.Email == email && i+1 != userID {
+			return fmt.Errorf("user %s: %w", email, ErrDuplicate)
+		}
+	}
+	h.db.users[userID-1].Email = email
+	return nil
+}
+
+// GetSettings returns the preferences of userID, unset if they have none
+func (h *InMemoryHandle) GetSettings(userID int) Settings {
+	if !h.UserExists(userID) {
+		return Settings{}
+	}
+	return h.db.users[userID-1].Settings
+}
+
+// SetSettings replaces the preferences of userID. ErrNotFound is returned if the
+// user doesn't exist.
+func (h *InMemoryHandle) SetSettings(userID int, s Settings) error {
+	if !h.UserExists(userID) {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	h.db.users[userID-1].Settings = s
+	return nil
+}
+
+// DeleteUser anonymizes a user, keeping their expenses intact
+func (h *InMemoryHandle) DeleteUser(userID int) {
+	if userID >= 1 && userID <= len(h.db.users) {
+		h.db.users[userID-1] = userWithPassword{Email: anonymizedEmail(userID), Deleted: true}
+	}
+}
+
+// UserExists returns true if userID exists and hasn't been deleted
+func (h *InMemoryHandle) UserExists(userID int) bool {
+	return userID >= 1 && userID <= len(h.db.users) && !h.db.users[userID-1].Deleted
+}
+
+// IsAdmin returns true if userID is an administrator
+func (h *InMemoryHandle) IsAdmin(userID int) bool {
+	return h.UserExists(userID) && h.db.users[userID-1].Admin
+}
+
+// SetAdmin makes userID an administrator or not
+func (h *InMemoryHandle) SetAdmin(userID int, admin bool) {
+	if h.UserExists(userID) {
+		h.db.users[userID-1].Admin = admin
+	}
+}
+
+// MergeUsers moves all expenses, settlements and friendships of sourceID to
+// targetID and deletes sourceID. ErrNotFound is returned if either user doesn't
+// exist.
+func (h *InMemoryHandle) MergeUsers(sourceID int, targetID int) error {
+	if !h.UserExists(sourceID) || !h.UserExists(targetID) {
+		return fmt.Errorf("merging user %d into %d: %w", sourceID, targetID, ErrNotFound)
+	}
+
+	for i, e := range h.db.expenses {
+		h.db.expenses[i] = e.MergeUser(sourceID, targetID)
+	}
+
+	// Settlements between the two users cancel out
+	settlements := make([]ledger.Settlement, 0, len(h.db.settlements))
+	for _, s := range h.db.settlements {
+		if s.FromUserID == sourceID {
+			s.FromUserID = targetID
+		}
+		if s.ToUserID == sourceID {
+			s.ToUserID = targetID
+		}
+		if s.FromUserID != s.ToUserID {
+			settlements = append(settlements, s)
+		}
+	}
+	h.db.settlements = settlements
+
+	// Friendships between the two users are dropped. If both were friends with
+	// the same user, only one friendship is kept, confirmed if either was.
+	friendships := make([]friendship, 0, len(h.db.friendships))
+	seen := make(map[[2]int]int) // Index in friendships, keyed by the pair of users
+	for _, f := range h.db.friendships {
+		if f.userID == sourceID {
+			f.userID = targetID
+		}
+		if f.friendID == sourceID {
+			f.friendID = targetID
+		}
+		if f.userID == f.friendID {
+			continue
+		}
+
+		pair := [2]int{f.userID, f.friendID}
+		if pair[0] > pair[1] {
+			pair[0], pair[1] = pair[1], pair[0]
+		}
+		if i, exists := seen[pair]; exists {
+			friendships[i].confirmed = friendships[i].confirmed || f.confirmed
+			continue
+		}
+		seen[pair] = len(friendships)
+		friendships = append(friendships, f)
+	}
+	h.db.friendships = friendships
+
+	for i, a := range h.db.actions {
+		if a.userID == sourceID {
+			h.db.actions[i].userID = targetID
+		}
+	}
+
+	for i, g := range h.db.groups {
+		if g.OwnerID == sourceID {
+			g.OwnerID = targetID
+		}
+		for j, u := range g.Members {
+			if u == sourceID {
+				g.Members[j] = targetID
+			}
+		}
+		g.Members = groupMembers(g)
+		h.db.groups[i] = g
+	}
+
+	h.DeleteUser(sourceID)
+	return nil
+}
+
+// RequestFriend requests a friendship with friendID, or confirms it if friendID
+// has already requested it. ErrNotFound is returned if friendID doesn't exist.
+// ErrDuplicate is returned if the friendship has already been requested.
+func (h *InMemoryHandle) RequestFriend(userID int, friendID int) (FriendStatus, error) {
+	if friendID < 1 || friendID > len(h.db.users) || h.db.users[friendID-1].Deleted {
+		return "", fmt.Errorf("user %d: %w", friendID, ErrNotFound)
+	}
+
+	for i, f := range h.db.friendships {
+		if f.userID == userID && f.friendID == friendID {
+			return "", fmt.Errorf("friendship of users %d and %d: %w", userID, friendID, ErrDuplicate)
+		}
+
+		if f.userID == friendID && f.friendID == userID {
+			if f.confirmed {
+				return "", fmt.Errorf("friendship of users %d and %d: %w", userID, friendID, ErrDuplicate)
+			}
+			h.db.friendships[i].confirmed = true
+			return FriendConfirmed, nil
+		}
+	}
+
+	h.db.friendships = append(h.db.friendships, friendship{userID: userID, friendID: friendID})
+	return FriendPending, nil
+}
+
+// GetFriends returns a list of the confirmed friends of a user
+func (h *InMemoryHandle) GetFriends(userID int) []User {
+	ids := make([]int, 0)
+	for _, f := range h.db.friendships {
+		if f.confirmed && f.userID == userID {
+			ids = append(ids, f.friendID)
+		} else if f.confirmed && f.friendID == userID {
+			ids = append(ids, f.userID)
+		}
+	}
+	return h.GetUsersByID(ids)
+}
+
+// GetRecentContacts returns up to limit users who share expenses with userID,
+// most recent shared expense first. Deleted users are left out.
+func (h *InMemoryHandle) GetRecentContacts(userID int, limit int) []User {
+	lastSharedAt := make(map[int]time.Time)
+	for _, e := range h.db.expenses {
+		if !e.HasUser(userID) {
+			continue
+		}
+		for _, u := range e.Users {
+			if u != userID && h.UserExists(u) && e.CreatedAt.After(lastSharedAt[u]) {
+				lastSharedAt[u] = e.CreatedAt
+			}
+		}
+	}
+
+	ids := make([]int, 0, len(lastSharedAt))
+	for id := range lastSharedAt {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if !lastSharedAt[ids[i]].Equal(lastSharedAt[ids[j]]) {
+			return lastSharedAt[ids[i]].After(lastSharedAt[ids[j]])
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return h.GetUsersByID(ids)
+}
+
+// CreateExpense creates an expense. ErrTooManyParticipants is returned if more
+// than MaxParticipants users share the expense.
+func (h *InMemoryHandle) CreateExpense(expense ledger.Expense) error {
+	if err := checkParticipants(expense); err != nil {
+		return err
+	}
+
+	expense.Users = expenseUsers(expense)
+	expense.ExpenseID = h.db.nextExpenseID
+	expense.RecordedAt = time.Now()
+	tags := expense.Tags
+	expense.Tags = nil
+	h.db.nextExpenseID++
 	h.db.expenses = append(h.db.expenses, expense)
+	h.AddExpenseTags(expense.ExpenseID, tags)
+	h.recordAction(expense.OwnerID, ActionExpense, expense.ExpenseID, affectedUsers(expense))
+	return nil
 }
 
-// GetExpenses returns a list of all expenses
+// UpdateExpense replaces the details and users of an expense, keeping its owner,
+// tags and the time it was recorded. ErrNotFound is returned if it doesn't exist
+// and ErrTooManyParticipants if more than MaxParticipants users share it.
+func (h *InMemoryHandle) UpdateExpense(expense ledger.Expense) error {
+	if err := checkParticipants(expense); err != nil {
+		return err
+	}
+
+	for i, e := range h.db.expenses {
+		if e.ExpenseID != expense.ExpenseID {
+			continue
+		}
+
+		expense.OwnerID = e.OwnerID
+		expense.Users = expenseUsers(expense)
+		expense.RecordedAt = e.RecordedAt
+		expense.Tags = e.Tags
+		h.db.expenses[i] = expense
+
+		// Undoing the expense affects the users sharing it now
+		for j, a := range h.db.actions {
+			if a.Type == ActionExpense && a.ID == expense.ExpenseID {
+				h.db.actions[j].Users = affectedUsers(expense)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("expense %d: %w", expense.ExpenseID, ErrNotFound)
+}
+
+// GetExpense returns an expense. ErrNotFound is returned if it doesn't exist.
+func (h *InMemoryHandle) GetExpense(expenseID int) (ledger.Expense, error) {
+	for _, e := range h.db.expenses {
+		if e.ExpenseID == expenseID {
+			return e, nil
+		}
+	}
+	return ledger.Expense{}, fmt.Errorf("expense %d: %w", expenseID, ErrNotFound)
+}
+
+// GetExpenses returns the expenses involving userID. Like in postgres, where
+// expenses are joined with their users, expenses without users aren't included.
 func (h *InMemoryHandle) GetExpenses(userID int) []ledger.Expense {
-	return h.db.expenses
+	expenses := make([]ledger.Expense, 0)
+	for _, e := range h.db.expenses {
+		if len(e.Users) > 0 && e.Involves(userID) {
+			expenses = append(expenses, e)
+		}
+	}
+	return expenses
+}
+
+// SearchExpenses returns the expenses involving userID with a description
+// containing query, ignoring case
+func (h *InMemoryHandle) SearchExpenses(userID int, query string) []ledger.Expense {
+	query = strings.ToLower(query)
+	expenses := make([]ledger.Expense, 0)
+	for _, e := range h.db.expenses {
+		if e.Involves(userID) && strings.Contains(strings.ToLower(e.Description), query) {
+			expenses = append(expenses, e)
+		}
+	}
+	return expenses
+}
+
+// containsString returns true if s is in list
+func containsString(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// GetExpensesByTag returns the expenses involving userID with tag
+func (h *InMemoryHandle) GetExpensesByTag(userID int, tag string) []ledger.Expense {
+	expenses := make([]ledger.Expense, 0)
+	for _, e := range h.db.expenses {
+		if e.Involves(userID) && containsString(e.Tags, tag) {
+			expenses = append(expenses, e)
+		}
+	}
+	return expenses
+}
+
+// GetExpensesSharedWith returns the expenses involving userID and all, or any,
+// of the users in q
+func (h *InMemoryHandle) GetExpensesSharedWith(userID int, q SharedWithQuery) []ledger.Expense {
+	expenses := make([]ledger.Expense, 0)
+	for _, e := range h.db.expenses {
+		if !e.Involves(userID) {
+			continue
+		}
+
+		matches := 0
+		for _, u := range q.UserIDs {
+			if e.Involves(u) {
+				matches++
+			}
+		}
+		if (q.All && matches == len(q.UserIDs)) || (!q.All && matches > 0) {
+			expenses = append(expenses, e)
+		}
+	}
+	return expenses
+}
+
+// GetExpensesPaidBy returns the expenses userID paid for
+func (h *InMemoryHandle) GetExpensesPaidBy(userID int) []ledger.Expense {
+	expenses := make([]ledger.Expense, 0)
+	for _, e := range h.db.expenses {
+		if e.Payer() == userID {
+			expenses = append(expenses, e)
+		}
+	}
+	return expenses
+}
+
+// FindExpenses returns the expenses of all users matching q in the order of
+// q.Order
+func (h *InMemoryHandle) FindExpenses(q ExpensesQuery) []ledger.Expense {
+	ordered := append([]ledger.Expense{}, h.db.expenses...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if q.Order == NewestFirst {
+			a, b = b, a
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ExpenseID < b.ExpenseID
+	})
+
+	expenses := make([]ledger.Expense, 0)
+	skipped := 0
+	for _, e := range ordered {
+		if (q.OwnerID != 0 && e.OwnerID != q.OwnerID) ||
+			(q.ParticipantID != 0 && !e.HasUser(q.ParticipantID)) ||
+			(q.InvolvedID != 0 && !e.Involves(q.InvolvedID)) ||
+			(q.Tag != "" && !containsString(e.Tags, q.Tag)) ||
+			(!q.From.IsZero() && e.CreatedAt.Before(q.From)) ||
+			(!q.To.IsZero() && e.CreatedAt.After(q.To)) ||
+			(q.MinAmount != 0 && e.Amount < q.MinAmount) ||
+			(q.MaxAmount != 0 && e.Amount > q.MaxAmount) {
+			continue
+		}
+
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+
+		expenses = append(expenses, e)
+		if q.Limit > 0 && len(expenses) == q.Limit {
+			break
+		}
+	}
+	return expenses
+}
+
+// AddExpenseTags attaches tags to an expense, skipping tags it already has
+func (h *InMemoryHandle) AddExpenseTags(expenseID int, tags []string) {
+	for i, e := range h.db.expenses {
+		if e.ExpenseID != expenseID {
+			continue
+		}
+
+		// Copy the tags, the slice may be shared with a caller
+		newTags := append([]string{}, e.Tags...)
+		for _, tag := range tags {
+			if !containsString(newTags, tag) {
+				newTags = append(newTags, tag)
+			}
+		}
+		sort.Strings(newTags)
+		h.db.expenses[i].Tags = newTags
+	}
+}
+
+// GetTags returns the tags of the expenses involving userID in alphabetical order
+func (h *InMemoryHandle) GetTags(userID int) []string {
+	unique := make(map[string]bool)
+	for _, e := range h.db.expenses {
+		if e.Involves(userID) {
+			for _, t := range e.Tags {
+				unique[t] = true
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(unique))
+	for t := range unique {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// GetPayerTotals returns the total amount each user paid for expenses incurred
+// between from and to inclusive, highest first. Ties are ordered by user id.
+func (h *InMemoryHandle) GetPayerTotals(from time.Time, to time.Time) []PayerTotal {
+	totals := make(map[int]*PayerTotal)
+	for _, e := range h.db.expenses {
+		if e.CreatedAt.Before(from) || e.CreatedAt.After(to) {
+			continue
+		}
+
+		payerID := e.Payer()
+		if _, exists := totals[payerID]; !exists {
+			totals[payerID] = &PayerTotal{UserID: payerID}
+		}
+		totals[payerID].Total += e.Amount
+		totals[payerID].Count++
+	}
+
+	result := make([]PayerTotal, 0, len(totals))
+	for _, t := range totals {
+		result = append(result, *t)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].UserID < result[j].UserID
+	})
+
+	return result
+}
+
+// CreateGroup creates a group of the owner and the members
+func (h *InMemoryHandle) CreateGroup(g Group) int {
+	g.ID = len(h.db.groups) + 1
+	g.Members = groupMembers(g)
+	h.db.groups = append(h.db.groups, g)
+	return g.ID
+}
+
+// GetGroup returns a group. ErrNotFound is returned if it doesn't exist.
+func (h *InMemoryHandle) GetGroup(groupID int) (Group, error) {
+	if groupID < 1 || groupID > len(h.db.groups) {
+		return Group{}, fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+	}
+	g := h.db.groups[groupID-1]
+	g.Members = append([]int{}, g.Members...)
+	return g, nil
+}
+
+// CreateSettlement creates a settlement
+func (h *InMemoryHandle) CreateSettlement(settlement ledger.Settlement) int {
+	settlement.SettlementID = h.db.nextSettlementID
+	h.db.nextSettlementID++
+	h.db.settlements = append(h.db.settlements, settlement)
+	users := []int{settlement.FromUserID, settlement.ToUserID}
+	h.recordAction(settlement.FromUserID, ActionSettlement, settlement.SettlementID, users)
+	return settlement.SettlementID
+}
+
+// GetSettlement returns a settlement. ErrNotFound is returned if it doesn't exist.
+func (h *InMemoryHandle) GetSettlement(settlementID int) (ledger.Settlement, error) {
+	for _, s := range h.db.settlements {
+		if s.SettlementID == settlementID {
+			return s, nil
+		}
+	}
+	return ledger.Settlement{}, fmt.Errorf("settlement %d: %w", settlementID, ErrNotFound)
+}
+
+// GetSettlements returns a list of all settlements userID paid or received
+func (h *InMemoryHandle) GetSettlements(userID int) []ledger.Settlement {
+	settlements := make([]ledger.Settlement, 0)
+	for _, s := range h.db.settlements {
+		if s.FromUserID == userID || s.ToUserID == userID {
+			settlements = append(settlements, s)
+		}
+	}
+	return settlements
+}
+
+// recordAction appends an action to the log of actions
+func (h *InMemoryHandle) recordAction(userID int, actionType ActionType, id int, users []int) {
+	h.db.actions = append(h.db.actions, inMemoryAction{
+		Action: Action{Type: actionType, ID: id, Users: users, RecordedAt: time.Now()},
+		userID: userID,
+	})
+}
+
+// forgetAction removes an action from the log of actions
+func (h *InMemoryHandle) forgetAction(actionType ActionType, id int) {
+	for i, a := range h.db.actions {
+		if a.Type == actionType && a.ID == id {
+			h.db.actions = append(h.db.actions[:i], h.db.actions[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetLastAction returns the most recent action created by userID. ErrNotFound
+// is returned if the user hasn't created any.
+func (h *InMemoryHandle) GetLastAction(userID int) (Action, error) {
+	for i := len(h.db.actions) - 1; i >= 0; i-- {
+		if h.db.actions[i].userID == userID {
+			return h.db.actions[i].Action, nil
+		}
+	}
+	return Action{}, fmt.Errorf("last action of user %d: %w", userID, ErrNotFound)
+}
+
+// CountExpensesSince returns the number of expenses created by userID that were
+// recorded at or after since
+func (h *InMemoryHandle) CountExpensesSince(userID int, since time.Time) int {
+	count := 0
+	for _, a := range h.db.actions {
+		if a.userID == userID && a.Type == ActionExpense && !a.RecordedAt.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// DeleteExpense deletes an expense
+func (h *InMemoryHandle) DeleteExpense(expenseID int) {
+	for i, e := range h.db.expenses {
+		if e.ExpenseID == expenseID {
+			h.db.expenses = append(h.db.expenses[:i], h.db.expenses[i+1:]...)
+			break
+		}
+	}
+	h.forgetAction(ActionExpense, expenseID)
+}
+
+// DeleteSettlement deletes a settlement
+func (h *InMemoryHandle) DeleteSettlement(settlementID int) {
+	for i, s := range h.db.settlements {
+		if s.SettlementID == settlementID {
+			h.db.settlements = append(h.db.settlements[:i], h.db.settlements[i+1:]...)
+			break
+		}
+	}
+	h.forgetAction(ActionSettlement, settlementID)
+}
+
+// WithAudit makes a mutation and appends the entry it returns to the audit log.
+// If the mutation fails or panics, the database is restored to the state it was
+// in before and nothing is logged.
+func (h *InMemoryHandle) WithAudit(mutate AuditMutation) (err error) {
+	saved := *h.db
+	saved.users = append([]userWithPassword(nil), h.db.users...)
+	saved.expenses = append([]ledger.Expense(nil), h.db.expenses...)
+	saved.settlements = append([]ledger.Settlement(nil), h.db.settlements...)
+	saved.friendships = append([]friendship(nil), h.db.friendships...)
+	saved.actions = append([]inMemoryAction(nil), h.db.actions...)
+
+	committed := false
+	defer func() {
+		if !committed {
+			*h.db = saved
+		}
+	}()
+
+	entry, err := mutate(h)
+	if err != nil {
+		return err
+	}
+
+	entry.ID = len(h.db.audit) + 1
+	entry.CreatedAt = time.Now()
+	h.db.audit = append(h.db.audit, entry)
+	committed = true
+	return nil
+}
+
+// GetAuditEntries returns the entries of the audit log matching q, newest first
+func (h *InMemoryHandle) GetAuditEntries(q AuditQuery) []AuditEntry {
+	entries := make([]AuditEntry, 0)
+	for i := len(h.db.audit) - 1; i >= 0; i-- {
+		e := h.db.audit[i]
+		if (q.UserID != 0 && e.UserID != q.UserID) ||
+			(q.Action != "" && e.Action != q.Action) ||
+			(!q.From.IsZero() && e.CreatedAt.Before(q.From)) ||
+			(!q.To.IsZero() && e.CreatedAt.After(q.To)) {
+			continue
+		}
+
+		entries = append(entries, e)
+		if q.Limit > 0 && len(entries) == q.Limit {
+			break
+		}
+	}
+	return entries
 }
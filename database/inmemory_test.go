@@ -0,0 +1,127 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/freewilll/splitter/ledger"
+)
+
+func TestCreateExpenseDedupesUsers(t *testing.T) {
+	// The owner and other users share an expense once, even if the owner or
+	// another user is in the users more than once
+
+	dbh := NewInMemoryDatabase().Connect()
+	defer dbh.Close()
+
+	tests := []struct {
+		Expense ledger.Expense
+		Users   []int
+	}{
+		{ledger.Expense{OwnerID: 1, Users: []int{2, 3}}, []int{1, 2, 3}},
+		{ledger.Expense{OwnerID: 1, Users: []int{1, 2, 1, 2}}, []int{1, 2}},
+		{ledger.Expense{OwnerID: 1, Users: []int{1, 2}, ExcludeOwner: true}, []int{2}},
+	}
+	for _, test := range tests {
+		test.Expense.Amount = 30
+		dbh.CreateExpense(test.Expense)
+		action, _ := dbh.GetLastAction(1)
+		got, _ := dbh.GetExpense(action.ID)
+		if !reflect.DeepEqual(got.Users, test.Users) {
+			t.Errorf("%v: wanted users %v, got %v", test.Expense.Users, test.Users, got.Users)
+		}
+	}
+}
+
+func TestGetExpensesInvolvingUser(t *testing.T) {
+	// Only the expenses a user owns, paid for or shares are returned, like in
+	// postgres
+
+	dbh := NewInMemoryDatabase().Connect()
+	defer dbh.Close()
+
+	dbh.CreateExpense(ledger.Expense{OwnerID: 1, Users: []int{2}, Amount: 10, Description: "Shared"})
+	dbh.CreateExpense(ledger.Expense{OwnerID: 2, Users: []int{3}, Amount: 10, Description: "Not shared"})
+	dbh.CreateExpense(ledger.Expense{OwnerID: 3, PayerID: 1, Users: []int{2}, Amount: 10, Description: "Paid", ExcludeOwner: true})
+	dbh.CreateExpense(ledger.Expense{OwnerID: 1, Amount: 10, Description: "No users", ExcludeOwner: true})
+
+	tests := []struct {
+		UserID int
+		Wanted []string
+	}{
+		{1, []string{"Shared", "Paid"}},
+		{2, []string{"Shared", "Not shared", "Paid"}},
+		{3, []string{"Not shared", "Paid"}},
+		{4, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, e := range dbh.GetExpenses(test.UserID) {
+			got = append(got, e.Description)
+		}
+		if !reflect.DeepEqual(got, test.Wanted) {
+			t.Errorf("user %d: wanted %v, got %v", test.UserID, test.Wanted, got)
+		}
+	}
+}
+
+func TestCreateExpenseMaxParticipants(t *testing.T) {
+	// An expense shared by more than MaxParticipants users, counting the owner
+	// unless they're excluded, isn't created
+
+	dbh := NewInMemoryDatabase().Connect()
+	defer dbh.Close()
+
+	old := MaxParticipants
+	defer func() { MaxParticipants = old }()
+	MaxParticipants = 3
+
+	err := dbh.CreateExpense(ledger.Expense{OwnerID: 1, Users: []int{2, 3, 4}, Amount: 40})
+	if !errors.Is(err, ErrTooManyParticipants) {
+		t.Errorf("wanted %v, got %v", ErrTooManyParticipants, err)
+	}
+	if expenses := dbh.GetExpenses(1); len(expenses) != 0 {
+		t.Errorf("wanted no expenses, got %+v", expenses)
+	}
+	if _, err := dbh.GetLastAction(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted no action, got %v", err)
+	}
+
+	for _, e := range []ledger.Expense{
+		{OwnerID: 1, Users: []int{2, 3}, Amount: 30},
+		{OwnerID: 1, Users: []int{2, 3, 4}, Amount: 30, ExcludeOwner: true},
+		{OwnerID: 1, Users: []int{2, 3, 2, 3}, Amount: 30},
+	} {
+		if err := dbh.CreateExpense(e); err != nil {
+			t.Errorf("%v: wanted the expense created, got %v", e.Users, err)
+		}
+	}
+}
+
+func TestUpdateExpense(t *testing.T) {
+	// An updated expense keeps its owner and tags, and undoing it affects the
+	// users sharing it now
+
+	db := NewInMemoryDatabase()
+	dbh := db.Connect()
+
+	dbh.CreateExpense(ledger.Expense{OwnerID: 1, Users: []int{2}, Amount: 42, Description: "Dinner", Tags: []string{"food"}})
+	action, _ := dbh.GetLastAction(1)
+
+	if err := dbh.UpdateExpense(ledger.Expense{ExpenseID: action.ID, OwnerID: 2, Users: []int{3}, Amount: 30, Description: "Lunch"}); err != nil {
+		t.Fatalf("Unable to update expense: %v", err)
+	}
+
+	expense, _ := dbh.GetExpense(action.ID)
+	if expense.OwnerID != 1 || expense.Amount != 30 || !reflect.DeepEqual(expense.Users, []int{1, 3}) || !reflect.DeepEqual(expense.Tags, []string{"food"}) {
+		t.Errorf("unexpected expense %+v", expense)
+	}
+	if action, _ := dbh.GetLastAction(1); !reflect.DeepEqual(action.Users, []int{1, 3}) {
+		t.Errorf("wanted the action to affect users [1 3], got %v", action.Users)
+	}
+
+	if err := dbh.UpdateExpense(ledger.Expense{ExpenseID: 42, OwnerID: 1, Users: []int{2}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+}
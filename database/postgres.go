@@ -1,12 +1,15 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/freewilll/splitter/errkind"
 	"github.com/freewilll/splitter/ledger"
 	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
@@ -17,52 +20,172 @@ const schema = `
 CREATE TABLE users (
 	id 			SERIAL PRIMARY KEY,
 	email 		TEXT NOT NULL UNIQUE,
-	password 	TEXT
+	name 		TEXT NOT NULL DEFAULT '',
+	password 	TEXT,
+	deleted 	BOOLEAN NOT NULL DEFAULT false,
+	is_admin 	BOOLEAN NOT NULL DEFAULT false
 );
 
+CREATE TABLE user_settings (
+	user_id 			INT PRIMARY KEY REFERENCES users,
+	default_currency 	TEXT NOT NULL DEFAULT '',
+	locale 				TEXT NOT NULL DEFAULT '',
+	notifications 		BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE friends (
+	user_id 	INT NOT NULL REFERENCES users,
+	friend_id 	INT NOT NULL REFERENCES users,
+	confirmed 	BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE UNIQUE INDEX friends_unique_id ON friends(user_id, friend_id);
+CREATE INDEX friends_friend_id ON friends(friend_id);
+
 CREATE TABLE expenses (
 	id 			SERIAL PRIMARY KEY,
 	user_id 	INT NOT NULL REFERENCES users,
+	payer_id 	INT NOT NULL REFERENCES users,
 	description TEXT NOT NULL,
 	amount 		DOUBLE PRECISION NOT NULL,
-	created_at 	TIMESTAMP NOT NULL
+	currency 	TEXT NOT NULL DEFAULT '',
+	created_at 	TIMESTAMPTZ NOT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	latitude 	DOUBLE PRECISION,
+	longitude 	DOUBLE PRECISION,
+	place 		TEXT,
+	base_per_person DOUBLE PRECISION NOT NULL DEFAULT 0,
+	notes 		TEXT NOT NULL DEFAULT '',
+	type 		TEXT NOT NULL DEFAULT 'expense'
 );
 
 CREATE INDEX expenses_user_id ON expenses(user_id);
+CREATE INDEX expenses_payer_id ON expenses(payer_id);
 
 CREATE TABLE expenses_users (
 	expense_id INT NOT NULL REFERENCES expenses,
-	user_id INT NOT NULL REFERENCES users
+	user_id INT NOT NULL REFERENCES users,
+	percentage DOUBLE PRECISION,
+	shares INT
 );
 
 CREATE INDEX expenses_users_expense_id ON expenses_users(expense_id);
 CREATE INDEX expenses_users_user_id ON expenses_users(user_id);
 CREATE UNIQUE INDEX expenses_users_unique_id ON expenses_users(expense_id, user_id);
 
+CREATE TABLE tags (
+	id 		SERIAL PRIMARY KEY,
+	name 	TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE expense_tags (
+	expense_id 	INT NOT NULL REFERENCES expenses,
+	tag_id 		INT NOT NULL REFERENCES tags
+);
+
+CREATE UNIQUE INDEX expense_tags_unique_id ON expense_tags(expense_id, tag_id);
+CREATE INDEX expense_tags_tag_id ON expense_tags(tag_id);
+
+CREATE TABLE settlements (
+	id 				SERIAL PRIMARY KEY,
+	from_user_id 	INT NOT NULL REFERENCES users,
+	to_user_id 		INT NOT NULL REFERENCES users,
+	amount 			DOUBLE PRECISION NOT NULL,
+	created_at 		TIMESTAMPTZ NOT NULL,
+	recorded_at 	TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX settlements_from_user_id ON settlements(from_user_id);
+CREATE INDEX settlements_to_user_id ON settlements(to_user_id);
+
+CREATE TABLE user_groups (
+	id 			SERIAL PRIMARY KEY,
+	name 		TEXT NOT NULL,
+	owner_id 	INT NOT NULL REFERENCES users
+);
+
+CREATE TABLE group_members (
+	group_id 	INT NOT NULL REFERENCES user_groups,
+	user_id 	INT NOT NULL REFERENCES users
+);
+
+CREATE UNIQUE INDEX group_members_unique_id ON group_members(group_id, user_id);
+CREATE INDEX group_members_user_id ON group_members(user_id);
+
+-- The audit log is append-only
+CREATE TABLE audit_log (
+	id 			SERIAL PRIMARY KEY,
+	user_id 	INT NOT NULL,
+	action 		TEXT NOT NULL,
+	entity_id 	INT NOT NULL,
+	before 		JSONB,
+	after 		JSONB,
+	created_at 	TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_log_user_id ON audit_log(user_id);
+CREATE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
+
 -- Create three test users with password "secret"
 INSERT INTO users (email, password) VALUES('test1@getstream.io', '$2a$08$NNqRkMg.vGfhnvtyrsfVN.uTndun9TuctRpxs5k5NTHjcXybPTQAa');
 INSERT INTO users (email, password) VALUES('test2@getstream.io', '$2a$08$NNqRkMg.vGfhnvtyrsfVN.uTndun9TuctRpxs5k5NTHjcXybPTQAa');
 INSERT INTO users (email, password) VALUES('test3@getstream.io', '$2a$08$NNqRkMg.vGfhnvtyrsfVN.uTndun9TuctRpxs5k5NTHjcXybPTQAa');
+
+-- Make the first test user an administrator
+UPDATE users SET is_admin = true WHERE email = 'test1@getstream.io';
+
+-- Make the three test users friends
+INSERT INTO friends (user_id, friend_id, confirmed) VALUES(1, 2, true), (1, 3, true), (2, 3, true);
 `
 
-// ErrDuplicate is returned when create request fails due to a duplicate entry
-var ErrDuplicate = errors.New("Duplicate")
+// ErrDuplicate is returned when create request fails due to a duplicate entry.
+// Like the other errors, it is wrapped with context and is matched with
+// errors.Is or errkind.Of.
+var ErrDuplicate = errkind.New(errkind.Duplicate)
 
 // ErrNotFound is returned when an entry could not be found
-var ErrNotFound = errors.New("Not found")
+var ErrNotFound = errkind.New(errkind.NotFound)
 
 // ErrPasswordMismatch is returned when authentication fails due to a bad password
-var ErrPasswordMismatch = errors.New("Password mismatch")
+var ErrPasswordMismatch = errkind.New(errkind.PasswordMismatch)
+
+// ErrTooManyParticipants is returned when an expense is shared by more than
+// MaxParticipants users
+var ErrTooManyParticipants = errkind.New(errkind.LimitExceeded)
 
 // Config holds the configuration for the postgresql database
 type Config struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Name     string
+	Host      string
+	Port      int
+	User      string
+	Password  string
+	Name      string
+	Isolation sql.IsolationLevel // Isolation level of transactions creating expenses
+
+	SlowQueryThreshold time.Duration // Queries taking longer are logged, none if zero
+}
+
+// isolationLevels are the isolation levels that can be configured by name
+var isolationLevels = map[string]sql.IsolationLevel{
+	"read-committed": sql.LevelReadCommitted,
+	"serializable":   sql.LevelSerializable,
+}
+
+// ParseIsolationLevel returns the isolation level named read-committed or
+// serializable
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	level, ok := isolationLevels[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown isolation level %q", name)
+	}
+	return level, nil
 }
 
+// maxTxAttempts is the number of times a transaction is attempted before a
+// serialization failure is given up on
+const maxTxAttempts = 5
+
 // PgDatabase implements the Database interface for postgresql
 type PgDatabase struct {
 	config Config
@@ -70,7 +193,115 @@ type PgDatabase struct {
 
 // PgHandle implements the DatabaseHandle interface for postgresql
 type PgHandle struct {
-	db *sql.DB
+	db        *sql.DB
+	tx        *sql.Tx            // The transaction the handle is in, if any
+	isolation sql.IsolationLevel // Isolation level of transactions started by inTransaction
+	slowQuery time.Duration      // Queries taking longer are logged, none if zero
+}
+
+// pgConn is implemented by both *sql.DB and *sql.Tx
+type pgConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// pgTx is implemented by *sql.Tx and nestedTx
+type pgTx interface {
+	pgConn
+	Commit() error
+	Rollback() error
+}
+
+// nestedTx is a transaction started by a handle that is already in one. The
+// outer transaction decides whether to commit or roll back.
+type nestedTx struct {
+	*sql.Tx
+}
+
+// Commit is a noop, the outer transaction is committed instead
+func (t nestedTx) Commit() error { return nil }
+
+// Rollback is a noop, the outer transaction is rolled back instead
+func (t nestedTx) Rollback() error { return nil }
+
+// conn returns the transaction the handle is in, or the database if it isn't
+func (p PgHandle) conn() pgConn {
+	if p.tx != nil {
+		return timedConn{p.tx, p.slowQuery}
+	}
+	return timedConn{p.db, p.slowQuery}
+}
+
+// begin starts a transaction, which is nested if the handle is already in one
+func (p PgHandle) begin() (pgTx, error) {
+	if p.tx != nil {
+		return p.timed(nestedTx{p.tx}), nil
+	}
+
+	txn, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return p.timed(txn), nil
+}
+
+// timed returns a transaction whose slow queries are logged
+func (p PgHandle) timed(txn pgTx) pgTx {
+	return timedTx{timedConn{txn, p.slowQuery}, txn}
+}
+
+// isRetryable returns true if err is a serialization failure or a deadlock, after
+// which the transaction can be attempted again
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
+
+// inTransaction runs fn with a handle in a transaction with the configured
+// isolation level. The transaction is committed if fn returns nil, otherwise it
+// is rolled back. Serialization failures are retried up to maxTxAttempts times.
+// If the handle is already in a transaction, fn is run in it and retrying is up
+// to the outer transaction.
+func (p PgHandle) inTransaction(fn func(h PgHandle) error) error {
+	if p.tx != nil {
+		return fn(p)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := p.tryTransaction(fn)
+		if !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+		log.Printf("Retrying transaction after attempt %d failed: %v", attempt, err)
+	}
+}
+
+// tryTransaction makes a single attempt at running fn in a transaction. Since
+// queries panic on errors, a panic with a retryable error is returned instead.
+func (p PgHandle) tryTransaction(fn func(h PgHandle) error) (err error) {
+	txn, err := p.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: p.isolation})
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			txn.Rollback()
+			if e, ok := r.(error); ok && isRetryable(e) {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	if err := fn(PgHandle{db: p.db, tx: txn, isolation: p.isolation, slowQuery: p.slowQuery}); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
 }
 
 // NewPgDatabase creates an instance of PgDatabase
@@ -96,6 +327,8 @@ func (d PgDatabase) Connect() Handle {
 
 	dbh := new(PgHandle)
 	dbh.db = db
+	dbh.isolation = d.config.Isolation
+	dbh.slowQuery = d.config.SlowQueryThreshold
 
 	return dbh
 }
@@ -109,7 +342,7 @@ func (p PgHandle) Close() {
 // the database.
 func (p PgHandle) CreateSchema() {
 	log.Print("Creating database schema")
-	_, err := p.db.Exec(schema)
+	_, err := p.conn().Exec(schema)
 	if err != nil {
 		panic(err)
 	}
@@ -124,19 +357,17 @@ func (p PgHandle) CreateUser(email string, password string) (int, error) {
 	}
 
 	var id int
-	err = p.db.QueryRow(`
+	err = p.conn().QueryRow(`
         INSERT INTO users (email, password)
         VALUES($1, $2)
         RETURNING id
     `, email, hashedPassword).Scan(&id)
 	if err != nil {
-		pqErr := err.(*pq.Error)
-		switch pqErr.Code.Name() {
-		case "unique_violation":
-			return 0, ErrDuplicate
-		default:
-			panic(err)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return 0, fmt.Errorf("user %s: %w", email, ErrDuplicate)
 		}
+		panic(err)
 	}
 
 	return id, nil
@@ -148,22 +379,67 @@ func (p PgHandle) CreateUser(email string, password string) (int, error) {
 func (p PgHandle) AuthenticateUser(email string, password string) (int, error) {
 	var dbID int
 	var dbPassword string
-	err := p.db.QueryRow("SELECT id, password FROM users WHERE email=$1", email).Scan(&dbID, &dbPassword)
+	err := p.conn().QueryRow("SELECT id, password FROM users WHERE email=$1", email).Scan(&dbID, &dbPassword)
 	if err != nil {
 		log.Printf("Unknown user '%s'", email)
-		return 0, ErrNotFound
+		return 0, fmt.Errorf("user %s: %w", email, ErrNotFound)
 	}
 
 	if err = bcrypt.CompareHashAndPassword([]byte(dbPassword), []byte(password)); err != nil {
-		return 0, ErrPasswordMismatch
+		return 0, fmt.Errorf("user %s: %w", email, ErrPasswordMismatch)
 	}
 
 	return dbID, nil
 }
 
-// GetUsers returns all users in the database, ordered by email
-func (p PgHandle) GetUsers() []User {
-	rows, err := p.db.Query("SELECT id, email FROM users ORDER BY email")
+// ChangePassword replaces a user's password if the current password matches.
+// ErrNotFound is returned if the user doesn't exist. ErrPasswordMismatch is
+// returned if the current password mismatches.
+func (p PgHandle) ChangePassword(userID int, current string, new string) error {
+	var dbPassword string
+	err := p.conn().QueryRow("SELECT password FROM users WHERE id=$1 AND NOT deleted", userID).Scan(&dbPassword)
+	if err != nil {
+		log.Printf("Unknown user %d", userID)
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(dbPassword), []byte(current)); err != nil {
+		return fmt.Errorf("user %d: %w", userID, ErrPasswordMismatch)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(new), 8)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = p.conn().Exec("UPDATE users SET password = $2 WHERE id = $1", userID, hashedPassword)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// userOrderColumns maps the allowed orderings of users to their column
+var userOrderColumns = map[UserOrder]string{
+	OrderByEmail: "email",
+	OrderByID:    "id",
+}
+
+// GetUsers returns all users in the database in the order and up to the limit of q
+func (p PgHandle) GetUsers(q UsersQuery) []User {
+	// The column can't be a query parameter, so only ever use a known one
+	column, ok := userOrderColumns[q.OrderBy]
+	if !ok {
+		column = userOrderColumns[OrderByEmail]
+	}
+	direction := "ASC"
+	if q.Descending {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf("SELECT id, email, name FROM users WHERE NOT deleted ORDER BY %s %s LIMIT NULLIF($1, 0)", column, direction)
+	rows, err := p.conn().Query(query, q.Limit)
 	if err != nil {
 		panic(err)
 	}
@@ -171,12 +447,11 @@ func (p PgHandle) GetUsers() []User {
 
 	users := make([]User, 0)
 	for rows.Next() {
-		var id int
-		var email string
-		if err := rows.Scan(&id, &email); err != nil {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name); err != nil {
 			panic(err)
 		}
-		users = append(users, User{id, email})
+		users = append(users, u)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -186,98 +461,1260 @@ func (p PgHandle) GetUsers() []User {
 	return users
 }
 
-// CreateExpense creates entries in the expenses and expenses_users tables.
-// The expenses_users tables also includes the owner
-func (p PgHandle) CreateExpense(e ledger.Expense) {
-	// Insert into expenses and expense_users in a transaction to ensure consistency
-	txn, err := p.db.Begin()
+// GetUsersByID returns the users with the given ids, ordered by id. Unknown ids
+// are skipped.
+func (p PgHandle) GetUsersByID(ids []int) []User {
+	rows, err := p.conn().Query("SELECT id, email, name FROM users WHERE id = ANY($1) ORDER BY id", pq.Array(ids))
 	if err != nil {
 		panic(err)
 	}
+	defer rows.Close()
 
-	// Insert into expenses
-	var expenseID int
-	err = p.db.QueryRow(`
-        INSERT INTO expenses (user_id, description, amount, created_at)
-        VALUES($1, $2, $3, $4)
-        RETURNING id
-    `, e.OwnerID, e.Description, e.Amount, e.CreatedAt).Scan(&expenseID)
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name); err != nil {
+			panic(err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return users
+}
+
+// GetUsersByEmail returns the users with the given emails, ordered by id. Unknown
+// emails and deleted users are skipped.
+func (p PgHandle) GetUsersByEmail(emails []string) []User {
+	rows, err := p.conn().Query("SELECT id, email, name FROM users WHERE email = ANY($1) AND NOT deleted ORDER BY id", pq.Array(emails))
 	if err != nil {
 		panic(err)
 	}
+	defer rows.Close()
 
-	// Insert into expenses_users
-	stmt, err := txn.Prepare(`
-        INSERT INTO expenses_users (expense_id, user_id)
-        VALUES($1, $2)
-    `)
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name); err != nil {
+			panic(err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return users
+}
+
+// SetName changes the display name of a user. ErrNotFound is returned if the
+// user doesn't exist.
+func (p PgHandle) SetName(userID int, name string) error {
+	result, err := p.conn().Exec("UPDATE users SET name = $2 WHERE id = $1 AND NOT deleted", userID, name)
 	if err != nil {
-		log.Fatal(err)
+		panic(err)
 	}
 
-	// Insert self into user list
-	_, err = stmt.Exec(expenseID, e.OwnerID)
+	count, err := result.RowsAffected()
 	if err != nil {
 		panic(err)
 	}
+	if count == 0 {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	return nil
+}
 
-	// Insert other users to user list
-	for _, u := range e.Users {
-		_, err = stmt.Exec(expenseID, u)
-		if err != nil {
-			panic(err)
+// SetEmail changes the email of a user. ErrNotFound is returned if the user
+// doesn't exist and ErrDuplicate if another user has the email.
+func (p PgHandle) SetEmail(userID int, email string) error {
+	result, err := p.conn().Exec("UPDATE users SET email = $2 WHERE id = $1 AND NOT deleted", userID, email)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return fmt.Errorf("user %s: %w", email, ErrDuplicate)
 		}
+		panic(err)
 	}
 
-	err = txn.Commit()
+	count, err := result.RowsAffected()
 	if err != nil {
 		panic(err)
 	}
+	if count == 0 {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	return nil
 }
 
-// GetExpenses returns all expenses in the database in order of expense_id and
-// created_at
-func (p PgHandle) GetExpenses(userID int) []ledger.Expense {
-	rows, err := p.db.Query(`
-	       SELECT e.id, e.user_id, ue.user_id, e.description, e.amount, e.created_at
-	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
-	       ORDER BY expense_id, created_at
-	   `)
+// GetSettings returns the preferences of userID, unset if they have none
+func (p PgHandle) GetSettings(userID int) Settings {
+	var s Settings
+	err := p.conn().QueryRow(`
+        SELECT default_currency, locale, notifications FROM user_settings WHERE user_id = $1
+    `, userID).Scan(&s.DefaultCurrency, &s.Locale, &s.Notifications)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	return s
+}
+
+// SetSettings replaces the preferences of userID. ErrNotFound is returned if the
+// user doesn't exist.
+func (p PgHandle) SetSettings(userID int, s Settings) error {
+	result, err := p.conn().Exec(`
+        INSERT INTO user_settings (user_id, default_currency, locale, notifications)
+        SELECT id, $2, $3, $4 FROM users WHERE id = $1 AND NOT deleted
+        ON CONFLICT (user_id) DO UPDATE
+        SET default_currency = EXCLUDED.default_currency, locale = EXCLUDED.locale, notifications = EXCLUDED.notifications
+    `, userID, s.DefaultCurrency, s.Locale, s.Notifications)
 	if err != nil {
 		panic(err)
 	}
-	defer rows.Close()
 
-	expensesMap := make(map[int]*ledger.Expense)
-	for rows.Next() {
-		var expenseID int
-		var ownerID int
-		var userID int
-		var amount float64
-		var description string
-		var rawCreatedAt string
-		if err := rows.Scan(&expenseID, &ownerID, &userID, &description, &amount, &rawCreatedAt); err != nil {
+	count, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	if count == 0 {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteUser anonymizes a user by replacing their email and removing their
+// password and settings, so that they can no longer sign in. Their expenses and
+// settlements are kept so that other users' balances remain intact.
+func (p PgHandle) DeleteUser(userID int) {
+	txn, err := p.begin()
+	if err != nil {
+		panic(err)
+	}
+	defer txn.Rollback()
+
+	deleteUser(txn, userID)
+
+	if err := txn.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// deleteUser anonymizes userID and removes their settings
+func deleteUser(db execer, userID int) {
+	_, err := db.Exec(`
+        UPDATE users SET email = $2, name = '', password = NULL, deleted = true
+        WHERE id = $1
+    `, userID, anonymizedEmail(userID))
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := db.Exec("DELETE FROM user_settings WHERE user_id = $1", userID); err != nil {
+		panic(err)
+	}
+}
+
+// UserExists returns true if userID exists and hasn't been deleted
+func (p PgHandle) UserExists(userID int) bool {
+	var exists bool
+	err := p.conn().QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND NOT deleted)", userID).Scan(&exists)
+	if err != nil {
+		panic(err)
+	}
+	return exists
+}
+
+// IsAdmin returns true if userID is an administrator
+func (p PgHandle) IsAdmin(userID int) bool {
+	var admin bool
+	err := p.conn().QueryRow("SELECT is_admin FROM users WHERE id = $1 AND NOT deleted", userID).Scan(&admin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	} else if err != nil {
+		panic(err)
+	}
+	return admin
+}
+
+// SetAdmin makes userID an administrator or not
+func (p PgHandle) SetAdmin(userID int, admin bool) {
+	_, err := p.conn().Exec("UPDATE users SET is_admin = $2 WHERE id = $1", userID, admin)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// mergeUsersStatements move all data of user $1 to user $2. They are run in order
+// in a single transaction.
+var mergeUsersStatements = []string{
+	// In expenses shared by both users, the target takes over the share of the
+	// source. The target would pay one base per person less, so make expenses
+	// with a base a percentage split of the whole amount.
+	`UPDATE expenses_users eu
+	 SET percentage = (e.base_per_person + (e.amount - e.base_per_person * c.n) * CASE
+	         WHEN eu.percentage IS NOT NULL THEN eu.percentage / 100
+	         WHEN eu.shares IS NOT NULL THEN eu.shares::float / c.total_shares
+	         ELSE 1.0 / c.n
+	     END) * 100 / e.amount,
+	     shares = NULL
+	 FROM expenses e, (
+	     SELECT expense_id, count(*) AS n, sum(shares) AS total_shares FROM expenses_users GROUP BY expense_id
+	 ) c
+	 WHERE e.id = eu.expense_id AND c.expense_id = eu.expense_id AND e.base_per_person <> 0 AND eu.expense_id IN (
+	     SELECT expense_id FROM expenses_users WHERE user_id = $1
+	     INTERSECT
+	     SELECT expense_id FROM expenses_users WHERE user_id = $2
+	 )`,
+	`UPDATE expenses SET base_per_person = 0
+	 WHERE base_per_person <> 0 AND id IN (
+	     SELECT expense_id FROM expenses_users WHERE user_id = $1
+	     INTERSECT
+	     SELECT expense_id FROM expenses_users WHERE user_id = $2
+	 )`,
+	// An equal split is no longer equal either, so make it a percentage split.
+	`UPDATE expenses_users eu
+	 SET percentage = 100.0 / (SELECT count(*) FROM expenses_users c WHERE c.expense_id = eu.expense_id)
+	 WHERE eu.percentage IS NULL AND eu.shares IS NULL AND eu.expense_id IN (
+	     SELECT expense_id FROM expenses_users WHERE user_id = $1
+	     INTERSECT
+	     SELECT expense_id FROM expenses_users WHERE user_id = $2
+	 )`,
+	`UPDATE expenses_users t SET percentage = t.percentage + s.percentage, shares = t.shares + s.shares
+	 FROM expenses_users s
+	 WHERE s.user_id = $1 AND t.user_id = $2 AND s.expense_id = t.expense_id`,
+	`DELETE FROM expenses_users s USING expenses_users t
+	 WHERE s.user_id = $1 AND t.user_id = $2 AND s.expense_id = t.expense_id`,
+	`UPDATE expenses_users SET user_id = $2 WHERE user_id = $1`,
+	`UPDATE expenses SET user_id = $2 WHERE user_id = $1`,
+	`UPDATE expenses SET payer_id = $2 WHERE payer_id = $1`,
+
+	// Settlements between the two users cancel out
+	`DELETE FROM settlements
+	 WHERE (from_user_id = $1 AND to_user_id = $2) OR (from_user_id = $2 AND to_user_id = $1)`,
+	`UPDATE settlements SET from_user_id = $2 WHERE from_user_id = $1`,
+	`UPDATE settlements SET to_user_id = $2 WHERE to_user_id = $1`,
+
+	// Drop friendships between the two users and the ones the target already has,
+	// keeping them confirmed if either was
+	`DELETE FROM friends
+	 WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)`,
+	`UPDATE friends t SET confirmed = true
+	 FROM friends s
+	 WHERE s.confirmed AND (
+	     (s.user_id = $1 AND t.user_id = $2 AND s.friend_id = t.friend_id) OR
+	     (s.user_id = $1 AND t.friend_id = $2 AND s.friend_id = t.user_id) OR
+	     (s.friend_id = $1 AND t.friend_id = $2 AND s.user_id = t.user_id) OR
+	     (s.friend_id = $1 AND t.user_id = $2 AND s.user_id = t.friend_id)
+	 )`,
+	`DELETE FROM friends s USING friends t
+	 WHERE (s.user_id = $1 AND t.user_id = $2 AND s.friend_id = t.friend_id) OR
+	       (s.user_id = $1 AND t.friend_id = $2 AND s.friend_id = t.user_id) OR
+	       (s.friend_id = $1 AND t.friend_id = $2 AND s.user_id = t.user_id) OR
+	       (s.friend_id = $1 AND t.user_id = $2 AND s.user_id = t.friend_id)`,
+	`UPDATE friends SET user_id = $2 WHERE user_id = $1`,
+	`UPDATE friends SET friend_id = $2 WHERE friend_id = $1`,
+
+	// The target takes over the group memberships of the source
+	`DELETE FROM group_members s USING group_members t
+	 WHERE s.user_id = $1 AND t.user_id = $2 AND s.group_id = t.group_id`,
+	`UPDATE group_members SET user_id = $2 WHERE user_id = $1`,
+	`UPDATE user_groups SET owner_id = $2 WHERE owner_id = $1`,
+}
+
+// MergeUsers moves all expenses, settlements, friendships and groups of sourceID to
+// targetID and deletes sourceID in a transaction. ErrNotFound is returned if
+// either user doesn't exist.
+func (p PgHandle) MergeUsers(sourceID int, targetID int) error {
+	txn, err := p.begin()
+	if err != nil {
+		panic(err)
+	}
+	defer txn.Rollback()
+
+	var count int
+	err = txn.QueryRow(`
+        SELECT count(*) FROM users WHERE id IN ($1, $2) AND NOT deleted
+    `, sourceID, targetID).Scan(&count)
+	if err != nil {
+		panic(err)
+	}
+	if count != 2 {
+		return fmt.Errorf("merging user %d into %d: %w", sourceID, targetID, ErrNotFound)
+	}
+
+	for _, statement := range mergeUsersStatements {
+		if _, err := txn.Exec(statement, sourceID, targetID); err != nil {
 			panic(err)
 		}
+	}
+
+	deleteUser(txn, sourceID)
+
+	if err := txn.Commit(); err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// RequestFriend requests a friendship with friendID, or confirms it if friendID
+// has already requested it. ErrNotFound is returned if friendID doesn't exist.
+// ErrDuplicate is returned if the friendship has already been requested.
+func (p PgHandle) RequestFriend(userID int, friendID int) (FriendStatus, error) {
+	txn, err := p.begin()
+	if err != nil {
+		panic(err)
+	}
+	defer txn.Rollback()
+
+	var exists bool
+	err = txn.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND NOT deleted)", friendID).Scan(&exists)
+	if err != nil {
+		panic(err)
+	}
+	if !exists {
+		return "", fmt.Errorf("user %d: %w", friendID, ErrNotFound)
+	}
+
+	err = txn.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM friends
+            WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1 AND confirmed)
+        )
+    `, userID, friendID).Scan(&exists)
+	if err != nil {
+		panic(err)
+	}
+	if exists {
+		return "", fmt.Errorf("friendship of users %d and %d: %w", userID, friendID, ErrDuplicate)
+	}
+
+	// Confirm a pending request from friendID
+	result, err := txn.Exec(`
+        UPDATE friends SET confirmed = true
+        WHERE user_id = $2 AND friend_id = $1 AND NOT confirmed
+    `, userID, friendID)
+	if err != nil {
+		panic(err)
+	}
 
-		createdAt, err := time.Parse(time.RFC3339, rawCreatedAt)
+	status := FriendConfirmed
+	if n, _ := result.RowsAffected(); n == 0 {
+		status = FriendPending
+		_, err = txn.Exec("INSERT INTO friends (user_id, friend_id) VALUES($1, $2)", userID, friendID)
 		if err != nil {
 			panic(err)
 		}
+	}
 
-		if _, exists := expensesMap[expenseID]; !exists {
-			expensesMap[expenseID] = &ledger.Expense{expenseID, ownerID, make([]int, 0), amount, description, createdAt}
+	if err = txn.Commit(); err != nil {
+		panic(err)
+	}
+
+	return status, nil
+}
+
+// GetFriends returns the confirmed friends of a user, ordered by email
+func (p PgHandle) GetFriends(userID int) []User {
+	rows, err := p.conn().Query(`
+	       SELECT u.id, u.email, u.name
+	       FROM friends f JOIN users u ON (u.id = CASE WHEN f.user_id = $1 THEN f.friend_id ELSE f.user_id END)
+	       WHERE f.confirmed AND (f.user_id = $1 OR f.friend_id = $1)
+	       ORDER BY u.email
+	   `, userID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name); err != nil {
+			panic(err)
 		}
-		expensesMap[expenseID].Users = append(expensesMap[expenseID].Users, userID)
+		users = append(users, u)
 	}
 
 	if err := rows.Err(); err != nil {
 		panic(err)
 	}
 
-	expenses := make([]ledger.Expense, 0)
-	for _, expense := range expensesMap {
-		expenses = append(expenses, *expense)
+	return users
+}
+
+// GetRecentContacts returns up to limit users who share expenses with userID,
+// most recent shared expense first. Deleted users are left out.
+func (p PgHandle) GetRecentContacts(userID int, limit int) []User {
+	rows, err := p.conn().Query(`
+	       SELECT u.id, u.email, u.name
+	       FROM (
+	           SELECT other.user_id, MAX(e.created_at) AS last_shared_at
+	           FROM expenses_users mine
+	           JOIN expenses_users other ON (other.expense_id = mine.expense_id AND other.user_id <> mine.user_id)
+	           JOIN expenses e ON (e.id = mine.expense_id)
+	           WHERE mine.user_id = $1
+	           GROUP BY other.user_id
+	       ) recent JOIN users u ON (u.id = recent.user_id)
+	       WHERE NOT u.deleted
+	       ORDER BY recent.last_shared_at DESC, u.id
+	       LIMIT $2
+	   `, userID, limit)
+	if err != nil {
+		panic(err)
 	}
-	return expenses
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name); err != nil {
+			panic(err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return users
+}
+
+// CreateExpense creates entries in the expenses and expenses_users tables.
+// The expenses_users tables also includes the owner. ErrTooManyParticipants is
+// returned if more than MaxParticipants users share the expense.
+func (p PgHandle) CreateExpense(e ledger.Expense) error {
+	if err := checkParticipants(e); err != nil {
+		return err
+	}
+
+	var latitude, longitude *float64
+	var place string
+	if e.Location != nil {
+		latitude, longitude, place = e.Location.Latitude, e.Location.Longitude, e.Location.Place
+	}
+	expenseType := e.Type
+	if expenseType == "" {
+		expenseType = ledger.TypeExpense
+	}
+
+	// Insert into expenses and expense_users in a transaction to ensure consistency
+	err := p.inTransaction(func(h PgHandle) error {
+		// Insert into expenses
+		var expenseID int
+		err := h.conn().QueryRow(`
+            INSERT INTO expenses (user_id, payer_id, description, amount, currency, created_at, latitude, longitude, place, base_per_person, notes, type)
+            VALUES($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, $11, $12)
+            RETURNING id
+        `, e.OwnerID, e.Payer(), e.Description, e.Amount, e.Currency, e.CreatedAt, latitude, longitude, place, e.BasePerPerson, e.Notes, expenseType).Scan(&expenseID)
+		if err != nil {
+			return err
+		}
+
+		// Insert into expenses_users
+		stmt, err := h.tx.Prepare(`
+            INSERT INTO expenses_users (expense_id, user_id, percentage, shares)
+            VALUES($1, $2, $3, $4)
+        `)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		// Insert self and the other users into the user list, each once
+		for _, u := range expenseUsers(e) {
+			if _, err := stmt.Exec(expenseID, u, percentage(e, u), shares(e, u)); err != nil {
+				return err
+			}
+		}
+
+		addExpenseTags(h.tx, expenseID, e.Tags)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// UpdateExpense replaces the details of an expense and its entries in
+// expenses_users, keeping its owner, tags and the time it was recorded.
+// ErrNotFound is returned if it doesn't exist and ErrTooManyParticipants if more
+// than MaxParticipants users share it.
+func (p PgHandle) UpdateExpense(e ledger.Expense) error {
+	if err := checkParticipants(e); err != nil {
+		return err
+	}
+
+	var latitude, longitude *float64
+	var place string
+	if e.Location != nil {
+		latitude, longitude, place = e.Location.Latitude, e.Location.Longitude, e.Location.Place
+	}
+	expenseType := e.Type
+	if expenseType == "" {
+		expenseType = ledger.TypeExpense
+	}
+
+	err := p.inTransaction(func(h PgHandle) error {
+		err := h.conn().QueryRow(`
+            UPDATE expenses
+            SET payer_id = $2, description = $3, amount = $4, currency = $5, created_at = $6, latitude = $7, longitude = $8, place = NULLIF($9, ''), base_per_person = $10, notes = $11, type = $12
+            WHERE id = $1
+            RETURNING user_id
+        `, e.ExpenseID, e.Payer(), e.Description, e.Amount, e.Currency, e.CreatedAt, latitude, longitude, place, e.BasePerPerson, e.Notes, expenseType).Scan(&e.OwnerID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := h.tx.Exec("DELETE FROM expenses_users WHERE expense_id = $1", e.ExpenseID); err != nil {
+			return err
+		}
+
+		stmt, err := h.tx.Prepare(`
+            INSERT INTO expenses_users (expense_id, user_id, percentage, shares)
+            VALUES($1, $2, $3, $4)
+        `)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, u := range expenseUsers(e) {
+			if _, err := stmt.Exec(e.ExpenseID, u, percentage(e, u), shares(e, u)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("expense %d: %w", e.ExpenseID, ErrNotFound)
+	} else if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// percentage returns the percentage of a user in an expense's percentage split,
+// or NULL if the expense is split equally
+func percentage(e ledger.Expense, userID int) sql.NullFloat64 {
+	if e.PercentageSplit == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: e.PercentageSplit[userID], Valid: true}
+}
+
+// shares returns the number of shares of a user in an expense's share split, or
+// NULL if the expense isn't split by shares
+func shares(e ledger.Expense, userID int) sql.NullInt64 {
+	if e.ShareSplit == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(e.ShareSplit[userID]), Valid: true}
+}
+
+// GetExpense returns an expense. ErrNotFound is returned if it doesn't exist.
+func (p PgHandle) GetExpense(expenseID int) (ledger.Expense, error) {
+	rows, err := p.conn().Query(`
+	       SELECT e.id, e.user_id, e.payer_id, ue.user_id, ue.percentage, ue.shares, e.description, e.amount, e.currency, e.created_at, e.latitude, e.longitude, e.place, e.base_per_person, e.notes, e.type, e.recorded_at
+	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
+	       WHERE e.id = $1
+	   `, expenseID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	expenses := p.scanExpenses(rows)
+	if len(expenses) == 0 {
+		return ledger.Expense{}, fmt.Errorf("expense %d: %w", expenseID, ErrNotFound)
+	}
+	return expenses[0], nil
+}
+
+// GetExpenses returns the expenses involving userID in order of expense_id and
+// created_at
+func (p PgHandle) GetExpenses(userID int) []ledger.Expense {
+	rows, err := p.conn().Query(`
+	       SELECT e.id, e.user_id, e.payer_id, ue.user_id, ue.percentage, ue.shares, e.description, e.amount, e.currency, e.created_at, e.latitude, e.longitude, e.place, e.base_per_person, e.notes, e.type, e.recorded_at
+	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
+	       WHERE (e.user_id = $1 OR e.payer_id = $1 OR e.id IN (SELECT expense_id FROM expenses_users WHERE user_id = $1))
+	       ORDER BY expense_id, created_at
+	   `, userID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return p.scanExpenses(rows)
+}
+
+// SearchExpenses returns the expenses involving userID with a description
+// containing query, ignoring case
+func (p PgHandle) SearchExpenses(userID int, query string) []ledger.Expense {
+	rows, err := p.conn().Query(`
+	       SELECT e.id, e.user_id, e.payer_id, ue.user_id, ue.percentage, ue.shares, e.description, e.amount, e.currency, e.created_at, e.latitude, e.longitude, e.place, e.base_per_person, e.notes, e.type, e.recorded_at
+	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
+	       WHERE e.description ILIKE '%' || $2 || '%'
+	       AND (e.user_id = $1 OR e.payer_id = $1 OR e.id IN (SELECT expense_id FROM expenses_users WHERE user_id = $1))
+	       ORDER BY expense_id, created_at
+	   `, userID, escapeLike(query))
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return p.scanExpenses(rows)
+}
+
+// GetExpensesByTag returns the expenses involving userID with tag
+func (p PgHandle) GetExpensesByTag(userID int, tag string) []ledger.Expense {
+	rows, err := p.conn().Query(`
+	       SELECT e.id, e.user_id, e.payer_id, ue.user_id, ue.percentage, ue.shares, e.description, e.amount, e.currency, e.created_at, e.latitude, e.longitude, e.place, e.base_per_person, e.notes, e.type, e.recorded_at
+	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
+	       WHERE (e.user_id = $1 OR e.payer_id = $1 OR e.id IN (SELECT expense_id FROM expenses_users WHERE user_id = $1))
+	       AND e.id IN (SELECT et.expense_id FROM expense_tags et JOIN tags t ON (t.id = et.tag_id) WHERE t.name = $2)
+	       ORDER BY expense_id, created_at
+	   `, userID, tag)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return p.scanExpenses(rows)
+}
+
+// GetExpensesSharedWith returns the expenses involving userID and all, or any,
+// of the users in q. A user is involved if they share, paid for or created the
+// expense.
+func (p PgHandle) GetExpensesSharedWith(userID int, q SharedWithQuery) []ledger.Expense {
+	minMatches := 1
+	if q.All {
+		minMatches = len(q.UserIDs)
+	}
+
+	rows, err := p.conn().Query(`
+	       WITH involved AS (
+	           SELECT expense_id, user_id FROM expenses_users
+	           UNION SELECT id, user_id FROM expenses
+	           UNION SELECT id, payer_id FROM expenses
+	       ), shared AS (
+	           SELECT expense_id FROM involved
+	           WHERE user_id = ANY($2)
+	           AND expense_id IN (SELECT expense_id FROM involved WHERE user_id = $1)
+	           GROUP BY expense_id
+	           HAVING COUNT(DISTINCT user_id) >= $3
+	       )
+	       SELECT e.id, e.user_id, e.payer_id, ue.user_id, ue.percentage, ue.shares, e.description, e.amount, e.currency, e.created_at, e.latitude, e.longitude, e.place, e.base_per_person, e.notes, e.type, e.recorded_at
+	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
+	       WHERE e.id IN (SELECT expense_id FROM shared)
+	       ORDER BY expense_id, created_at
+	   `, userID, pq.Array(q.UserIDs), minMatches)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return p.scanExpenses(rows)
+}
+
+// GetExpensesPaidBy returns the expenses userID paid for
+func (p PgHandle) GetExpensesPaidBy(userID int) []ledger.Expense {
+	rows, err := p.conn().Query(`
+	       SELECT e.id, e.user_id, e.payer_id, ue.user_id, ue.percentage, ue.shares, e.description, e.amount, e.currency, e.created_at, e.latitude, e.longitude, e.place, e.base_per_person, e.notes, e.type, e.recorded_at
+	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
+	       WHERE e.payer_id = $1
+	       ORDER BY expense_id, created_at
+	   `, userID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return p.scanExpenses(rows)
+}
+
+// FindExpenses returns the expenses of all users matching q in the order of
+// q.Order
+func (p PgHandle) FindExpenses(q ExpensesQuery) []ledger.Expense {
+	conditions := []string{"true"}
+	args := []interface{}{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if q.OwnerID != 0 {
+		addCondition("user_id = $%d", q.OwnerID)
+	}
+	if q.ParticipantID != 0 {
+		addCondition("id IN (SELECT expense_id FROM expenses_users WHERE user_id = $%d)", q.ParticipantID)
+	}
+	if q.InvolvedID != 0 {
+		addCondition("(user_id = $%[1]d OR payer_id = $%[1]d OR id IN (SELECT expense_id FROM expenses_users WHERE user_id = $%[1]d))", q.InvolvedID)
+	}
+	if q.Tag != "" {
+		addCondition("id IN (SELECT et.expense_id FROM expense_tags et JOIN tags t ON (t.id = et.tag_id) WHERE t.name = $%d)", q.Tag)
+	}
+	if !q.From.IsZero() {
+		addCondition("created_at >= $%d", q.From)
+	}
+	if !q.To.IsZero() {
+		addCondition("created_at <= $%d", q.To)
+	}
+	if q.MinAmount != 0 {
+		addCondition("amount >= $%d", q.MinAmount)
+	}
+	if q.MaxAmount != 0 {
+		addCondition("amount <= $%d", q.MaxAmount)
+	}
+	args = append(args, q.Offset, q.Limit)
+
+	direction := "ASC"
+	if q.Order == NewestFirst {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf(`
+	       SELECT e.id, e.user_id, e.payer_id, ue.user_id, ue.percentage, ue.shares, e.description, e.amount, e.currency, e.created_at, e.latitude, e.longitude, e.place, e.base_per_person, e.notes, e.type, e.recorded_at
+	       FROM expenses e JOIN expenses_users ue ON (e.id = ue.expense_id)
+	       WHERE e.id IN (
+	           SELECT id FROM expenses
+	           WHERE %[1]s
+	           ORDER BY created_at %[2]s, id %[2]s
+	           OFFSET $%[3]d LIMIT NULLIF($%[4]d, 0)
+	       )
+	       ORDER BY e.created_at %[2]s, e.id %[2]s
+	   `, strings.Join(conditions, " AND "), direction, len(args)-1, len(args))
+	rows, err := p.conn().Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return p.scanExpenses(rows)
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// addExpenseTags attaches tags to an expense, creating the tags that don't exist
+// yet and skipping tags the expense already has
+func addExpenseTags(db execer, expenseID int, tags []string) {
+	for _, tag := range tags {
+		_, err := db.Exec("INSERT INTO tags (name) VALUES($1) ON CONFLICT (name) DO NOTHING", tag)
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = db.Exec(`
+            INSERT INTO expense_tags (expense_id, tag_id)
+            SELECT $1, id FROM tags WHERE name = $2
+            ON CONFLICT (expense_id, tag_id) DO NOTHING
+        `, expenseID, tag)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// AddExpenseTags attaches tags to an expense, skipping tags it already has
+func (p PgHandle) AddExpenseTags(expenseID int, tags []string) {
+	txn, err := p.begin()
+	if err != nil {
+		panic(err)
+	}
+	defer txn.Rollback()
+
+	addExpenseTags(txn, expenseID, tags)
+
+	if err := txn.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// GetTags returns the tags of the expenses involving userID in alphabetical order
+func (p PgHandle) GetTags(userID int) []string {
+	rows, err := p.conn().Query(`
+        SELECT DISTINCT t.name
+        FROM tags t JOIN expense_tags et ON (t.id = et.tag_id)
+        WHERE et.expense_id IN (
+            SELECT id FROM expenses WHERE user_id = $1 OR payer_id = $1
+            UNION
+            SELECT expense_id FROM expenses_users WHERE user_id = $1
+        )
+        ORDER BY t.name
+    `, userID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	tags := make([]string, 0)
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			panic(err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return tags
+}
+
+// escapeLike escapes the wildcards in a LIKE pattern so that they match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// newLocation returns the location of an expense out of its nullable columns,
+// nil if it has none
+func newLocation(latitude sql.NullFloat64, longitude sql.NullFloat64, place sql.NullString) *ledger.Location {
+	if !latitude.Valid && !place.Valid {
+		return nil
+	}
+
+	location := &ledger.Location{Place: place.String}
+	if latitude.Valid && longitude.Valid {
+		location.Latitude = &latitude.Float64
+		location.Longitude = &longitude.Float64
+	}
+	return location
+}
+
+// scanExpenses reads expenses joined with their users from rows, one row per
+// expense user, and loads their tags
+func (p PgHandle) scanExpenses(rows *sql.Rows) []ledger.Expense {
+	expensesMap := make(map[int]*ledger.Expense)
+	expenseIDs := make([]int, 0) // Expense ids in the order of the rows
+	for rows.Next() {
+		var expenseID int
+		var ownerID int
+		var payerID int
+		var userID int
+		var percentage sql.NullFloat64
+		var shareCount sql.NullInt64
+		var amount float64
+		var currency string
+		var description string
+		var createdAt time.Time
+		var latitude sql.NullFloat64
+		var longitude sql.NullFloat64
+		var place sql.NullString
+		var basePerPerson float64
+		var notes string
+		var expenseType string
+		var recordedAt time.Time
+		if err := rows.Scan(&expenseID, &ownerID, &payerID, &userID, &percentage, &shareCount, &description, &amount, &currency, &createdAt, &latitude, &longitude, &place, &basePerPerson, &notes, &expenseType, &recordedAt); err != nil {
+			panic(err)
+		}
+
+		if _, exists := expensesMap[expenseID]; !exists {
+			expenseIDs = append(expenseIDs, expenseID)
+			expensesMap[expenseID] = &ledger.Expense{
+				ExpenseID:   expenseID,
+				OwnerID:     ownerID,
+				PayerID:     payerID,
+				Users:       make([]int, 0),
+				Amount:      amount,
+				Currency:    currency,
+				Description: description,
+				Notes:       notes,
+				CreatedAt:   createdAt.UTC(),
+				RecordedAt:  recordedAt.UTC(),
+				Location:    newLocation(latitude, longitude, place),
+				Type:        ledger.ExpenseType(expenseType),
+
+				BasePerPerson: basePerPerson,
+			}
+		}
+		expensesMap[expenseID].Users = append(expensesMap[expenseID].Users, userID)
+		if percentage.Valid {
+			if expensesMap[expenseID].PercentageSplit == nil {
+				expensesMap[expenseID].PercentageSplit = make(map[int]float64)
+			}
+			expensesMap[expenseID].PercentageSplit[userID] = percentage.Float64
+		}
+		if shareCount.Valid {
+			if expensesMap[expenseID].ShareSplit == nil {
+				expensesMap[expenseID].ShareSplit = make(map[int]int)
+			}
+			expensesMap[expenseID].ShareSplit[userID] = int(shareCount.Int64)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	// Load the tags of all expenses at once
+	tagRows, err := p.conn().Query(`
+        SELECT et.expense_id, t.name
+        FROM expense_tags et JOIN tags t ON (t.id = et.tag_id)
+        WHERE et.expense_id = ANY($1)
+        ORDER BY t.name
+    `, pq.Array(expenseIDs))
+	if err != nil {
+		panic(err)
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var expenseID int
+		var tag string
+		if err := tagRows.Scan(&expenseID, &tag); err != nil {
+			panic(err)
+		}
+		expensesMap[expenseID].Tags = append(expensesMap[expenseID].Tags, tag)
+	}
+
+	if err := tagRows.Err(); err != nil {
+		panic(err)
+	}
+
+	expenses := make([]ledger.Expense, len(expenseIDs))
+	for i, expenseID := range expenseIDs {
+		expenses[i] = *expensesMap[expenseID]
+		expenses[i].ExcludeOwner = !expenses[i].HasUser(expenses[i].OwnerID)
+	}
+	return expenses
+}
+
+// GetPayerTotals returns the total amount each user paid for expenses incurred
+// between from and to inclusive, highest first. Ties are ordered by user id.
+func (p PgHandle) GetPayerTotals(from time.Time, to time.Time) []PayerTotal {
+	rows, err := p.conn().Query(`
+	       SELECT payer_id, SUM(amount), COUNT(*)
+	       FROM expenses
+	       WHERE created_at >= $1 AND created_at <= $2
+	       GROUP BY payer_id
+	       ORDER BY SUM(amount) DESC, payer_id
+	   `, from, to)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	totals := make([]PayerTotal, 0)
+	for rows.Next() {
+		var t PayerTotal
+		if err := rows.Scan(&t.UserID, &t.Total, &t.Count); err != nil {
+			panic(err)
+		}
+		totals = append(totals, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return totals
+}
+
+// CreateSettlement inserts a settlement into the database and returns its id
+func (p PgHandle) CreateSettlement(s ledger.Settlement) int {
+	var id int
+	err := p.conn().QueryRow(`
+        INSERT INTO settlements (from_user_id, to_user_id, amount, created_at)
+        VALUES($1, $2, $3, $4)
+        RETURNING id
+    `, s.FromUserID, s.ToUserID, s.Amount, s.CreatedAt).Scan(&id)
+	if err != nil {
+		panic(err)
+	}
+
+	return id
+}
+
+// CreateGroup creates a group of the owner and the members in a transaction
+func (p PgHandle) CreateGroup(g Group) int {
+	var groupID int
+	err := p.inTransaction(func(h PgHandle) error {
+		err := h.conn().QueryRow(`
+            INSERT INTO user_groups (name, owner_id)
+            VALUES($1, $2)
+            RETURNING id
+        `, g.Name, g.OwnerID).Scan(&groupID)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range groupMembers(g) {
+			if _, err := h.conn().Exec("INSERT INTO group_members (group_id, user_id) VALUES($1, $2)", groupID, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return groupID
+}
+
+// GetGroup returns a group. ErrNotFound is returned if it doesn't exist.
+func (p PgHandle) GetGroup(groupID int) (Group, error) {
+	g := Group{ID: groupID}
+	err := p.conn().QueryRow("SELECT name, owner_id FROM user_groups WHERE id = $1", groupID).Scan(&g.Name, &g.OwnerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Group{}, fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+	} else if err != nil {
+		panic(err)
+	}
+
+	rows, err := p.conn().Query("SELECT user_id FROM group_members WHERE group_id = $1 ORDER BY user_id", groupID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	g.Members = make([]int, 0)
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			panic(err)
+		}
+		g.Members = append(g.Members, userID)
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return g, nil
+}
+
+// GetSettlement returns a settlement. ErrNotFound is returned if it doesn't exist.
+func (p PgHandle) GetSettlement(settlementID int) (ledger.Settlement, error) {
+	var s ledger.Settlement
+	err := p.conn().QueryRow(`
+	       SELECT id, from_user_id, to_user_id, amount, created_at
+	       FROM settlements
+	       WHERE id = $1
+	   `, settlementID).Scan(&s.SettlementID, &s.FromUserID, &s.ToUserID, &s.Amount, &s.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ledger.Settlement{}, fmt.Errorf("settlement %d: %w", settlementID, ErrNotFound)
+	} else if err != nil {
+		panic(err)
+	}
+	s.CreatedAt = s.CreatedAt.UTC()
+	return s, nil
+}
+
+// GetSettlements returns all settlements paid or received by userID in order of
+// created_at
+func (p PgHandle) GetSettlements(userID int) []ledger.Settlement {
+	rows, err := p.conn().Query(`
+	       SELECT id, from_user_id, to_user_id, amount, created_at
+	       FROM settlements
+	       WHERE from_user_id = $1 OR to_user_id = $1
+	       ORDER BY created_at, id
+	   `, userID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	settlements := make([]ledger.Settlement, 0)
+	for rows.Next() {
+		var s ledger.Settlement
+		if err := rows.Scan(&s.SettlementID, &s.FromUserID, &s.ToUserID, &s.Amount, &s.CreatedAt); err != nil {
+			panic(err)
+		}
+		s.CreatedAt = s.CreatedAt.UTC()
+		settlements = append(settlements, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return settlements
+}
+
+// CountExpensesSince returns the number of expenses created by userID that were
+// recorded at or after since
+func (p PgHandle) CountExpensesSince(userID int, since time.Time) int {
+	var count int
+	err := p.conn().QueryRow(`
+        SELECT count(*) FROM expenses WHERE user_id = $1 AND recorded_at >= $2
+    `, userID, since).Scan(&count)
+	if err != nil {
+		panic(err)
+	}
+	return count
+}
+
+// GetLastAction returns the most recently recorded expense or settlement created
+// by userID, together with all users affected by it. ErrNotFound is returned
+// if the user hasn't created any.
+func (p PgHandle) GetLastAction(userID int) (Action, error) {
+	var a Action
+	err := p.conn().QueryRow(`
+        SELECT type, id, recorded_at FROM (
+            SELECT 'expense' AS type, id, recorded_at FROM expenses WHERE user_id = $1
+            UNION ALL
+            SELECT 'settlement' AS type, id, recorded_at FROM settlements WHERE from_user_id = $1
+        ) actions
+        ORDER BY recorded_at DESC, id DESC
+        LIMIT 1
+    `, userID).Scan(&a.Type, &a.ID, &a.RecordedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Action{}, fmt.Errorf("last action of user %d: %w", userID, ErrNotFound)
+	} else if err != nil {
+		panic(err)
+	}
+
+	var rows *sql.Rows
+	if a.Type == ActionExpense {
+		// The owner and payer are affected even if they don't share the expense
+		rows, err = p.conn().Query(`
+            SELECT user_id FROM expenses_users WHERE expense_id = $1
+            UNION
+            SELECT user_id FROM expenses WHERE id = $1
+            UNION
+            SELECT payer_id FROM expenses WHERE id = $1
+        `, a.ID)
+	} else {
+		rows, err = p.conn().Query(`
+            SELECT from_user_id FROM settlements WHERE id = $1
+            UNION ALL
+            SELECT to_user_id FROM settlements WHERE id = $1
+        `, a.ID)
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	a.Users = make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			panic(err)
+		}
+		a.Users = append(a.Users, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return a, nil
+}
+
+// DeleteExpense deletes an expense and its entries in expenses_users
+func (p PgHandle) DeleteExpense(expenseID int) {
+	txn, err := p.begin()
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err = txn.Exec("DELETE FROM expenses_users WHERE expense_id = $1", expenseID); err != nil {
+		txn.Rollback()
+		panic(err)
+	}
+
+	if _, err = txn.Exec("DELETE FROM expense_tags WHERE expense_id = $1", expenseID); err != nil {
+		txn.Rollback()
+		panic(err)
+	}
+
+	if _, err = txn.Exec("DELETE FROM expenses WHERE id = $1", expenseID); err != nil {
+		txn.Rollback()
+		panic(err)
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// DeleteSettlement deletes a settlement
+func (p PgHandle) DeleteSettlement(settlementID int) {
+	_, err := p.conn().Exec("DELETE FROM settlements WHERE id = $1", settlementID)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// WithAudit makes a mutation and records the entry it returns in the audit log,
+// all in a single transaction. If the mutation fails or panics, the transaction
+// is rolled back.
+func (p PgHandle) WithAudit(mutate AuditMutation) error {
+	return p.inTransaction(func(h PgHandle) error {
+		entry, err := mutate(h)
+		if err != nil {
+			return err
+		}
+
+		_, err = h.conn().Exec(`
+            INSERT INTO audit_log (user_id, action, entity_id, before, after)
+            VALUES($1, $2, $3, NULLIF($4, '')::jsonb, NULLIF($5, '')::jsonb)
+        `, entry.UserID, entry.Action, entry.EntityID, entry.Before, entry.After)
+		if err != nil {
+			panic(err)
+		}
+		return nil
+	})
+}
+
+// GetAuditEntries returns the entries of the audit log matching q, newest first
+func (p PgHandle) GetAuditEntries(q AuditQuery) []AuditEntry {
+	conditions := []string{"true"}
+	args := []interface{}{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if q.UserID != 0 {
+		addCondition("user_id = $%d", q.UserID)
+	}
+	if q.Action != "" {
+		addCondition("action = $%d", q.Action)
+	}
+	if !q.From.IsZero() {
+		addCondition("created_at >= $%d", q.From)
+	}
+	if !q.To.IsZero() {
+		addCondition("created_at <= $%d", q.To)
+	}
+	args = append(args, q.Limit)
+
+	query := fmt.Sprintf(`
+	       SELECT id, user_id, action, entity_id, COALESCE(before::text, ''), COALESCE(after::text, ''), created_at
+	       FROM audit_log
+	       WHERE %s
+	       ORDER BY id DESC
+	       LIMIT NULLIF($%d, 0)
+	   `, strings.Join(conditions, " AND "), len(args))
+	rows, err := p.conn().Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Action, &e.EntityID, &e.Before, &e.After, &e.CreatedAt); err != nil {
+			panic(err)
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return entries
 }
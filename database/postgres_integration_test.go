@@ -0,0 +1,578 @@
+//go:build integration
+// +build integration
+
+package database
+
+// Integration tests against a real postgresql database, started in a docker
+// container. Run them with:
+//
+//	go test -tags integration ./database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/freewilll/splitter/ledger"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// startPostgres starts a postgresql container and returns a database with the
+// schema created. The container is terminated when the test finishes.
+func startPostgres(t *testing.T) Database {
+	return startPostgresWithIsolation(t, sql.LevelDefault)
+}
+
+// startPostgresWithIsolation is like startPostgres, creating expenses with the
+// given isolation level
+func startPostgresWithIsolation(t *testing.T, isolation sql.IsolationLevel) Database {
+	db := startEmptyPostgres(t, isolation)
+
+	dbh := db.Connect()
+	defer dbh.Close()
+	dbh.CreateSchema()
+
+	return db
+}
+
+// startEmptyPostgres starts a postgresql container and returns a database
+// without a schema
+func startEmptyPostgres(t *testing.T, isolation sql.IsolationLevel) PgDatabase {
+	ctx := context.Background()
+
+	port := nat.Port("5432/tcp")
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "postgres:13",
+			ExposedPorts: []string{string(port)},
+			Env: map[string]string{
+				"POSTGRES_USER":     "splitter",
+				"POSTGRES_PASSWORD": "secret",
+				"POSTGRES_DB":       "splitter",
+			},
+			WaitingFor: wait.ForSQL(port, "postgres", func(p nat.Port) string {
+				return "host=localhost port=" + p.Port() + " user=splitter password=secret dbname=splitter sslmode=disable"
+			}).Timeout(time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("Unable to start postgres: %v", err)
+	}
+	t.Cleanup(func() { container.Terminate(ctx) })
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("Unable to get postgres host: %v", err)
+	}
+	mappedPort, err := container.MappedPort(ctx, port)
+	if err != nil {
+		t.Fatalf("Unable to get postgres port: %v", err)
+	}
+
+	return NewPgDatabase(Config{
+		Host:      host,
+		Port:      mappedPort.Int(),
+		User:      "splitter",
+		Password:  "secret",
+		Name:      "splitter",
+		Isolation: isolation,
+	})
+}
+
+func TestPgUsers(t *testing.T) {
+	// Create a user and authenticate with the right and wrong password
+
+	db := startPostgres(t)
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	userID, err := dbh.CreateUser("test4@getstream.io", "secret")
+	if err != nil {
+		t.Fatalf("Unable to create user: %v", err)
+	}
+
+	if _, err := dbh.CreateUser("test4@getstream.io", "secret"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("wanted %v, got %v", ErrDuplicate, err)
+	}
+
+	gotUserID, err := dbh.AuthenticateUser("test4@getstream.io", "secret")
+	if err != nil || gotUserID != userID {
+		t.Errorf("wanted user %d, got %d (%v)", userID, gotUserID, err)
+	}
+
+	if _, err := dbh.AuthenticateUser("test4@getstream.io", "wrong"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("wanted %v, got %v", ErrPasswordMismatch, err)
+	}
+
+	if _, err := dbh.AuthenticateUser("nobody@getstream.io", "secret"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+
+	if !dbh.UserExists(userID) {
+		t.Errorf("wanted user %d to exist", userID)
+	}
+
+	if err := dbh.SetEmail(userID, "test1@getstream.io"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("wanted %v, got %v", ErrDuplicate, err)
+	}
+	if err := dbh.SetEmail(userID, "test5@getstream.io"); err != nil {
+		t.Fatalf("Unable to change email: %v", err)
+	}
+	if gotUserID, err := dbh.AuthenticateUser("test5@getstream.io", "secret"); err != nil || gotUserID != userID {
+		t.Errorf("wanted user %d with the new email, got %d (%v)", userID, gotUserID, err)
+	}
+
+	if s := dbh.GetSettings(userID); s != (Settings{}) {
+		t.Errorf("wanted no settings, got %+v", s)
+	}
+	for _, settings := range []Settings{{Locale: "de-DE", Notifications: true}, {DefaultCurrency: "GBP"}} {
+		if err := dbh.SetSettings(userID, settings); err != nil {
+			t.Fatalf("Unable to set settings: %v", err)
+		}
+		if s := dbh.GetSettings(userID); s != settings {
+			t.Errorf("wanted %+v, got %+v", settings, s)
+		}
+	}
+
+	dbh.DeleteUser(userID)
+	if s := dbh.GetSettings(userID); s != (Settings{}) {
+		t.Errorf("wanted the settings of the deleted user removed, got %+v", s)
+	}
+	if err := dbh.SetSettings(userID, Settings{Locale: "nl"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+	if dbh.UserExists(userID) {
+		t.Errorf("wanted deleted user %d not to exist", userID)
+	}
+}
+
+func TestPgExpenses(t *testing.T) {
+	// Create expenses between the seeded test users and read them back
+
+	db := startPostgres(t)
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	// The owner is added to the users by CreateExpense
+	createdAt := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	latitude, longitude := 52.37, 4.89
+	dbh.CreateExpense(ledger.Expense{
+		OwnerID:     1,
+		Users:       []int{2, 3},
+		Amount:      42,
+		Currency:    "EUR",
+		Description: "Dinner",
+		Notes:       "Table 12, tip included",
+		CreatedAt:   createdAt,
+		Tags:        []string{"food", "work"},
+		Location:    &ledger.Location{Latitude: &latitude, Longitude: &longitude, Place: "Amsterdam"},
+	})
+	dbh.CreateExpense(ledger.Expense{
+		OwnerID:         2,
+		PayerID:         1,
+		Users:           []int{1},
+		Amount:          10,
+		Currency:        "EUR",
+		Description:     "Coffee",
+		CreatedAt:       createdAt.Add(time.Hour),
+		PercentageSplit: map[int]float64{1: 40, 2: 60},
+	})
+
+	expenses := dbh.GetExpenses(1)
+	if len(expenses) != 2 {
+		t.Fatalf("wanted 2 expenses, got %d", len(expenses))
+	}
+	sort.Slice(expenses, func(i, j int) bool { return expenses[i].ExpenseID < expenses[j].ExpenseID })
+
+	dinner := expenses[0]
+	sort.Ints(dinner.Users)
+	if dinner.OwnerID != 1 || dinner.Payer() != 1 || dinner.Amount != 42 || dinner.Description != "Dinner" || dinner.Notes != "Table 12, tip included" || len(dinner.Users) != 3 {
+		t.Errorf("unexpected expense %+v", dinner)
+	}
+	if !dinner.CreatedAt.Equal(createdAt) {
+		t.Errorf("wanted created at %v, got %v", createdAt, dinner.CreatedAt)
+	}
+
+	if dinner.Type != ledger.TypeExpense {
+		t.Errorf("wanted type %q by default, got %q", ledger.TypeExpense, dinner.Type)
+	}
+
+	coffee := expenses[1]
+	if coffee.OwnerID != 2 || coffee.Payer() != 1 || coffee.PercentageSplit[2] != 60 {
+		t.Errorf("unexpected expense %+v", coffee)
+	}
+
+	// Expenses shared by too many users aren't created
+	oldMaxParticipants := MaxParticipants
+	MaxParticipants = 2
+	err := dbh.CreateExpense(ledger.Expense{OwnerID: 1, Users: []int{2, 3}, Amount: 30, Currency: "EUR", Description: "Too many", CreatedAt: createdAt})
+	MaxParticipants = oldMaxParticipants
+	if !errors.Is(err, ErrTooManyParticipants) {
+		t.Errorf("wanted %v, got %v", ErrTooManyParticipants, err)
+	}
+	if got := dbh.GetExpenses(1); len(got) != 2 {
+		t.Errorf("wanted 2 expenses, got %d", len(got))
+	}
+
+	// Only the expenses a user is involved in are returned
+	if got := dbh.GetExpenses(3); len(got) != 1 || got[0].Description != "Dinner" {
+		t.Errorf("wanted only the dinner for user 3, got %+v", got)
+	}
+
+	// The balances add up across both expenses
+	balance := ledger.CalculateBalance(expenses, nil, 2)
+	if balance.Balance != -20 {
+		t.Errorf("wanted balance -20, got %f", balance.Balance)
+	}
+
+	if !reflect.DeepEqual(dinner.Location, &ledger.Location{Latitude: &latitude, Longitude: &longitude, Place: "Amsterdam"}) || coffee.Location != nil {
+		t.Errorf("wanted a location on the dinner only, got %+v and %+v", dinner.Location, coffee.Location)
+	}
+
+	if !reflect.DeepEqual(dinner.Tags, []string{"food", "work"}) || coffee.Tags != nil {
+		t.Errorf("wanted tags on the dinner only, got %v and %v", dinner.Tags, coffee.Tags)
+	}
+
+	dbh.AddExpenseTags(coffee.ExpenseID, []string{"work"})
+	tagged := dbh.GetExpensesByTag(3, "work")
+	if len(tagged) != 1 || tagged[0].Description != "Dinner" {
+		t.Errorf("wanted only the dinner, got %+v", tagged)
+	}
+	if tags := dbh.GetTags(2); !reflect.DeepEqual(tags, []string{"food", "work"}) {
+		t.Errorf("wanted tags food and work, got %v", tags)
+	}
+
+	// Both expenses were paid for by user 1, even though user 2 recorded one
+	if paid := dbh.GetExpensesPaidBy(1); len(paid) != 2 {
+		t.Errorf("wanted 2 expenses paid by user 1, got %+v", paid)
+	}
+	if paid := dbh.GetExpensesPaidBy(2); len(paid) != 0 {
+		t.Errorf("wanted no expenses paid by user 2, got %+v", paid)
+	}
+
+	// The owner in the users doesn't violate the uniqueness of expense users
+	dbh.CreateExpense(ledger.Expense{
+		OwnerID:       3,
+		Users:         []int{3, 1, 1},
+		Amount:        6,
+		Currency:      "EUR",
+		Description:   "Snack",
+		CreatedAt:     createdAt,
+		BasePerPerson: 1,
+	})
+	action, err := dbh.GetLastAction(3)
+	if err != nil {
+		t.Fatalf("Unable to get the snack: %v", err)
+	}
+	snack, _ := dbh.GetExpense(action.ID)
+	if sort.Ints(snack.Users); !reflect.DeepEqual(snack.Users, []int{1, 3}) {
+		t.Errorf("wanted the snack shared by users 1 and 3 once, got %v", snack.Users)
+	}
+	if snack.BasePerPerson != 1 {
+		t.Errorf("wanted a base per person of 1, got %v", snack.BasePerPerson)
+	}
+	dbh.DeleteExpense(snack.ExpenseID)
+
+	// Expenses of all users can be filtered and paged
+	found := dbh.FindExpenses(ExpensesQuery{OwnerID: 2})
+	if len(found) != 1 || found[0].Description != "Coffee" {
+		t.Errorf("wanted only the coffee, got %+v", found)
+	}
+	found = dbh.FindExpenses(ExpensesQuery{ParticipantID: 3, MinAmount: 40, To: createdAt})
+	if len(found) != 1 || found[0].Description != "Dinner" || len(found[0].Users) != 3 {
+		t.Errorf("wanted only the dinner with all its users, got %+v", found)
+	}
+	if found = dbh.FindExpenses(ExpensesQuery{Offset: 1, Limit: 1}); len(found) != 1 || found[0].Description != "Coffee" {
+		t.Errorf("wanted the second page to be the coffee, got %+v", found)
+	}
+	if found = dbh.FindExpenses(ExpensesQuery{Limit: 1, Order: NewestFirst}); len(found) != 1 || found[0].Description != "Coffee" {
+		t.Errorf("wanted the newest expense to be the coffee, got %+v", found)
+	}
+	if found = dbh.FindExpenses(ExpensesQuery{InvolvedID: 3, Tag: "work"}); len(found) != 1 || found[0].Description != "Dinner" {
+		t.Errorf("wanted only the dinner tagged work, got %+v", found)
+	}
+
+	// User 1 shared the coffee with user 2 after the dinner with user 3
+	contacts := dbh.GetRecentContacts(1, 10)
+	if len(contacts) != 2 || contacts[0].ID != 2 || contacts[1].ID != 3 {
+		t.Errorf("wanted users 2 and 3, got %+v", contacts)
+	}
+	if contacts = dbh.GetRecentContacts(3, 1); len(contacts) != 1 || contacts[0].ID != 1 {
+		t.Errorf("wanted only user 1, got %+v", contacts)
+	}
+
+	// Searching only finds the matching expense
+	found = dbh.SearchExpenses(3, "DINNER")
+	if len(found) != 1 || found[0].Description != "Dinner" {
+		t.Errorf("wanted only the dinner, got %+v", found)
+	}
+}
+
+func TestPgExpensesSharedWith(t *testing.T) {
+	// Filter the expenses of test user 1 by the other users involved
+
+	db := startPostgres(t)
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	createdAt := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, e := range []ledger.Expense{
+		{OwnerID: 1, Users: []int{2, 3}, Description: "Hotel"},
+		{OwnerID: 1, Users: []int{2}, Description: "Train"},
+		{OwnerID: 2, PayerID: 3, Users: []int{1}, Description: "Paid by user 3"},
+		{OwnerID: 2, Users: []int{3}, Description: "Without user 1"},
+	} {
+		e.Amount = 42
+		e.Currency = "EUR"
+		e.CreatedAt = createdAt
+		dbh.CreateExpense(e)
+	}
+
+	tests := []struct {
+		Query  SharedWithQuery
+		Wanted []string
+	}{
+		{SharedWithQuery{UserIDs: []int{2, 3}, All: true}, []string{"Hotel", "Paid by user 3"}},
+		{SharedWithQuery{UserIDs: []int{2}, All: true}, []string{"Hotel", "Paid by user 3", "Train"}},
+		{SharedWithQuery{UserIDs: []int{3}}, []string{"Hotel", "Paid by user 3"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, e := range dbh.GetExpensesSharedWith(1, test.Query) {
+			got = append(got, e.Description)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.Wanted) {
+			t.Errorf("%+v: wanted %v, got %v", test.Query, test.Wanted, got)
+		}
+	}
+}
+
+func TestPgMergeUsers(t *testing.T) {
+	// Merge test user 1 into test user 2, who both share an expense paid by
+	// test user 3
+
+	db := startPostgres(t)
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	dbh.CreateExpense(ledger.Expense{
+		OwnerID:     3,
+		Users:       []int{1, 2},
+		Amount:      30,
+		Currency:    "EUR",
+		Description: "Dinner",
+		CreatedAt:   time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC),
+	})
+	dbh.CreateSettlement(ledger.Settlement{FromUserID: 1, ToUserID: 2, Amount: 5, CreatedAt: time.Now()})
+
+	if err := dbh.MergeUsers(1, 2); err != nil {
+		t.Fatalf("Unable to merge users: %v", err)
+	}
+
+	balance := ledger.CalculateBalance(dbh.GetExpenses(2), dbh.GetSettlements(2), 2)
+	if balance.Balance != -20 || balance.DebtTo(3) != 20 {
+		t.Errorf("wanted user 2 to owe user 3 €20, got %+v", balance)
+	}
+
+	if _, err := dbh.AuthenticateUser("test1@getstream.io", "secret"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+
+	if err := dbh.MergeUsers(1, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestPgAuditLog(t *testing.T) {
+	// An audited mutation writes one audit entry in the same transaction. A
+	// failing one is rolled back along with its entry.
+
+	db := startPostgres(t)
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	audited := NewAuditedHandle(dbh, 1)
+	settlementID := audited.CreateSettlement(ledger.Settlement{FromUserID: 1, ToUserID: 2, Amount: 5, CreatedAt: time.Now()})
+
+	entries := dbh.GetAuditEntries(AuditQuery{UserID: 1})
+	if len(entries) != 1 || entries[0].Action != AuditCreateSettlement || entries[0].EntityID != settlementID {
+		t.Fatalf("wanted one create_settlement entry, got %+v", entries)
+	}
+
+	err := audited.MergeUsers(1, 999)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+
+	func() {
+		defer func() { recover() }()
+		dbh.WithAudit(func(h Handle) (AuditEntry, error) {
+			h.DeleteSettlement(settlementID)
+			panic("failure after the mutation")
+		})
+	}()
+
+	if _, err := dbh.GetSettlement(settlementID); err != nil {
+		t.Errorf("wanted the deletion rolled back, got %v", err)
+	}
+	if entries := dbh.GetAuditEntries(AuditQuery{}); len(entries) != 1 {
+		t.Errorf("wanted 1 audit entry, got %d", len(entries))
+	}
+}
+
+func TestPgConcurrentExpenses(t *testing.T) {
+	// Create overlapping expenses between the seeded test users concurrently with
+	// serializable transactions and ensure the balances are consistent
+
+	db := startPostgresWithIsolation(t, sql.LevelSerializable)
+
+	const expensesPerUser = 10
+	var wg sync.WaitGroup
+	for ownerID := 1; ownerID <= 3; ownerID++ {
+		for i := 0; i < expensesPerUser; i++ {
+			wg.Add(1)
+			go func(ownerID int) {
+				defer wg.Done()
+				dbh := db.Connect()
+				defer dbh.Close()
+
+				others := make([]int, 0, 2)
+				for u := 1; u <= 3; u++ {
+					if u != ownerID {
+						others = append(others, u)
+					}
+				}
+				dbh.CreateExpense(ledger.Expense{
+					OwnerID:     ownerID,
+					Users:       others,
+					Amount:      float64(3 * ownerID),
+					Currency:    "EUR",
+					Description: "Round",
+					CreatedAt:   time.Now(),
+				})
+			}(ownerID)
+		}
+	}
+	wg.Wait()
+
+	dbh := db.Connect()
+	defer dbh.Close()
+	expenses := dbh.GetExpenses(1)
+	if len(expenses) != 3*expensesPerUser {
+		t.Fatalf("wanted %d expenses, got %d", 3*expensesPerUser, len(expenses))
+	}
+
+	// User n paid 3n per expense and owes n' for every expense of user n'
+	total := 0.0
+	for userID := 1; userID <= 3; userID++ {
+		balance := ledger.CalculateBalance(expenses, nil, userID)
+		wanted := float64(expensesPerUser * (3*userID - 6))
+		if balance.Balance != wanted {
+			t.Errorf("user %d: wanted balance %f, got %f", userID, wanted, balance.Balance)
+		}
+		total += balance.Balance
+	}
+	if total != 0 {
+		t.Errorf("wanted balances to add up to 0, got %f", total)
+	}
+}
+
+func TestPgCheckSchema(t *testing.T) {
+	// A database without a schema, or with an outdated one, fails the check with
+	// what is missing
+
+	db := startEmptyPostgres(t, sql.LevelDefault)
+
+	err := db.CheckSchema()
+	if !errors.Is(err, ErrSchemaMismatch) || !strings.Contains(err.Error(), "table users") || !strings.Contains(err.Error(), "table expenses") {
+		t.Errorf("wanted %v with the missing tables, got %v", ErrSchemaMismatch, err)
+	}
+
+	dbh := db.Connect()
+	defer dbh.Close()
+	dbh.CreateSchema()
+	if err := db.CheckSchema(); err != nil {
+		t.Errorf("wanted no error with the schema created, got %v", err)
+	}
+
+	if _, err := dbh.(*PgHandle).conn().Exec("ALTER TABLE expenses DROP COLUMN notes"); err != nil {
+		t.Fatalf("Unable to drop column: %v", err)
+	}
+	err = db.CheckSchema()
+	if !errors.Is(err, ErrSchemaMismatch) || !strings.HasSuffix(err.Error(), "missing column expenses.notes") {
+		t.Errorf("wanted %v with the missing column, got %v", ErrSchemaMismatch, err)
+	}
+}
+
+func TestPgGroups(t *testing.T) {
+	// A group has its owner and members without duplicates, and a merged user's
+	// membership is taken over by the target
+
+	db := startPostgres(t)
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	groupID := dbh.CreateGroup(Group{Name: "Trip", OwnerID: 2, Members: []int{3, 1, 3}})
+	group, err := dbh.GetGroup(groupID)
+	if err != nil {
+		t.Fatalf("Unable to get group: %v", err)
+	}
+	if group.Name != "Trip" || group.OwnerID != 2 || !reflect.DeepEqual(group.Members, []int{1, 2, 3}) {
+		t.Errorf("unexpected group %+v", group)
+	}
+
+	if err := dbh.MergeUsers(1, 3); err != nil {
+		t.Fatalf("Unable to merge users: %v", err)
+	}
+	if group, _ := dbh.GetGroup(groupID); !reflect.DeepEqual(group.Members, []int{2, 3}) {
+		t.Errorf("wanted members [2 3] after merging, got %+v", group)
+	}
+
+	if _, err := dbh.GetGroup(42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestPgUpdateExpense(t *testing.T) {
+	// An updated expense has its new details and users, and keeps its owner and
+	// tags
+
+	db := startPostgres(t)
+	dbh := db.Connect()
+	defer dbh.Close()
+
+	createdAt := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	dbh.CreateExpense(ledger.Expense{OwnerID: 1, Users: []int{2}, Amount: 42, Currency: "EUR", Description: "Dinner", CreatedAt: createdAt, Tags: []string{"food"}})
+	action, err := dbh.GetLastAction(1)
+	if err != nil {
+		t.Fatalf("Unable to get last action: %v", err)
+	}
+
+	err = dbh.UpdateExpense(ledger.Expense{ExpenseID: action.ID, OwnerID: 2, Users: []int{3}, Amount: 30, Currency: "EUR", Description: "Lunch", CreatedAt: createdAt})
+	if err != nil {
+		t.Fatalf("Unable to update expense: %v", err)
+	}
+
+	expense, err := dbh.GetExpense(action.ID)
+	if err != nil {
+		t.Fatalf("Unable to get expense: %v", err)
+	}
+	sort.Ints(expense.Users)
+	if expense.OwnerID != 1 || expense.Amount != 30 || expense.Description != "Lunch" || !reflect.DeepEqual(expense.Users, []int{1, 3}) || !reflect.DeepEqual(expense.Tags, []string{"food"}) {
+		t.Errorf("unexpected expense %+v", expense)
+	}
+
+	if err := dbh.UpdateExpense(ledger.Expense{ExpenseID: 42, OwnerID: 1, Users: []int{2}, Amount: 1, Currency: "EUR", Description: "Snack", CreatedAt: createdAt}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v, got %v", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrSchemaMismatch is returned when the database doesn't have the tables and
+// columns of the schema, e.g. because it hasn't been created or is out of date
+var ErrSchemaMismatch = errors.New("database schema is missing or out of date")
+
+// schemaTable is a table of the schema with its columns
+type schemaTable struct {
+	name    string
+	columns []string
+}
+
+// createTableRe matches a CREATE TABLE statement of the schema
+var createTableRe = regexp.MustCompile(`(?s)CREATE TABLE (\w+) \((.*?)\n\);`)
+
+// expectedTables returns the tables and columns created by the schema, in the
+// order they are created
+func expectedTables() []schemaTable {
+	var tables []schemaTable
+	for _, match := range createTableRe.FindAllStringSubmatch(schema, -1) {
+		table := schemaTable{name: match[1]}
+		for _, line := range strings.Split(match[2], "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "--") {
+				continue
+			}
+			table.columns = append(table.columns, fields[0])
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// CheckSchema verifies that the database has all tables and columns of the
+// schema, so that a database that is missing or out of date is reported when
+// starting up, rather than by the first query that uses it. ErrSchemaMismatch
+// is returned with the missing tables and columns.
+func (d PgDatabase) CheckSchema() error {
+	dbh := d.Connect().(*PgHandle)
+	defer dbh.Close()
+
+	rows, err := dbh.conn().Query(`
+        SELECT table_name, column_name
+        FROM information_schema.columns
+        WHERE table_schema = current_schema()
+    `)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			panic(err)
+		}
+		if existing[table] == nil {
+			existing[table] = make(map[string]bool)
+		}
+		existing[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	var missing []string
+	for _, table := range expectedTables() {
+		if existing[table.name] == nil {
+			missing = append(missing, "table "+table.name)
+			continue
+		}
+		for _, column := range table.columns {
+			if !existing[table.name][column] {
+				missing = append(missing, fmt.Sprintf("column %s.%s", table.name, column))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing %s", ErrSchemaMismatch, strings.Join(missing, ", "))
+	}
+	return nil
+}
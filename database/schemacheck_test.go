@@ -0,0 +1,32 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpectedTables(t *testing.T) {
+	// The tables and columns are read from the schema, skipping comments
+
+	tables := make(map[string][]string)
+	var names []string
+	for _, table := range expectedTables() {
+		tables[table.name] = table.columns
+		names = append(names, table.name)
+	}
+
+	wantedNames := []string{"users", "user_settings", "friends", "expenses", "expenses_users", "tags", "expense_tags", "settlements", "user_groups", "group_members", "audit_log"}
+	if !reflect.DeepEqual(names, wantedNames) {
+		t.Errorf("wanted tables %v, got %v", wantedNames, names)
+	}
+
+	wantedUsers := []string{"id", "email", "name", "password", "deleted", "is_admin"}
+	if !reflect.DeepEqual(tables["users"], wantedUsers) {
+		t.Errorf("wanted users columns %v, got %v", wantedUsers, tables["users"])
+	}
+
+	wantedSettlements := []string{"id", "from_user_id", "to_user_id", "amount", "created_at", "recorded_at"}
+	if !reflect.DeepEqual(tables["settlements"], wantedSettlements) {
+		t.Errorf("wanted settlements columns %v, got %v", wantedSettlements, tables["settlements"])
+	}
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// timedConn wraps a connection or transaction and logs queries that take longer
+// than threshold, with the name of the method that made them. A zero threshold
+// logs nothing. Prepared statements aren't timed.
+type timedConn struct {
+	pgConn
+	threshold time.Duration
+}
+
+// Exec runs a statement that doesn't return rows
+func (c timedConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer c.logIfSlow(time.Now())
+	return c.pgConn.Exec(query, args...)
+}
+
+// Query runs a query that returns rows
+func (c timedConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer c.logIfSlow(time.Now())
+	return c.pgConn.Query(query, args...)
+}
+
+// QueryRow runs a query that returns at most one row
+func (c timedConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer c.logIfSlow(time.Now())
+	return c.pgConn.QueryRow(query, args...)
+}
+
+// timedTx is a transaction whose queries are timed
+type timedTx struct {
+	timedConn
+	tx pgTx
+}
+
+// Commit commits the transaction
+func (t timedTx) Commit() error { return t.tx.Commit() }
+
+// Rollback rolls back the transaction
+func (t timedTx) Rollback() error { return t.tx.Rollback() }
+
+// logIfSlow logs a warning if a query that started at start exceeded the
+// threshold. It must be deferred by the method running the query.
+func (c timedConn) logIfSlow(start time.Time) {
+	if c.threshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed > c.threshold {
+		log.Printf("WARNING: slow query in %s took %v", queryName(3), elapsed)
+	}
+}
+
+// closureSuffix matches the suffix of the names of anonymous functions
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// queryName returns the name of the function skip frames up the stack, e.g.
+// PgHandle.GetExpenses, which is the name of the query it runs
+func queryName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	name := runtime.FuncForPC(pc).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = name[strings.Index(name, ".")+1:]
+	return closureSuffix.ReplaceAllString(name, "")
+}
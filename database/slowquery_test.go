@@ -0,0 +1,58 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sleepyConn is a connection whose statements take delay
+type sleepyConn struct {
+	pgConn
+	delay time.Duration
+}
+
+func (c sleepyConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	time.Sleep(c.delay)
+	return nil, nil
+}
+
+// deleteEverything runs a statement on conn, so that it shows up as the name of
+// the query
+func deleteEverything(conn pgConn) {
+	conn.Exec("DELETE FROM everything")
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	// Queries taking longer than the threshold are logged with the name of the
+	// function running them, faster queries aren't. A zero threshold logs nothing.
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		Delay     time.Duration
+		Threshold time.Duration
+		Logged    bool
+	}{
+		{50 * time.Millisecond, 10 * time.Millisecond, true},
+		{0, 10 * time.Millisecond, false},
+		{50 * time.Millisecond, time.Second, false},
+		{50 * time.Millisecond, 0, false},
+	}
+
+	for _, test := range tests {
+		buf.Reset()
+		deleteEverything(timedConn{sleepyConn{delay: test.Delay}, test.Threshold})
+
+		output := buf.String()
+		if logged := strings.Contains(output, "slow query in deleteEverything took"); logged != test.Logged {
+			t.Errorf("delay %v, threshold %v: wanted logged %v, got %q", test.Delay, test.Threshold, test.Logged, output)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package errkind classifies the errors of the application by kind, so that they
+// can be told apart after being wrapped with context
+package errkind
+
+import "errors"
+
+// Kind is the class of an error, e.g. an entry that couldn't be found
+type Kind string
+
+// The kinds of errors. Errors that aren't classified are of kind Other.
+const (
+	Other            Kind = ""
+	NotFound         Kind = "not found"
+	Duplicate        Kind = "duplicate"
+	PasswordMismatch Kind = "password mismatch"
+	LimitExceeded    Kind = "limit exceeded"
+)
+
+// Error is an error of a kind
+type Error struct {
+	Kind Kind
+}
+
+// New returns an error of kind, to be used as a sentinel
+func New(kind Kind) *Error {
+	return &Error{Kind: kind}
+}
+
+// Error returns the kind as the error message
+func (e *Error) Error() string {
+	return string(e.Kind)
+}
+
+// Of returns the kind of the first Error in the chain of err, also if it has
+// been wrapped with fmt.Errorf and %w. Other is returned if there is none.
+func Of(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Other
+}
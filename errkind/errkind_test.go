@@ -0,0 +1,34 @@
+package errkind
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	// The kind of an error is found however deeply it has been wrapped
+
+	errDuplicate := New(Duplicate)
+
+	tests := []struct {
+		Err    error
+		Wanted Kind
+	}{
+		{nil, Other},
+		{errors.New("boom"), Other},
+		{errDuplicate, Duplicate},
+		{fmt.Errorf("user 1: %w", New(NotFound)), NotFound},
+		{fmt.Errorf("creating user: %w", fmt.Errorf("email test1@getstream.io: %w", errDuplicate)), Duplicate},
+		{fmt.Errorf("creating user: %v", errDuplicate), Other},
+	}
+	for _, test := range tests {
+		if got := Of(test.Err); got != test.Wanted {
+			t.Errorf("%v: wanted kind %q, got %q", test.Err, test.Wanted, got)
+		}
+	}
+
+	if err := fmt.Errorf("user 1: %w", errDuplicate); !errors.Is(err, errDuplicate) {
+		t.Errorf("wanted %v to wrap %v", err, errDuplicate)
+	}
+}
@@ -4,8 +4,10 @@ go 1.14
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/docker/go-connections v0.4.0
 	github.com/go-redis/redis/v8 v8.4.8
 	github.com/lib/pq v1.9.0
-	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
-	google.golang.org/protobuf v1.24.0 // indirect
+	github.com/testcontainers/testcontainers-go v0.13.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 )
@@ -1,6 +1,9 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -12,60 +15,175 @@ var expirationTime = 30 * time.Minute
 
 var jwtKey = []byte("my-secret-stream-key")
 
+// Issuer and Audience are the iss and aud claims of created tokens. Tokens with
+// other claims are rejected, e.g. those meant for another service.
+var (
+	Issuer   = "splitter"
+	Audience = "splitter"
+)
+
+// Now returns the current time, replaced in tests
+var Now = time.Now
+
+// Leeway is the clock skew tolerated between servers when checking the expiry,
+// not before and issued at claims of a token
+var Leeway time.Duration
+
 type claims struct {
 	UserID int `json:"user_id"`
 	jwt.StandardClaims
 }
 
-// CreateCookie creates an cookie containing a JWT token that is set to expire in
-// expirationTime.
-func CreateCookie(userID int, cookieName string) http.Cookie {
-	expirationTime := time.Now().Add(expirationTime)
+// Session identifies a single signed in session of a user
+type Session struct {
+	UserID    int       // The authenticated user
+	TokenID   string    // Unique id of the token, the jti claim
+	ExpiresAt time.Time // Expiry of the token, zero when creating one
+}
+
+// The reasons a token isn't valid, returned by ParseToken
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("token is expired")
+	ErrNotValidYet      = errors.New("token is not valid yet")
+	ErrRevoked          = errors.New("token has been revoked")
+	ErrInvalidIssuer    = errors.New("invalid issuer")
+	ErrInvalidAudience  = errors.New("invalid audience")
+)
+
+// SessionChecker returns true if the session hasn't been revoked
+type SessionChecker func(session Session) bool
+
+// Valid checks the time based claims like jwt.StandardClaims, allowing for
+// Leeway
+func (c claims) Valid() error {
+	now := Now().Unix()
+	leeway := int64(Leeway / time.Second)
 
-	// Create a claim with an expiry and userID
+	vErr := &jwt.ValidationError{}
+	if !c.VerifyExpiresAt(now-leeway, false) {
+		vErr.Errors |= jwt.ValidationErrorExpired
+	}
+	if !c.VerifyIssuedAt(now+leeway, false) {
+		vErr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+	if !c.VerifyNotBefore(now+leeway, false) {
+		vErr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+
+	if vErr.Errors != 0 {
+		return vErr
+	}
+	return nil
+}
+
+// NewTokenID generates a random unique token id
+func NewTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// CreateToken creates a signed JWT token for session that expires at expiresAt
+func CreateToken(session Session, expiresAt time.Time) string {
+	// Create a claim with an expiry, token id and userID that is valid from now
+	now := Now().Unix()
 	claims := &claims{
-		UserID: userID,
+		UserID: session.UserID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now,
+			NotBefore: now,
+			Id:        session.TokenID,
+			Issuer:    Issuer,
+			Audience:  Audience,
 		},
 	}
 
-	// Create the JWT token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
 		panic(err)
 	}
+	return tokenString
+}
+
+// CreateCookie creates an cookie containing a JWT token that is set to expire in
+// expirationTime. Without a domain, the cookie is only sent to the host that set
+// it.
+func CreateCookie(session Session, cookieName string, domain string) http.Cookie {
+	expirationTime := Now().Add(expirationTime)
 
 	// Return an http cookie with the token
 	return http.Cookie{
 		Name:    cookieName,
-		Value:   tokenString,
+		Value:   CreateToken(session, expirationTime),
 		Expires: expirationTime,
+		Domain:  domain,
 	}
 }
 
-// VerifyToken verifies a JWT token. If successful, the function returns (userID, true),
-// if unsuccessful, it returns (0, false)
-func VerifyToken(tokenString string) (int, bool) {
+// ParseToken parses and verifies a JWT token. If isValid is not nil, it's
+// consulted to check the session hasn't been revoked. The error is one of
+// ErrMalformed, ErrInvalidSignature, ErrExpired, ErrNotValidYet,
+// ErrInvalidIssuer, ErrInvalidAudience and ErrRevoked if the token isn't valid. The session is
+// returned with the error if the signature is valid, e.g. for an expired token.
+func ParseToken(tokenString string, isValid SessionChecker) (Session, error) {
 	claims := &claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return jwtKey, nil
 	})
 	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			log.Println("Invalid signature")
-			return 0, false
+		var validationErr *jwt.ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorMalformed != 0 {
+			return Session{}, ErrMalformed
 		}
-		log.Println("Bad jwt token")
-		return 0, false
+		if validationErr.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0 {
+			return Session{}, ErrInvalidSignature
+		}
+		if validationErr.Errors == jwt.ValidationErrorExpired {
+			return newSession(claims), ErrExpired
+		}
+		if validationErr.Errors&^(jwt.ValidationErrorIssuedAt|jwt.ValidationErrorNotValidYet) == 0 {
+			return newSession(claims), ErrNotValidYet
+		}
+		return Session{}, ErrMalformed
 	}
 
 	if !token.Valid {
-		log.Println("Invalid jwt token")
-		return 0, false
+		return Session{}, ErrMalformed
+	}
+
+	session := newSession(claims)
+	if claims.Issuer != Issuer {
+		return session, ErrInvalidIssuer
+	}
+	if claims.Audience != Audience {
+		return session, ErrInvalidAudience
+	}
+	if isValid != nil && !isValid(session) {
+		return session, ErrRevoked
 	}
 
-	return claims.UserID, true
+	return session, nil
+}
+
+// newSession returns the session identified by claims
+func newSession(c *claims) Session {
+	return Session{UserID: c.UserID, TokenID: c.Id, ExpiresAt: time.Unix(c.ExpiresAt, 0).UTC()}
+}
+
+// VerifyToken verifies a JWT token like ParseToken. If successful, the function
+// returns (session, true), if unsuccessful, it logs why and returns (Session{}, false)
+func VerifyToken(tokenString string, isValid SessionChecker) (Session, bool) {
+	session, err := ParseToken(tokenString, isValid)
+	if err != nil {
+		log.Printf("Rejecting jwt token: %v", err)
+		return Session{}, false
+	}
+	return session, true
 }
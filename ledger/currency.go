@@ -0,0 +1,89 @@
+package ledger
+
+import (
+	"math"
+	"sort"
+)
+
+// defaultDecimals is the number of decimal places used for expenses without a currency
+const defaultDecimals = 2
+
+// currencyDecimals maps ISO 4217 currency codes to the number of decimal places
+// of their minor unit
+var currencyDecimals = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CLP": 0,
+	"CNY": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"HUF": 2,
+	"IDR": 2,
+	"ILS": 2,
+	"INR": 2,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"NOK": 2,
+	"NZD": 2,
+	"OMR": 3,
+	"PLN": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"TND": 3,
+	"TRY": 2,
+	"USD": 2,
+	"VND": 0,
+	"ZAR": 2,
+}
+
+// IsValidCurrency returns true if the currency is a known ISO 4217 code
+func IsValidCurrency(currency string) bool {
+	_, exists := currencyDecimals[currency]
+	return exists
+}
+
+// Currencies returns the known ISO 4217 currency codes in alphabetical order
+func Currencies() []string {
+	currencies := make([]string, 0, len(currencyDecimals))
+	for currency := range currencyDecimals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// Decimals returns the number of decimal places of a currency's minor unit. An
+// empty or unknown currency has two decimal places.
+func Decimals(currency string) int {
+	if decimals, exists := currencyDecimals[currency]; exists {
+		return decimals
+	}
+	return defaultDecimals
+}
+
+// minorUnitTolerance is the tolerance, in minor units, for amounts that can't be
+// represented exactly by a float64, e.g. 0.29 is 28.999999999999996 cents
+const minorUnitTolerance = 1e-6
+
+// IsInMinorUnits returns true if an amount is a whole number of minor units of
+// a currency, e.g. USD 10.05 is but USD 10.005 and JPY 10.5 aren't
+func IsInMinorUnits(amount float64, currency string) bool {
+	units := amount * math.Pow10(Decimals(currency))
+	return math.Abs(units-math.Round(units)) <= minorUnitTolerance
+}
+
+// RoundAmount rounds an amount to the minor unit of a currency
+func RoundAmount(amount float64, currency string) float64 {
+	factor := math.Pow10(Decimals(currency))
+	return math.Round(amount*factor) / factor
+}
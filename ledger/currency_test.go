@@ -0,0 +1,81 @@
+package ledger
+
+import (
+	"testing"
+)
+
+func TestSharesWithCurrency(t *testing.T) {
+	// Split amounts that don't divide evenly and check the shares are rounded to
+	// the currency's minor unit, with the payer absorbing the leftover
+
+	tests := []struct {
+		Expense Expense
+		Shares  map[int]float64
+	}{
+		// ¥1000 split between three users, no fractional yen
+		{
+			Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 1000, Currency: "JPY"},
+			map[int]float64{1: 334, 2: 333, 3: 333},
+		},
+
+		// €10 split between three users, rounded to cents
+		{
+			Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 10, Currency: "EUR"},
+			map[int]float64{1: 3.34, 2: 3.33, 3: 3.33},
+		},
+
+		// €42 split between three users, as before
+		{
+			Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 42, Currency: "EUR"},
+			map[int]float64{1: 14, 2: 14, 3: 14},
+		},
+
+		// 10 BHD split between three users, rounded to thousandths
+		{
+			Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 10, Currency: "BHD"},
+			map[int]float64{1: 3.334, 2: 3.333, 3: 3.333},
+		},
+	}
+
+	for _, test := range tests {
+		got := test.Expense.Shares()
+		for userID, share := range test.Shares {
+			if !almostEqual(share, got[userID]) {
+				t.Errorf("%s share mismatch for user %d, expected: %f, got: %f",
+					test.Expense.Currency, userID, share, got[userID])
+			}
+		}
+	}
+
+	// The balance of a user who owes the payer contains no fractional yen
+	expense := Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 1000, Currency: "JPY"}
+	got := CalculateBalance([]Expense{expense}, nil, 1)
+	if !almostEqual(666, got.Balance) {
+		t.Errorf("Balance mismatch, expected: %f, got: %f", 666.0, got.Balance)
+	}
+}
+
+func TestIsInMinorUnits(t *testing.T) {
+	// Amounts must be a whole number of minor units of their currency
+
+	tests := []struct {
+		Amount   float64
+		Currency string
+		Wanted   bool
+	}{
+		{10, "JPY", true},
+		{10.5, "JPY", false},
+		{10.05, "USD", true},
+		{0.29, "USD", true},
+		{10.005, "USD", false},
+		{10.005, "BHD", true},
+		{10.0005, "BHD", false},
+		{10.05, "", true},
+		{10.005, "", false},
+	}
+	for _, test := range tests {
+		if got := IsInMinorUnits(test.Amount, test.Currency); got != test.Wanted {
+			t.Errorf("%s %v: wanted %v, got %v", test.Currency, test.Amount, test.Wanted, got)
+		}
+	}
+}
@@ -1,88 +1,562 @@
 package ledger
 
 import (
+	"errors"
+	"math"
+	"sort"
 	"time"
 )
 
+// percentageEpsilon is the tolerance used when checking percentages add up to 100
+const percentageEpsilon = 1e-6
+
+// Epsilon is the tolerance for floating point noise when comparing amounts. A net
+// debt between two users must be larger than it to be a debit or credit, one of
+// exactly Epsilon or less is dropped like a settled debt.
+var Epsilon = 1e-9
+
+// AutoSettleThreshold is the amount at or below which a net debt between two
+// users is considered settled, e.g. a residual of €0.003 left by rounding. It is
+// zero by default, dropping no debts.
+var AutoSettleThreshold float64
+
+// ErrInvalidPercentages is returned when the percentages of a split don't add up to 100
+var ErrInvalidPercentages = errors.New("percentages must add up to 100")
+
+// ErrInvalidShares is returned when the numbers of shares of a split are negative
+// or add up to zero
+var ErrInvalidShares = errors.New("shares must not be negative and add up to at least one")
+
+// ErrInvalidBase is returned when the base per person of a split is negative or
+// adds up to more than the amount
+var ErrInvalidBase = errors.New("base per person must not be negative and add up to at most the amount")
+
+// ErrInvalidReimbursement is returned when a reimbursement isn't paid to anyone
+// but the payer or has a custom split
+var ErrInvalidReimbursement = errors.New("a reimbursement must be paid to other users and split equally")
+
+// ErrAmountTooLarge is returned when an expense can't be split without losing precision
+var ErrAmountTooLarge = errors.New("amount is too large to split precisely")
+
+// maxSafeMinorUnits is the largest number of minor units, e.g. cents, that is
+// represented exactly by a float64. It also fits in an int64.
+const maxSafeMinorUnits = 1 << 53
+
+// ExpenseType is the kind of an expense
+type ExpenseType string
+
+// Types of expenses
+const (
+	TypeExpense       ExpenseType = "expense"       // The payer bought something shared by the users
+	TypeReimbursement ExpenseType = "reimbursement" // The payer paid the other users back
+)
+
 // Expense is a single expense, paid for by a user. The expense is shared by
 // at least one more users. The Users slice contains the other users, not including
-// the OwnerID of the expense.
+// the OwnerID of the expense. The payer is usually the owner, but the owner can
+// also record an expense paid for by another user. The amount is split equally
+// among the users, unless a PercentageSplit or ShareSplit is set. With a
+// BasePerPerson, e.g. a cover charge, each user pays the base first and the rest
+// of the amount is split. If the expense has a currency, the shares are rounded
+// to its minor unit. A reimbursement is split equally among the users other than
+// the payer, so that it reduces what the payer owes them.
 type Expense struct {
 	ExpenseID   int       // Id of the expense
-	OwnerID     int       // User id who paid for the expense
+	OwnerID     int       // User id who created the expense
+	PayerID     int       // User id who paid for the expense, the owner if zero
 	Users       []int     // Slice of other users that share the expense
 	Amount      float64   // Amount the owner paid for
+	Currency    string    // Optional ISO 4217 currency code of the amount
 	Description string    // Description, set by the owner
+	Notes       string    // Optional longer note, only for the users sharing the expense
 	CreatedAt   time.Time // The time the expense was incurred
+	RecordedAt  time.Time // The time the expense was stored, set by the database
+	Tags        []string  // Optional free-form tags
+
+	Type         ExpenseType // TypeExpense if empty, or TypeReimbursement
+	ExcludeOwner bool        // The owner doesn't share the expense, e.g. when it's a gift
+
+	PercentageSplit map[int]float64 // Optional percentage of the amount per user, adding up to 100
+	ShareSplit      map[int]int     // Optional number of shares of the amount per user
+	BasePerPerson   float64         // Optional fixed amount per user, the rest is split
+
+	Location *Location // Optional place where the expense was incurred
+}
+
+// Location is where an expense was incurred, given by coordinates, the name of
+// a place or both
+type Location struct {
+	Latitude  *float64 // Optional, from -90 to 90
+	Longitude *float64 // Optional, from -180 to 180, set if Latitude is
+	Place     string   // Optional name of the place
+}
+
+// Payer returns the user id who paid for the expense
+func (e Expense) Payer() int {
+	if e.PayerID == 0 {
+		return e.OwnerID
+	}
+	return e.PayerID
+}
+
+// HasUser returns true if userID shares the expense
+func (e Expense) HasUser(userID int) bool {
+	for _, u := range e.Users {
+		if u == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPersonal returns true if the payer is the only user sharing the expense,
+// e.g. when the owner records something they bought for themselves. A personal
+// expense nets to zero: it creates no debts and doesn't change any balance.
+func (e Expense) IsPersonal() bool {
+	payerID := e.Payer()
+	for _, u := range e.Users {
+		if u != payerID {
+			return false
+		}
+	}
+	if !e.ExcludeOwner && e.OwnerID != payerID {
+		return false
+	}
+	return len(e.Users) > 0 || !e.ExcludeOwner
+}
+
+// IsReimbursement returns true if the payer paid the other users back, rather
+// than for something they share
+func (e Expense) IsReimbursement() bool {
+	return e.Type == TypeReimbursement
+}
+
+// recipients returns the users a reimbursement is paid to
+func (e Expense) recipients() []int {
+	payerID := e.Payer()
+	recipients := make([]int, 0, len(e.Users))
+	for _, u := range e.Users {
+		if u != payerID {
+			recipients = append(recipients, u)
+		}
+	}
+	return recipients
+}
+
+// paidToOthers returns true if anyone but the payer shares the expense, whether
+// or not the owner has been added to Users yet
+func (e Expense) paidToOthers() bool {
+	payerID := e.Payer()
+	if !e.ExcludeOwner && e.OwnerID != payerID {
+		return true
+	}
+	for _, u := range e.Users {
+		if u != payerID {
+			return true
+		}
+	}
+	return false
+}
+
+// Involves returns true if userID shares, paid for or created the expense
+func (e Expense) Involves(userID int) bool {
+	return e.HasUser(userID) || e.Payer() == userID || e.OwnerID == userID
+}
+
+// participants returns the number of users sharing the expense, including the
+// owner unless they're excluded
+func (e Expense) participants() int {
+	if e.ExcludeOwner || e.HasUser(e.OwnerID) {
+		return len(e.Users)
+	}
+	return len(e.Users) + 1
+}
+
+// Validate checks the split of the expense is consistent. ErrInvalidPercentages
+// is returned if a percentage split doesn't add up to 100, ErrInvalidShares if
+// a share split has no shares and ErrInvalidBase if the base per person of all
+// participants exceeds the amount. ErrInvalidReimbursement is returned if a
+// reimbursement has no other users than the payer or a custom split.
+// ErrAmountTooLarge is returned if the amount in minor units times the number of
+// participants exceeds what can be represented exactly.
+func (e Expense) Validate() error {
+	minorUnits := e.Amount * math.Pow10(Decimals(e.Currency))
+	if minorUnits*float64(e.participants()) > maxSafeMinorUnits {
+		return ErrAmountTooLarge
+	}
+
+	if e.IsReimbursement() && (!e.paidToOthers() || e.PercentageSplit != nil || e.ShareSplit != nil || e.BasePerPerson != 0) {
+		return ErrInvalidReimbursement
+	}
+
+	if e.BasePerPerson < 0 || (e.BasePerPerson > 0 && e.BasePerPerson*float64(e.participants())-e.Amount > Epsilon) {
+		return ErrInvalidBase
+	}
+
+	if e.ShareSplit != nil {
+		total := 0
+		for _, shares := range e.ShareSplit {
+			if shares < 0 {
+				return ErrInvalidShares
+			}
+			total += shares
+		}
+		if total == 0 {
+			return ErrInvalidShares
+		}
+		if minorUnits*float64(total) > maxSafeMinorUnits {
+			return ErrAmountTooLarge
+		}
+	}
+
+	if e.PercentageSplit == nil {
+		return nil
+	}
+
+	var total float64
+	for _, percentage := range e.PercentageSplit {
+		if percentage < 0 {
+			return ErrInvalidPercentages
+		}
+		total += percentage
+	}
+
+	if math.Abs(total-100) > percentageEpsilon {
+		return ErrInvalidPercentages
+	}
+
+	return nil
+}
+
+// MergeUser returns a copy of the expense with sourceID replaced by targetID. If
+// both share the expense, targetID takes over the share of sourceID, so that the
+// shares of all users stay the same.
+func (e Expense) MergeUser(sourceID int, targetID int) Expense {
+	if e.OwnerID == sourceID {
+		e.OwnerID = targetID
+	}
+	if e.PayerID == sourceID {
+		e.PayerID = targetID
+	}
+	if !e.HasUser(sourceID) {
+		return e
+	}
+
+	// An equal split is no longer equal once targetID has two shares
+	merging := e.HasUser(targetID)
+
+	// Nor would targetID pay the base of sourceID, so the shares are kept as
+	// percentages instead
+	if merging && e.BasePerPerson != 0 {
+		shares := e.Shares()
+		split := make(map[int]float64, len(shares))
+		for u, share := range shares {
+			split[u] = share / e.Amount * 100
+		}
+		e.BasePerPerson = 0
+		e.ShareSplit = nil
+		e.PercentageSplit = split
+	}
+	users := make([]int, 0, len(e.Users))
+	for _, u := range e.Users {
+		if u != sourceID {
+			users = append(users, u)
+		} else if !merging {
+			users = append(users, targetID)
+		}
+	}
+
+	if e.ShareSplit != nil {
+		shareSplit := make(map[int]int, len(e.ShareSplit))
+		for u, shares := range e.ShareSplit {
+			shareSplit[u] = shares
+		}
+		shareSplit[targetID] += shareSplit[sourceID]
+		delete(shareSplit, sourceID)
+		e.Users = users
+		e.ShareSplit = shareSplit
+		return e
+	}
+
+	split := make(map[int]float64, len(e.Users))
+	for _, u := range e.Users {
+		if e.PercentageSplit != nil {
+			split[u] = e.PercentageSplit[u]
+		} else {
+			split[u] = 100 / float64(len(e.Users))
+		}
+	}
+
+	e.Users = users
+
+	if merging || e.PercentageSplit != nil {
+		split[targetID] += split[sourceID]
+		delete(split, sourceID)
+		e.PercentageSplit = split
+	}
+
+	return e
+}
+
+// Shares returns the part of the amount each user in Users is responsible for.
+// If the expense has a currency, the shares of the other users are rounded to
+// its minor unit and the payer absorbs what is left over. If the payer doesn't
+// share the expense, the first user does. A reimbursement is shared by the users
+// other than the payer.
+func (e Expense) Shares() map[int]float64 {
+	if e.IsReimbursement() {
+		reimbursement := e
+		reimbursement.Type = TypeExpense
+		reimbursement.Users = e.recipients()
+		return reimbursement.Shares()
+	}
+	if e.BasePerPerson != 0 {
+		return e.sharesWithBase()
+	}
+	if e.ShareSplit != nil {
+		return e.sharesByCount()
+	}
+
+	shares := make(map[int]float64, len(e.Users))
+	for _, u := range e.Users {
+		if e.PercentageSplit != nil {
+			shares[u] = e.Amount * e.PercentageSplit[u] / 100
+		} else {
+			shares[u] = e.Amount / float64(len(e.Users))
+		}
+	}
+
+	e.roundShares(shares)
+	return shares
+}
+
+// roundShares rounds the shares of the other users to the minor unit of the
+// currency, if any. The payer absorbs what is left over, or the first user if the
+// payer doesn't share the expense.
+func (e Expense) roundShares(shares map[int]float64) {
+	if e.Currency == "" || len(e.Users) == 0 {
+		return
+	}
+
+	absorberID := e.Payer()
+	if _, exists := shares[absorberID]; !exists {
+		absorberID = e.Users[0]
+	}
+
+	rest := e.Amount
+	for u, share := range shares {
+		if u != absorberID {
+			shares[u] = RoundAmount(share, e.Currency)
+			rest -= shares[u]
+		}
+	}
+	shares[absorberID] = RoundAmount(rest, e.Currency)
+}
+
+// sharesWithBase gives each user BasePerPerson and splits the rest of the amount
+// as if the expense had no base
+func (e Expense) sharesWithBase() map[int]float64 {
+	rest := e
+	rest.BasePerPerson = 0
+	rest.Amount = e.Amount - e.BasePerPerson*float64(len(e.Users))
+
+	shares := rest.Shares()
+	for u := range shares {
+		shares[u] += e.BasePerPerson
+	}
+	e.roundShares(shares)
+	return shares
+}
+
+// sharesByCount splits the amount in minor units by the number of shares of each
+// user in ShareSplit, rounding down. The minor units left over go to the payer,
+// or the first user if the payer doesn't share the expense.
+func (e Expense) sharesByCount() map[int]float64 {
+	factor := math.Pow10(Decimals(e.Currency))
+	minorUnits := int64(math.Round(e.Amount * factor))
+
+	var totalShares int64
+	for _, u := range e.Users {
+		totalShares += int64(e.ShareSplit[u])
+	}
+
+	shares := make(map[int]float64, len(e.Users))
+	if totalShares == 0 {
+		for _, u := range e.Users {
+			shares[u] = 0
+		}
+		return shares
+	}
+
+	absorberID := e.Payer()
+	if !e.HasUser(absorberID) {
+		absorberID = e.Users[0]
+	}
+
+	rest := minorUnits
+	split := make(map[int]int64, len(e.Users))
+	for _, u := range e.Users {
+		split[u] = minorUnits * int64(e.ShareSplit[u]) / totalShares
+		rest -= split[u]
+	}
+	split[absorberID] += rest
+
+	for u, units := range split {
+		shares[u] = float64(units) / factor
+	}
+	return shares
+}
+
+// Settlement is a payment from one user to another, paying back (part of) a debt
+type Settlement struct {
+	SettlementID int       // Id of the settlement
+	FromUserID   int       // User id who paid
+	ToUserID     int       // User id who received the money
+	Amount       float64   // Amount paid
+	CreatedAt    time.Time // The time the settlement was made
 }
 
 // Debt represents money owed by one user to another. The amount is negative in case
 // of a credit.
 type Debt struct {
-	UserID int     `json:"user_id"` // The owner of the debt
-	Amount float64 `json:"amount"`  // The amount of the debt
+	UserID    int        `json:"user_id"`             // The owner of the debt
+	Name      string     `json:"name,omitempty"`      // Display name of the owner, if known
+	Amount    float64    `json:"amount"`              // The amount of the debt
+	Breakdown []DebtItem `json:"breakdown,omitempty"` // The expenses and settlements making up the debt
+}
+
+// DebtItem is the contribution of a single expense or settlement to a Debt. The
+// amounts of all items in a breakdown add up to the amount of the debt. An item
+// has a negative amount if it reduces the debt, e.g. a settlement.
+type DebtItem struct {
+	ExpenseID    int     `json:"expense_id,omitempty"`    // Id of the expense, if any
+	SettlementID int     `json:"settlement_id,omitempty"` // Id of the settlement, if any
+	Amount       float64 `json:"amount"`                  // Amount contributed to the debt
 }
 
-// Balance is a user's balance
+// Balance is a user's balance. CalculateBalance leaves FromCache and ComputedAt
+// unset, they are filled in by the cache and the API.
 type Balance struct {
 	Balance float64 `json:"balance"` // Amount of the balance
 	Debit   []Debt  `json:"debit"`   // Money this user owes to other users
 	Credit  []Debt  `json:"credit"`  // Money other users owe this user
+
+	FromCache  bool      `json:"from_cache"`  // Read from the cache rather than calculated for this request
+	ComputedAt time.Time `json:"computed_at"` // When the balance was calculated
 }
 
-// CalculateBalance takes a []Expense and calculates who owes what and what their
-// balance is for a given userID. This is the heart of the application.
-func CalculateBalance(expenses []Expense, userID int) Balance {
+// DebtTo returns the amount this balance's user owes to userID. Zero is returned
+// if nothing is owed.
+func (b Balance) DebtTo(userID int) float64 {
+	for _, d := range b.Debit {
+		if d.UserID == userID {
+			return d.Amount
+		}
+	}
+	return 0
+}
+
+// CalculateBalanceAsOf calculates the balance like CalculateBalance, only taking
+// into account expenses and settlements made at or before asOf
+func CalculateBalanceAsOf(expenses []Expense, settlements []Settlement, userID int, asOf time.Time) Balance {
+	filteredExpenses := make([]Expense, 0, len(expenses))
+	for _, e := range expenses {
+		if !e.CreatedAt.After(asOf) {
+			filteredExpenses = append(filteredExpenses, e)
+		}
+	}
+
+	filteredSettlements := make([]Settlement, 0, len(settlements))
+	for _, s := range settlements {
+		if !s.CreatedAt.After(asOf) {
+			filteredSettlements = append(filteredSettlements, s)
+		}
+	}
+
+	return CalculateBalance(filteredExpenses, filteredSettlements, userID)
+}
+
+// CalculateBalance takes a []Expense and []Settlement and calculates who owes what
+// and what their balance is for a given userID. A settlement reduces the debt
+// between two users by the settled amount; paying more than is owed flips the debt
+// around. Personal expenses are skipped. Debts no larger than AutoSettleThreshold
+// or Epsilon are left out, as is their part of the balance. This is the heart of the
+// application.
+func CalculateBalance(expenses []Expense, settlements []Settlement, userID int) Balance {
 	var balance float64                    // Total balance
 	debts := make(map[int]map[int]float64) // Double map of money owed to other users
+	breakdowns := make(map[int][]DebtItem) // What makes up userID's debt to other users
 
 	// Loop over all expenses and amend balance and debts
 	for _, expense := range expenses {
 		// Is userID involved in this expense? If not, skip it
-		userTookPart := false
-		for _, expenseUserID := range expense.Users {
-			if expenseUserID == userID {
-				userTookPart = true
-			}
-		}
-		if !userTookPart {
+		if !expense.HasUser(userID) && expense.Payer() != userID {
 			continue
 		}
 
-		owned := expense.OwnerID == userID // Did userID pay for this expense?
-
-		l := len(expense.Users)
-		perPersonAmount := float64(expense.Amount) / float64(l)
-		var delta float64
-		if owned {
-			// l-1 users owe userID money
-			delta = float64(l-1) * perPersonAmount
-		} else {
-			// userID owes the expense owner money
-			delta = -perPersonAmount
+		// Nobody owes anything for a personal expense, the payer doesn't owe themselves
+		if expense.IsPersonal() {
+			continue
 		}
 
-		balance = balance + delta // Change our own balance
+		payerID := expense.Payer()
+		shares := expense.Shares()
 
-		// Amend the debts the debts map
+		// Amend the balance and debts map
 		for _, expenseUserID := range expense.Users {
 			// userID never owes themselves anything
-			if expenseUserID == expense.OwnerID {
+			if expenseUserID == payerID {
 				continue
 			}
 
+			share := shares[expenseUserID]
+			if payerID == userID {
+				// expenseUserID owes userID money
+				balance += share
+				breakdowns[expenseUserID] = append(breakdowns[expenseUserID], DebtItem{ExpenseID: expense.ExpenseID, Amount: -share})
+			} else if expenseUserID == userID {
+				// userID owes the payer money
+				balance -= share
+				breakdowns[payerID] = append(breakdowns[payerID], DebtItem{ExpenseID: expense.ExpenseID, Amount: share})
+			}
+
 			// Allocate maps where needed
 			if debts[expenseUserID] == nil {
 				debts[expenseUserID] = make(map[int]float64)
 			}
-			if debts[expense.OwnerID] == nil {
-				debts[expense.OwnerID] = make(map[int]float64)
+			if debts[payerID] == nil {
+				debts[payerID] = make(map[int]float64)
 			}
 
 			// Amend debit & credits
-			debts[expenseUserID][expense.OwnerID] += perPersonAmount
-			debts[expense.OwnerID][expenseUserID] -= perPersonAmount
+			debts[expenseUserID][payerID] += share
+			debts[payerID][expenseUserID] -= share
+		}
+	}
+
+	// Loop over all settlements and pay down debts
+	for _, settlement := range settlements {
+		if settlement.FromUserID != userID && settlement.ToUserID != userID {
+			continue
+		}
+
+		if settlement.FromUserID == userID {
+			balance += settlement.Amount
+			breakdowns[settlement.ToUserID] = append(breakdowns[settlement.ToUserID], DebtItem{SettlementID: settlement.SettlementID, Amount: -settlement.Amount})
+		} else {
+			balance -= settlement.Amount
+			breakdowns[settlement.FromUserID] = append(breakdowns[settlement.FromUserID], DebtItem{SettlementID: settlement.SettlementID, Amount: settlement.Amount})
+		}
+
+		if debts[settlement.FromUserID] == nil {
+			debts[settlement.FromUserID] = make(map[int]float64)
+		}
+		if debts[settlement.ToUserID] == nil {
+			debts[settlement.ToUserID] = make(map[int]float64)
 		}
+
+		debts[settlement.FromUserID][settlement.ToUserID] -= settlement.Amount
+		debts[settlement.ToUserID][settlement.FromUserID] += settlement.Amount
 	}
 
 	// Make final debit and credit slices
@@ -90,12 +564,63 @@ func CalculateBalance(expenses []Expense, userID int) Balance {
 	credit := make([]Debt, 0)
 	userDebts := debts[userID]
 	for userID, amount := range userDebts {
+		if math.Abs(amount) <= math.Max(AutoSettleThreshold, Epsilon) {
+			// Too small to bother anyone with, a positive amount is owed by userID
+			balance += amount
+			continue
+		}
+
 		if amount > 0 {
-			debit = append(debit, Debt{UserID: userID, Amount: amount})
+			debit = append(debit, Debt{UserID: userID, Amount: amount, Breakdown: breakdowns[userID]})
 		} else {
-			credit = append(credit, Debt{UserID: userID, Amount: -amount})
+			// Flip the breakdown around, so that it adds up to the credit
+			breakdown := make([]DebtItem, len(breakdowns[userID]))
+			for i, item := range breakdowns[userID] {
+				item.Amount = -item.Amount
+				breakdown[i] = item
+			}
+			credit = append(credit, Debt{UserID: userID, Amount: -amount, Breakdown: breakdown})
 		}
 	}
 
+	// Order by user id, so that the same balance always looks the same
+	sort.Slice(debit, func(i, j int) bool { return debit[i].UserID < debit[j].UserID })
+	sort.Slice(credit, func(i, j int) bool { return credit[i].UserID < credit[j].UserID })
+
 	return Balance{Balance: balance, Debit: debit, Credit: credit}
 }
+
+// Counterparties returns the ids of the users userID has shared an expense with,
+// in the sense that one of them paid for the other, or has settled with, ordered
+// by id. Users are included even if the debts between them cancel out.
+func Counterparties(expenses []Expense, settlements []Settlement, userID int) []int {
+	seen := make(map[int]bool)
+	for _, expense := range expenses {
+		payerID := expense.Payer()
+		for _, expenseUserID := range expense.Users {
+			if expenseUserID == payerID {
+				continue
+			}
+			if payerID == userID {
+				seen[expenseUserID] = true
+			} else if expenseUserID == userID {
+				seen[payerID] = true
+			}
+		}
+	}
+
+	for _, settlement := range settlements {
+		if settlement.FromUserID == userID {
+			seen[settlement.ToUserID] = true
+		} else if settlement.ToUserID == userID {
+			seen[settlement.FromUserID] = true
+		}
+	}
+
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -1,15 +1,15 @@
 package ledger
 
 import (
+	"fmt"
 	"math"
+	"math/rand"
 	"testing"
 )
 
-const float64EqualityThreshold = 1e-9 // Use in float comparison function
-
 // almostEqual compares two floats
 func almostEqual(a, b float64) bool {
-	return math.Abs(a-b) <= float64EqualityThreshold
+	return math.Abs(a-b) <= Epsilon
 }
 
 // makeOweMap makes a map out of a slice of debts, keyed by the user id
@@ -71,9 +71,9 @@ func TestCalculateBalance(t *testing.T) {
 		{
 			[]Expense{meal},
 			map[int]Balance{
-				1: Balance{Balance: 28, Credit: []Debt{{2, 14}, {3, 14}}},
-				2: Balance{Balance: -14, Debit: []Debt{{1, 14}}},
-				3: Balance{Balance: -14, Debit: []Debt{{1, 14}}},
+				1: Balance{Balance: 28, Credit: []Debt{{UserID: 2, Amount: 14}, {UserID: 3, Amount: 14}}},
+				2: Balance{Balance: -14, Debit: []Debt{{UserID: 1, Amount: 14}}},
+				3: Balance{Balance: -14, Debit: []Debt{{UserID: 1, Amount: 14}}},
 			},
 		},
 
@@ -81,8 +81,8 @@ func TestCalculateBalance(t *testing.T) {
 		{
 			[]Expense{coffee},
 			map[int]Balance{
-				1: Balance{Balance: -4, Debit: []Debt{{2, 4}}},
-				2: Balance{Balance: 4, Credit: []Debt{{1, 4}}},
+				1: Balance{Balance: -4, Debit: []Debt{{UserID: 2, Amount: 4}}},
+				2: Balance{Balance: 4, Credit: []Debt{{UserID: 1, Amount: 4}}},
 				3: Balance{Balance: 0},
 			},
 		},
@@ -93,16 +93,16 @@ func TestCalculateBalance(t *testing.T) {
 		{
 			[]Expense{meal, coffee},
 			map[int]Balance{
-				1: Balance{Balance: 24, Credit: []Debt{{2, 10}, {3, 14}}},
-				2: Balance{Balance: -10, Debit: []Debt{{1, 10}}},
-				3: Balance{Balance: -14, Debit: []Debt{{1, 14}}},
+				1: Balance{Balance: 24, Credit: []Debt{{UserID: 2, Amount: 10}, {UserID: 3, Amount: 14}}},
+				2: Balance{Balance: -10, Debit: []Debt{{UserID: 1, Amount: 10}}},
+				3: Balance{Balance: -14, Debit: []Debt{{UserID: 1, Amount: 14}}},
 			},
 		},
 	}
 
 	for _, test := range tests {
 		for userID, balance := range test.Balances {
-			got := CalculateBalance(test.Expenses, userID)
+			got := CalculateBalance(test.Expenses, nil, userID)
 			if !almostEqual(balance.Balance, got.Balance) {
 				t.Errorf("Balance mismatch, expected: %f, got: %f", balance.Balance, got.Balance)
 			}
@@ -113,3 +113,816 @@ func TestCalculateBalance(t *testing.T) {
 		}
 	}
 }
+
+func TestCalculateBalanceWithSettlements(t *testing.T) {
+	// User 1 pays €42 split between users 1,2,3. User 2 then pays back part of
+	// their €14 debt and ends up with a residual debt.
+
+	meal := Expense{
+		ExpenseID: 1,
+		OwnerID:   1,
+		Users:     []int{1, 2, 3},
+		Amount:    42,
+	}
+
+	tests := []struct {
+		Settlements []Settlement
+		Balances    map[int]Balance
+	}{
+		// User 2 pays back €10 and still owes €4
+		{
+			[]Settlement{{SettlementID: 1, FromUserID: 2, ToUserID: 1, Amount: 10}},
+			map[int]Balance{
+				1: Balance{Balance: 18, Credit: []Debt{{UserID: 2, Amount: 4}, {UserID: 3, Amount: 14}}},
+				2: Balance{Balance: -4, Debit: []Debt{{UserID: 1, Amount: 4}}},
+				3: Balance{Balance: -14, Debit: []Debt{{UserID: 1, Amount: 14}}},
+			},
+		},
+
+		// User 2 pays back in two installments and is settled up
+		{
+			[]Settlement{
+				{SettlementID: 1, FromUserID: 2, ToUserID: 1, Amount: 10},
+				{SettlementID: 2, FromUserID: 2, ToUserID: 1, Amount: 4},
+			},
+			map[int]Balance{
+				1: Balance{Balance: 14, Credit: []Debt{{UserID: 3, Amount: 14}}},
+				2: Balance{Balance: 0},
+			},
+		},
+
+		// User 2 overpays by €1, so user 1 now owes user 2
+		{
+			[]Settlement{{SettlementID: 1, FromUserID: 2, ToUserID: 1, Amount: 15}},
+			map[int]Balance{
+				1: Balance{Balance: 13, Debit: []Debt{{UserID: 2, Amount: 1}}, Credit: []Debt{{UserID: 3, Amount: 14}}},
+				2: Balance{Balance: 1, Credit: []Debt{{UserID: 1, Amount: 1}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		for userID, balance := range test.Balances {
+			got := CalculateBalance([]Expense{meal}, test.Settlements, userID)
+			if !almostEqual(balance.Balance, got.Balance) {
+				t.Errorf("Balance mismatch, expected: %f, got: %f", balance.Balance, got.Balance)
+			}
+
+			if !debtsInBalanceEqual(got, balance) {
+				t.Errorf("Owes mismatch, expected: %+v, got: %+v", balance, got)
+			}
+		}
+	}
+}
+
+func TestAutoSettleThreshold(t *testing.T) {
+	// User 1 pays €42 split between users 1,2,3. User 2 pays back nearly all of
+	// their €14 debt, leaving a residual that is dropped if below the threshold.
+
+	old := AutoSettleThreshold
+	defer func() { AutoSettleThreshold = old }()
+	AutoSettleThreshold = 0.005
+
+	meal := Expense{
+		ExpenseID: 1,
+		OwnerID:   1,
+		Users:     []int{1, 2, 3},
+		Amount:    42,
+	}
+
+	tests := []struct {
+		Settled  float64
+		Balances map[int]Balance
+	}{
+		// A residual of €0.003 is treated as settled
+		{
+			13.997,
+			map[int]Balance{
+				1: Balance{Balance: 14, Credit: []Debt{{UserID: 3, Amount: 14}}},
+				2: Balance{Balance: 0},
+			},
+		},
+
+		// So is an overpayment of €0.003
+		{
+			14.003,
+			map[int]Balance{
+				1: Balance{Balance: 14, Credit: []Debt{{UserID: 3, Amount: 14}}},
+				2: Balance{Balance: 0},
+			},
+		},
+
+		// A residual of €0.01 is retained
+		{
+			13.99,
+			map[int]Balance{
+				1: Balance{Balance: 14.01, Credit: []Debt{{UserID: 2, Amount: 0.01}, {UserID: 3, Amount: 14}}},
+				2: Balance{Balance: -0.01, Debit: []Debt{{UserID: 1, Amount: 0.01}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		settlements := []Settlement{{SettlementID: 1, FromUserID: 2, ToUserID: 1, Amount: test.Settled}}
+		for userID, balance := range test.Balances {
+			got := CalculateBalance([]Expense{meal}, settlements, userID)
+			if !almostEqual(balance.Balance, got.Balance) {
+				t.Errorf("%f settled, user %d: expected balance %f, got %f", test.Settled, userID, balance.Balance, got.Balance)
+			}
+
+			gotDebts := makeOweMap(append(got.Debit, got.Credit...))
+			wantedDebts := makeOweMap(append(balance.Debit, balance.Credit...))
+			if len(gotDebts) != len(wantedDebts) || len(got.Debit) != len(balance.Debit) {
+				t.Errorf("%f settled, user %d: expected %+v, got %+v", test.Settled, userID, balance, got)
+				continue
+			}
+			for id, amount := range wantedDebts {
+				if !almostEqual(amount, gotDebts[id]) {
+					t.Errorf("%f settled, user %d: expected %+v, got %+v", test.Settled, userID, balance, got)
+				}
+			}
+		}
+	}
+}
+
+func TestEpsilon(t *testing.T) {
+	// A net debt of exactly Epsilon is dropped, one just above it is a debit for
+	// the debtor and a credit for the creditor
+
+	old := Epsilon
+	defer func() { Epsilon = old }()
+	Epsilon = 0.25
+
+	tests := []struct {
+		Amount  float64 // Split between users 1 and 2, paid by user 1
+		Balance float64 // Of user 2
+		Debt    bool    // User 2 has a debit and user 1 a credit
+	}{
+		{0.5, 0, false},
+		{0.50390625, -0.251953125, true},
+		{0.49609375, 0, false},
+	}
+
+	for _, test := range tests {
+		expenses := []Expense{{ExpenseID: 1, OwnerID: 1, Users: []int{1, 2}, Amount: test.Amount}}
+		debtor := CalculateBalance(expenses, nil, 2)
+		creditor := CalculateBalance(expenses, nil, 1)
+
+		if debtor.Balance != test.Balance || creditor.Balance != -test.Balance {
+			t.Errorf("%f: expected balances %f and %f, got %f and %f", test.Amount, test.Balance, -test.Balance, debtor.Balance, creditor.Balance)
+		}
+		if got := len(debtor.Debit) == 1 && len(creditor.Credit) == 1; got != test.Debt {
+			t.Errorf("%f: expected a debt %v, got %+v and %+v", test.Amount, test.Debt, debtor, creditor)
+		}
+		if len(debtor.Credit) != 0 || len(creditor.Debit) != 0 {
+			t.Errorf("%f: expected no reversed debts, got %+v and %+v", test.Amount, debtor, creditor)
+		}
+	}
+
+	// With the default, floating point noise left by a settlement is dropped
+	Epsilon = old
+	expenses := []Expense{{ExpenseID: 1, OwnerID: 1, Users: []int{1, 2}, Amount: 0.6}}
+	settlements := []Settlement{{SettlementID: 1, FromUserID: 2, ToUserID: 1, Amount: 0.1}, {SettlementID: 2, FromUserID: 2, ToUserID: 1, Amount: 0.2}}
+	if got := CalculateBalance(expenses, settlements, 2); len(got.Debit) != 0 || len(got.Credit) != 0 {
+		t.Errorf("expected no debts, got %+v", got)
+	}
+}
+
+func TestCalculateBalanceWithPayer(t *testing.T) {
+	// User 1 records a €42 meal split between users 1,2,3 that user 2 paid for.
+	// User 2 is credited, not user 1.
+
+	meal := Expense{
+		ExpenseID: 1,
+		OwnerID:   1,
+		PayerID:   2,
+		Users:     []int{1, 2, 3},
+		Amount:    42,
+	}
+
+	balances := map[int]Balance{
+		1: Balance{Balance: -14, Debit: []Debt{{UserID: 2, Amount: 14}}},
+		2: Balance{Balance: 28, Credit: []Debt{{UserID: 1, Amount: 14}, {UserID: 3, Amount: 14}}},
+		3: Balance{Balance: -14, Debit: []Debt{{UserID: 2, Amount: 14}}},
+	}
+
+	for userID, balance := range balances {
+		got := CalculateBalance([]Expense{meal}, nil, userID)
+		if !almostEqual(balance.Balance, got.Balance) {
+			t.Errorf("Balance mismatch, expected: %f, got: %f", balance.Balance, got.Balance)
+		}
+
+		if !debtsInBalanceEqual(got, balance) {
+			t.Errorf("Owes mismatch, expected: %+v, got: %+v", balance, got)
+		}
+	}
+}
+
+func TestCalculateBalanceWithPercentageSplit(t *testing.T) {
+	// User 1 pays €100 split 50/30/20 between users 1,2,3
+
+	meal := Expense{
+		ExpenseID:       1,
+		OwnerID:         1,
+		Users:           []int{1, 2, 3},
+		Amount:          100,
+		PercentageSplit: map[int]float64{1: 50, 2: 30, 3: 20},
+	}
+
+	if err := meal.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error %v", err)
+	}
+
+	balances := map[int]Balance{
+		1: Balance{Balance: 50, Credit: []Debt{{UserID: 2, Amount: 30}, {UserID: 3, Amount: 20}}},
+		2: Balance{Balance: -30, Debit: []Debt{{UserID: 1, Amount: 30}}},
+		3: Balance{Balance: -20, Debit: []Debt{{UserID: 1, Amount: 20}}},
+	}
+
+	for userID, balance := range balances {
+		got := CalculateBalance([]Expense{meal}, nil, userID)
+		if !almostEqual(balance.Balance, got.Balance) {
+			t.Errorf("Balance mismatch, expected: %f, got: %f", balance.Balance, got.Balance)
+		}
+
+		if !debtsInBalanceEqual(got, balance) {
+			t.Errorf("Owes mismatch, expected: %+v, got: %+v", balance, got)
+		}
+	}
+}
+
+func TestValidatePercentageSplit(t *testing.T) {
+	// Percentages adding up to 90 are rejected
+
+	meal := Expense{
+		ExpenseID:       1,
+		OwnerID:         1,
+		Users:           []int{1, 2, 3},
+		Amount:          100,
+		PercentageSplit: map[int]float64{1: 50, 2: 20, 3: 20},
+	}
+
+	if err := meal.Validate(); err != ErrInvalidPercentages {
+		t.Errorf("wanted %v, got %v", ErrInvalidPercentages, err)
+	}
+}
+
+func TestCalculateBalanceWithShareSplit(t *testing.T) {
+	// User 2 pays €10 split in 3 shares, user 1 takes 1 and user 2 takes 2. The
+	// cent left over goes to user 2, who paid, not user 1.
+
+	meal := Expense{
+		ExpenseID:  1,
+		OwnerID:    1,
+		PayerID:    2,
+		Users:      []int{1, 2},
+		Amount:     10,
+		Currency:   "EUR",
+		ShareSplit: map[int]int{1: 1, 2: 2},
+	}
+
+	if err := meal.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error %v", err)
+	}
+
+	shares := meal.Shares()
+	if !almostEqual(shares[1], 3.33) || !almostEqual(shares[2], 6.67) {
+		t.Errorf("wanted shares 3.33 and 6.67, got %v", shares)
+	}
+
+	balances := map[int]Balance{
+		1: Balance{Balance: -3.33, Debit: []Debt{{UserID: 2, Amount: 3.33}}},
+		2: Balance{Balance: 3.33, Credit: []Debt{{UserID: 1, Amount: 3.33}}},
+	}
+
+	for userID, balance := range balances {
+		got := CalculateBalance([]Expense{meal}, nil, userID)
+		if !almostEqual(balance.Balance, got.Balance) {
+			t.Errorf("Balance mismatch, expected: %f, got: %f", balance.Balance, got.Balance)
+		}
+
+		if !debtsInBalanceEqual(got, balance) {
+			t.Errorf("Owes mismatch, expected: %+v, got: %+v", balance, got)
+		}
+	}
+}
+
+func TestShareSplitRatio(t *testing.T) {
+	// Without a leftover, the shares follow the ratio exactly. A payer who
+	// doesn't share the expense leaves the leftover to the first user.
+
+	tests := []struct {
+		Expense Expense
+		Wanted  map[int]float64
+	}{
+		{Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 60, Currency: "EUR", ShareSplit: map[int]int{1: 1, 2: 2, 3: 3}}, map[int]float64{1: 10, 2: 20, 3: 30}},
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 100, Currency: "JPY", ShareSplit: map[int]int{1: 2, 2: 1}}, map[int]float64{1: 67, 2: 33}},
+		{Expense{OwnerID: 1, PayerID: 3, Users: []int{1, 2}, Amount: 1, Currency: "EUR", ShareSplit: map[int]int{1: 1, 2: 2}}, map[int]float64{1: 0.34, 2: 0.66}},
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 5, Currency: "EUR", ShareSplit: map[int]int{2: 1}}, map[int]float64{1: 0, 2: 5}},
+	}
+
+	for _, test := range tests {
+		got := test.Expense.Shares()
+		if len(got) != len(test.Wanted) {
+			t.Fatalf("wanted %v, got %v", test.Wanted, got)
+		}
+		for u, share := range test.Wanted {
+			if !almostEqual(got[u], share) {
+				t.Errorf("%+v: wanted %v, got %v", test.Expense, test.Wanted, got)
+			}
+		}
+	}
+}
+
+func TestValidateShareSplit(t *testing.T) {
+	// Negative shares and splits without any shares are rejected
+
+	tests := []struct {
+		ShareSplit map[int]int
+		Wanted     error
+	}{
+		{map[int]int{1: 1, 2: 2}, nil},
+		{map[int]int{1: 0, 2: 1}, nil},
+		{map[int]int{1: 0, 2: 0}, ErrInvalidShares},
+		{map[int]int{1: -1, 2: 2}, ErrInvalidShares},
+	}
+
+	for _, test := range tests {
+		e := Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 10, ShareSplit: test.ShareSplit}
+		if err := e.Validate(); err != test.Wanted {
+			t.Errorf("%v: wanted %v, got %v", test.ShareSplit, test.Wanted, err)
+		}
+	}
+}
+
+func TestSharesWithBase(t *testing.T) {
+	// Each user pays the base first, then the rest is split. The shares add up to
+	// the amount.
+
+	tests := []struct {
+		Expense Expense
+		Wanted  map[int]float64
+	}{
+		// A €5 cover charge each, then €15 split evenly
+		{Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 30, Currency: "EUR", BasePerPerson: 5}, map[int]float64{1: 10, 2: 10, 3: 10}},
+		// The rest of €10 can't be split evenly, the payer absorbs the cent
+		{Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 25, Currency: "EUR", BasePerPerson: 5}, map[int]float64{1: 8.34, 2: 8.33, 3: 8.33}},
+		// The rest of €20 is split by percentage
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 30, Currency: "EUR", BasePerPerson: 5, PercentageSplit: map[int]float64{1: 25, 2: 75}}, map[int]float64{1: 10, 2: 20}},
+		// The bases are the whole amount
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 10, Currency: "EUR", BasePerPerson: 5}, map[int]float64{1: 5, 2: 5}},
+	}
+
+	for _, test := range tests {
+		if err := test.Expense.Validate(); err != nil {
+			t.Fatalf("%+v: unexpected validation error %v", test.Expense, err)
+		}
+
+		got := test.Expense.Shares()
+		if len(got) != len(test.Wanted) {
+			t.Fatalf("wanted %v, got %v", test.Wanted, got)
+		}
+		var total float64
+		for u, share := range test.Wanted {
+			if !almostEqual(got[u], share) {
+				t.Errorf("%+v: wanted %v, got %v", test.Expense, test.Wanted, got)
+			}
+			total += got[u]
+		}
+		if !almostEqual(total, test.Expense.Amount) {
+			t.Errorf("%+v: wanted shares adding up to %v, got %v", test.Expense, test.Expense.Amount, total)
+		}
+	}
+
+	// User 1 pays €25, users 2 and 3 owe their €5 base and a third of the €10 rest
+	meal := Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 25, Currency: "EUR", BasePerPerson: 5}
+	balance := CalculateBalance([]Expense{meal}, nil, 1)
+	if !almostEqual(balance.Balance, 16.66) {
+		t.Errorf("wanted balance 16.66, got %v", balance.Balance)
+	}
+}
+
+func TestValidateBase(t *testing.T) {
+	// The bases of all participants, including the owner, must fit in the amount
+
+	tests := []struct {
+		Expense Expense
+		Wanted  error
+	}{
+		{Expense{OwnerID: 1, Users: []int{2, 3}, Amount: 15, BasePerPerson: 5}, nil},
+		{Expense{OwnerID: 1, Users: []int{2, 3}, Amount: 14.99, BasePerPerson: 5}, ErrInvalidBase},
+		{Expense{OwnerID: 1, Users: []int{2, 3}, Amount: 10, BasePerPerson: 5, ExcludeOwner: true}, nil},
+		{Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 0.3, BasePerPerson: 0.1}, nil},
+		{Expense{OwnerID: 1, Users: []int{2}, Amount: 10, BasePerPerson: -1}, ErrInvalidBase},
+	}
+
+	for _, test := range tests {
+		if err := test.Expense.Validate(); err != test.Wanted {
+			t.Errorf("%+v: wanted %v, got %v", test.Expense, test.Wanted, err)
+		}
+	}
+}
+
+func TestValidateAmountTooLarge(t *testing.T) {
+	// Amounts are accepted up to the point where the amount in minor units times
+	// the number of participants can no longer be represented exactly
+
+	tests := []struct {
+		Expense Expense
+		Wanted  error
+	}{
+		// 2^52 yen split between two users is exactly at the limit
+		{Expense{OwnerID: 1, Users: []int{2}, Amount: 1 << 52, Currency: "JPY"}, nil},
+		{Expense{OwnerID: 1, Users: []int{2}, Amount: 1<<52 + 1, Currency: "JPY"}, ErrAmountTooLarge},
+
+		// The owner is only counted once
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 1 << 52, Currency: "JPY"}, nil},
+
+		// More participants lower the limit
+		{Expense{OwnerID: 1, Users: []int{2, 3, 4}, Amount: 1 << 52, Currency: "JPY"}, ErrAmountTooLarge},
+		{Expense{OwnerID: 1, Users: []int{2, 3, 4}, Amount: 1 << 51, Currency: "JPY"}, nil},
+
+		// Minor units lower the limit too
+		{Expense{OwnerID: 1, Users: []int{2}, Amount: 1 << 52, Currency: "EUR"}, ErrAmountTooLarge},
+		{Expense{OwnerID: 1, Users: []int{2}, Amount: 1 << 45, Currency: "EUR"}, nil},
+		{Expense{OwnerID: 1, Users: []int{2}, Amount: 1 << 45, Currency: "BHD"}, ErrAmountTooLarge},
+	}
+
+	for _, test := range tests {
+		if err := test.Expense.Validate(); err != test.Wanted {
+			t.Errorf("%+v: wanted %v, got %v", test.Expense, test.Wanted, err)
+		}
+	}
+}
+
+func TestCalculateBalanceWithSoloExpense(t *testing.T) {
+	// User 1 pays €10 for themselves, alongside a €42 meal split between users 1,2,3.
+	// The personal expense doesn't change anything.
+
+	personal := Expense{
+		ExpenseID: 1,
+		OwnerID:   1,
+		Users:     []int{1},
+		Amount:    10,
+		Currency:  "EUR",
+	}
+
+	meal := Expense{
+		ExpenseID: 2,
+		OwnerID:   1,
+		Users:     []int{1, 2, 3},
+		Amount:    42,
+	}
+
+	tests := []struct {
+		Expenses []Expense
+		Balances map[int]Balance
+	}{
+		{
+			[]Expense{personal},
+			map[int]Balance{
+				1: Balance{Balance: 0},
+			},
+		},
+		{
+			[]Expense{personal, meal},
+			map[int]Balance{
+				1: Balance{Balance: 28, Credit: []Debt{{UserID: 2, Amount: 14}, {UserID: 3, Amount: 14}}},
+				2: Balance{Balance: -14, Debit: []Debt{{UserID: 1, Amount: 14}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		for userID, balance := range test.Balances {
+			got := CalculateBalance(test.Expenses, nil, userID)
+			if !almostEqual(balance.Balance, got.Balance) {
+				t.Errorf("Balance mismatch, expected: %f, got: %f", balance.Balance, got.Balance)
+			}
+
+			if !debtsInBalanceEqual(got, balance) {
+				t.Errorf("Owes mismatch, expected: %+v, got: %+v", balance, got)
+			}
+		}
+	}
+}
+
+func TestPersonalExpenses(t *testing.T) {
+	// An expense only shared by its payer, however it's split, nets to zero
+	// without any debts or breakdowns
+
+	tests := []struct {
+		Expense  Expense
+		Personal bool
+	}{
+		{Expense{OwnerID: 1, Users: []int{1}, Amount: 10, Currency: "EUR"}, true},
+		{Expense{OwnerID: 1, Users: []int{}, Amount: 10}, true},
+		{Expense{OwnerID: 1, Users: []int{1}, Amount: 10, PercentageSplit: map[int]float64{1: 100}}, true},
+		{Expense{OwnerID: 1, Users: []int{1}, Amount: 10, ShareSplit: map[int]int{1: 3}}, true},
+		{Expense{OwnerID: 1, PayerID: 2, Users: []int{2}, Amount: 10, ExcludeOwner: true}, true},
+		{Expense{OwnerID: 1, PayerID: 2, Users: []int{1, 2}, Amount: 10}, false},
+		{Expense{OwnerID: 1, PayerID: 2, Users: []int{2}, Amount: 10}, false},
+		{Expense{OwnerID: 1, Users: []int{}, Amount: 10, ExcludeOwner: true}, false},
+	}
+
+	for i, test := range tests {
+		test.Expense.ExpenseID = i + 1
+		if got := test.Expense.IsPersonal(); got != test.Personal {
+			t.Errorf("%+v: wanted personal %v, got %v", test.Expense, test.Personal, got)
+		}
+		if !test.Personal {
+			continue
+		}
+
+		for _, userID := range []int{1, 2} {
+			got := CalculateBalance([]Expense{test.Expense}, nil, userID)
+			if got.Balance != 0 || len(got.Debit) != 0 || len(got.Credit) != 0 {
+				t.Errorf("%+v: wanted an empty balance for user %d, got %+v", test.Expense, userID, got)
+			}
+		}
+	}
+}
+
+func TestCalculateBalanceBreakdown(t *testing.T) {
+	// The breakdown of every debt and credit adds up to its amount
+
+	expenses := []Expense{
+		{ExpenseID: 1, OwnerID: 1, Users: []int{1, 2, 3}, Amount: 42},
+		{ExpenseID: 2, OwnerID: 2, Users: []int{1, 2}, Amount: 8},
+		{ExpenseID: 3, OwnerID: 3, Users: []int{1, 3}, Amount: 10, PercentageSplit: map[int]float64{1: 30, 3: 70}},
+	}
+	settlements := []Settlement{{SettlementID: 1, FromUserID: 2, ToUserID: 1, Amount: 5}}
+
+	for userID := 1; userID <= 3; userID++ {
+		got := CalculateBalance(expenses, settlements, userID)
+		for _, debt := range append(got.Debit, got.Credit...) {
+			var total float64
+			for _, item := range debt.Breakdown {
+				total += item.Amount
+			}
+			if !almostEqual(total, debt.Amount) {
+				t.Errorf("user %d: breakdown of debt with user %d adds up to %f, wanted %f", userID, debt.UserID, total, debt.Amount)
+			}
+		}
+	}
+
+	// User 2 owes user 1 €14 for the meal, minus €4 for the coffee and €5 settled
+	got := CalculateBalance(expenses, settlements, 2)
+	if len(got.Debit) != 1 || len(got.Debit[0].Breakdown) != 3 {
+		t.Fatalf("wanted a single debt made up of 3 items, got %+v", got.Debit)
+	}
+
+	wanted := []DebtItem{{ExpenseID: 1, Amount: 14}, {ExpenseID: 2, Amount: -4}, {SettlementID: 1, Amount: -5}}
+	for i, item := range got.Debit[0].Breakdown {
+		if item.ExpenseID != wanted[i].ExpenseID || item.SettlementID != wanted[i].SettlementID || !almostEqual(item.Amount, wanted[i].Amount) {
+			t.Errorf("wanted %+v, got %+v", wanted[i], item)
+		}
+	}
+}
+
+// makeBenchmarkExpenses makes count expenses between numUsers users, each shared
+// by participants users. The same expenses are made on every call.
+func makeBenchmarkExpenses(count int, numUsers int, participants int) []Expense {
+	r := rand.New(rand.NewSource(1))
+	expenses := make([]Expense, count)
+	for i := range expenses {
+		users := r.Perm(numUsers)[:participants]
+		for j := range users {
+			users[j]++ // User ids start at 1
+		}
+		expenses[i] = Expense{
+			ExpenseID: i + 1,
+			OwnerID:   users[0],
+			Users:     users,
+			Amount:    float64(r.Intn(10000)) / 100,
+		}
+	}
+	return expenses
+}
+
+func BenchmarkCalculateBalance(b *testing.B) {
+	// User 1 is one of a few users that share all expenses
+
+	for _, count := range []int{10, 1000, 100000} {
+		for _, participants := range []int{2, 5, 20} {
+			b.Run(fmt.Sprintf("expenses=%d/participants=%d", count, participants), func(b *testing.B) {
+				expenses := makeBenchmarkExpenses(count, participants, participants)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					CalculateBalance(expenses, nil, 1)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCalculateBalanceSparse(b *testing.B) {
+	// User 1 is one of 100 users and only shares a fraction of the expenses
+
+	for _, count := range []int{10, 1000, 100000} {
+		b.Run(fmt.Sprintf("expenses=%d", count), func(b *testing.B) {
+			expenses := makeBenchmarkExpenses(count, 100, 5)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				CalculateBalance(expenses, nil, 1)
+			}
+		})
+	}
+}
+
+func TestMergeUser(t *testing.T) {
+	// Merging a user into another keeps the shares of everyone the same
+
+	tests := []struct {
+		Expense Expense
+		Wanted  map[int]float64 // Shares after merging user 1 into user 2
+	}{
+		// User 2 doesn't share the expense
+		{
+			Expense{OwnerID: 1, Users: []int{1, 3}, Amount: 42},
+			map[int]float64{2: 21, 3: 21},
+		},
+
+		// Both users share an equally split expense
+		{
+			Expense{OwnerID: 3, Users: []int{1, 2, 3}, Amount: 42},
+			map[int]float64{2: 28, 3: 14},
+		},
+
+		// Both users share a percentage split
+		{
+			Expense{OwnerID: 3, Users: []int{1, 2, 3}, Amount: 100, PercentageSplit: map[int]float64{1: 10, 2: 20, 3: 70}},
+			map[int]float64{2: 30, 3: 70},
+		},
+
+		// Both users share a share split
+		{
+			Expense{OwnerID: 3, Users: []int{1, 2, 3}, Amount: 60, ShareSplit: map[int]int{1: 1, 2: 2, 3: 3}},
+			map[int]float64{2: 30, 3: 30},
+		},
+
+		// Both users share a split with a base per person
+		{
+			Expense{OwnerID: 3, Users: []int{1, 2, 3}, Amount: 60, BasePerPerson: 10},
+			map[int]float64{2: 40, 3: 20},
+		},
+
+		// User 2 doesn't share a split with a base per person
+		{
+			Expense{OwnerID: 3, Users: []int{1, 3}, Amount: 60, BasePerPerson: 10},
+			map[int]float64{2: 30, 3: 30},
+		},
+	}
+
+	for _, test := range tests {
+		got := test.Expense.MergeUser(1, 2)
+		if got.HasUser(1) || !got.HasUser(2) {
+			t.Errorf("wanted user 1 replaced by user 2, got users %v", got.Users)
+		}
+		if err := got.Validate(); err != nil {
+			t.Errorf("invalid merged expense: %v", err)
+		}
+
+		shares := got.Shares()
+		if len(shares) != len(test.Wanted) {
+			t.Errorf("wanted shares %v, got %v", test.Wanted, shares)
+		}
+		for u, share := range test.Wanted {
+			if !almostEqual(shares[u], share) {
+				t.Errorf("wanted share %f for user %d, got %f", share, u, shares[u])
+			}
+		}
+	}
+
+	// The owner and payer are replaced too, without modifying the original
+	e := Expense{OwnerID: 1, PayerID: 1, Users: []int{1, 2}, Amount: 42}
+	got := e.MergeUser(1, 2)
+	if got.OwnerID != 2 || got.Payer() != 2 {
+		t.Errorf("wanted owner and payer 2, got %d and %d", got.OwnerID, got.Payer())
+	}
+	if e.OwnerID != 1 || !e.HasUser(1) {
+		t.Errorf("original expense was modified: %+v", e)
+	}
+}
+
+func TestCalculateBalanceWithExcludedOwner(t *testing.T) {
+	// User 1 pays €10 for users 2, 3 and 4 without taking a share. The full
+	// amount is credited to user 1, with a rounding remainder for the first user.
+
+	gift := Expense{
+		ExpenseID:    1,
+		OwnerID:      1,
+		Users:        []int{2, 3, 4},
+		Amount:       10,
+		Currency:     "EUR",
+		ExcludeOwner: true,
+	}
+
+	balances := map[int]Balance{
+		1: Balance{Balance: 10, Credit: []Debt{{UserID: 2, Amount: 3.34}, {UserID: 3, Amount: 3.33}, {UserID: 4, Amount: 3.33}}},
+		2: Balance{Balance: -3.34, Debit: []Debt{{UserID: 1, Amount: 3.34}}},
+		3: Balance{Balance: -3.33, Debit: []Debt{{UserID: 1, Amount: 3.33}}},
+		4: Balance{Balance: -3.33, Debit: []Debt{{UserID: 1, Amount: 3.33}}},
+	}
+
+	for userID, balance := range balances {
+		got := CalculateBalance([]Expense{gift}, nil, userID)
+		if !almostEqual(balance.Balance, got.Balance) {
+			t.Errorf("user %d: balance mismatch, expected: %f, got: %f", userID, balance.Balance, got.Balance)
+		}
+
+		if !debtsInBalanceEqual(got, balance) {
+			t.Errorf("user %d: owes mismatch, expected: %+v, got: %+v", userID, balance, got)
+		}
+	}
+}
+
+func TestCalculateBalanceWithReimbursement(t *testing.T) {
+	// User 1 pays €30 for dinner with users 2 and 3. User 2 pays user 1 back €6
+	// and user 1 records that user 3 paid them back €10. As expenses these would
+	// be shared with user 1, as reimbursements they reduce the debts in full.
+
+	expenses := []Expense{
+		{ExpenseID: 1, OwnerID: 1, Users: []int{1, 2, 3}, Amount: 30, Currency: "EUR"},
+		{ExpenseID: 2, OwnerID: 2, Users: []int{2, 1}, Amount: 6, Currency: "EUR", Type: TypeReimbursement},
+		{ExpenseID: 3, OwnerID: 1, PayerID: 3, Users: []int{1, 3}, Amount: 10, Currency: "EUR", Type: TypeReimbursement},
+	}
+
+	balances := map[int]Balance{
+		1: Balance{Balance: 4, Credit: []Debt{{UserID: 2, Amount: 4}}},
+		2: Balance{Balance: -4, Debit: []Debt{{UserID: 1, Amount: 4}}},
+		3: Balance{Balance: 0},
+	}
+
+	for userID, balance := range balances {
+		got := CalculateBalance(expenses, nil, userID)
+		if !almostEqual(balance.Balance, got.Balance) {
+			t.Errorf("user %d: balance mismatch, expected: %f, got: %f", userID, balance.Balance, got.Balance)
+		}
+
+		if !debtsInBalanceEqual(got, balance) {
+			t.Errorf("user %d: owes mismatch, expected: %+v, got: %+v", userID, balance, got)
+		}
+	}
+
+	// Paying back more than is owed leaves the recipient owing the rest
+	overpaid := append(expenses[:1:1], Expense{ExpenseID: 2, OwnerID: 2, Users: []int{2, 1}, Amount: 15, Type: TypeReimbursement})
+	if got := CalculateBalance(overpaid, nil, 2); !almostEqual(got.Balance, 5) || len(got.Credit) != 1 || !almostEqual(got.Credit[0].Amount, 5) {
+		t.Errorf("expected user 1 to owe user 2 €5, got %+v", got)
+	}
+}
+
+func TestValidateReimbursement(t *testing.T) {
+	// A reimbursement must be paid to someone other than the payer and is split
+	// equally
+
+	tests := []struct {
+		Expense Expense
+		Wanted  error
+	}{
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 10, Type: TypeReimbursement}, nil},
+		{Expense{OwnerID: 1, Users: []int{1, 2, 3}, Amount: 10, Type: TypeReimbursement}, nil},
+		{Expense{OwnerID: 1, Users: []int{1}, Amount: 10, Type: TypeReimbursement}, ErrInvalidReimbursement},
+		{Expense{OwnerID: 1, PayerID: 2, Users: []int{2}, Amount: 10, Type: TypeReimbursement}, nil},
+		{Expense{OwnerID: 1, PayerID: 2, Users: []int{2}, ExcludeOwner: true, Amount: 10, Type: TypeReimbursement}, ErrInvalidReimbursement},
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 10, Type: TypeReimbursement, ShareSplit: map[int]int{2: 1}}, ErrInvalidReimbursement},
+		{Expense{OwnerID: 1, Users: []int{1, 2}, Amount: 10, Type: TypeReimbursement, BasePerPerson: 1}, ErrInvalidReimbursement},
+	}
+
+	for i, test := range tests {
+		if got := test.Expense.Validate(); got != test.Wanted {
+			t.Errorf("%d: expected %v, got %v", i, test.Wanted, got)
+		}
+	}
+}
+
+func TestCounterparties(t *testing.T) {
+	// User 1 pays for users 2 and 3, user 4 pays for users 3 and 5 and user 1
+	// settles with user 6. User 3 has only user 1 and 4 as counterparties, not
+	// user 2 and 5 who shared the same expenses.
+
+	expenses := []Expense{
+		{ExpenseID: 1, OwnerID: 1, Users: []int{1, 2, 3}, Amount: 30},
+		{ExpenseID: 2, OwnerID: 4, Users: []int{3, 4, 5}, Amount: 30},
+	}
+	settlements := []Settlement{{SettlementID: 1, FromUserID: 6, ToUserID: 1, Amount: 5}}
+
+	tests := []struct {
+		UserID int
+		Wanted []int
+	}{
+		{1, []int{2, 3, 6}},
+		{3, []int{1, 4}},
+		{6, []int{1}},
+		{7, []int{}},
+	}
+
+	for _, test := range tests {
+		got := Counterparties(expenses, settlements, test.UserID)
+		if fmt.Sprint(got) != fmt.Sprint(test.Wanted) {
+			t.Errorf("user %d: wanted %v, got %v", test.UserID, test.Wanted, got)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"math"
+	"sort"
+)
+
+// Transfer is a payment from one user to another that settles (part of) the
+// debts of a group
+type Transfer struct {
+	FromUserID int     `json:"from_user_id"` // User id who pays
+	ToUserID   int     `json:"to_user_id"`   // User id who receives the money
+	Amount     float64 `json:"amount"`       // Amount paid
+}
+
+// SimplifyDebts returns the payments that settle a group, given the net balance
+// of each member, positive if they are owed money. The balances must add up to
+// zero. First, members whose debt matches a credit exactly pay that creditor.
+// Then the largest debtor pays the largest creditor until everyone is settled.
+// This takes at most one transfer less than the number of members who aren't
+// settled yet, where settling every debt between two members separately could
+// take many more.
+func SimplifyDebts(balances map[int]float64) []Transfer {
+	type member struct {
+		userID int
+		amount float64 // Amount owed to or by the member
+	}
+
+	var creditors, debtors []member
+	for userID, balance := range balances {
+		if balance > Epsilon {
+			creditors = append(creditors, member{userID, balance})
+		} else if balance < -Epsilon {
+			debtors = append(debtors, member{userID, -balance})
+		}
+	}
+
+	// Largest amounts first, by user id if they are equal, so that the same
+	// balances always give the same transfers
+	byAmount := func(members []member) {
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].amount != members[j].amount {
+				return members[i].amount > members[j].amount
+			}
+			return members[i].userID < members[j].userID
+		})
+	}
+	byAmount(creditors)
+	byAmount(debtors)
+
+	transfers := make([]Transfer, 0)
+	pay := func(debtor *member, creditor *member, amount float64) {
+		transfers = append(transfers, Transfer{FromUserID: debtor.userID, ToUserID: creditor.userID, Amount: amount})
+		debtor.amount -= amount
+		creditor.amount -= amount
+	}
+
+	for i := range debtors {
+		for j := range creditors {
+			if creditors[j].amount > Epsilon && math.Abs(debtors[i].amount-creditors[j].amount) <= Epsilon {
+				pay(&debtors[i], &creditors[j], debtors[i].amount)
+				creditors[j].amount = 0
+				break
+			}
+		}
+	}
+
+	for {
+		byAmount(creditors)
+		byAmount(debtors)
+		if len(debtors) == 0 || len(creditors) == 0 || debtors[0].amount <= Epsilon || creditors[0].amount <= Epsilon {
+			break
+		}
+		pay(&debtors[0], &creditors[0], math.Min(debtors[0].amount, creditors[0].amount))
+	}
+
+	return transfers
+}
@@ -0,0 +1,80 @@
+package ledger
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSimplifyDebts(t *testing.T) {
+	// Four users share three expenses, which leaves five debts between pairs of
+	// users. Users 3 and 4 owe user 1 and user 2 is settled, so two transfers
+	// settle everyone.
+
+	expenses := []Expense{
+		{ExpenseID: 1, OwnerID: 1, Users: []int{1, 2, 3, 4}, Amount: 80, Description: "Dinner"},
+		{ExpenseID: 2, OwnerID: 2, Users: []int{2, 3}, Amount: 40, Description: "Taxi"},
+		{ExpenseID: 3, OwnerID: 4, Users: []int{1, 4}, Amount: 20, Description: "Coffee"},
+	}
+	users := []int{1, 2, 3, 4}
+
+	balances := make(map[int]float64)
+	for _, u := range users {
+		balances[u] = CalculateBalance(expenses, nil, u).Balance
+	}
+
+	transfers := SimplifyDebts(balances)
+	wanted := []Transfer{
+		{FromUserID: 3, ToUserID: 1, Amount: 40},
+		{FromUserID: 4, ToUserID: 1, Amount: 10},
+	}
+	if !reflect.DeepEqual(transfers, wanted) {
+		t.Fatalf("wanted %+v, got %+v", wanted, transfers)
+	}
+
+	// Making the transfers brings everyone's balance to zero. Pairwise debts
+	// remain, e.g. user 3 owes user 2, but they cancel out.
+	var settlements []Settlement
+	for i, transfer := range transfers {
+		settlements = append(settlements, Settlement{
+			SettlementID: i + 1,
+			FromUserID:   transfer.FromUserID,
+			ToUserID:     transfer.ToUserID,
+			Amount:       transfer.Amount,
+			CreatedAt:    time.Now(),
+		})
+	}
+	for _, u := range users {
+		balance := CalculateBalance(expenses, settlements, u)
+		if !almostEqual(balance.Balance, 0) {
+			t.Errorf("wanted user %d settled, got %+v", u, balance)
+		}
+	}
+}
+
+func TestSimplifyDebtsExactMatches(t *testing.T) {
+	// Debts matching a credit are paid directly, largest amounts go first
+
+	tests := []struct {
+		Balances map[int]float64
+		Wanted   []Transfer
+	}{
+		{map[int]float64{}, []Transfer{}},
+		{map[int]float64{1: 0, 2: 0}, []Transfer{}},
+		{
+			map[int]float64{1: 10, 2: 3, 3: -7, 4: -3, 5: -3},
+			[]Transfer{{4, 2, 3}, {3, 1, 7}, {5, 1, 3}},
+		},
+		{
+			map[int]float64{1: 6, 2: 4, 3: -5, 4: -5},
+			[]Transfer{{3, 1, 5}, {4, 2, 4}, {4, 1, 1}},
+		},
+	}
+
+	for _, test := range tests {
+		got := SimplifyDebts(test.Balances)
+		if !reflect.DeepEqual(got, test.Wanted) {
+			t.Errorf("%v: wanted %+v, got %+v", test.Balances, test.Wanted, got)
+		}
+	}
+}
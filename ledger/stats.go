@@ -0,0 +1,50 @@
+package ledger
+
+// Stats summarizes the expenses a user takes part in
+type Stats struct {
+	TotalPaid                float64 `json:"total_paid"`                 // Amount of the expenses the user paid for
+	TotalOwed                float64 `json:"total_owed"`                 // Amount the user currently owes other users
+	ExpenseCount             int     `json:"expense_count"`              // Number of expenses the user takes part in
+	MostFrequentCounterparty int     `json:"most_frequent_counterparty"` // User id the user shares most expenses with, 0 if none
+	TotalExpenses            float64 `json:"total_expenses"`             // Amount of all expenses the user takes part in
+}
+
+// CalculateStats takes a []Expense and []Settlement and summarizes them for a
+// given userID. Ties for the most frequent counterparty go to the lowest user id.
+func CalculateStats(expenses []Expense, settlements []Settlement, userID int) Stats {
+	var stats Stats
+	counterparties := make(map[int]int) // Number of shared expenses per user
+
+	for _, expense := range expenses {
+		// Is userID involved in this expense? If not, skip it
+		if !expense.Involves(userID) {
+			continue
+		}
+
+		stats.ExpenseCount++
+		stats.TotalExpenses += expense.Amount
+		if expense.Payer() == userID {
+			stats.TotalPaid += expense.Amount
+		}
+
+		for _, expenseUserID := range expense.Users {
+			if expenseUserID != userID {
+				counterparties[expenseUserID]++
+			}
+		}
+	}
+
+	mostFrequentCount := 0
+	for counterparty, count := range counterparties {
+		if count > mostFrequentCount || (count == mostFrequentCount && counterparty < stats.MostFrequentCounterparty) {
+			stats.MostFrequentCounterparty = counterparty
+			mostFrequentCount = count
+		}
+	}
+
+	for _, debt := range CalculateBalance(expenses, settlements, userID).Debit {
+		stats.TotalOwed += debt.Amount
+	}
+
+	return stats
+}
@@ -0,0 +1,36 @@
+package ledger
+
+import (
+	"testing"
+)
+
+func TestCalculateStats(t *testing.T) {
+	// Summarize a known set of expenses for every user
+
+	expenses := []Expense{
+		// User 1 pays €42 split between users 1,2,3
+		{ExpenseID: 1, OwnerID: 1, Users: []int{1, 2, 3}, Amount: 42},
+		// User 2 pays €8 split between users 1,2
+		{ExpenseID: 2, OwnerID: 2, Users: []int{1, 2}, Amount: 8},
+		// User 3 pays €20 split between users 1,3
+		{ExpenseID: 3, OwnerID: 3, Users: []int{1, 3}, Amount: 20},
+	}
+
+	tests := map[int]Stats{
+		1: {TotalPaid: 42, TotalOwed: 0, ExpenseCount: 3, MostFrequentCounterparty: 2, TotalExpenses: 70},
+		2: {TotalPaid: 8, TotalOwed: 10, ExpenseCount: 2, MostFrequentCounterparty: 1, TotalExpenses: 50},
+		3: {TotalPaid: 20, TotalOwed: 4, ExpenseCount: 2, MostFrequentCounterparty: 1, TotalExpenses: 62},
+		4: {},
+	}
+
+	for userID, wanted := range tests {
+		got := CalculateStats(expenses, nil, userID)
+		if !almostEqual(wanted.TotalPaid, got.TotalPaid) ||
+			!almostEqual(wanted.TotalOwed, got.TotalOwed) ||
+			!almostEqual(wanted.TotalExpenses, got.TotalExpenses) ||
+			wanted.ExpenseCount != got.ExpenseCount ||
+			wanted.MostFrequentCounterparty != got.MostFrequentCounterparty {
+			t.Errorf("Stats mismatch for user %d, expected: %+v, got: %+v", userID, wanted, got)
+		}
+	}
+}
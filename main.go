@@ -7,33 +7,81 @@ import (
 	"github.com/freewilll/splitter/api"
 	"github.com/freewilll/splitter/cache"
 	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/jwt"
+	"github.com/freewilll/splitter/ledger"
 )
 
 // General flags
 var createSchema = flag.Bool("create-schema", false, "create schema")
 
+// Ledger flags
+var autoSettleThreshold = flag.Float64("auto-settle-threshold", 0, "net debts between two users up to this amount are considered settled")
+var epsilon = flag.Float64("epsilon", ledger.Epsilon, "tolerance for floating point noise when comparing amounts")
+
+// JWT flags
+var jwtIssuer = flag.String("jwt-issuer", jwt.Issuer, "iss claim of created tokens, tokens with another issuer are rejected")
+var jwtAudience = flag.String("jwt-audience", jwt.Audience, "aud claim of created tokens, tokens with another audience are rejected")
+var jwtLeeway = flag.Duration("jwt-leeway", jwt.Leeway, "clock skew tolerated when checking the expiry and not before time of tokens")
+
+// Database flags
+var maxParticipants = flag.Int("max-participants", database.MaxParticipants, "maximum number of users sharing an expense, including the owner, 0 for no limit")
+
 // Postgresql flags
 var dbHost = flag.String("db-host", "localhost", "database host")
 var dbPort = flag.Int("db-port", 5432, "database port")
 var dbUser = flag.String("db-user", "postgres", "database user")
 var dbPassword = flag.String("db-password", "stream", "database password")
 var dbName = flag.String("db-name", "postgres", "database name")
+var dbIsolation = flag.String("db-isolation", "read-committed", "isolation level of transactions creating expenses: read-committed or serializable")
+var dbSlowQueryThreshold = flag.Duration("db-slow-query-threshold", 0, "log database queries taking longer than this, 0 to disable")
+var dbCheckSchema = flag.Bool("db-check-schema", true, "refuse to start if the database schema is missing or out of date")
 
 // Redis flags
 var cacheAddr = flag.String("cache-addr", "localhost:6379", "redis cache address")
 var cachePassword = flag.String("cache-password", "", "redis cache password")
 var cacheDb = flag.Int("cache-db", 0, "redis cache db")
+var cacheKeyPrefix = flag.String("cache-key-prefix", "splitter:", "redis cache key prefix")
+var cacheHealthWindow = flag.Int("cache-health-window", cache.DefaultHealthWindow, "number of recent redis calls the error rate of the cache is calculated over")
+var cacheErrorThreshold = flag.Float64("cache-error-threshold", cache.DefaultErrorThreshold, "error rate of recent redis calls above which the cache is degraded")
 
 func main() {
 	flag.Parse()
 
+	if *autoSettleThreshold < 0 {
+		log.Fatal("auto-settle-threshold must not be negative")
+	}
+	ledger.AutoSettleThreshold = *autoSettleThreshold
+	if *epsilon < 0 {
+		log.Fatal("epsilon must not be negative")
+	}
+	ledger.Epsilon = *epsilon
+
+	jwt.Issuer = *jwtIssuer
+	jwt.Audience = *jwtAudience
+	if *jwtLeeway < 0 {
+		log.Fatal("jwt-leeway must not be negative")
+	}
+	jwt.Leeway = *jwtLeeway
+
+	if *maxParticipants < 0 {
+		log.Fatal("max-participants must not be negative")
+	}
+	database.MaxParticipants = *maxParticipants
+
 	// Configure Postgresql
+	isolation, err := database.ParseIsolationLevel(*dbIsolation)
+	if err != nil {
+		log.Fatal(err)
+	}
 	dbConfig := database.Config{
-		Host:     *dbHost,
-		Port:     *dbPort,
-		User:     *dbUser,
-		Password: *dbPassword,
-		Name:     *dbName,
+		Host:      *dbHost,
+		Port:      *dbPort,
+		User:      *dbUser,
+		Password:  *dbPassword,
+		Name:      *dbName,
+		Isolation: isolation,
+
+		SlowQueryThreshold: *dbSlowQueryThreshold,
 	}
 	db := database.NewPgDatabase(dbConfig)
 
@@ -46,11 +94,27 @@ func main() {
 		return
 	}
 
+	if *dbCheckSchema {
+		if err := db.CheckSchema(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Configure Redis
+	if *cacheHealthWindow < 1 {
+		log.Fatal("cache-health-window must be at least 1")
+	}
+	if *cacheErrorThreshold < 0 || *cacheErrorThreshold >= 1 {
+		log.Fatal("cache-error-threshold must be at least 0 and less than 1")
+	}
 	cacheConfig := cache.Config{
-		Addr:     *cacheAddr,
-		Password: *cachePassword,
-		Db:       *cacheDb,
+		Addr:      *cacheAddr,
+		Password:  *cachePassword,
+		Db:        *cacheDb,
+		KeyPrefix: *cacheKeyPrefix,
+
+		HealthWindow:   *cacheHealthWindow,
+		ErrorThreshold: *cacheErrorThreshold,
 	}
 	cache := cache.NewRedisCache(cacheConfig)
 
@@ -0,0 +1,95 @@
+package testutil
+
+import (
+	"time"
+
+	"github.com/freewilll/splitter/cache"
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// MockCache implements the cache.Cache interface by wrapping another cache.
+// Calls are passed through, unless a fault has been injected into the method, in
+// which case it panics with the injected error, e.g. to simulate redis being down.
+type MockCache struct {
+	Faults
+
+	cache cache.Cache // The wrapped cache
+}
+
+// NewMockCache creates a MockCache that passes calls through to c
+func NewMockCache(c cache.Cache) *MockCache {
+	return &MockCache{cache: c}
+}
+
+// SetBalance sets a balance in the wrapped cache
+func (m *MockCache) SetBalance(balance ledger.Balance, userID int, ttl time.Duration) {
+	m.panicIfFailing("SetBalance")
+	m.cache.SetBalance(balance, userID, ttl)
+}
+
+// GetBalance gets a balance from the wrapped cache
+func (m *MockCache) GetBalance(db database.Database, userID int) ledger.Balance {
+	m.panicIfFailing("GetBalance")
+	return m.cache.GetBalance(db, userID)
+}
+
+// GetBalances gets several balances from the wrapped cache
+func (m *MockCache) GetBalances(db database.Database, userIDs []int) map[int]ledger.Balance {
+	m.panicIfFailing("GetBalances")
+	return m.cache.GetBalances(db, userIDs)
+}
+
+// DeleteBalance deletes a balance from the wrapped cache
+func (m *MockCache) DeleteBalance(userID int) {
+	m.panicIfFailing("DeleteBalance")
+	m.cache.DeleteBalance(userID)
+}
+
+// PruneBalances prunes the balances in the wrapped cache
+func (m *MockCache) PruneBalances(before time.Time) int {
+	m.panicIfFailing("PruneBalances")
+	return m.cache.PruneBalances(before)
+}
+
+// GetFailedLogins gets the number of failed sign ins from the wrapped cache
+func (m *MockCache) GetFailedLogins(email string) int {
+	m.panicIfFailing("GetFailedLogins")
+	return m.cache.GetFailedLogins(email)
+}
+
+// RecordFailedLogin records a failed sign in in the wrapped cache
+func (m *MockCache) RecordFailedLogin(email string, ttl time.Duration) int {
+	m.panicIfFailing("RecordFailedLogin")
+	return m.cache.RecordFailedLogin(email, ttl)
+}
+
+// ResetFailedLogins forgets the failed sign ins in the wrapped cache
+func (m *MockCache) ResetFailedLogins(email string) {
+	m.panicIfFailing("ResetFailedLogins")
+	m.cache.ResetFailedLogins(email)
+}
+
+// AddSession adds a session to the wrapped cache
+func (m *MockCache) AddSession(userID int, tokenID string, ttl time.Duration) {
+	m.panicIfFailing("AddSession")
+	m.cache.AddSession(userID, tokenID, ttl)
+}
+
+// IsValidSession checks a session in the wrapped cache
+func (m *MockCache) IsValidSession(userID int, tokenID string) bool {
+	m.panicIfFailing("IsValidSession")
+	return m.cache.IsValidSession(userID, tokenID)
+}
+
+// RevokeSessions revokes sessions in the wrapped cache
+func (m *MockCache) RevokeSessions(userID int, except string) {
+	m.panicIfFailing("RevokeSessions")
+	m.cache.RevokeSessions(userID, except)
+}
+
+// Healthy returns false if a fault has been injected into Healthy, otherwise
+// the health of the wrapped cache
+func (m *MockCache) Healthy() bool {
+	return m.check("Healthy") == nil && m.cache.Healthy()
+}
@@ -0,0 +1,328 @@
+package testutil
+
+import (
+	"time"
+
+	"github.com/freewilll/splitter/database"
+	"github.com/freewilll/splitter/ledger"
+)
+
+// MockDatabase implements the database.Database interface by wrapping another
+// database. Calls are passed through, unless a fault has been injected into the
+// method. Methods returning an error return the injected error, the others panic
+// with it, just like the postgresql implementation does.
+type MockDatabase struct {
+	Faults
+
+	db database.Database // The wrapped database
+}
+
+// MockHandle implements the database.Handle interface for a MockDatabase
+type MockHandle struct {
+	dbh    database.Handle
+	faults *Faults
+}
+
+// NewMockDatabase creates a MockDatabase that passes calls through to db
+func NewMockDatabase(db database.Database) *MockDatabase {
+	return &MockDatabase{db: db}
+}
+
+// Connect returns a handle to the wrapped database. It panics if a fault has
+// been injected into "Connect".
+func (m *MockDatabase) Connect() database.Handle {
+	m.panicIfFailing("Connect")
+	return &MockHandle{dbh: m.db.Connect(), faults: &m.Faults}
+}
+
+// Close closes the wrapped handle
+func (h *MockHandle) Close() {
+	h.dbh.Close()
+}
+
+// CreateSchema creates the schema in the wrapped database
+func (h *MockHandle) CreateSchema() {
+	h.faults.panicIfFailing("CreateSchema")
+	h.dbh.CreateSchema()
+}
+
+// CreateUser creates a user in the wrapped database
+func (h *MockHandle) CreateUser(email string, password string) (int, error) {
+	if err := h.faults.check("CreateUser"); err != nil {
+		return 0, err
+	}
+	return h.dbh.CreateUser(email, password)
+}
+
+// AuthenticateUser authenticates a user against the wrapped database
+func (h *MockHandle) AuthenticateUser(email string, password string) (int, error) {
+	if err := h.faults.check("AuthenticateUser"); err != nil {
+		return 0, err
+	}
+	return h.dbh.AuthenticateUser(email, password)
+}
+
+// ChangePassword changes a user's password in the wrapped database
+func (h *MockHandle) ChangePassword(userID int, current string, new string) error {
+	if err := h.faults.check("ChangePassword"); err != nil {
+		return err
+	}
+	return h.dbh.ChangePassword(userID, current, new)
+}
+
+// GetUsers returns the users in the wrapped database
+func (h *MockHandle) GetUsers(q database.UsersQuery) []database.User {
+	h.faults.panicIfFailing("GetUsers")
+	return h.dbh.GetUsers(q)
+}
+
+// GetUsersByID returns the users with the given ids in the wrapped database
+func (h *MockHandle) GetUsersByID(ids []int) []database.User {
+	h.faults.panicIfFailing("GetUsersByID")
+	return h.dbh.GetUsersByID(ids)
+}
+
+// GetUsersByEmail returns the users with the given emails in the wrapped database
+func (h *MockHandle) GetUsersByEmail(emails []string) []database.User {
+	h.faults.panicIfFailing("GetUsersByEmail")
+	return h.dbh.GetUsersByEmail(emails)
+}
+
+// DeleteUser deletes a user from the wrapped database
+func (h *MockHandle) DeleteUser(userID int) {
+	h.faults.panicIfFailing("DeleteUser")
+	h.dbh.DeleteUser(userID)
+}
+
+// UserExists checks if a user exists in the wrapped database
+func (h *MockHandle) UserExists(userID int) bool {
+	h.faults.panicIfFailing("UserExists")
+	return h.dbh.UserExists(userID)
+}
+
+// GetSettings returns a user's preferences in the wrapped database
+func (h *MockHandle) GetSettings(userID int) database.Settings {
+	h.faults.panicIfFailing("GetSettings")
+	return h.dbh.GetSettings(userID)
+}
+
+// SetSettings replaces a user's preferences in the wrapped database
+func (h *MockHandle) SetSettings(userID int, s database.Settings) error {
+	if err := h.faults.check("SetSettings"); err != nil {
+		return err
+	}
+	return h.dbh.SetSettings(userID, s)
+}
+
+// SetName changes a user's display name in the wrapped database
+func (h *MockHandle) SetName(userID int, name string) error {
+	if err := h.faults.check("SetName"); err != nil {
+		return err
+	}
+	return h.dbh.SetName(userID, name)
+}
+
+// SetEmail changes a user's email in the wrapped database
+func (h *MockHandle) SetEmail(userID int, email string) error {
+	if err := h.faults.check("SetEmail"); err != nil {
+		return err
+	}
+	return h.dbh.SetEmail(userID, email)
+}
+
+// IsAdmin checks if a user is an administrator in the wrapped database
+func (h *MockHandle) IsAdmin(userID int) bool {
+	h.faults.panicIfFailing("IsAdmin")
+	return h.dbh.IsAdmin(userID)
+}
+
+// SetAdmin makes a user an administrator or not in the wrapped database
+func (h *MockHandle) SetAdmin(userID int, admin bool) {
+	h.faults.panicIfFailing("SetAdmin")
+	h.dbh.SetAdmin(userID, admin)
+}
+
+// MergeUsers merges two users in the wrapped database
+func (h *MockHandle) MergeUsers(sourceID int, targetID int) error {
+	if err := h.faults.check("MergeUsers"); err != nil {
+		return err
+	}
+	return h.dbh.MergeUsers(sourceID, targetID)
+}
+
+// RequestFriend requests a friendship in the wrapped database
+func (h *MockHandle) RequestFriend(userID int, friendID int) (database.FriendStatus, error) {
+	if err := h.faults.check("RequestFriend"); err != nil {
+		return "", err
+	}
+	return h.dbh.RequestFriend(userID, friendID)
+}
+
+// GetRecentContacts returns the users a user recently shared expenses with in the wrapped database
+func (h *MockHandle) GetRecentContacts(userID int, limit int) []database.User {
+	h.faults.panicIfFailing("GetRecentContacts")
+	return h.dbh.GetRecentContacts(userID, limit)
+}
+
+// CreateGroup creates a group in the wrapped database
+func (h *MockHandle) CreateGroup(g database.Group) int {
+	h.faults.panicIfFailing("CreateGroup")
+	return h.dbh.CreateGroup(g)
+}
+
+// GetGroup returns a group in the wrapped database
+func (h *MockHandle) GetGroup(groupID int) (database.Group, error) {
+	if err := h.faults.check("GetGroup"); err != nil {
+		return database.Group{}, err
+	}
+	return h.dbh.GetGroup(groupID)
+}
+
+// GetFriends returns a user's friends in the wrapped database
+func (h *MockHandle) GetFriends(userID int) []database.User {
+	h.faults.panicIfFailing("GetFriends")
+	return h.dbh.GetFriends(userID)
+}
+
+// CreateExpense creates an expense in the wrapped database
+func (h *MockHandle) CreateExpense(e ledger.Expense) error {
+	if err := h.faults.check("CreateExpense"); err != nil {
+		return err
+	}
+	return h.dbh.CreateExpense(e)
+}
+
+// UpdateExpense updates an expense in the wrapped database
+func (h *MockHandle) UpdateExpense(e ledger.Expense) error {
+	if err := h.faults.check("UpdateExpense"); err != nil {
+		return err
+	}
+	return h.dbh.UpdateExpense(e)
+}
+
+// GetExpense returns an expense in the wrapped database
+func (h *MockHandle) GetExpense(expenseID int) (ledger.Expense, error) {
+	if err := h.faults.check("GetExpense"); err != nil {
+		return ledger.Expense{}, err
+	}
+	return h.dbh.GetExpense(expenseID)
+}
+
+// GetExpenses returns the expenses in the wrapped database
+func (h *MockHandle) GetExpenses(userID int) []ledger.Expense {
+	h.faults.panicIfFailing("GetExpenses")
+	return h.dbh.GetExpenses(userID)
+}
+
+// SearchExpenses searches a user's expenses in the wrapped database
+func (h *MockHandle) SearchExpenses(userID int, query string) []ledger.Expense {
+	h.faults.panicIfFailing("SearchExpenses")
+	return h.dbh.SearchExpenses(userID, query)
+}
+
+// GetExpensesSharedWith returns a user's expenses involving other users in the
+// wrapped database
+func (h *MockHandle) GetExpensesSharedWith(userID int, q database.SharedWithQuery) []ledger.Expense {
+	h.faults.panicIfFailing("GetExpensesSharedWith")
+	return h.dbh.GetExpensesSharedWith(userID, q)
+}
+
+// GetExpensesPaidBy returns the expenses a user paid for in the wrapped database
+func (h *MockHandle) GetExpensesPaidBy(userID int) []ledger.Expense {
+	h.faults.panicIfFailing("GetExpensesPaidBy")
+	return h.dbh.GetExpensesPaidBy(userID)
+}
+
+// FindExpenses returns the expenses of all users matching q in the wrapped database
+func (h *MockHandle) FindExpenses(q database.ExpensesQuery) []ledger.Expense {
+	h.faults.panicIfFailing("FindExpenses")
+	return h.dbh.FindExpenses(q)
+}
+
+// GetExpensesByTag returns a user's expenses with a tag in the wrapped database
+func (h *MockHandle) GetExpensesByTag(userID int, tag string) []ledger.Expense {
+	h.faults.panicIfFailing("GetExpensesByTag")
+	return h.dbh.GetExpensesByTag(userID, tag)
+}
+
+// AddExpenseTags attaches tags to an expense in the wrapped database
+func (h *MockHandle) AddExpenseTags(expenseID int, tags []string) {
+	h.faults.panicIfFailing("AddExpenseTags")
+	h.dbh.AddExpenseTags(expenseID, tags)
+}
+
+// GetTags returns the tags of a user's expenses in the wrapped database
+func (h *MockHandle) GetTags(userID int) []string {
+	h.faults.panicIfFailing("GetTags")
+	return h.dbh.GetTags(userID)
+}
+
+// GetPayerTotals returns the totals paid per user in the wrapped database
+func (h *MockHandle) GetPayerTotals(from time.Time, to time.Time) []database.PayerTotal {
+	h.faults.panicIfFailing("GetPayerTotals")
+	return h.dbh.GetPayerTotals(from, to)
+}
+
+// CreateSettlement creates a settlement in the wrapped database
+func (h *MockHandle) CreateSettlement(s ledger.Settlement) int {
+	h.faults.panicIfFailing("CreateSettlement")
+	return h.dbh.CreateSettlement(s)
+}
+
+// GetSettlement returns a settlement in the wrapped database
+func (h *MockHandle) GetSettlement(settlementID int) (ledger.Settlement, error) {
+	if err := h.faults.check("GetSettlement"); err != nil {
+		return ledger.Settlement{}, err
+	}
+	return h.dbh.GetSettlement(settlementID)
+}
+
+// GetSettlements returns a user's settlements in the wrapped database
+func (h *MockHandle) GetSettlements(userID int) []ledger.Settlement {
+	h.faults.panicIfFailing("GetSettlements")
+	return h.dbh.GetSettlements(userID)
+}
+
+// CountExpensesSince counts the expenses a user recorded since a time in the
+// wrapped database
+func (h *MockHandle) CountExpensesSince(userID int, since time.Time) int {
+	h.faults.panicIfFailing("CountExpensesSince")
+	return h.dbh.CountExpensesSince(userID, since)
+}
+
+// GetLastAction returns a user's most recent action in the wrapped database
+func (h *MockHandle) GetLastAction(userID int) (database.Action, error) {
+	if err := h.faults.check("GetLastAction"); err != nil {
+		return database.Action{}, err
+	}
+	return h.dbh.GetLastAction(userID)
+}
+
+// DeleteExpense deletes an expense from the wrapped database
+func (h *MockHandle) DeleteExpense(expenseID int) {
+	h.faults.panicIfFailing("DeleteExpense")
+	h.dbh.DeleteExpense(expenseID)
+}
+
+// DeleteSettlement deletes a settlement from the wrapped database
+func (h *MockHandle) DeleteSettlement(settlementID int) {
+	h.faults.panicIfFailing("DeleteSettlement")
+	h.dbh.DeleteSettlement(settlementID)
+}
+
+// WithAudit makes an audited mutation in the wrapped database. The mutation is
+// made through a MockHandle too, so that it's subject to the injected faults.
+func (h *MockHandle) WithAudit(mutate database.AuditMutation) error {
+	if err := h.faults.check("WithAudit"); err != nil {
+		return err
+	}
+	return h.dbh.WithAudit(func(dbh database.Handle) (database.AuditEntry, error) {
+		return mutate(&MockHandle{dbh: dbh, faults: h.faults})
+	})
+}
+
+// GetAuditEntries returns entries of the audit log in the wrapped database
+func (h *MockHandle) GetAuditEntries(q database.AuditQuery) []database.AuditEntry {
+	h.faults.panicIfFailing("GetAuditEntries")
+	return h.dbh.GetAuditEntries(q)
+}
@@ -0,0 +1,48 @@
+// Package testutil contains test doubles for the database and cache that can be
+// programmed to fail, so that error handling can be tested deterministically.
+package testutil
+
+import "sync"
+
+// Faults holds the errors injected into the methods of a test double, keyed by
+// method name
+type Faults struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// Fail makes all subsequent calls to method fail with err
+func (f *Faults) Fail(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.errs == nil {
+		f.errs = make(map[string]error)
+	}
+	f.errs[method] = err
+}
+
+// Reset makes method succeed again
+func (f *Faults) Reset(method string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.errs, method)
+}
+
+// check returns the error injected into method, if any
+func (f *Faults) check(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.errs[method]
+}
+
+// panicIfFailing panics with the error injected into method, if any. It's used for
+// methods without an error return value, which panic on failure in the real
+// implementations.
+func (f *Faults) panicIfFailing(method string) {
+	if err := f.check(method); err != nil {
+		panic(err)
+	}
+}